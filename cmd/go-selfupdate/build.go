@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type target struct {
+	goos, goarch string
+	goarm        string // ARM version on 32 bit ARM, 5, 6 or 7, if it's a variant of its own
+	libc         string // musl if it's a variant of its own
+}
+
+// platform returns the platform key of the target, GOOS-GOARCH followed by
+// its variants, as in linux-arm-7 or linux-amd64-musl.
+func (t target) platform() string {
+	p := t.goos + "-" + t.goarch
+	if t.goarm != "" {
+		p += "-" + t.goarm
+	}
+	if t.libc != "" {
+		p += "-" + t.libc
+	}
+	return p
+}
+
+// parseTarget parses a platform such as linux/amd64, optionally followed by
+// the variants /7 for the ARM version on arm and /musl. A dash is accepted
+// in place of the slash.
+func parseTarget(p string) (target, error) {
+	parts := strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '-' })
+	if len(parts) < 2 {
+		return target{}, fmt.Errorf("invalid platform %q, expected OS/ARCH", p)
+	}
+	t := target{goos: parts[0], goarch: parts[1]}
+	for _, v := range parts[2:] {
+		switch {
+		case v == "musl" && t.libc == "":
+			t.libc = v
+		case t.goarch == "arm" && (v == "5" || v == "6" || v == "7") && t.goarm == "" && t.libc == "":
+			t.goarm = v
+		default:
+			return target{}, fmt.Errorf("invalid platform %q, unknown variant %q", p, v)
+		}
+	}
+	return t, nil
+}
+
+// parsePlatforms parses a comma separated list of platforms such as
+// "linux/amd64,darwin/arm64,linux/arm/7", see parseTarget.
+func parsePlatforms(s string) ([]target, error) {
+	var targets []target
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		t, err := parseTarget(p)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no platforms given")
+	}
+	return targets, nil
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func printBuildUsage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage: go-selfupdate build [flags] package version")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Cross compiles package for every platform and generates the update files.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Example:")
+	fmt.Fprintln(os.Stderr, "\tgo-selfupdate build ./cmd/myapp 1.2 -platforms linux/amd64,darwin/arm64,windows/amd64 -X main.version")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Flags:")
+	fs.PrintDefaults()
+}
+
+// runBuild implements the build subcommand: it runs `go build` once per
+// target platform and then creates the update files for every binary.
+func runBuild(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	var opts generatorOptions
+	opts.register(fs)
+	platformsFlag := fs.String("platforms", runtime.GOOS+"/"+runtime.GOARCH, "Comma separated list of OS/ARCH targets to build")
+	versionVarFlag := fs.String("X", "", "Package variable to stamp with the version via -ldflags, e.g. main.version")
+	ldflagsFlag := fs.String("ldflags", "", "Additional flags to pass to the linker")
+	fs.Usage = func() { printBuildUsage(fs) }
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		os.Exit(2)
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	targets, err := parsePlatforms(*platformsFlag)
+	if err != nil {
+		fail(inputError{err})
+	}
+	if err := opts.apply(); err != nil {
+		fail(inputError{err})
+	}
+
+	version = positional[1]
+	if opts.name == "" {
+		releaseName = defaultReleaseName(positional[0])
+	}
+	if err := buildRelease(positional[0], targets, *versionVarFlag, *ldflagsFlag); err != nil {
+		fail(err)
+	}
+	if err := finishReport(os.Stdout, opts.report); err != nil {
+		fail(fmt.Errorf("writing report: %w", err))
+	}
+}
+
+// buildRelease cross compiles pkg for every target, stamping the version
+// into the package variable versionVar if set, and creates the update files
+// for the binaries.
+func buildRelease(pkg string, targets []target, versionVar, ldflags string) error {
+	if versionVar != "" {
+		ldflags = strings.TrimSpace(ldflags + " -X " + versionVar + "=" + version)
+	}
+
+	buildDir, err := ioutil.TempDir("", "go-selfupdate-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(buildDir)
+
+	for _, t := range targets {
+		out := filepath.Join(buildDir, t.platform())
+		fmt.Printf("Building %s for %s\n", pkg, t.platform())
+		if err := goBuild(pkg, out, t, ldflags); err != nil {
+			return inputError{fmt.Errorf("building %s for %s: %s", pkg, t.platform(), err)}
+		}
+	}
+
+	if err := createBuildDir(); err != nil {
+		return err
+	}
+	var binaries []platformBinary
+	for _, t := range targets {
+		binaries = append(binaries, platformBinary{filepath.Join(buildDir, t.platform()), t.platform()})
+	}
+	return createUpdates(binaries)
+}
+
+func goBuild(pkg, out string, t target, ldflags string) error {
+	args := []string{"build", "-o", out}
+	if ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	args = append(args, pkg)
+
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GOOS="+t.goos, "GOARCH="+t.goarch)
+	if t.goarm != "" {
+		cmd.Env = append(cmd.Env, "GOARM="+t.goarm)
+	}
+	if t.libc == "musl" && os.Getenv("CC") == "" {
+		// without a musl toolchain a static binary is the one that runs
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
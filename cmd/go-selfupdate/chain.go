@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// diffDepth is the number of most recent releases patches are created
+// from, 0 for all of them. Older releases update through the patch chain.
+var diffDepth int
+
+// keepReleases is the number of most recent releases kept in the output
+// directory, 0 for all of them.
+var keepReleases int
+
+// patchHop is the patch from one release to the one published after it.
+type patchHop struct {
+	From          string
+	To            string
+	DiffAlgorithm string
+	Size          int64
+	Sha256        []byte
+	PatchSha256   []byte `json:",omitempty"`
+}
+
+// releaseManifestPath returns the path of the copy of the manifest of
+// version for platform kept in its version directory.
+func releaseManifestPath(version, platform string) string {
+	return filepath.Join(genDir, version, platform+".json")
+}
+
+func readReleaseManifest(version, platform string) (current, error) {
+	var c current
+	b, err := ioutil.ReadFile(releaseManifestPath(version, platform))
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// releases returns the versions in genDir other than the current one,
+// oldest first by the timestamp of their manifests. Releases without one,
+// written by older versions of the generator, come first.
+func releases() ([]string, error) {
+	files, err := ioutil.ReadDir(genDir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	released := make(map[string]time.Time)
+	for _, file := range files {
+		if !file.IsDir() || file.Name() == version {
+			continue
+		}
+		versions = append(versions, file.Name())
+		manifests, _ := filepath.Glob(filepath.Join(genDir, file.Name(), "*.json"))
+		for _, m := range manifests {
+			var c current
+			if b, err := ioutil.ReadFile(m); err == nil && json.Unmarshal(b, &c) == nil && c.Timestamp.After(released[file.Name()]) {
+				released[file.Name()] = c.Timestamp
+			}
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return released[versions[i]].Before(released[versions[j]])
+	})
+	return versions, nil
+}
+
+// olderReleases returns the versions in genDir other than the current one
+// that have a full binary for platform, oldest first.
+func olderReleases(platform string) ([]string, error) {
+	all, err := releases()
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, v := range all {
+		r, err := openRelease(filepath.Join(genDir, v), platform)
+		if err != nil {
+			continue
+		}
+		r.Close()
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// pruneReleases deletes the oldest releases so that, with the current one,
+// only keepReleases remain.
+func pruneReleases() error {
+	if keepReleases <= 0 {
+		return nil
+	}
+	versions, err := releases()
+	if err != nil {
+		return err
+	}
+	if len(versions) < keepReleases {
+		return nil
+	}
+	for _, v := range versions[:len(versions)-(keepReleases-1)] {
+		fmt.Printf("Removing release %s\n", v)
+		if err := os.RemoveAll(filepath.Join(genDir, v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchChain returns the patches between consecutive releases that lead up
+// to c, oldest first, as far back as they were published. older are the
+// earlier releases, oldest first.
+func patchChain(platform string, older []string, c current) []patchHop {
+	var hops []patchHop
+	to := c
+	for i := len(older) - 1; i >= 0; i-- {
+		from := older[i]
+		p, ok := to.Patches[from]
+		if !ok {
+			break
+		}
+		algorithm := to.DiffAlgorithm
+		if algorithm == "" {
+			// manifests before the field were always bsdiff
+			algorithm = diffBsdiff
+		}
+		hops = append(hops, patchHop{From: from, To: to.Version, DiffAlgorithm: algorithm, Size: p.Size, Sha256: to.Sha256, PatchSha256: p.Sha256})
+
+		var err error
+		if to, err = readReleaseManifest(from, platform); err != nil {
+			break
+		}
+	}
+	// a single hop is the patch straight to c
+	if len(hops) < 2 {
+		return nil
+	}
+	for i, j := 0, len(hops)-1; i < j; i, j = i+1, j-1 {
+		hops[i], hops[j] = hops[j], hops[i]
+	}
+	return hops
+}
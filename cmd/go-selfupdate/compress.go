@@ -0,0 +1,169 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats for full binaries. The name is recorded in the
+// manifest so clients know how to decompress them, gzip is left out for
+// compatibility with older clients.
+const (
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+	compressXz   = "xz"
+)
+
+// compressExtensions are the file extensions of the full binaries, in the
+// order they are looked for.
+var compressExtensions = []struct {
+	format, ext string
+}{
+	{compressGzip, ".gz"},
+	{compressZstd, ".zst"},
+	{compressXz, ".xz"},
+}
+
+func compressExtension(format string) string {
+	for _, e := range compressExtensions {
+		if e.format == format {
+			return e.ext
+		}
+	}
+	return ""
+}
+
+// manifestCompression returns the Compression recorded in the manifest.
+func manifestCompression(format string) string {
+	if format == compressGzip {
+		return ""
+	}
+	return format
+}
+
+func validCompression(format string) bool {
+	return compressExtension(format) != ""
+}
+
+// compress writes data compressed with the given format to w.
+func compress(format string, r io.Reader, w io.Writer) error {
+	var zw io.WriteCloser
+	var err error
+	switch format {
+	case compressGzip:
+		zw = gzip.NewWriter(w)
+	case compressZstd:
+		zw, err = zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	case compressXz:
+		zw, err = xz.NewWriter(w)
+	default:
+		err = fmt.Errorf("unknown compression %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decompressReader closes both the decompressor and the file below it.
+type decompressReader struct {
+	io.Reader
+	close func()
+	f     *os.File
+}
+
+func (d *decompressReader) Close() error {
+	if d.close != nil {
+		d.close()
+	}
+	return d.f.Close()
+}
+
+// openRelease returns the decompressed full binary for platform in the
+// version directory dir, whichever compression or naming scheme it was
+// written with.
+func openRelease(dir, platform string) (io.ReadCloser, error) {
+	for _, e := range compressExtensions {
+		r, err := openCompressed(filepath.Join(dir, platform+e.ext), e.format)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return r, err
+	}
+	return openGoreleaserRelease(dir, platform)
+}
+
+// openCompressed returns the decompressed contents of the file at path,
+// compressed with format.
+func openCompressed(path, format string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &decompressReader{f: f}
+	switch format {
+	case compressGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		d.Reader = gz
+	case compressZstd:
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		d.Reader, d.close = zr, zr.Close
+	case compressXz:
+		xr, err := xz.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		d.Reader = xr
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unknown compression %q", format)
+	}
+	return d, nil
+}
+
+// releaseSize returns the uncompressed size of the full binary for platform
+// in the version directory dir.
+func releaseSize(dir, platform string) (int64, error) {
+	// gzip records the size, the other formats have to be decompressed
+	if size, err := gzSize(filepath.Join(dir, platform+".gz")); err == nil {
+		return size, nil
+	}
+	r, err := openRelease(dir, platform)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(ioutil.Discard, r)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// configEntry is a key and its values from a release config file. Arrays
+// have a value per element.
+type configEntry struct {
+	key    string
+	values []string
+	line   int
+}
+
+// parseConfig reads a release config in the subset of TOML that release
+// jobs need: key = value pairs with string, integer, boolean and single line
+// string array values, and # comments. Tables are not supported.
+func parseConfig(r io.Reader) ([]configEntry, error) {
+	var entries []configEntry
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(s.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", n)
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, key)
+		}
+		seen[key] = true
+		values, err := parseConfigValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %s", n, key, err)
+		}
+		entries = append(entries, configEntry{key: key, values: values, line: n})
+	}
+	return entries, s.Err()
+}
+
+// stripComment removes a # comment that isn't inside a string from line.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseConfigValue(v string) ([]string, error) {
+	if !strings.HasPrefix(v, "[") {
+		s, err := parseConfigScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+	if !strings.HasSuffix(v, "]") {
+		return nil, fmt.Errorf("arrays have to be on one line")
+	}
+	var values []string
+	for _, elem := range splitConfigArray(v[1 : len(v)-1]) {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			// a trailing comma
+			continue
+		}
+		s, err := parseConfigScalar(elem)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// splitConfigArray splits the elements of an array at commas outside
+// strings.
+func splitConfigArray(s string) []string {
+	var elems []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			elems = append(elems, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(elems, s[start:])
+}
+
+func parseConfigScalar(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		return strconv.Unquote(v)
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	case v == "true" || v == "false":
+		return v, nil
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", v)
+	}
+	return v, nil
+}
+
+// configAliases are config keys for flags with short names.
+var configAliases = map[string]string{
+	"output":      "o",
+	"jobs":        "j",
+	"version-var": "X",
+}
+
+// releaseJob is what a config file describes besides the generator flags.
+type releaseJob struct {
+	version  string // Version to release, may be given on the command line instead
+	pkg      string // Package to build for every platform in platforms
+	binaries string // Binary or directory of binaries, see directoryBinaries
+	platform string // Platform of binaries if it is a single file
+	channel  string // Subdirectory of the output directory to publish to
+}
+
+// applyConfig sets the flags in fs from the entries of a config file and
+// returns the rest of the job.
+func applyConfig(fs *flag.FlagSet, entries []configEntry) (releaseJob, error) {
+	job := releaseJob{platform: runtime.GOOS + "-" + runtime.GOARCH}
+	for _, e := range entries {
+		value := strings.Join(e.values, ",")
+		switch e.key {
+		case "version":
+			job.version = value
+			continue
+		case "package":
+			job.pkg = value
+			continue
+		case "binaries":
+			job.binaries = value
+			continue
+		case "platform":
+			job.platform = value
+			continue
+		case "channel":
+			job.channel = value
+			continue
+		}
+
+		name := e.key
+		if alias, ok := configAliases[name]; ok {
+			name = alias
+		}
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return job, fmt.Errorf("line %d: unknown setting %s", e.line, e.key)
+		}
+		values := e.values
+		if _, repeated := f.Value.(*stringList); !repeated {
+			values = []string{value}
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return job, fmt.Errorf("line %d: %s: %s", e.line, e.key, err)
+			}
+		}
+	}
+	if (job.pkg == "") == (job.binaries == "") {
+		return job, fmt.Errorf("exactly one of package and binaries has to be set")
+	}
+	return job, nil
+}
+
+func printConfigUsage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage: go-selfupdate -config release.toml [flags] [version]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Runs the release job described in the config file. Its keys are the names of the")
+	fmt.Fprintln(os.Stderr, "flags below, output, jobs and version-var for -o, -j and -X, and version, package,")
+	fmt.Fprintln(os.Stderr, "binaries, platform and channel. Flags given on the command line take precedence.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Flags:")
+	fs.PrintDefaults()
+}
+
+// configArg returns the config file given with -config in args.
+func configArg(args []string) (string, bool) {
+	for i, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		if name == "config" {
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", true
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config="), true
+		}
+	}
+	return "", false
+}
+
+func readConfig(path string) ([]configEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseConfig(f)
+}
+
+// runConfig implements -config: it runs the release job described in a
+// config file, either building a package like the build command or
+// publishing existing binaries.
+func runConfig(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	var opts generatorOptions
+	opts.register(fs)
+	fs.String("config", "", "Release config file")
+	platformsFlag := fs.String("platforms", runtime.GOOS+"/"+runtime.GOARCH, "Comma separated list of OS/ARCH targets to build")
+	versionVarFlag := fs.String("X", "", "Package variable to stamp with the version via -ldflags, e.g. main.version")
+	ldflagsFlag := fs.String("ldflags", "", "Additional flags to pass to the linker")
+	fs.Usage = func() { printConfigUsage(fs) }
+
+	// the file is applied first so that the command line overrides it
+	path, _ := configArg(args)
+	entries, err := readConfig(path)
+	if err != nil {
+		fail(inputError{err})
+	}
+	job, err := applyConfig(fs, entries)
+	if err != nil {
+		fail(inputError{fmt.Errorf("%s: %w", path, err)})
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil || len(positional) > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(positional) == 1 {
+		job.version = positional[0]
+	}
+	if job.version == "" {
+		fail(inputError{fmt.Errorf("%s: no version, set it in the file or give it as an argument", path)})
+	}
+	if job.channel != "" {
+		opts.output = filepath.Join(opts.output, job.channel)
+	}
+	if err := opts.apply(); err != nil {
+		fail(inputError{err})
+	}
+	version = job.version
+
+	if job.pkg != "" {
+		targets, err := parsePlatforms(*platformsFlag)
+		if err != nil {
+			fail(inputError{err})
+		}
+		if opts.name == "" {
+			releaseName = defaultReleaseName(job.pkg)
+		}
+		if err := buildRelease(job.pkg, targets, *versionVarFlag, *ldflagsFlag); err != nil {
+			fail(err)
+		}
+	} else {
+		if opts.name == "" {
+			releaseName = defaultReleaseName(job.binaries)
+		}
+		if err := generateRelease(job.binaries, job.platform); err != nil {
+			fail(err)
+		}
+	}
+	exitWithReport(opts.report)
+}
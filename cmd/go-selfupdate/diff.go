@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/kr/binarydist"
+)
+
+// Diff algorithms understood by the generator. The name is recorded in the
+// manifest so clients know which decoder to apply to the patches.
+const (
+	diffBsdiff  = "bsdiff"
+	diffXdelta3 = "xdelta3"
+	diffZstd    = "zstd"
+	diffNone    = "none"
+)
+
+func validDiffAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case diffBsdiff, diffXdelta3, diffZstd, diffNone:
+		return true
+	}
+	return false
+}
+
+// checkPatchFormat refuses algorithm unless the default client can apply its
+// patches or format, the -patch-format flag, names it. Clients only have
+// bsdiff built in, xdelta3 and zstd patches need a Patcher registered in
+// Updater.Patchers, which nothing checks before they are published.
+func checkPatchFormat(algorithm, format string) error {
+	if format != "" && format != algorithm {
+		return fmt.Errorf("-patch-format %s does not match -diff %s", format, algorithm)
+	}
+	switch algorithm {
+	case diffBsdiff, diffNone:
+		return nil
+	}
+	if format == "" {
+		return fmt.Errorf("clients can only apply %s patches with a Patcher registered in Updater.Patchers, pass -patch-format %s if they all have one", algorithm, algorithm)
+	}
+	return nil
+}
+
+// createPatch writes a patch turning old into new to patch using the given
+// algorithm. bsdiff is built in, xdelta3 and zstd run the respective command
+// line tools which must be installed on the machine generating updates.
+func createPatch(algorithm string, old, new io.Reader, patch io.Writer) error {
+	switch algorithm {
+	case diffBsdiff:
+		return binarydist.Diff(old, new, patch)
+	case diffXdelta3:
+		return externalDiff(old, new, patch, func(oldPath, newPath, patchPath string) *exec.Cmd {
+			return exec.Command("xdelta3", "-e", "-f", "-s", oldPath, newPath, patchPath)
+		})
+	case diffZstd:
+		return externalDiff(old, new, patch, func(oldPath, newPath, patchPath string) *exec.Cmd {
+			return exec.Command("zstd", "-q", "-f", "--long=31", "--patch-from="+oldPath, newPath, "-o", patchPath)
+		})
+	}
+	return fmt.Errorf("unknown diff algorithm %q", algorithm)
+}
+
+// applyPatch is the inverse of createPatch, it writes the result of applying
+// patch to old to new.
+func applyPatch(algorithm string, old, patch io.Reader, new io.Writer) error {
+	switch algorithm {
+	case "", diffBsdiff:
+		return binarydist.Patch(old, new, patch)
+	case diffXdelta3:
+		return externalDiff(old, patch, new, func(oldPath, patchPath, newPath string) *exec.Cmd {
+			return exec.Command("xdelta3", "-d", "-f", "-s", oldPath, patchPath, newPath)
+		})
+	case diffZstd:
+		return externalDiff(old, patch, new, func(oldPath, patchPath, newPath string) *exec.Cmd {
+			return exec.Command("zstd", "-q", "-d", "-f", "--long=31", "--patch-from="+oldPath, patchPath, "-o", newPath)
+		})
+	}
+	return fmt.Errorf("unknown diff algorithm %q", algorithm)
+}
+
+// externalDiff runs command on temporary copies of a and b and copies the
+// file it writes to out.
+func externalDiff(a, b io.Reader, out io.Writer, command func(aPath, bPath, outPath string) *exec.Cmd) error {
+	dir, err := ioutil.TempDir("", "go-selfupdate-diff")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	aPath := filepath.Join(dir, "a")
+	bPath := filepath.Join(dir, "b")
+	outPath := filepath.Join(dir, "out")
+	if err := writeFile(aPath, a); err != nil {
+		return err
+	}
+	if err := writeFile(bPath, b); err != nil {
+		return err
+	}
+
+	cmd := command(aPath, bPath, outPath)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s", cmd.Path, err)
+	}
+
+	f, err := os.Open(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(out, f)
+	return err
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit codes of the generator, so that scripts can tell a mistake in the
+// invocation from a failing disk or a diff that could not be created.
+const (
+	exitIOError   = 1
+	exitBadInput  = 2
+	exitDiffError = 3
+)
+
+// inputError marks an error caused by the arguments, flags or files given to
+// the generator rather than by the environment.
+type inputError struct {
+	err error
+}
+
+func (e inputError) Error() string { return e.err.Error() }
+func (e inputError) Unwrap() error { return e.err }
+
+// diffError is returned when a patch between two releases can't be created.
+type diffError struct {
+	from, to string
+	err      error
+}
+
+func (e diffError) Error() string {
+	return fmt.Sprintf("creating patch from %s to %s: %s", e.from, e.to, e.err)
+}
+
+func (e diffError) Unwrap() error { return e.err }
+
+// exitCode returns the exit code the generator ends with for err.
+func exitCode(err error) int {
+	var ie inputError
+	var de diffError
+	switch {
+	case errors.As(err, &ie):
+		return exitBadInput
+	case errors.As(err, &de):
+		return exitDiffError
+	default:
+		return exitIOError
+	}
+}
+
+// fail prints err and exits with its exit code.
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// releaseFile is a file published with a release besides the executable.
+type releaseFile struct {
+	Name   string
+	Sha256 []byte
+	Size   int64
+	Mode   os.FileMode `json:",omitempty"`
+}
+
+// extraFiles are the paths of the files published with every release,
+// {platform} in them is replaced with the platform being generated.
+var extraFiles []string
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// writeExtraFiles writes the extra files for platform, compressed with the
+// manifest compression comp, next to its full binary and returns their
+// manifest entries, which keep the permissions of the files.
+func writeExtraFiles(platform, comp string) ([]releaseFile, error) {
+	format := comp
+	if format == "" {
+		format = compressGzip
+	}
+	var files []releaseFile
+	for _, path := range extraFiles {
+		path = strings.Replace(path, "{platform}", platform, -1)
+		if strings.HasPrefix(platform, "windows-") && !fileExists(path) && fileExists(path+".exe") {
+			path += ".exe"
+		}
+		f, err := writeExtraFile(path, platform, format)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// writeExtraFile writes the file at path for platform, compressed with
+// format, and returns its manifest entry.
+func writeExtraFile(path, platform, format string) (releaseFile, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return releaseFile{}, inputError{err}
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return releaseFile{}, err
+	}
+	name := filepath.Base(path)
+	out, err := os.Create(filepath.Join(genDir, version, platform+"-"+name+compressExtension(format)))
+	if err != nil {
+		return releaseFile{}, err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	cw := &countingWriter{w: out}
+	if err := compress(format, io.TeeReader(in, h), cw); err != nil {
+		return releaseFile{}, fmt.Errorf("writing %s: %w", out.Name(), err)
+	}
+	if err := out.Close(); err != nil {
+		return releaseFile{}, err
+	}
+	if err := minisignFile(out.Name()); err != nil {
+		return releaseFile{}, err
+	}
+	return releaseFile{Name: name, Sha256: h.Sum(nil), Size: cw.n, Mode: info.Mode().Perm()}, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
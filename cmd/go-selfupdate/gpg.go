@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// gpgKey signs the manifests with detached OpenPGP signatures when set.
+var gpgKey *openpgp.Entity
+
+// readGPGKey reads the first key with a private key from the armored or
+// binary OpenPGP keyring at path, as written by gpg --export-secret-keys,
+// decrypting it with passphrase if it is encrypted.
+func readGPGKey(path, passphrase string) (*openpgp.Entity, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN PGP")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if e.PrivateKey.Encrypted {
+			if passphrase == "" {
+				return nil, fmt.Errorf("%s: key is encrypted, set its passphrase in GO_SELFUPDATE_GPG_PASSPHRASE", path)
+			}
+			if err := e.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		return e, nil
+	}
+	return nil, errors.New(path + ": no private key found")
+}
+
+// gpgSignFile writes an armored detached signature of the file at path next
+// to it, with .asc appended to its name, if a GPG key is configured.
+func gpgSignFile(path string) error {
+	if gpgKey == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, gpgKey, f, nil); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".asc", sig.Bytes(), 0644)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// limiter bounds the number of diffs running at once and the memory they
+// are estimated to use. A job larger than the whole memory budget still
+// runs, but only once nothing else is running.
+type limiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   int
+	mem    int64
+	maxJob int
+	maxMem int64 // 0 means no memory limit
+}
+
+func newLimiter(maxJobs int, maxMem int64) *limiter {
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+	l := &limiter{maxJob: maxJobs, maxMem: maxMem}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *limiter) clamp(mem int64) int64 {
+	if l.maxMem > 0 && mem > l.maxMem {
+		return l.maxMem
+	}
+	return mem
+}
+
+// acquire blocks until a job using mem bytes fits within the limits.
+func (l *limiter) acquire(mem int64) {
+	mem = l.clamp(mem)
+	l.mu.Lock()
+	for l.jobs >= l.maxJob || (l.maxMem > 0 && l.mem+mem > l.maxMem) {
+		l.cond.Wait()
+	}
+	l.jobs++
+	l.mem += mem
+	l.mu.Unlock()
+}
+
+// release returns the resources taken by acquire(mem).
+func (l *limiter) release(mem int64) {
+	mem = l.clamp(mem)
+	l.mu.Lock()
+	l.jobs--
+	l.mem -= mem
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+var limits = newLimiter(1, 0)
+
+// diffMemory estimates the peak memory used to diff binaries of the given
+// sizes. bsdiff keeps both binaries in memory plus two suffix array
+// integers for every byte of the old binary.
+func diffMemory(oldSize, newSize int64) int64 {
+	return 17*oldSize + 2*newSize
+}
+
+// gzSize returns the uncompressed size of the gzip file at path as recorded
+// in its trailer, which is exact for files smaller than 4GiB.
+func gzSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() < 4 {
+		return 0, fmt.Errorf("%s: not a gzip file", path)
+	}
+	var trailer [4]byte
+	if _, err := f.ReadAt(trailer[:], fi.Size()-4); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:])), nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
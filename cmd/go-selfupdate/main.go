@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/json"
 	"flag"
@@ -12,116 +10,318 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-
-	"github.com/kr/binarydist"
+	"strings"
+	"sync"
+	"time"
 )
 
-var version, genDir string
+var version, genDir, diffAlgorithm string
+
+// compression is the format full binaries are compressed with.
+var compression = compressGzip
+
+// manifestExpiry is how long after generation clients accept the manifest.
+var manifestExpiry time.Duration
+
+// releaseSeverity is recorded in the manifest, "critical" marks security fixes.
+var releaseSeverity string
+
+// releaseNotes, minimumVersion and downloadURL fill the manifest version 2
+// fields, downloadURL is the base URL the output directory is served at.
+var releaseNotes, minimumVersion, downloadURL string
 
 type current struct {
-	Version string
-	Sha256  []byte
+	Version       string
+	Sha256        []byte
+	DiffAlgorithm string     `json:",omitempty"`
+	Expires       *time.Time `json:",omitempty"`
+	Severity      string     `json:",omitempty"`
+	Timestamp     time.Time
+	Size          int64                `json:",omitempty"`
+	Patches       map[string]patchInfo `json:",omitempty"`
+	PatchChain    []patchHop           `json:",omitempty"`
+	Signature     []byte               `json:",omitempty"`
+	Compression   string               `json:",omitempty"`
+	Archive       string               `json:",omitempty"`
+
+	// Fields of manifest version 2
+	ManifestVersion int
+	ReleaseNotes    string        `json:",omitempty"`
+	MinimumVersion  string        `json:",omitempty"`
+	Downloads       *downloadURLs `json:",omitempty"`
+	Files           []releaseFile `json:",omitempty"`
+	RolloutPercent  int           `json:",omitempty"`
+	UpdateFrom      string        `json:",omitempty"`
+	SkipPlatforms   []string      `json:",omitempty"`
+	Minisig         string        `json:",omitempty"` // minisign signature of the executable, with -sign-key
+	Paused          bool          `json:",omitempty"` // not signed, so it can be set by hand
 }
 
-func generateSha256(path string) []byte {
+// manifestVersion is the version of the manifest format written.
+const manifestVersion = 2
+
+// downloadURLs are the absolute URLs of the files of a release.
+type downloadURLs struct {
+	Binary  string
+	Patches map[string]string `json:",omitempty"`
+}
+
+// patchInfo describes the patch from one older version to the current one.
+type patchInfo struct {
+	Size   int64
+	Sha256 []byte `json:",omitempty"`
+}
+
+func generateSha256(path string) ([]byte, error) {
 	h := sha256.New()
-	b, err := ioutil.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
 	}
-	h.Write(b)
-	sum := h.Sum(nil)
-	return sum
-	//return base64.URLEncoding.EncodeToString(sum)
+	return h.Sum(nil), nil
 }
 
-type gzReader struct {
-	z, r io.ReadCloser
+// platformBinary is the executable published for a platform.
+type platformBinary struct {
+	path, platform string
 }
 
-func (g *gzReader) Read(p []byte) (int, error) {
-	return g.z.Read(p)
-}
+// createUpdates creates the update files for every binary. Platforms are
+// processed as many at once as -j allows, their diffs share the limits. It
+// returns the first error of any platform.
+func createUpdates(binaries []platformBinary) error {
+	// old releases go first so that no patches are made from them
+	if err := pruneReleases(); err != nil {
+		return fmt.Errorf("removing old releases: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(genDir, version), 0755); err != nil {
+		return err
+	}
+	if releaseNotes != "" {
+		// for clients that don't read them from the manifest
+		notes := filepath.Join(genDir, version, "CHANGELOG.md")
+		if err := ioutil.WriteFile(notes, []byte(releaseNotes), 0644); err != nil {
+			return err
+		}
+		if err := minisignFile(notes); err != nil {
+			return err
+		}
+	}
 
-func (g *gzReader) Close() error {
-	g.z.Close()
-	return g.r.Close()
+	slots := make(chan struct{}, limits.maxJob)
+	errs := make(chan error, len(binaries))
+	var wg sync.WaitGroup
+	for _, b := range binaries {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(b platformBinary) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := createUpdate(b.path, b.platform); err != nil {
+				errs <- fmt.Errorf("%s: %w", b.platform, err)
+			}
+		}(b)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
 }
 
-func newGzReader(r io.ReadCloser) io.ReadCloser {
-	var err error
-	g := new(gzReader)
-	g.r = r
-	g.z, err = gzip.NewReader(r)
+func createUpdate(path string, platform string) error {
+	sum, err := generateSha256(path)
 	if err != nil {
-		panic(err)
+		return inputError{err}
 	}
-	return g
-}
+	c := current{
+		Version:       version,
+		Sha256:        sum,
+		DiffAlgorithm: diffAlgorithm,
+		Severity:      releaseSeverity,
 
-func createUpdate(path string, platform string) {
-	c := current{Version: version, Sha256: generateSha256(path)}
+		ManifestVersion: manifestVersion,
+		ReleaseNotes:    releaseNotes,
+		MinimumVersion:  minimumVersion,
+		RolloutPercent:  rolloutPercent,
+		UpdateFrom:      updateFrom,
+		SkipPlatforms:   skipPlatforms,
+		Timestamp:       time.Now().UTC().Truncate(time.Second),
+	}
+	if manifestExpiry > 0 {
+		expires := time.Now().Add(manifestExpiry).UTC().Truncate(time.Second)
+		c.Expires = &expires
+	}
 
-	b, err := json.MarshalIndent(c, "", "    ")
+	if err := os.MkdirAll(filepath.Join(genDir, version), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(path)
 	if err != nil {
-		fmt.Println("error:", err)
+		return inputError{err}
 	}
-	err = ioutil.WriteFile(filepath.Join(genDir, platform+".json"), b, 0755)
+	defer in.Close()
+	fi, err := in.Stat()
 	if err != nil {
-		panic(err)
+		return inputError{err}
+	}
+	binFile, archive, manifestComp := fullBinaryFile(platform)
+	c.Archive, c.Compression = archive, manifestComp
+	out, err := os.OpenFile(filepath.Join(genDir, version, binFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{w: out}
+	err = writeFullBinary(platform, in, fi.Size(), cw)
+	if errClose := out.Close(); err == nil {
+		err = errClose
 	}
-
-	os.MkdirAll(filepath.Join(genDir, version), 0755)
-
-	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
-	f, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("writing %s: %w", out.Name(), err)
+	}
+	c.Size = cw.n
+	if err := minisignFile(out.Name()); err != nil {
+		return err
+	}
+	if c.Minisig, err = minisignExecutable(path); err != nil {
+		return err
+	}
+	if downloadURL != "" {
+		c.Downloads = &downloadURLs{Binary: downloadURL + version + "/" + binFile}
+	}
+	if c.Files, err = writeExtraFiles(platform, manifestComp); err != nil {
+		return err
+	}
+
+	if diffAlgorithm == diffNone {
+		return writeManifest(platform, c)
 	}
-	w.Write(f)
-	w.Close() // You must close this first to flush the bytes to the buffer.
-	err = ioutil.WriteFile(filepath.Join(genDir, version, platform+".gz"), buf.Bytes(), 0755)
 
-	files, err := ioutil.ReadDir(genDir)
+	older, err := olderReleases(platform)
 	if err != nil {
-		fmt.Println(err)
+		return err
+	}
+	direct := older
+	if diffDepth > 0 && len(direct) > diffDepth {
+		direct = direct[len(direct)-diffDepth:]
 	}
 
-	for _, file := range files {
-		if file.IsDir() == false {
-			continue
-		}
-		if file.Name() == version {
-			continue
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var patchErr error
+	patches := make(map[string]patchInfo)
+	for _, from := range direct {
+		if err := os.MkdirAll(filepath.Join(genDir, from, version), 0755); err != nil {
+			return err
 		}
 
-		os.Mkdir(filepath.Join(genDir, file.Name(), version), 0755)
-
-		fName := filepath.Join(genDir, file.Name(), platform+".gz")
-		old, err := os.Open(fName)
+		oldSize, err := releaseSize(filepath.Join(genDir, from), platform)
 		if err != nil {
 			// Don't have an old release for this os/arch, continue on
 			continue
 		}
 
-		fName = filepath.Join(genDir, version, platform+".gz")
-		newF, err := os.Open(fName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Can't open %s: error: %s\n", fName, err)
-			os.Exit(1)
-		}
+		// wait for a free slot before starting the next diff so that only
+		// as many diffs run at once as the limits allow
+		mem := diffMemory(oldSize, fi.Size())
+		limits.acquire(mem)
+		wg.Add(1)
+		go func(from string) {
+			defer wg.Done()
+			defer limits.release(mem)
+			p, err := createPatchFile(platform, from, c.Size)
+
+			mu.Lock()
+			if err != nil && patchErr == nil {
+				patchErr = err
+			}
+			patches[from] = p
+			mu.Unlock()
+		}(from)
+	}
+	wg.Wait()
+	if patchErr != nil {
+		return patchErr
+	}
 
-		ar := newGzReader(old)
-		defer ar.Close()
-		br := newGzReader(newF)
-		defer br.Close()
-		patch := new(bytes.Buffer)
-		if err := binarydist.Diff(ar, br, patch); err != nil {
-			panic(err)
+	if len(patches) > 0 {
+		c.Patches = patches
+	}
+	c.PatchChain = patchChain(platform, older, c)
+	if c.Downloads != nil {
+		c.Downloads.Patches = make(map[string]string)
+		for from := range patches {
+			c.Downloads.Patches[from] = downloadURL + from + "/" + version + "/" + platform
 		}
-		ioutil.WriteFile(filepath.Join(genDir, file.Name(), version, platform), patch.Bytes(), 0755)
 	}
+	return writeManifest(platform, c)
+}
+
+// writeManifest writes the manifest clients fetch to learn about the latest
+// version for platform. It is written last so that everything it refers to
+// exists once it is published. A copy is kept in the version directory for
+// building patch chains later.
+func writeManifest(platform string, c current) error {
+	sign(&c)
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(releaseManifestPath(c.Version, platform), b, 0644); err != nil {
+		return err
+	}
+	if err := gpgSignFile(releaseManifestPath(c.Version, platform)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(genDir, platform+".json"), b, 0755); err != nil {
+		return err
+	}
+	return gpgSignFile(filepath.Join(genDir, platform+".json"))
+}
+
+// createPatchFile writes the patch from version from to the current version
+// for platform into the from directory and returns its size and hash.
+func createPatchFile(platform, from string, fullSize int64) (patchInfo, error) {
+	ar, err := openRelease(filepath.Join(genDir, from), platform)
+	if err != nil {
+		return patchInfo{}, fmt.Errorf("opening %s release: %w", from, err)
+	}
+	defer ar.Close()
+
+	br, err := openRelease(filepath.Join(genDir, version), platform)
+	if err != nil {
+		return patchInfo{}, fmt.Errorf("opening %s release: %w", version, err)
+	}
+	defer br.Close()
+	out, err := os.OpenFile(filepath.Join(genDir, from, version, platform), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return patchInfo{}, err
+	}
+	h := sha256.New()
+	patch := &countingWriter{w: io.MultiWriter(out, h)}
+	err = createPatch(diffAlgorithm, ar, br, patch)
+	if errClose := out.Close(); err == nil && errClose != nil {
+		return patchInfo{}, errClose
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		return patchInfo{}, diffError{from: from, to: version, err: err}
+	}
+	if err := minisignFile(out.Name()); err != nil {
+		return patchInfo{}, err
+	}
+
+	addPatchStat(patchStat{
+		Platform:    platform,
+		FromVersion: from,
+		ToVersion:   version,
+		PatchSize:   patch.n,
+		FullSize:    fullSize,
+	})
+	return patchInfo{Size: patch.n, Sha256: h.Sum(nil)}, nil
 }
 
 func printUsage() {
@@ -129,14 +329,56 @@ func printUsage() {
 	fmt.Println("Positional arguments:")
 	fmt.Println("\tSingle platform: go-selfupdate myapp 1.2")
 	fmt.Println("\tCross platform: go-selfupdate /tmp/mybinares/ 1.2")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("\tbuild: go-selfupdate build ./cmd/myapp 1.2 -platforms linux/amd64,windows/amd64")
+	fmt.Println("\tsimulate: go-selfupdate simulate -dir public")
+	fmt.Println("\tverify: go-selfupdate verify -dir public")
+	fmt.Println("\tconfig file: go-selfupdate -config release.toml")
+	fmt.Println("\tkeygen: go-selfupdate keygen -o selfupdate.key")
+	fmt.Println("\trotate: go-selfupdate rotate -dir public -key selfupdate.key -new-key next.key")
+	fmt.Println("\tserve: go-selfupdate serve -dir public -addr :8080")
+	fmt.Println("\trollout: go-selfupdate rollout -dir public -key selfupdate.key 50")
 }
 
-func createBuildDir() {
-	os.MkdirAll(genDir, 0755)
+func createBuildDir() error {
+	return os.MkdirAll(genDir, 0755)
 }
 
 func main() {
-	outputDirFlag := flag.String("o", "public", "Output directory for writing updates")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "build":
+			runBuild(os.Args[2:])
+			return
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "keygen":
+			runKeygen(os.Args[2:])
+			return
+		case "rotate":
+			runRotate(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "rollout":
+			runRollout(os.Args[2:])
+			return
+		}
+	}
+
+	if _, ok := configArg(os.Args[1:]); ok {
+		runConfig(os.Args[1:])
+		return
+	}
+
+	var opts generatorOptions
+	opts.register(flag.CommandLine)
 
 	var defaultPlatform string
 	goos := os.Getenv("GOOS")
@@ -156,28 +398,63 @@ func main() {
 		os.Exit(0)
 	}
 
-	platform := *platformFlag
+	if err := opts.apply(); err != nil {
+		fail(inputError{err})
+	}
+
 	appPath := flag.Arg(0)
 	version = flag.Arg(1)
-	genDir = *outputDirFlag
+	if opts.name == "" {
+		releaseName = defaultReleaseName(appPath)
+	}
+	if err := generateRelease(appPath, *platformFlag); err != nil {
+		fail(err)
+	}
+	exitWithReport(opts.report)
+}
 
-	createBuildDir()
+// generateRelease creates the update files for the binary at appPath, built
+// for platform, or for every binary in appPath if it is a directory, see
+// directoryBinaries. Subdirectories and hidden files such as .git are
+// skipped.
+func generateRelease(appPath, platform string) error {
+	if err := createBuildDir(); err != nil {
+		return err
+	}
 
 	// If dir is given create update for each file
 	fi, err := os.Stat(appPath)
 	if err != nil {
-		panic(err)
+		return inputError{err}
+	}
+	if !fi.IsDir() {
+		return createUpdates([]platformBinary{{appPath, platform}})
 	}
 
-	if fi.IsDir() {
-		files, err := ioutil.ReadDir(appPath)
-		if err == nil {
-			for _, file := range files {
-				createUpdate(filepath.Join(appPath, file.Name()), file.Name())
-			}
-			os.Exit(0)
+	files, err := ioutil.ReadDir(appPath)
+	if err != nil {
+		return inputError{err}
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
 		}
+		names = append(names, file.Name())
+	}
+	binaries, err := directoryBinaries(appPath, names)
+	if err != nil {
+		return err
 	}
+	if len(binaries) == 0 {
+		return inputError{fmt.Errorf("%s: no binaries found", appPath)}
+	}
+	return createUpdates(binaries)
+}
 
-	createUpdate(appPath, platform)
+func exitWithReport(reportPath string) {
+	if err := finishReport(os.Stdout, reportPath); err != nil {
+		fail(fmt.Errorf("writing report: %w", err))
+	}
+	os.Exit(0)
 }
@@ -1,6 +1,942 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/scrypt"
+)
 
 func TestUpdater(t *testing.T) {
 }
+
+func TestParsePlatforms(t *testing.T) {
+	targets, err := parsePlatforms("linux/amd64, darwin-arm64,,windows/386,linux/arm/6,linux-arm-7-musl,linux/amd64/musl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"linux-amd64", "darwin-arm64", "windows-386", "linux-arm-6", "linux-arm-7-musl", "linux-amd64-musl"}
+	if len(targets) != len(want) {
+		t.Fatalf("got %d targets, want %d", len(targets), len(want))
+	}
+	for i, target := range targets {
+		if target.platform() != want[i] {
+			t.Errorf("target %d is %q, want %q", i, target.platform(), want[i])
+		}
+	}
+
+	if _, err := parsePlatforms("linux"); err == nil {
+		t.Error("expected an error for a platform without an architecture")
+	}
+	for _, p := range []string{"linux/amd64/7", "linux/arm/musl/7", "linux/arm/8"} {
+		if _, err := parsePlatforms(p); err == nil {
+			t.Errorf("expected an error for the variant in %s", p)
+		}
+	}
+	if got := goreleaserPlatform("linux-arm-7-musl"); got != "linux_armv7_musl" {
+		t.Errorf("goreleaser platform %s", got)
+	}
+}
+
+func TestPatchStatSavings(t *testing.T) {
+	s := patchStat{PatchSize: 25, FullSize: 100}
+	if s.Savings() != 75 {
+		t.Errorf("Savings() = %v, want 75", s.Savings())
+	}
+	if (patchStat{}).Savings() != 0 {
+		t.Error("Savings() of an empty full binary should be 0")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{"0": 0, "512": 512, "4k": 4 << 10, "200M": 200 << 20, "2GB": 2 << 30} {
+		got, err := parseSize(in)
+		if err != nil {
+			t.Errorf("parseSize(%q) returned error %v", in, err)
+		}
+		if got != want {
+			t.Errorf("parseSize(%q) = %d, want %d", in, got, want)
+		}
+	}
+	if _, err := parseSize("lots"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+}
+
+func TestCheckPatchFormat(t *testing.T) {
+	for _, ok := range [][2]string{{diffBsdiff, ""}, {diffNone, ""}, {diffBsdiff, diffBsdiff}, {diffXdelta3, diffXdelta3}, {diffZstd, diffZstd}} {
+		if err := checkPatchFormat(ok[0], ok[1]); err != nil {
+			t.Errorf("-diff %s -patch-format %q: %v", ok[0], ok[1], err)
+		}
+	}
+	for _, bad := range [][2]string{{diffXdelta3, ""}, {diffZstd, ""}, {diffZstd, diffXdelta3}, {diffBsdiff, diffZstd}} {
+		if err := checkPatchFormat(bad[0], bad[1]); err == nil {
+			t.Errorf("-diff %s -patch-format %q: expected an error", bad[0], bad[1])
+		}
+	}
+}
+
+func TestLimiterOversizeJob(t *testing.T) {
+	l := newLimiter(2, 100)
+	// a job larger than the budget must still be able to run on its own
+	l.acquire(1000)
+	l.release(1000)
+	l.acquire(60)
+	l.acquire(40)
+	if l.jobs != 2 || l.mem != 100 {
+		t.Errorf("limiter has %d jobs using %d bytes, want 2 jobs using 100 bytes", l.jobs, l.mem)
+	}
+	l.release(60)
+	l.release(40)
+}
+
+func TestSignatureVerifiesInClient(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	signingKey = key
+	defer func() { signingKey = nil }()
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	c := current{
+		Version:   "1.3",
+		Sha256:    make([]byte, 32),
+		Expires:   &expires,
+		Severity:  "critical",
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}
+	sign(&c)
+	manifest, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.2",
+		CmdName:        "myapp",
+		Dir:            "update/",
+		PublicKey:      key.Public().(ed25519.PublicKey),
+		Requester: selfupdate.RequesterFunc(func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		}),
+	}
+	v, err := u.UpdateAvailable()
+	if err != nil {
+		t.Fatalf("client rejected generator signature: %v", err)
+	}
+	if v != "1.3" {
+		t.Errorf("UpdateAvailable() = %q, want 1.3", v)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := bytes.Repeat([]byte("binary"), 1000)
+	for _, e := range compressExtensions {
+		var buf bytes.Buffer
+		if err := compress(e.format, bytes.NewReader(bin), &buf); err != nil {
+			t.Fatalf("%s: %v", e.format, err)
+		}
+		versionDir := filepath.Join(dir, e.format)
+		os.Mkdir(versionDir, 0755)
+		if err := ioutil.WriteFile(filepath.Join(versionDir, "linux-amd64"+e.ext), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		size, err := releaseSize(versionDir, "linux-amd64")
+		if err != nil || size != int64(len(bin)) {
+			t.Errorf("%s: releaseSize() = %d, %v, want %d", e.format, size, err, len(bin))
+		}
+		r, err := openRelease(versionDir, "linux-amd64")
+		if err != nil {
+			t.Fatalf("%s: %v", e.format, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, bin) {
+			t.Errorf("%s: round trip failed: %v", e.format, err)
+		}
+	}
+}
+
+func TestServeHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "linux-amd64.json"), []byte(`{"Version": "1.2"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := serveOptions{dir: dir, auth: "ci"}
+	if _, _, err := opts.handler(nil); err == nil {
+		t.Fatal("expected an error for -auth without password")
+	}
+
+	var log bytes.Buffer
+	opts.auth = "ci:secret"
+	h, _, err := opts.handler(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/linux-amd64.json", nil)
+	req.SetBasicAuth("ci", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"Version": "1.2"}` {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if !bytes.Contains(log.Bytes(), []byte(" - ci [")) {
+		t.Errorf("request not logged: %q", log.String())
+	}
+
+	// clients report without credentials, only reading the stats needs them
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	reporter := selfupdate.HTTPReporter{URL: srv.URL + "/stats"}
+	if err := reporter.Report(selfupdate.UpdateReport{Cmd: "myapp", Platform: "linux-amd64", From: "1.1", To: "1.2"}); err != nil {
+		t.Errorf("report with -auth: %v", err)
+	}
+	for _, auth := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/stats", nil)
+		if auth {
+			req.SetBasicAuth("ci", "secret")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if !auth && rec.Code != http.StatusUnauthorized {
+			t.Errorf("stats without credentials: got %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if auth && (rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"To":"1.2","Count":1`)) {
+			t.Errorf("stats: got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestPatchChainUpdatesClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v, d string, l int) { genDir, version, diffAlgorithm, diffDepth = g, v, d, l }(genDir, version, diffAlgorithm, diffDepth)
+	genDir, diffAlgorithm, diffDepth = filepath.Join(dir, "myapp"), diffBsdiff, 1
+
+	// incompressible, so that patches are cheaper than the full binary
+	base := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(base)
+	bins := map[string][]byte{}
+	for i, v := range []string{"1.0", "1.1", "1.2"} {
+		bins[v] = append(append([]byte{}, base...), byte('0'+i))
+		path := filepath.Join(dir, "bin-"+v)
+		if err := ioutil.WriteFile(path, bins[v], 0755); err != nil {
+			t.Fatal(err)
+		}
+		version = v
+		if err := createUpdate(path, "linux-amd64"); err != nil {
+			t.Fatal(err)
+		}
+		// manifest timestamps have a resolution of a second, spread them out
+		c, err := readReleaseManifest(v, "linux-amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Timestamp = c.Timestamp.Add(time.Duration(i-3) * time.Hour)
+		b, _ := json.Marshal(c)
+		ioutil.WriteFile(releaseManifestPath(v, "linux-amd64"), b, 0644)
+	}
+
+	c, err := readReleaseManifest("1.2", "linux-amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Patches["1.0"]; ok || len(c.PatchChain) != 2 {
+		t.Fatalf("got patches %v and chain %v, want only a chain from 1.0", c.Patches, c.PatchChain)
+	}
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	target := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(target, bins["1.0"], 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.0",
+		ApiURL:         srv.URL + "/",
+		BinURL:         srv.URL + "/",
+		DiffURL:        srv.URL + "/",
+		Dir:            filepath.Join(dir, "state"),
+		CmdName:        "myapp",
+		Platform:       platformResolver("linux-amd64"),
+		Target:         selfupdate.FileResolver(target),
+	}
+	if err := u.Update(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(target)
+	if !bytes.Equal(b, bins["1.2"]) {
+		t.Error("client did not end up with 1.2")
+	}
+	want := []string{"/myapp/linux-amd64.json", "/myapp/1.0/1.1/linux-amd64", "/myapp/1.1/1.2/linux-amd64"}
+	if len(paths) != len(want) {
+		t.Fatalf("client fetched %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("client fetched %v, want %v", paths, want)
+		}
+	}
+}
+
+type platformResolver string
+
+func (p platformResolver) Platform() string { return string(p) }
+
+func TestCreateUpdatesConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v, d string, l *limiter) { genDir, version, diffAlgorithm, limits = g, v, d, l }(genDir, version, diffAlgorithm, limits)
+	genDir, diffAlgorithm, limits = filepath.Join(dir, "public"), diffBsdiff, newLimiter(4, 0)
+
+	platforms := []string{"linux-amd64", "linux-arm64", "darwin-arm64", "windows-amd64"}
+	for _, v := range []string{"1.0", "1.1"} {
+		version = v
+		var binaries []platformBinary
+		for _, p := range platforms {
+			path := filepath.Join(dir, p+"-"+v)
+			if err := ioutil.WriteFile(path, []byte(p+" executable "+v), 0755); err != nil {
+				t.Fatal(err)
+			}
+			binaries = append(binaries, platformBinary{path, p})
+		}
+		if err := createUpdates(binaries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range platforms {
+		c, err := readReleaseManifest("1.1", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, err := generateSha256(filepath.Join(dir, p+"-1.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c.Sha256, sum) {
+			t.Errorf("%s: manifest has the wrong hash", p)
+		}
+		if _, ok := c.Patches["1.0"]; !ok {
+			t.Errorf("%s: no patch from 1.0", p)
+		}
+	}
+}
+
+func TestSetRollout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v, d string, l *limiter, k ed25519.PrivateKey, r int) {
+		genDir, version, diffAlgorithm, limits, signingKey, rolloutPercent = g, v, d, l, k, r
+	}(genDir, version, diffAlgorithm, limits, signingKey, rolloutPercent)
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	genDir, version, diffAlgorithm, limits = filepath.Join(dir, "public"), "1.0", diffBsdiff, newLimiter(1, 0)
+	signingKey, rolloutPercent = key, 10
+
+	path := filepath.Join(dir, "linux-amd64")
+	if err := ioutil.WriteFile(path, []byte("executable"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := createUpdates([]platformBinary{{path, "linux-amd64"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	signingKey = nil
+	if _, err := setRollout(genDir, 50); exitCode(err) != exitBadInput {
+		t.Errorf("signed manifests changed without a key: %v", err)
+	}
+	signingKey = key
+	if _, err := setRollout(genDir, 101); exitCode(err) != exitBadInput {
+		t.Errorf("invalid percentage accepted: %v", err)
+	}
+	platforms, err := setRollout(genDir, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(platforms) != 1 || platforms[0] != "linux-amd64" {
+		t.Errorf("unexpected platforms %v", platforms)
+	}
+	c, err := readReleaseManifest("1.0", "linux-amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RolloutPercent != 50 {
+		t.Errorf("release manifest has rollout %d, want 50", c.RolloutPercent)
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(genDir, "linux-amd64.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &selfupdate.Updater{
+		CurrentVersion: "0.9",
+		CmdName:        "myapp",
+		State:          &selfupdate.MemoryStore{},
+		PublicKey:      key.Public().(ed25519.PublicKey),
+		Requester: selfupdate.RequesterFunc(func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		}),
+	}
+	if _, err := u.UpdateAvailable(); err != nil {
+		t.Errorf("client rejected the signature of the rollout: %v", err)
+	}
+
+	// fence the release off from older versions, for everyone
+	if _, err := setRollout(genDir, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retarget(genDir, func(c *current) { c.UpdateFrom = ">=1.0" }); err != nil {
+		t.Fatal(err)
+	}
+	if manifest, err = ioutil.ReadFile(filepath.Join(genDir, "linux-amd64.json")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := u.UpdateAvailable()
+	if err != nil {
+		t.Errorf("client rejected the signature of the targeting rules: %v", err)
+	}
+	if v != "" {
+		t.Errorf("version %s offered to 0.9 despite UpdateFrom >=1.0", v)
+	}
+	if err := checkConstraint("~>1.0"); err == nil {
+		t.Error("unknown operator accepted")
+	}
+
+	// pausing doesn't touch the signature, so it needs no key
+	signingKey = nil
+	if _, err := retarget(genDir, func(c *current) { c.UpdateFrom, c.Paused = "", true }); exitCode(err) != exitBadInput {
+		t.Errorf("signed fields changed without a key: %v", err)
+	}
+	if _, err := retarget(genDir, func(c *current) { c.Paused = true }); err != nil {
+		t.Fatal(err)
+	}
+	if manifest, err = ioutil.ReadFile(filepath.Join(genDir, "linux-amd64.json")); err != nil {
+		t.Fatal(err)
+	}
+	u.CurrentVersion = "1.1"
+	v, err = u.UpdateAvailable()
+	if err != nil {
+		t.Errorf("client rejected the signature of the paused release: %v", err)
+	}
+	if v != "" {
+		t.Errorf("paused version %s offered", v)
+	}
+}
+
+func TestPruneReleases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v string, k int) { genDir, version, keepReleases = g, v, k }(genDir, version, keepReleases)
+	genDir, version, keepReleases = dir, "1.10", 2
+
+	// releases are ordered by when they were published, not by name
+	now := time.Now()
+	for i, v := range []string{"1.8", "1.9", "1.7"} {
+		os.Mkdir(filepath.Join(dir, v), 0755)
+		b, _ := json.Marshal(current{Version: v, Timestamp: now.Add(time.Duration([]int{2, 3, 1}[i]) * time.Hour)})
+		if err := ioutil.WriteFile(releaseManifestPath(v, "linux-amd64"), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneReleases(); err != nil {
+		t.Fatal(err)
+	}
+	left, err := releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0] != "1.9" {
+		t.Errorf("kept %v, want [1.9]", left)
+	}
+}
+
+func TestVerifyTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v, d string) { genDir, version, diffAlgorithm = g, v, d }(genDir, version, diffAlgorithm)
+	genDir, diffAlgorithm = filepath.Join(dir, "public"), diffBsdiff
+
+	for _, v := range []string{"1.0", "1.1"} {
+		version = v
+		path := filepath.Join(dir, "bin-"+v)
+		if err := ioutil.WriteFile(path, []byte("executable "+v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := createUpdates([]platformBinary{{path, "linux-amd64"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := verifyTree(genDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.failures) != 0 || len(r.warnings) != 0 {
+		t.Fatalf("fresh tree failed verification: %v %v", r.failures, r.warnings)
+	}
+
+	os.Mkdir(filepath.Join(genDir, "0.9"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(genDir, "1.0", "1.1", "linux-amd64"), []byte("not a patch"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = verifyTree(genDir); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.failures) != 1 || !strings.Contains(r.failures[0], "patch 1.0 -> 1.1") {
+		t.Errorf("got failures %v, want one for the patch", r.failures)
+	}
+	if len(r.warnings) != 1 || !strings.Contains(r.warnings[0], "0.9") {
+		t.Errorf("got warnings %v, want one for 0.9", r.warnings)
+	}
+}
+
+func TestSimulateUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v, d string) { genDir, version, diffAlgorithm = g, v, d }(genDir, version, diffAlgorithm)
+	genDir, diffAlgorithm = filepath.Join(dir, "public"), diffBsdiff
+
+	for _, v := range []string{"1.0", "1.1", "1.2"} {
+		version = v
+		path := filepath.Join(dir, "bin-"+v)
+		if err := ioutil.WriteFile(path, []byte("executable "+v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := createUpdates([]platformBinary{{path, "linux-amd64"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a well-formed patch producing the wrong binary, which only the hash
+	// catches
+	var patch bytes.Buffer
+	if err := createPatch(diffBsdiff, strings.NewReader("executable 1.1"), strings.NewReader("executable 1.3"), &patch); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(genDir, "1.1", "1.2", "linux-amd64"), patch.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := readManifests(genDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := manifests["linux-amd64"]
+	if !ok || c.Version != "1.2" {
+		t.Fatalf("got manifests %v, want linux-amd64 at 1.2", manifests)
+	}
+	if err := verifyFullBinary(genDir, "linux-amd64", c); err != nil {
+		t.Errorf("full binary: %v", err)
+	}
+	if err := simulateUpdate(genDir, "linux-amd64", "1.0", c); err != nil {
+		t.Errorf("good patch 1.0 -> 1.2: %v", err)
+	}
+	if err := simulateUpdate(genDir, "linux-amd64", "1.1", c); err == nil || !strings.Contains(err.Error(), "does not match manifest hash") {
+		t.Errorf("corrupted patch 1.1 -> 1.2: got %v, want a hash mismatch", err)
+	}
+}
+
+func TestGenerateReleaseErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(g, v, d string, l *limiter) { genDir, version, diffAlgorithm, limits = g, v, d, l }(genDir, version, diffAlgorithm, limits)
+	genDir, diffAlgorithm, limits = filepath.Join(dir, "public"), diffBsdiff, newLimiter(1, 0)
+
+	// a binaries directory that is also a git checkout
+	bins := filepath.Join(dir, "bins")
+	os.MkdirAll(filepath.Join(bins, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(bins, ".gitignore"), []byte("*.tmp"), 0644)
+	ioutil.WriteFile(filepath.Join(bins, "linux-amd64"), []byte("executable 1.0"), 0755)
+	version = "1.0"
+	if err := generateRelease(bins, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(genDir, ".git.json")); !os.IsNotExist(err) {
+		t.Errorf("release created for .git")
+	}
+
+	err = generateRelease(filepath.Join(dir, "missing"), "linux-amd64")
+	if code := exitCode(err); code != exitBadInput {
+		t.Errorf("missing binary: exit code %d, want %d", code, exitBadInput)
+	}
+
+	ioutil.WriteFile(filepath.Join(bins, "linux-amd64"), []byte("executable 1.1"), 0755)
+	version, diffAlgorithm = "1.1", "unknown"
+	err = generateRelease(bins, "")
+	if code := exitCode(err); code != exitDiffError {
+		t.Errorf("failed diff: exit code %d, want %d", code, exitDiffError)
+	}
+	if !strings.Contains(err.Error(), "linux-amd64: creating patch from 1.0 to 1.1") {
+		t.Errorf("error without context: %v", err)
+	}
+}
+
+func TestDirectoryBinaries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string) { namePattern = p }(namePattern)
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := runtime.GOOS + "-" + runtime.GOARCH
+
+	write := func(files map[string][]byte) []string {
+		os.RemoveAll(dir)
+		os.Mkdir(dir, 0755)
+		var names []string
+		for name, b := range files {
+			ioutil.WriteFile(filepath.Join(dir, name), b, 0755)
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	platforms := func(binaries []platformBinary) string {
+		var p []string
+		for _, b := range binaries {
+			p = append(p, filepath.Base(b.path)+"="+b.platform)
+		}
+		return strings.Join(p, ",")
+	}
+
+	// named after their platform or detected from the header
+	namePattern = ""
+	names := write(map[string][]byte{"myapp": self, "linux-arm64": []byte("executable"), "windows-amd64.exe": []byte("MZ"), "README.md": []byte("# myapp")})
+	binaries, err := directoryBinaries(dir, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := platforms(binaries), "linux-arm64=linux-arm64,myapp="+host+",windows-amd64.exe=windows-amd64"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// the name and the header have to agree
+	names = write(map[string][]byte{"plan9-386": self})
+	if _, err := directoryBinaries(dir, names); exitCode(err) != exitBadInput {
+		t.Errorf("mismatched platform accepted: %v", err)
+	}
+
+	namePattern = "myapp-{{os}}-{{arch}}"
+	names = write(map[string][]byte{"myapp-windows-amd64.exe": []byte("MZ"), "myapp-" + host: self, "myapp.sha256": []byte("")})
+	binaries, err = directoryBinaries(dir, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := platforms(binaries), "myapp-"+host+"="+host+",myapp-windows-amd64.exe=windows-amd64"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	entries, err := parseConfig(strings.NewReader(`
+# nightly builds
+package = "./cmd/myapp"
+platforms = ["linux/amd64", 'darwin/arm64',]
+output = "dist # not a comment"
+jobs = 4
+extra = ["helper-{platform}", "completions.bash"]
+channel = "nightly"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var opts generatorOptions
+	opts.register(fs)
+	platforms := fs.String("platforms", "", "")
+	job, err := applyConfig(fs, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.pkg != "./cmd/myapp" || job.channel != "nightly" {
+		t.Errorf("got job %+v", job)
+	}
+	if *platforms != "linux/amd64,darwin/arm64" || opts.output != "dist # not a comment" || opts.jobs != 4 || len(opts.extra) != 2 {
+		t.Errorf("got platforms %q and options %+v", *platforms, opts)
+	}
+
+	for _, bad := range []string{"[release]", "jobs = lots", "output = \"a\"\noutput = \"b\"", "colour = \"blue\"", "package = \"a\"\nbinaries = \"b\""} {
+		entries, err := parseConfig(strings.NewReader(bad))
+		if err == nil {
+			_, err = applyConfig(flag.NewFlagSet("test", flag.ContinueOnError), entries)
+		}
+		if err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+// writeMinisignKey writes a minisign secret key for key with ID id to path,
+// encrypted with password unless it is empty.
+func writeMinisignKey(t *testing.T, path string, id []byte, key ed25519.PrivateKey, password string, ops, mem uint64) {
+	keynum := append(append([]byte(nil), id...), key...)
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte("Ed"))
+	h.Write(keynum)
+	keynum = h.Sum(keynum)
+	kdf := "\x00\x00"
+	salt := bytes.Repeat([]byte{7}, 32)
+	if password != "" {
+		kdf = "Sc"
+		logN, r, p := scryptParams(ops, mem)
+		stream, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(keynum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	}
+	raw := append([]byte("Ed"+kdf+"B2"), salt...)
+	raw = append(raw, make([]byte, 16)...)
+	binary.LittleEndian.PutUint64(raw[38:], ops)
+	binary.LittleEndian.PutUint64(raw[46:], mem)
+	raw = append(raw, keynum...)
+	b := "untrusted comment: minisign encrypted secret key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+	if err := ioutil.WriteFile(path, []byte(b), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinisignVerifiesInClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	id := []byte("keyid123")
+	pub := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), key.Public().(ed25519.PublicKey)...))
+
+	// the limits minisign stores by default mean scrypt with N=2^20
+	logN, r, p := scryptParams(1<<25, 1<<30)
+	if logN != 20 || r != 8 || p != 1 {
+		t.Errorf("scryptParams = %d, %d, %d, want 20, 8, 1", logN, r, p)
+	}
+	keyPath := filepath.Join(dir, "minisign.key")
+	writeMinisignKey(t, keyPath, id, key, "secret", 1<<16, 1<<22)
+	if _, err := readMinisignKey(keyPath, "wrong"); err == nil {
+		t.Error("expected an error for the wrong password")
+	}
+	sk, err := readMinisignKey(keyPath, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMinisignKey(t, keyPath, id, key, "", 0, 0)
+	if _, err := readMinisignKey(keyPath, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(g, v, d string, l *limiter, k *minisignSecretKey) {
+		genDir, version, diffAlgorithm, limits, minisignKey = g, v, d, l, k
+	}(genDir, version, diffAlgorithm, limits, minisignKey)
+	genDir, diffAlgorithm, limits, minisignKey = filepath.Join(dir, "public"), diffBsdiff, newLimiter(1, 0), sk
+	for _, v := range []string{"1.0", "1.1"} {
+		version = v
+		path := filepath.Join(dir, "linux-amd64-"+v)
+		if err := ioutil.WriteFile(path, []byte("executable "+v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := createUpdates([]platformBinary{{path, "linux-amd64"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := readReleaseManifest("1.1", "linux-amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := selfupdate.MinisignVerifier{PublicKey: pub}
+	if err := v.Verify(strings.NewReader("executable 1.1"), selfupdate.VersionInfo{Minisig: c.Minisig}); err != nil {
+		t.Errorf("client rejected the signature of the executable: %v", err)
+	}
+	for _, name := range []string{"1.1/linux-amd64.gz", "1.0/1.1/linux-amd64"} {
+		sig, err := ioutil.ReadFile(filepath.Join(genDir, name+".minisig"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(filepath.Join(genDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = v.Verify(f, selfupdate.VersionInfo{Minisig: string(sig)})
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: client rejected the signature: %v", name, err)
+		}
+	}
+}
+
+func TestGPGSignatureVerifiesInClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e, err := openpgp.NewEntity("Publisher", "", "publisher@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var private, public bytes.Buffer
+	w, _ := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	e.SerializePrivate(w, nil)
+	w.Close()
+	w, _ = armor.Encode(&public, openpgp.PublicKeyType, nil)
+	e.Serialize(w)
+	w.Close()
+	keyPath := filepath.Join(dir, "key.asc")
+	if err := ioutil.WriteFile(keyPath, private.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := readGPGKey(keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(g, v string, k *openpgp.Entity) { genDir, version, gpgKey = g, v, k }(genDir, version, gpgKey)
+	genDir, version, gpgKey = filepath.Join(dir, "public"), "1.3", key
+	if err := os.MkdirAll(filepath.Join(genDir, "1.3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManifest("linux-amd64", current{Version: "1.3", Sha256: make([]byte, 32)}); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         "http://updates.example.com/",
+		CmdName:        "myapp",
+		GPGKeyring:     public.Bytes(),
+		Requester: selfupdate.RequesterFunc(func(url string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(genDir, strings.TrimPrefix(url, "http://updates.example.com/myapp/")))
+		}),
+	}
+	v, err := u.CheckRemoteVersion(context.Background())
+	if err != nil {
+		t.Fatalf("client rejected generator signature: %v", err)
+	}
+	if v != "1.3" {
+		t.Errorf("CheckRemoteVersion() = %q, want 1.3", v)
+	}
+
+	// changing a GPG signed manifest takes the key
+	gpgKey = nil
+	if _, err := retarget(genDir, func(c *current) { c.Paused = true }); err == nil {
+		t.Error("expected retargeting GPG signed manifests without the key to fail")
+	}
+}
+
+func TestRotateKeysVerifiesInClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	newKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	if err := rotateKeys(dir, []ed25519.PrivateKey{oldKey}, newKey, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(g, v string, k ed25519.PrivateKey) { genDir, version, signingKey = g, v, k }(genDir, version, signingKey)
+	genDir, version, signingKey = dir, "1.3", newKey
+	if err := os.MkdirAll(filepath.Join(dir, "1.3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManifest("linux-amd64", current{Version: "1.3", Sha256: make([]byte, 32), Timestamp: time.Now().UTC().Truncate(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         "http://updates.example.com/",
+		CmdName:        "myapp",
+		State:          &selfupdate.MemoryStore{},
+		PublicKey:      oldKey.Public().(ed25519.PublicKey),
+		KeyManifest:    keyManifestFile,
+		Requester: selfupdate.RequesterFunc(func(url string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(dir, strings.TrimPrefix(url, "http://updates.example.com/myapp/")))
+		}),
+	}
+	v, err := u.CheckRemoteVersion(context.Background())
+	if err != nil {
+		t.Fatalf("client rejected the rotated key: %v", err)
+	}
+	if v != "1.3" {
+		t.Errorf("CheckRemoteVersion() = %q, want 1.3", v)
+	}
+
+	// the key manifest is no platform manifest
+	r, err := verifyTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.failures {
+		if strings.Contains(f, "keys") {
+			t.Errorf("verify took the key manifest for a manifest: %s", f)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// minisignKey signs every published file with minisign when set.
+var minisignKey *minisignSecretKey
+
+// minisignSecretKey is a minisign or signify secret key.
+type minisignSecretKey struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+// readMinisignKey reads the minisign secret key at path, decrypting it with
+// password if it is encrypted. Unencrypted signify secret keys, as created
+// by signify -G -n, are read too.
+func readMinisignKey(path, password string) (*minisignSecretKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := decodeMinisignLine(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var sk *minisignSecretKey
+	switch {
+	case len(raw) == 158 && string(raw[:2]) == "Ed":
+		sk, err = decodeMinisignSecretKey(raw, password)
+	case len(raw) == 104 && string(raw[:4]) == "EdBK":
+		sk, err = decodeSignifySecretKey(raw)
+	default:
+		err = errors.New("not a minisign or signify secret key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sk, nil
+}
+
+// decodeMinisignLine returns the base64 decoded first line of b that isn't
+// a comment.
+func decodeMinisignLine(b []byte) ([]byte, error) {
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	return nil, errors.New("no key found")
+}
+
+// decodeMinisignSecretKey decodes a minisign secret key: the signature,
+// key derivation and checksum algorithms, the scrypt salt and limits, and
+// the key ID, key and checksum, encrypted with the scrypt derived key unless
+// the key derivation algorithm is none.
+func decodeMinisignSecretKey(raw []byte, password string) (*minisignSecretKey, error) {
+	kdf, salt := string(raw[2:4]), raw[6:38]
+	ops, mem := binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54])
+	keynum := append([]byte(nil), raw[54:]...)
+	switch kdf {
+	case "\x00\x00":
+	case "Sc":
+		if password == "" {
+			return nil, errors.New("key is encrypted, set its password in GO_SELFUPDATE_SIGN_KEY_PASSWORD")
+		}
+		logN, r, p := scryptParams(ops, mem)
+		stream, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(keynum))
+		if err != nil {
+			return nil, err
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	default:
+		return nil, fmt.Errorf("unknown key derivation %q", kdf)
+	}
+	sk := &minisignSecretKey{key: ed25519.PrivateKey(keynum[8:72])}
+	copy(sk.id[:], keynum[:8])
+	h, _ := blake2b.New256(nil)
+	h.Write(raw[:2])
+	h.Write(keynum[:72])
+	if subtle.ConstantTimeCompare(h.Sum(nil), keynum[72:]) != 1 {
+		return nil, errors.New("wrong password or corrupt key")
+	}
+	return sk, nil
+}
+
+// decodeSignifySecretKey decodes an unencrypted signify secret key: the
+// signature and key derivation algorithms, the rounds and salt of the key
+// derivation, a checksum, the key ID and the key.
+func decodeSignifySecretKey(raw []byte) (*minisignSecretKey, error) {
+	if binary.BigEndian.Uint32(raw[4:8]) != 0 {
+		return nil, errors.New("encrypted signify keys are not supported, create one with signify -G -n")
+	}
+	sk := &minisignSecretKey{key: ed25519.PrivateKey(append([]byte(nil), raw[40:104]...))}
+	copy(sk.id[:], raw[32:40])
+	sum := sha512.Sum512(sk.key)
+	if !bytes.Equal(sum[:8], raw[24:32]) {
+		return nil, errors.New("corrupt key")
+	}
+	return sk, nil
+}
+
+// scryptParams returns the scrypt parameters libsodium derives from the
+// operations and memory limits that minisign stores with encrypted keys.
+func scryptParams(ops, mem uint64) (logN uint, r, p int) {
+	if ops < 32768 {
+		ops = 32768
+	}
+	r = 8
+	var maxN uint64
+	if ops < mem/32 {
+		p = 1
+		maxN = ops / uint64(r*4)
+	} else {
+		maxN = mem / uint64(r*128)
+	}
+	for logN = 1; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if ops >= mem/32 {
+		maxrp := (ops / 4) / (uint64(1) << logN)
+		if maxrp > 0x3fffffff {
+			maxrp = 0x3fffffff
+		}
+		p = int(maxrp) / r
+	}
+	return logN, r, p
+}
+
+// minisign returns a minisign signature of everything read from r, named
+// name in its trusted comment. The content is signed prehashed with
+// BLAKE2b-512, as minisign does by default.
+func (sk *minisignSecretKey) minisign(r io.Reader, name string) (string, error) {
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(sk.key, h.Sum(nil))
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), name)
+	global := ed25519.Sign(sk.key, append(append([]byte(nil), sig...), comment...))
+	line := append(append([]byte("ED"), sk.id[:]...), sig...)
+	return "untrusted comment: signature from go-selfupdate secret key\n" +
+		base64.StdEncoding.EncodeToString(line) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n", nil
+}
+
+// minisignExecutable returns the minisign signature of the executable at
+// path for the manifest, or "" if no minisign key is configured.
+func minisignExecutable(path string) (string, error) {
+	if minisignKey == nil {
+		return "", nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", inputError{err}
+	}
+	defer f.Close()
+	return minisignKey.minisign(f, filepath.Base(path))
+}
+
+// minisignFile writes the minisign signature of the published file at path
+// next to it, with .minisig appended to its name, if a minisign key is
+// configured.
+func minisignFile(path string) error {
+	if minisignKey == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sig, err := minisignKey.minisign(f, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".minisig", []byte(sig), 0644)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Naming schemes for the full binaries.
+const (
+	namingDefault    = "default"    // <version>/<os>-<arch>.gz
+	namingGoreleaser = "goreleaser" // <version>/<name>_<version>_<os>_<arch>.tar.gz
+)
+
+// namingScheme is how full binaries are named, releaseName the name of the
+// application used by namingGoreleaser.
+var namingScheme = namingDefault
+var releaseName string
+
+func validNamingScheme(scheme string) bool {
+	return scheme == namingDefault || scheme == namingGoreleaser
+}
+
+// defaultReleaseName returns the application name for the binary or package
+// at path, used when -name isn't given.
+func defaultReleaseName(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return strings.TrimSuffix(filepath.Base(path), ".exe")
+}
+
+// goreleaserFile returns the name goreleaser gives the archive of name at
+// version for platform.
+func goreleaserFile(name, version, platform string) string {
+	return name + "_" + strings.TrimPrefix(version, "v") + "_" + goreleaserPlatform(platform) + ".tar.gz"
+}
+
+// writeTarGz writes a tar.gz archive holding the size bytes of r as the
+// executable name.
+func writeTarGz(name string, r io.Reader, size int64, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0755,
+		Size:    size,
+		ModTime: time.Now().Truncate(time.Second),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// goreleaserPlatform returns platform the way goreleaser names it: os and
+// arch separated by an underscore and the ARM version attached as in armv7.
+func goreleaserPlatform(platform string) string {
+	t, err := parseTarget(platform)
+	if err != nil {
+		return platform
+	}
+	name := t.goos + "_" + t.goarch
+	if t.goarm != "" {
+		name += "v" + t.goarm
+	}
+	if t.libc != "" {
+		name += "_" + t.libc
+	}
+	return name
+}
+
+// fullBinaryFile returns the name of the full binary for platform in the
+// configured naming scheme and the manifest's Archive and Compression fields
+// for it.
+func fullBinaryFile(platform string) (file, archiveFormat, manifestComp string) {
+	if namingScheme == namingGoreleaser {
+		return goreleaserFile(releaseName, version, platform), "tar.gz", ""
+	}
+	return platform + compressExtension(compression), "", manifestCompression(compression)
+}
+
+// writeFullBinary writes the full binary of the size byte executable r for
+// platform in the configured naming scheme to w.
+func writeFullBinary(platform string, r io.Reader, size int64, w io.Writer) error {
+	if namingScheme == namingGoreleaser {
+		exe := releaseName
+		if strings.HasPrefix(platform, "windows-") {
+			exe += ".exe"
+		}
+		return writeTarGz(exe, r, size, w)
+	}
+	return compress(compression, r, w)
+}
+
+// openGoreleaserRelease returns the executable in the goreleaser style
+// archive for platform in the version directory dir.
+func openGoreleaserRelease(dir, platform string) (io.ReadCloser, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*_"+goreleaserPlatform(platform)+".tar.gz"))
+	if err != nil || len(matches) == 0 {
+		return nil, fmt.Errorf("no full binary for %s in %s: %w", platform, dir, os.ErrNotExist)
+	}
+	f, err := os.Open(matches[0])
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			f.Close()
+			if err == io.EOF {
+				err = fmt.Errorf("%s holds no executable", matches[0])
+			}
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return &decompressReader{Reader: tr, f: f}, nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// generatorOptions holds the flags shared by every command that writes an
+// update tree.
+type generatorOptions struct {
+	output   string
+	diff     string
+	format   string
+	report   string
+	jobs     int
+	mem      string
+	expires  time.Duration
+	severity string
+	key      string
+	signKey  string
+	gpgKey   string
+	compress string
+	notes    string
+	minimum  string
+	url      string
+	naming   string
+	name     string
+	extra    stringList
+	pattern  string
+	rollout  int
+	from     string
+	skip     stringList
+	depth    int
+	keep     int
+}
+
+func (o *generatorOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.output, "o", "public", "Output directory for writing updates")
+	fs.StringVar(&o.diff, "diff", diffBsdiff, "Diff algorithm used for patches: bsdiff, xdelta3, zstd or none. xdelta3 and zstd also need -patch-format")
+	fs.StringVar(&o.format, "patch-format", "", "Confirm that clients can apply patches of the -diff algorithm, required for xdelta3 and zstd which clients only apply with a Patcher registered in Updater.Patchers")
+	fs.StringVar(&o.report, "report", "", "Write the patch size report as JSON to this file")
+	fs.IntVar(&o.depth, "diff-depth", 0, "Only create patches from this many of the most recent releases, older ones update through a chain of patches. 0 means all")
+	fs.IntVar(&o.keep, "keep", 0, "Delete all but this many of the most recent releases, including the new one. 0 keeps all")
+	fs.IntVar(&o.jobs, "j", 1, "Number of patches to generate concurrently")
+	fs.StringVar(&o.mem, "mem", "0", "Approximate memory limit for concurrent patch generation, e.g. 4G. 0 means no limit")
+	fs.DurationVar(&o.expires, "expires", 0, "Time after which clients reject the manifest, e.g. 720h. 0 means it never expires")
+	fs.StringVar(&o.severity, "severity", "", "Severity of the release. \"critical\" marks a critical security fix that clients may install immediately")
+	fs.StringVar(&o.compress, "compress", compressGzip, "Compression of full binaries: gzip, zstd or xz. Clients before zstd and xz support need gzip")
+	fs.StringVar(&o.notes, "notes", "", "File with the release notes to include in the manifest")
+	fs.IntVar(&o.rollout, "rollout", 0, "Offer the release to this percentage of installations only, raise it later with the rollout command. 0 means all")
+	fs.StringVar(&o.from, "update-from", "", "Only offer the release to installations whose version meets this constraint, e.g. \">=1.4.0, <2\"")
+	fs.Var(&o.skip, "skip-platform", "Don't offer the release to installations on this platform, e.g. darwin-arm64. May be repeated")
+	fs.StringVar(&o.minimum, "min-version", "", "Oldest version still supported, clients may require an update from older ones")
+	fs.StringVar(&o.url, "url", "", "Base URL the output directory is served at, download URLs are added to the manifest when set")
+	fs.StringVar(&o.naming, "naming", namingDefault, "Naming of full binaries: default for <os>-<arch>.gz or goreleaser for <name>_<version>_<os>_<arch>.tar.gz")
+	fs.StringVar(&o.name, "name", "", "Application name used by -naming goreleaser, defaults to the name of the binary or package")
+	fs.StringVar(&o.pattern, "pattern", "", "Names of the binaries in a directory, e.g. myapp-{{os}}-{{arch}}. By default they are named after their platform or it is read from their header")
+	fs.Var(&o.extra, "extra", "File published with every release besides the binary, {platform} is replaced with the platform. May be repeated")
+	fs.StringVar(&o.key, "key", "", "Sign manifests with the Ed25519 private key in this file, see the keygen command")
+	fs.StringVar(&o.gpgKey, "gpg-key", "", "Sign manifests with the OpenPGP private key in this file, writing detached .asc signatures. An encrypted key's passphrase is read from GO_SELFUPDATE_GPG_PASSPHRASE")
+	fs.StringVar(&o.signKey, "sign-key", "", "Sign published files with the minisign or signify secret key in this file, writing .minisig files. An encrypted key's password is read from GO_SELFUPDATE_SIGN_KEY_PASSWORD")
+}
+
+// apply validates the options and sets up the generator with them.
+func (o *generatorOptions) apply() error {
+	if !validDiffAlgorithm(o.diff) {
+		return fmt.Errorf("unknown diff algorithm %q", o.diff)
+	}
+	if err := checkPatchFormat(o.diff, o.format); err != nil {
+		return err
+	}
+	if !validCompression(o.compress) {
+		return fmt.Errorf("unknown compression %q", o.compress)
+	}
+	if !validNamingScheme(o.naming) {
+		return fmt.Errorf("unknown naming scheme %q", o.naming)
+	}
+	if o.pattern != "" {
+		if _, err := compilePattern(o.pattern); err != nil {
+			return err
+		}
+	}
+	maxMem, err := parseSize(o.mem)
+	if err != nil {
+		return err
+	}
+	if o.depth < 0 {
+		return fmt.Errorf("invalid diff depth %d", o.depth)
+	}
+	if o.keep < 0 {
+		return fmt.Errorf("invalid number of releases to keep %d", o.keep)
+	}
+	if !validRollout(o.rollout) {
+		return fmt.Errorf("invalid rollout percentage %d", o.rollout)
+	}
+	if err := checkConstraint(o.from); err != nil {
+		return err
+	}
+	if o.expires < 0 {
+		return fmt.Errorf("invalid expiry %s", o.expires)
+	}
+	if o.key != "" {
+		key, err := readSigningKey(o.key)
+		if err != nil {
+			return err
+		}
+		signingKey = key
+	}
+	if o.gpgKey != "" {
+		key, err := readGPGKey(o.gpgKey, os.Getenv("GO_SELFUPDATE_GPG_PASSPHRASE"))
+		if err != nil {
+			return err
+		}
+		gpgKey = key
+	}
+	if o.signKey != "" {
+		key, err := readMinisignKey(o.signKey, os.Getenv("GO_SELFUPDATE_SIGN_KEY_PASSWORD"))
+		if err != nil {
+			return err
+		}
+		minisignKey = key
+	}
+
+	if o.notes != "" {
+		notes, err := ioutil.ReadFile(o.notes)
+		if err != nil {
+			return err
+		}
+		releaseNotes = string(notes)
+	}
+	if o.url != "" && !strings.HasSuffix(o.url, "/") {
+		o.url += "/"
+	}
+
+	genDir = o.output
+	minimumVersion = o.minimum
+	rolloutPercent = o.rollout
+	updateFrom = o.from
+	skipPlatforms = o.skip
+	downloadURL = o.url
+	diffAlgorithm = o.diff
+	compression = o.compress
+	namingScheme = o.naming
+	releaseName = o.name
+	extraFiles = o.extra
+	namePattern = o.pattern
+	diffDepth = o.depth
+	keepReleases = o.keep
+	manifestExpiry = o.expires
+	releaseSeverity = o.severity
+	limits = newLimiter(o.jobs, maxMem)
+	return nil
+}
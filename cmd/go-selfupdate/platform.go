@@ -0,0 +1,252 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// namePattern maps the file names in a directory of binaries to platforms,
+// e.g. "myapp-{{os}}-{{arch}}". When empty the names have to be platforms.
+var namePattern string
+
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "illumos": true, "ios": true, "js": true, "linux": true,
+	"netbsd": true, "openbsd": true, "plan9": true, "solaris": true,
+	"wasip1": true, "windows": true,
+}
+
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+	"mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+	"ppc64": true, "ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+}
+
+// errNotExecutable is returned by binaryPlatform for files that are not
+// ELF, Mach-O or PE executables.
+var errNotExecutable = errors.New("not an executable")
+
+// isPlatform reports whether name is a platform such as linux-amd64 or
+// linux-arm-7.
+func isPlatform(name string) bool {
+	t, err := parseTarget(name)
+	return err == nil && !strings.Contains(name, "/") && knownOS[t.goos] && knownArch[t.goarch]
+}
+
+// basePlatform returns platform without its variants, which can't be told
+// from the executable.
+func basePlatform(platform string) string {
+	if t, err := parseTarget(platform); err == nil {
+		return t.goos + "-" + t.goarch
+	}
+	return platform
+}
+
+// platformName returns the platform of a binary named after it. Windows
+// binaries may keep their .exe suffix.
+func platformName(name string) string {
+	if p := strings.TrimSuffix(name, ".exe"); strings.HasPrefix(p, "windows-") {
+		return p
+	}
+	return name
+}
+
+// compilePattern turns a -pattern template into a regular expression with
+// the groups os and arch. A ".exe" suffix is always allowed so that one
+// pattern covers the Windows binaries too.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.Contains(pattern, "{{os}}") || !strings.Contains(pattern, "{{arch}}") {
+		return nil, fmt.Errorf("pattern %q needs both {{os}} and {{arch}}", pattern)
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, regexp.QuoteMeta("{{os}}"), `(?P<os>[a-z0-9]+)`, 1)
+	expr = strings.Replace(expr, regexp.QuoteMeta("{{arch}}"), `(?P<arch>[a-z0-9]+)`, 1)
+	return regexp.Compile("^" + expr + `(?:\.exe)?$`)
+}
+
+// patternPlatform returns the platform of the file name according to the
+// pattern, or false if the name doesn't match it.
+func patternPlatform(re *regexp.Regexp, name string) (string, bool) {
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	return m[re.SubexpIndex("os")] + "-" + m[re.SubexpIndex("arch")], true
+}
+
+// binaryPlatform reads the header of the executable at path and returns the
+// platform it was built for.
+func binaryPlatform(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return "", errNotExecutable
+	}
+	switch {
+	case string(magic) == elf.ELFMAG:
+		return elfPlatform(f)
+	case magic[0] == 'M' && magic[1] == 'Z':
+		return pePlatform(f)
+	case isMachO(magic):
+		return machoPlatform(f)
+	}
+	return "", errNotExecutable
+}
+
+func isMachO(magic []byte) bool {
+	for _, m := range []uint32{macho.Magic32, macho.Magic64} {
+		be := []byte{byte(m >> 24), byte(m >> 16), byte(m >> 8), byte(m)}
+		le := []byte{be[3], be[2], be[1], be[0]}
+		if string(magic) == string(be) || string(magic) == string(le) {
+			return true
+		}
+	}
+	return false
+}
+
+func elfPlatform(r io.ReaderAt) (string, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return "", err
+	}
+	goos := "linux"
+	switch f.OSABI {
+	case elf.ELFOSABI_FREEBSD:
+		goos = "freebsd"
+	case elf.ELFOSABI_NETBSD:
+		goos = "netbsd"
+	case elf.ELFOSABI_OPENBSD:
+		goos = "openbsd"
+	case elf.ELFOSABI_SOLARIS:
+		goos = "solaris"
+	}
+	little := f.Data == elf.ELFDATA2LSB
+	var goarch string
+	switch f.Machine {
+	case elf.EM_X86_64:
+		goarch = "amd64"
+	case elf.EM_386:
+		goarch = "386"
+	case elf.EM_AARCH64:
+		goarch = "arm64"
+	case elf.EM_ARM:
+		goarch = "arm"
+	case elf.EM_RISCV:
+		goarch = "riscv64"
+	case elf.EM_S390:
+		goarch = "s390x"
+	case elf.EM_PPC64:
+		goarch = "ppc64"
+		if little {
+			goarch = "ppc64le"
+		}
+	case elf.EM_MIPS:
+		goarch = "mips"
+		if f.Class == elf.ELFCLASS64 {
+			goarch = "mips64"
+		}
+		if little {
+			goarch += "le"
+		}
+	default:
+		return "", fmt.Errorf("unknown ELF machine %s", f.Machine)
+	}
+	return goos + "-" + goarch, nil
+}
+
+func machoPlatform(r io.ReaderAt) (string, error) {
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return "", err
+	}
+	switch f.Cpu {
+	case macho.CpuAmd64:
+		return "darwin-amd64", nil
+	case macho.CpuArm64:
+		return "darwin-arm64", nil
+	}
+	return "", fmt.Errorf("unknown Mach-O CPU %s", f.Cpu)
+}
+
+func pePlatform(r io.ReaderAt) (string, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return "", err
+	}
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "windows-amd64", nil
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "windows-386", nil
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "windows-arm64", nil
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "windows-arm", nil
+	}
+	return "", fmt.Errorf("unknown PE machine %#x", f.Machine)
+}
+
+// directoryBinaries returns the binaries in the files of a directory and
+// their platforms. With a name pattern the platform is taken from the file
+// name and other files are skipped. Otherwise files named after a platform
+// are taken as they are and the platform of any other executable is read
+// from its header. Files that are neither are skipped. If the name and the
+// header of a binary disagree about the platform it's an error.
+func directoryBinaries(dir string, names []string) ([]platformBinary, error) {
+	var re *regexp.Regexp
+	if namePattern != "" {
+		var err error
+		if re, err = compilePattern(namePattern); err != nil {
+			return nil, inputError{err}
+		}
+	}
+
+	var binaries []platformBinary
+	seen := make(map[string]string)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		detected, err := binaryPlatform(path)
+		if err != nil && !errors.Is(err, errNotExecutable) {
+			return nil, inputError{fmt.Errorf("%s: %w", path, err)}
+		}
+
+		var platform string
+		switch {
+		case re != nil:
+			var ok bool
+			if platform, ok = patternPlatform(re, name); !ok {
+				fmt.Printf("Skipping %s, it doesn't match %s\n", name, namePattern)
+				continue
+			}
+		case isPlatform(platformName(name)):
+			platform = platformName(name)
+		case detected != "":
+			platform = detected
+		default:
+			fmt.Printf("Skipping %s, it isn't an executable\n", name)
+			continue
+		}
+		if detected != "" && detected != basePlatform(platform) {
+			return nil, inputError{fmt.Errorf("%s: named for %s but built for %s", path, platform, detected)}
+		}
+		if other, ok := seen[platform]; ok {
+			return nil, inputError{fmt.Errorf("%s and %s are both for %s", other, name, platform)}
+		}
+		seen[platform] = name
+		binaries = append(binaries, platformBinary{path, platform})
+	}
+	return binaries, nil
+}
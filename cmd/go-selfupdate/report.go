@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// patchStat records the size of one generated patch next to the size of the
+// full binary download it can replace.
+type patchStat struct {
+	Platform    string
+	FromVersion string
+	ToVersion   string
+	PatchSize   int64
+	FullSize    int64
+}
+
+// Savings returns the percentage of the full download saved by the patch.
+func (p patchStat) Savings() float64 {
+	if p.FullSize == 0 {
+		return 0
+	}
+	return 100 * float64(p.FullSize-p.PatchSize) / float64(p.FullSize)
+}
+
+// MarshalJSON includes the computed savings in the JSON report.
+func (p patchStat) MarshalJSON() ([]byte, error) {
+	type stat patchStat
+	return json.Marshal(struct {
+		stat
+		Savings float64
+	}{stat(p), p.Savings()})
+}
+
+var (
+	patchStatsMu sync.Mutex
+	patchStats   []patchStat
+)
+
+func addPatchStat(s patchStat) {
+	patchStatsMu.Lock()
+	patchStats = append(patchStats, s)
+	patchStatsMu.Unlock()
+}
+
+func printReport(w io.Writer, stats []patchStat) {
+	if len(stats) == 0 {
+		return
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Platform != stats[j].Platform {
+			return stats[i].Platform < stats[j].Platform
+		}
+		return stats[i].FromVersion < stats[j].FromVersion
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Platform\tFrom\tTo\tPatch\tFull\tSaved")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.1f%%\n", s.Platform, s.FromVersion, s.ToVersion, s.PatchSize, s.FullSize, s.Savings())
+	}
+	tw.Flush()
+}
+
+func writeReport(path string, stats []patchStat) error {
+	if stats == nil {
+		stats = []patchStat{}
+	}
+	b, err := json.MarshalIndent(stats, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// finishReport prints the patch size summary and writes it as JSON to
+// reportPath if one was given.
+func finishReport(w io.Writer, reportPath string) error {
+	printReport(w, patchStats)
+	if reportPath == "" {
+		return nil
+	}
+	return writeReport(reportPath, patchStats)
+}
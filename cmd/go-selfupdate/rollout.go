@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// rolloutPercent is the share of installations the release is offered to at
+// first, 0 means all.
+var rolloutPercent int
+
+// updateFrom and skipPlatforms fence the release off from installations it
+// must not be offered to: those whose version doesn't meet the constraint
+// updateFrom and those on one of skipPlatforms.
+var (
+	updateFrom    string
+	skipPlatforms []string
+)
+
+// validRollout reports whether percent is a valid rollout percentage.
+func validRollout(percent int) bool {
+	return percent >= 0 && percent <= 100
+}
+
+// checkConstraint checks a version constraint such as ">=1.4.0, <2" the way
+// clients parse it: comma separated comparisons with one of the operators
+// =, !=, <, <=, > and >=, or none for an exact match.
+func checkConstraint(constraint string) error {
+	for _, c := range strings.Split(constraint, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		i := strings.IndexFunc(c, isVersionStart)
+		if i < 0 {
+			return fmt.Errorf("constraint %q has no version", c)
+		}
+		switch op := strings.TrimSpace(c[:i]); op {
+		case "", "=", "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("constraint %q has unknown operator %q", c, op)
+		}
+	}
+	return nil
+}
+
+// isVersionStart reports whether r can start the version of a constraint,
+// which ends the operator before it.
+func isVersionStart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// setRollout changes the rollout percentage in the current manifests of the
+// update tree in dir and signs them again. It returns the platforms changed.
+func setRollout(dir string, percent int) ([]string, error) {
+	if !validRollout(percent) {
+		return nil, inputError{fmt.Errorf("invalid rollout percentage %d", percent)}
+	}
+	if percent == 100 {
+		// offered to all, like releases without a rollout
+		percent = 0
+	}
+	return retarget(dir, func(c *current) { c.RolloutPercent = percent })
+}
+
+// retarget applies change to the current manifests of the update tree in dir
+// and signs them again. It returns the platforms changed. Changes to fields
+// the signature doesn't cover, such as Paused, keep the signature and need no
+// key.
+func retarget(dir string, change func(*current)) ([]string, error) {
+	manifests, err := readManifests(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, inputError{fmt.Errorf("no manifests in %s", dir)}
+	}
+	var platforms []string
+	for platform, c := range manifests {
+		signed := signaturePayload(c)
+		change(&c)
+		if !bytes.Equal(signed, signaturePayload(c)) {
+			if c.Signature != nil && signingKey == nil {
+				return nil, inputError{errors.New("the manifests are signed, pass the signing key with -key")}
+			}
+			c.Signature = nil
+		}
+		if gpgKey == nil && fileExists(filepath.Join(dir, platform+".json.asc")) {
+			return nil, inputError{errors.New("the manifests are GPG signed, pass the key with -gpg-key")}
+		}
+		manifests[platform] = c
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	genDir = dir
+	for _, platform := range platforms {
+		c := manifests[platform]
+		if err := writeManifest(platform, c); err != nil {
+			return nil, err
+		}
+	}
+	return platforms, nil
+}
+
+func runRollout(args []string) {
+	fs := flag.NewFlagSet("rollout", flag.ExitOnError)
+	dirFlag := fs.String("dir", "public", "Update tree whose current release to change")
+	keyFlag := fs.String("key", "", "Sign the manifests with the Ed25519 private key in this file, required if they are signed")
+	gpgKeyFlag := fs.String("gpg-key", "", "Sign the manifests with the OpenPGP private key in this file, required if they are GPG signed")
+	fromFlag := fs.String("update-from", "", "Only offer the release to installations whose version meets this constraint, e.g. \">=1.4.0\". Empty offers it to all")
+	var skipFlag stringList
+	fs.Var(&skipFlag, "skip-platform", "Don't offer the release to installations on this platform, e.g. darwin-arm64. May be repeated, empty offers it to all")
+	pauseFlag := fs.Bool("pause", false, "Withhold the release from all installations until -resume")
+	resumeFlag := fs.Bool("resume", false, "Offer a paused release again")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-selfupdate rollout [-dir public] [-key selfupdate.key] [-gpg-key key.asc] [-update-from constraint] [-skip-platform platform] [-pause|-resume] [percent]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Offers the current release to the given percentage of installations, 100 offers it to all.")
+		fmt.Fprintln(os.Stderr, "With -update-from and -skip-platform it changes which installations it is offered to,")
+		fmt.Fprintln(os.Stderr, "with -pause it is halted for all.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	retargets := set["update-from"] || set["skip-platform"] || *pauseFlag || *resumeFlag
+	if fs.NArg() > 1 || (fs.NArg() == 0 && !retargets) || (*pauseFlag && *resumeFlag) {
+		fs.Usage()
+		os.Exit(exitBadInput)
+	}
+	percent := -1
+	if fs.NArg() == 1 {
+		if _, err := fmt.Sscanf(fs.Arg(0), "%d", &percent); err != nil || !validRollout(percent) {
+			fail(inputError{fmt.Errorf("invalid rollout percentage %q", fs.Arg(0))})
+		}
+	}
+	if err := checkConstraint(*fromFlag); err != nil {
+		fail(inputError{err})
+	}
+	var skip []string
+	for _, p := range skipFlag {
+		if p != "" {
+			skip = append(skip, p)
+		}
+	}
+	if *keyFlag != "" {
+		key, err := readSigningKey(*keyFlag)
+		if err != nil {
+			fail(inputError{err})
+		}
+		signingKey = key
+	}
+	if *gpgKeyFlag != "" {
+		key, err := readGPGKey(*gpgKeyFlag, os.Getenv("GO_SELFUPDATE_GPG_PASSPHRASE"))
+		if err != nil {
+			fail(inputError{err})
+		}
+		gpgKey = key
+	}
+
+	platforms, err := retarget(*dirFlag, func(c *current) {
+		switch {
+		case percent == 100:
+			c.RolloutPercent = 0
+		case percent >= 0:
+			c.RolloutPercent = percent
+		}
+		if set["update-from"] {
+			c.UpdateFrom = *fromFlag
+		}
+		if set["skip-platform"] {
+			c.SkipPlatforms = skip
+		}
+		if *pauseFlag || *resumeFlag {
+			c.Paused = *pauseFlag
+		}
+	})
+	if err != nil {
+		fail(err)
+	}
+	for _, platform := range platforms {
+		switch {
+		case *pauseFlag:
+			fmt.Printf("%s: paused\n", platform)
+		case percent >= 0:
+			fmt.Printf("%s: rollout %d%%\n", platform, percent)
+		default:
+			fmt.Printf("%s: updated\n", platform)
+		}
+	}
+}
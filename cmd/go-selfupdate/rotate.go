@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+)
+
+// keyManifestFile is the name the key manifest is published as in the
+// update tree.
+const keyManifestFile = "keys.json"
+
+// keyManifest lists the keys clients trust manifests signed with. It has to
+// be signed by a key clients already trust.
+type keyManifest struct {
+	Timestamp  time.Time
+	Keys       []trustedKey
+	Signatures []keySignature
+}
+
+type trustedKey struct {
+	PublicKey []byte
+	NotBefore *time.Time `json:",omitempty"`
+	NotAfter  *time.Time `json:",omitempty"`
+}
+
+type keySignature struct {
+	PublicKey []byte
+	Signature []byte
+}
+
+// keyManifestPayload returns the bytes the signatures of the key manifest
+// are computed over, the payload selfupdate verifies.
+func keyManifestPayload(m keyManifest) []byte {
+	signed := selfupdate.KeyManifest{Timestamp: m.Timestamp}
+	for _, k := range m.Keys {
+		key := selfupdate.TrustedKey{PublicKey: k.PublicKey}
+		if k.NotBefore != nil {
+			key.NotBefore = *k.NotBefore
+		}
+		if k.NotAfter != nil {
+			key.NotAfter = *k.NotAfter
+		}
+		signed.Keys = append(signed.Keys, key)
+	}
+	return signed.Payload()
+}
+
+// rotateKeys adds the public key of next to the key manifest of the update
+// tree in dir, valid from now on, retires the keys in current after retire
+// unless it is 0 and signs the key manifest with all of them. Without a key
+// manifest yet the keys in current start it.
+func rotateKeys(dir string, current []ed25519.PrivateKey, next ed25519.PrivateKey, retire time.Duration) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	var m keyManifest
+	b, err := ioutil.ReadFile(filepath.Join(dir, keyManifestFile))
+	if err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("%s: %v", keyManifestFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	index := func(pub []byte) int {
+		for i, k := range m.Keys {
+			if string(k.PublicKey) == string(pub) {
+				return i
+			}
+		}
+		return -1
+	}
+	for _, key := range current {
+		pub := key.Public().(ed25519.PublicKey)
+		i := index(pub)
+		if i < 0 {
+			m.Keys = append(m.Keys, trustedKey{PublicKey: pub})
+			i = len(m.Keys) - 1
+		}
+		if retire > 0 {
+			notAfter := now.Add(retire)
+			m.Keys[i].NotAfter = &notAfter
+		}
+	}
+	if pub := next.Public().(ed25519.PublicKey); index(pub) < 0 {
+		m.Keys = append(m.Keys, trustedKey{PublicKey: pub, NotBefore: &now})
+	}
+
+	m.Timestamp = now
+	m.Signatures = nil
+	payload := keyManifestPayload(m)
+	for _, key := range append(current, next) {
+		m.Signatures = append(m.Signatures, keySignature{
+			PublicKey: key.Public().(ed25519.PublicKey),
+			Signature: ed25519.Sign(key, payload),
+		})
+	}
+	b, err = json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, keyManifestFile), b, 0644)
+}
+
+// runRotate implements the rotate subcommand: it introduces a new signing
+// key in the key manifest, signed with the keys clients already trust.
+func runRotate(args []string) {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	dirFlag := fs.String("dir", "public", "Update tree to publish the key manifest in")
+	var keyFlags stringList
+	fs.Var(&keyFlags, "key", "Ed25519 private key clients trust now, which signs the key manifest. May be repeated")
+	newKeyFlag := fs.String("new-key", "", "Ed25519 private key to sign manifests with from now on, see the keygen command")
+	retireFlag := fs.Duration("retire-after", 0, "Time after which manifests signed with the -key keys are no longer trusted, e.g. 720h. 0 keeps them trusted")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-selfupdate rotate [-dir public] -key selfupdate.key -new-key next.key [-retire-after 720h]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Adds a new signing key to "+keyManifestFile+", signed with the current key so that clients")
+		fmt.Fprintln(os.Stderr, "with Updater.KeyManifest set trust manifests signed with the new one.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(keyFlags) == 0 || *newKeyFlag == "" || fs.NArg() != 0 || *retireFlag < 0 {
+		fs.Usage()
+		os.Exit(exitBadInput)
+	}
+	var current []ed25519.PrivateKey
+	for _, path := range keyFlags {
+		key, err := readSigningKey(path)
+		if err != nil {
+			fail(inputError{err})
+		}
+		current = append(current, key)
+	}
+	next, err := readSigningKey(*newKeyFlag)
+	if err != nil {
+		fail(inputError{err})
+	}
+	if _, err := os.Stat(*dirFlag); err != nil {
+		fail(inputError{errors.New("no update tree at " + *dirFlag)})
+	}
+	if err := rotateKeys(*dirFlag, current, next, *retireFlag); err != nil {
+		fail(err)
+	}
+	fmt.Printf("added key %s, sign manifests with -key %s from now on\n",
+		base64.StdEncoding.EncodeToString(next.Public().(ed25519.PublicKey)), *newKeyFlag)
+}
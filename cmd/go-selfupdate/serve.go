@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanbornm/go-selfupdate/server"
+)
+
+// serveOptions configures the update server started by the serve command.
+type serveOptions struct {
+	dir      string
+	addr     string
+	stats    string
+	auth     string
+	certFile string
+	keyFile  string
+	quiet    bool
+}
+
+func (o *serveOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.dir, "dir", "public", "Update tree to serve")
+	fs.StringVar(&o.addr, "addr", ":8080", "Address to listen on")
+	fs.StringVar(&o.stats, "stats", "", "File to keep update statistics in, served at /stats. Statistics are kept in memory if empty")
+	fs.StringVar(&o.auth, "auth", "", "Require HTTP basic auth with these credentials, in the form user:password, except for reports POSTed to /stats. Defaults to $GO_SELFUPDATE_AUTH")
+	fs.StringVar(&o.certFile, "tls-cert", "", "Certificate file to serve HTTPS with, requires -tls-key")
+	fs.StringVar(&o.keyFile, "tls-key", "", "Private key file for -tls-cert")
+	fs.BoolVar(&o.quiet, "quiet", false, "Don't write an access log to stdout")
+}
+
+// handler returns the handler serving the update tree and statistics as
+// configured, logging requests to accessLog unless it is nil, and the
+// statistics to flush on shutdown. With -auth
+// reports may still be POSTed to /stats without credentials, which an
+// HTTPReporter doesn't send, reading the statistics requires them.
+func (o *serveOptions) handler(accessLog io.Writer) (http.Handler, *server.Stats, error) {
+	if fi, err := os.Stat(o.dir); err != nil {
+		return nil, nil, err
+	} else if !fi.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", o.dir)
+	}
+	stats, err := server.NewStats(o.stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", server.FileServer(o.dir))
+	mux.Handle("/stats", stats)
+
+	h := server.Gzip(mux)
+	if o.auth != "" {
+		i := strings.IndexByte(o.auth, ':')
+		if i < 0 {
+			return nil, nil, errors.New("-auth must be in the form user:password")
+		}
+		open, protected := h, server.BasicAuth(h, o.auth[:i], o.auth[i+1:])
+		h = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && r.URL.Path == "/stats" {
+				open.ServeHTTP(rw, r)
+				return
+			}
+			protected.ServeHTTP(rw, r)
+		})
+	}
+	if accessLog != nil {
+		h = server.AccessLog(h, accessLog)
+	}
+	return h, stats, nil
+}
+
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var opts serveOptions
+	opts.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-selfupdate serve [-dir public] [-addr :8080]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Serves an update tree to clients, with update statistics at /stats.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if opts.auth == "" {
+		opts.auth = os.Getenv("GO_SELFUPDATE_AUTH")
+	}
+	if (opts.certFile == "") != (opts.keyFile == "") {
+		fmt.Fprintln(os.Stderr, "error: -tls-cert and -tls-key must be given together")
+		os.Exit(2)
+	}
+
+	var accessLog io.Writer = os.Stdout
+	if opts.quiet {
+		accessLog = nil
+	}
+	h, stats, err := opts.handler(accessLog)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{
+		Addr:              opts.addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	// finish the downloads in flight before exiting
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		if err := stats.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, "error: saving statistics:", err)
+		}
+		close(done)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %s on %s\n", opts.dir, opts.addr)
+	if opts.certFile != "" {
+		err = srv.ListenAndServeTLS(opts.certFile, opts.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	<-done
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+)
+
+// signingKey signs the manifests when set.
+var signingKey ed25519.PrivateKey
+
+// signaturePayload returns the bytes the manifest signature is computed
+// over, the payload selfupdate verifies.
+func signaturePayload(c current) []byte {
+	info := selfupdate.VersionInfo{
+		Version:        c.Version,
+		Sha256:         c.Sha256,
+		Severity:       c.Severity,
+		Timestamp:      c.Timestamp,
+		ReleaseNotes:   c.ReleaseNotes,
+		MinimumVersion: c.MinimumVersion,
+		RolloutPercent: c.RolloutPercent,
+		UpdateFrom:     c.UpdateFrom,
+		SkipPlatforms:  c.SkipPlatforms,
+	}
+	if c.Expires != nil {
+		info.Expires = *c.Expires
+	}
+	for _, f := range c.Files {
+		info.Files = append(info.Files, selfupdate.ReleaseFile(f))
+	}
+	return info.SignaturePayload()
+}
+
+// sign sets the signature of c if a signing key is configured.
+func sign(c *current) {
+	if signingKey == nil {
+		return
+	}
+	c.Signature = ed25519.Sign(signingKey, signaturePayload(*c))
+}
+
+// readSigningKey reads a PEM encoded PKCS #8 Ed25519 private key as written
+// by the keygen command.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PEM private key found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": not an Ed25519 key")
+	}
+	return edKey, nil
+}
+
+// runKeygen implements the keygen subcommand: it writes a new private key
+// for signing manifests and prints the public key to embed in the client.
+func runKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	outFlag := fs.String("o", "selfupdate.key", "File to write the private key to")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-selfupdate keygen [-o selfupdate.key]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Creates an Ed25519 key pair for signing manifests with -key and prints the public key for Updater.PublicKey.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	f, err := os.OpenFile(*outFlag, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(pub))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readManifests returns the manifests at the top of an update tree keyed by
+// platform.
+func readManifests(dir string) (map[string]current, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifests := make(map[string]current)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || file.Name() == keyManifestFile {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var c current
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %s", file.Name(), err)
+		}
+		manifests[strings.TrimSuffix(file.Name(), ".json")] = c
+	}
+	return manifests, nil
+}
+
+// simulateUpdate does what a client running version from does to reach the
+// version in c: it applies the published patch to the old full binary and
+// checks the result against the manifest hash.
+func simulateUpdate(dir, platform, from string, c current) error {
+	old, err := openRelease(filepath.Join(dir, from), platform)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	patch, err := os.Open(filepath.Join(dir, from, c.Version, platform))
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+
+	h := sha256.New()
+	if err := applyPatch(c.DiffAlgorithm, old, patch, h); err != nil {
+		return fmt.Errorf("applying patch: %s", err)
+	}
+	if !bytes.Equal(h.Sum(nil), c.Sha256) {
+		return fmt.Errorf("patched binary hash %x does not match manifest hash %x", h.Sum(nil), c.Sha256)
+	}
+	return nil
+}
+
+// verifyFullBinary checks the full binary of the version in c against the
+// manifest hash.
+func verifyFullBinary(dir, platform string, c current) error {
+	r, err := openRelease(filepath.Join(dir, c.Version), platform)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), c.Sha256) {
+		return fmt.Errorf("binary hash %x does not match manifest hash %x", h.Sum(nil), c.Sha256)
+	}
+	return nil
+}
+
+// runSimulate implements the simulate subcommand: for every platform it
+// updates each older version in the tree to the latest one using the
+// published patches and reports any result that would fail verification on
+// a client.
+func runSimulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	dirFlag := fs.String("dir", "public", "Update tree to simulate client updates against")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-selfupdate simulate [-dir public]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Applies every published patch to its old version and checks the result against the manifest.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	dir := *dirFlag
+
+	manifests, err := readManifests(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	versions, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	platforms := make([]string, 0, len(manifests))
+	for platform := range manifests {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	failed := false
+	for _, platform := range platforms {
+		c := manifests[platform]
+		if err := verifyFullBinary(dir, platform, c); err != nil {
+			fmt.Printf("FAIL %s %s full binary: %s\n", platform, c.Version, err)
+			failed = true
+		}
+		for _, v := range versions {
+			if !v.IsDir() || v.Name() == c.Version {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, v.Name(), c.Version, platform)); err != nil {
+				// no patch from this version for this platform
+				continue
+			}
+			if err := simulateUpdate(dir, platform, v.Name(), c); err != nil {
+				fmt.Printf("FAIL %s %s -> %s: %s\n", platform, v.Name(), c.Version, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("ok   %s %s -> %s\n", platform, v.Name(), c.Version)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// treeReport collects what verify found wrong with an update tree. Failures
+// would break clients, warnings only waste space.
+type treeReport struct {
+	failures []string
+	warnings []string
+}
+
+func (r *treeReport) fail(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *treeReport) warn(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+// hashFile returns the sha256 of everything read from r.
+func hashFile(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	return h.Sum(nil), err
+}
+
+// readStrictManifest reads the manifest at path, rejecting fields that no
+// version of the format has.
+func readStrictManifest(path string) (current, error) {
+	var c current
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	err = d.Decode(&c)
+	return c, err
+}
+
+// checkManifestShape reports fields of the manifest c for platform that no
+// client could use.
+func checkManifestShape(r *treeReport, platform string, c current) {
+	if c.Version == "" {
+		r.fail("%s: manifest has no Version", platform)
+	}
+	if len(c.Sha256) != sha256.Size {
+		r.fail("%s: manifest Sha256 is %d bytes, want %d", platform, len(c.Sha256), sha256.Size)
+	}
+	if c.ManifestVersion > manifestVersion {
+		r.fail("%s: manifest version %d is newer than this tool understands", platform, c.ManifestVersion)
+	}
+	if c.DiffAlgorithm != "" && !validDiffAlgorithm(c.DiffAlgorithm) {
+		r.fail("%s: unknown diff algorithm %q", platform, c.DiffAlgorithm)
+	}
+	if c.Compression != "" && !validCompression(c.Compression) {
+		r.fail("%s: unknown compression %q", platform, c.Compression)
+	}
+	if c.Archive != "" && c.Archive != "tar.gz" {
+		r.fail("%s: unknown archive format %q", platform, c.Archive)
+	}
+	if !validRollout(c.RolloutPercent) {
+		r.fail("%s: invalid rollout percentage %d", platform, c.RolloutPercent)
+	}
+	if err := checkConstraint(c.UpdateFrom); err != nil {
+		r.fail("%s: invalid UpdateFrom: %s", platform, err)
+	}
+}
+
+// fullBinaryPath returns the path of the full binary of the release in c
+// for platform.
+func fullBinaryPath(dir, platform string, c current) (string, error) {
+	if c.Archive != "" {
+		matches, err := filepath.Glob(filepath.Join(dir, c.Version, "*_"+goreleaserPlatform(platform)+"."+c.Archive))
+		if err != nil || len(matches) == 0 {
+			return "", fmt.Errorf("no %s archive", c.Archive)
+		}
+		return matches[0], nil
+	}
+	format := c.Compression
+	if format == "" {
+		format = compressGzip
+	}
+	return filepath.Join(dir, c.Version, platform+compressExtension(format)), nil
+}
+
+// checkFile reports if the file at path is missing or doesn't have the size
+// published for it.
+func checkFile(r *treeReport, what, path string, size int64) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		r.fail("%s: %s", what, err)
+		return false
+	}
+	if size > 0 && fi.Size() != size {
+		r.fail("%s: size %d does not match manifest size %d", what, fi.Size(), size)
+		return false
+	}
+	return true
+}
+
+// checkPatch reports if the patch from from to to for platform is missing or
+// doesn't match its published size and hash.
+func checkPatch(r *treeReport, dir, platform, from, to string, size int64, sum []byte) bool {
+	what := fmt.Sprintf("%s patch %s -> %s", platform, from, to)
+	path := filepath.Join(dir, from, to, platform)
+	if !checkFile(r, what, path, size) {
+		return false
+	}
+	if sum == nil {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		r.fail("%s: %s", what, err)
+		return false
+	}
+	defer f.Close()
+	got, err := hashFile(f)
+	if err != nil {
+		r.fail("%s: %s", what, err)
+		return false
+	}
+	if !bytes.Equal(got, sum) {
+		r.fail("%s: hash %x does not match manifest hash %x", what, got, sum)
+		return false
+	}
+	return true
+}
+
+// verifyRelease checks everything the manifest c for platform refers to.
+func verifyRelease(r *treeReport, dir, platform string, c current) {
+	what := fmt.Sprintf("%s %s full binary", platform, c.Version)
+	if path, err := fullBinaryPath(dir, platform, c); err != nil {
+		r.fail("%s: %s", what, err)
+	} else if checkFile(r, what, path, c.Size) {
+		if err := verifyFullBinary(dir, platform, c); err != nil {
+			r.fail("%s: %s", what, err)
+		}
+	}
+
+	for from, p := range c.Patches {
+		if !checkPatch(r, dir, platform, from, c.Version, p.Size, p.Sha256) {
+			continue
+		}
+		if err := simulateUpdate(dir, platform, from, c); err != nil {
+			r.fail("%s patch %s -> %s: %s", platform, from, c.Version, err)
+		}
+	}
+
+	for _, hop := range c.PatchChain {
+		if !checkPatch(r, dir, platform, hop.From, hop.To, hop.Size, hop.PatchSha256) {
+			continue
+		}
+		// the hop has to produce the release it leads to
+		if err := verifyFullBinary(dir, platform, current{Version: hop.To, Sha256: hop.Sha256}); err != nil {
+			r.fail("%s patch chain %s -> %s: %s", platform, hop.From, hop.To, err)
+		}
+	}
+
+	format := c.Compression
+	if format == "" {
+		format = compressGzip
+	}
+	for _, f := range c.Files {
+		what := fmt.Sprintf("%s %s file %s", platform, c.Version, f.Name)
+		path := filepath.Join(dir, c.Version, platform+"-"+f.Name+compressExtension(format))
+		if !checkFile(r, what, path, f.Size) {
+			continue
+		}
+		in, err := openCompressed(path, format)
+		if err != nil {
+			r.fail("%s: %s", what, err)
+			continue
+		}
+		sum, err := hashFile(in)
+		in.Close()
+		if err != nil {
+			r.fail("%s: %s", what, err)
+			continue
+		}
+		if !bytes.Equal(sum, f.Sha256) {
+			r.fail("%s: hash %x does not match manifest hash %x", what, sum, f.Sha256)
+		}
+	}
+}
+
+// verifyTree checks the update tree in dir: the shape of every manifest,
+// every file they refer to and that there are no versions left that no
+// client can update from.
+func verifyTree(dir string) (*treeReport, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &treeReport{}
+	manifests := make(map[string]current)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || file.Name() == keyManifestFile {
+			continue
+		}
+		platform := strings.TrimSuffix(file.Name(), ".json")
+		c, err := readStrictManifest(filepath.Join(dir, file.Name()))
+		if err != nil {
+			r.fail("%s: %s", file.Name(), err)
+			continue
+		}
+		manifests[platform] = c
+	}
+	if len(manifests) == 0 {
+		r.fail("no manifests in %s", dir)
+	}
+
+	platforms := make([]string, 0, len(manifests))
+	for platform := range manifests {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	used := make(map[string]bool)
+	for _, platform := range platforms {
+		c := manifests[platform]
+		checkManifestShape(r, platform, c)
+		verifyRelease(r, dir, platform, c)
+		used[c.Version] = true
+		for from := range c.Patches {
+			used[from] = true
+		}
+		for _, hop := range c.PatchChain {
+			used[hop.From], used[hop.To] = true, true
+		}
+	}
+
+	for _, file := range files {
+		if file.IsDir() && !used[file.Name()] {
+			r.warn("version %s is not the latest for any platform and no patch leads from it", file.Name())
+		}
+	}
+	return r, nil
+}
+
+// runVerify implements the verify subcommand.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dirFlag := fs.String("dir", "public", "Update tree to verify")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-selfupdate verify [-dir public]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Checks every manifest, full binary, patch and file in the tree against the manifests and reports versions nothing refers to.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	r, err := verifyTree(*dirFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	for _, w := range r.warnings {
+		fmt.Println("WARN", w)
+	}
+	for _, f := range r.failures {
+		fmt.Println("FAIL", f)
+	}
+	if len(r.failures) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
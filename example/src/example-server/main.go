@@ -4,6 +4,8 @@ import (
 	"flag"
 	"log"
 	"net/http"
+
+	"github.com/sanbornm/go-selfupdate/server"
 )
 
 var servePath = flag.String("dir", "./public", "path to serve")
@@ -21,9 +23,18 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	flag.Parse()
 
+	stats, err := server.NewStats("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", server.FileServer(*servePath))
+	mux.Handle("/stats", stats)
+
 	// Simple static webserver with logging:
 	log.Printf("Starting HTTP server on :8080 serving path %q Ctrl + C to close and quit", *servePath)
+	log.Printf("Update adoption is shown at http://localhost:8080/stats?format=html")
 	log.Fatal(http.ListenAndServe(":8080", &logHandler{
-		handler: http.FileServer(http.Dir(*servePath))},
+		handler: mux},
 	))
 }
@@ -2,4 +2,9 @@ module github.com/sanbornm/go-selfupdate
 
 go 1.15
 
-require github.com/kr/binarydist v0.1.0
+require (
+	github.com/klauspost/compress v1.13.6
+	github.com/kr/binarydist v0.1.0
+	github.com/ulikunitz/xz v0.5.10
+	golang.org/x/crypto v0.1.0
+)
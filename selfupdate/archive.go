@@ -0,0 +1,165 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Archive formats a manifest may name in its Archive field. An empty Archive
+// means the full binary is the gzipped executable itself.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveHandler extracts the executable called name from an archive and
+// writes it to w.
+type ArchiveHandler interface {
+	Extract(archive io.Reader, name string, w io.Writer) error
+}
+
+// ArchiveHandlerFunc is an adapter to allow the use of ordinary functions as
+// an ArchiveHandler.
+type ArchiveHandlerFunc func(archive io.Reader, name string, w io.Writer) error
+
+// Extract calls f(archive, name, w).
+func (f ArchiveHandlerFunc) Extract(archive io.Reader, name string, w io.Writer) error {
+	return f(archive, name, w)
+}
+
+var archiveHandlers = map[string]ArchiveHandler{
+	ArchiveZip:   ArchiveHandlerFunc(extractZip),
+	ArchiveTarGz: ArchiveHandlerFunc(extractTarGz),
+}
+
+// archiveExtensions are the file extensions of the full binaries for each
+// archive format.
+var archiveExtensions = map[string]string{
+	ArchiveZip:   ".zip",
+	ArchiveTarGz: ".tar.gz",
+}
+
+// Naming schemes for the full binaries, see Updater.NamingScheme.
+const (
+	// NamingDefault names full binaries after the platform, such as
+	// linux-amd64.gz, as the go-selfupdate generator does by default.
+	NamingDefault = ""
+	// NamingGoreleaser names full binaries like goreleaser's default
+	// archives, such as myapp_1.2.3_linux_amd64.tar.gz. They are tar.gz
+	// archives unless the manifest names another Archive format.
+	NamingGoreleaser = "goreleaser"
+)
+
+// archiveHandler returns the ArchiveHandler for the archive format named in
+// the manifest, looking at u.Archives before the built-in formats.
+func (u *Updater) archiveHandler(format string) (ArchiveHandler, error) {
+	if h, ok := u.Archives[format]; ok {
+		return h, nil
+	}
+	if h, ok := archiveHandlers[format]; ok {
+		return h, nil
+	}
+	return nil, fmt.Errorf("no handler for archive format %q", format)
+}
+
+// archiveFormat returns the archive format of the full binary for the
+// fetched manifest, "" if it is a compressed executable.
+func (u *Updater) archiveFormat() string {
+	if u.Info.Archive == "" && u.NamingScheme == NamingGoreleaser {
+		return ArchiveTarGz
+	}
+	return u.Info.Archive
+}
+
+// binaryFile returns the file name of the full binary for the fetched
+// manifest, such as linux-amd64.gz or linux-amd64.zip.
+func (u *Updater) binaryFile() string {
+	if u.BinaryFileName != nil {
+		return u.BinaryFileName(u.Info.Version, u.platform())
+	}
+	ext := compressionExtensions[u.Info.Compression]
+	if format := u.archiveFormat(); format != "" {
+		var ok bool
+		if ext, ok = archiveExtensions[format]; !ok {
+			ext = "." + format
+		}
+	}
+	if u.NamingScheme == NamingGoreleaser {
+		// goreleaser leaves the v off the version and separates os and
+		// arch with an underscore
+		return u.CmdName + "_" + strings.TrimPrefix(u.Info.Version, "v") + "_" + goreleaserPlatform(u.platform()) + ext
+	}
+	return u.platform() + ext
+}
+
+// archiveName returns the name of the executable inside archives.
+func (u *Updater) archiveName() string {
+	if u.ArchiveName != "" {
+		return u.ArchiveName
+	}
+	if u.windows() {
+		return u.CmdName + ".exe"
+	}
+	return u.CmdName
+}
+
+// extractZip copies the archive to a temporary file first, zip archives can't
+// be read as a stream.
+func extractZip(archive io.Reader, name string, w io.Writer) error {
+	f, err := ioutil.TempFile("", "selfupdate-zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, archive)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() || path.Base(file.Name) != name {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(w, r)
+		return err
+	}
+	return fmt.Errorf("%s not found in zip archive", name)
+}
+
+func extractTarGz(archive io.Reader, name string, w io.Writer) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s not found in tar archive", name)
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Base(hdr.Name) != name {
+			continue
+		}
+		_, err = io.Copy(w, tr)
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package selfupdate
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCheckTimeout is delivered by CheckAsync when the check did not finish
+// before its deadline.
+var ErrCheckTimeout = errors.New("update check timed out")
+
+// CheckResult is the outcome of an asynchronous update check.
+type CheckResult struct {
+	Version string // Newer version available, empty if already up to date
+	Err     error  // Error that prevented the check from completing
+}
+
+// CheckAsync checks for an available update in the background and delivers
+// exactly one CheckResult on the returned channel, either when the check
+// completes or when timeout elapses, whichever happens first. It never
+// blocks the caller, which makes it suitable for CLIs that want to print a
+// "new version available" notice without delaying their real work:
+//
+//	check := updater.CheckAsync(2 * time.Second)
+//	// ... run the command ...
+//	if res := <-check; res.Err == nil && res.Version != "" {
+//		fmt.Println("A new version is available:", res.Version)
+//	}
+//
+// The check is the one of CheckRemoteVersion, which leaves u.Info and the
+// state alone, so u may be used concurrently. Its requests are cancelled once
+// timeout elapses.
+func (u *Updater) CheckAsync(timeout time.Duration) <-chan CheckResult {
+	result := make(chan CheckResult, 1)
+	done := make(chan CheckResult, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	go func() {
+		_, version, err := u.checkRemote(ctx)
+		done <- CheckResult{Version: version, Err: err}
+	}()
+
+	go func() {
+		defer cancel()
+		select {
+		case r := <-done:
+			result <- r
+		case <-ctx.Done():
+			result <- CheckResult{Err: ErrCheckTimeout}
+		}
+	}()
+
+	return result
+}
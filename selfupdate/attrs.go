@@ -0,0 +1,28 @@
+package selfupdate
+
+import "os"
+
+// copyAttributes gives the file at to the owner, permissions and extended
+// attributes of the one at from, which it replaces. The owner can only be
+// changed with enough privileges, without them the file keeps the current
+// user and loses the setuid and setgid bits, which are meant for the
+// original owner. Extended attributes are copied as far as permitted, they
+// hold for example SELinux labels, ACLs and file capabilities on Linux.
+//
+// The order matters: changing the owner clears the setuid bits and the
+// capabilities of a file, so it comes first.
+func copyAttributes(from, to string) error {
+	fi, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	mode := fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if !copyOwner(fi, to) {
+		mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	if err := os.Chmod(to, mode); err != nil {
+		return err
+	}
+	copyXattrs(from, to)
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package selfupdate
+
+import "os"
+
+// copyOwner does nothing, there are no Unix owners to copy and the new file
+// inherits its access rights from the directory.
+func copyOwner(fi os.FileInfo, path string) bool {
+	return true
+}
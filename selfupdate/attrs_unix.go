@@ -0,0 +1,23 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package selfupdate
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner gives the file at path the owner and group of fi and reports
+// whether it has them now.
+func copyOwner(fi os.FileInfo, path string) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	var cur syscall.Stat_t
+	if err := syscall.Stat(path, &cur); err == nil && cur.Uid == st.Uid && cur.Gid == st.Gid {
+		return true
+	}
+	return os.Chown(path, int(st.Uid), int(st.Gid)) == nil
+}
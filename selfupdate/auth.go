@@ -0,0 +1,43 @@
+package selfupdate
+
+import "net/http"
+
+// AuthProvider authorizes the requests HTTPRequester makes, for update
+// servers that aren't public. Authorize can add headers, such as a bearer
+// token it refreshes when it expires, or rewrite the URL, for example to
+// sign it.
+type AuthProvider interface {
+	Authorize(req *http.Request) error
+}
+
+// AuthFunc is an adapter to allow the use of ordinary functions as an
+// AuthProvider.
+type AuthFunc func(req *http.Request) error
+
+// Authorize calls f(req).
+func (f AuthFunc) Authorize(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken is an AuthProvider sending the token in an
+// "Authorization: Bearer" header.
+type BearerToken string
+
+// Authorize sets the Authorization header of req.
+func (t BearerToken) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+// BasicAuth is an AuthProvider sending HTTP basic auth credentials, as
+// required by `go-selfupdate serve -auth`.
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+// Authorize sets the basic auth credentials of req.
+func (a BasicAuth) Authorize(req *http.Request) error {
+	req.SetBasicAuth(a.User, a.Password)
+	return nil
+}
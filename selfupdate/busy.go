@@ -0,0 +1,76 @@
+package selfupdate
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrUpdateDeferred is returned when the update was downloaded but the
+// executable couldn't be replaced because another process, typically a
+// virus scanner, holds it open. The update is staged instead and installed
+// by BackgroundRun at the next start of the application. OnBeforeUpdate
+// returns it to defer an update the same way.
+var ErrUpdateDeferred = errors.New("executable in use, update deferred to the next start")
+
+// busyRetryDelays are the pauses between attempts to rename a file that
+// another process holds open. Virus scanners usually let go of a freshly
+// written executable within a few seconds.
+var busyRetryDelays = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+}
+
+// renameFile renames files for install, tests replace it to simulate a
+// busy executable.
+var renameFile = os.Rename
+
+// renameRetry renames from to to, trying again after a while as long as the
+// file is busy.
+func renameRetry(from, to string) error {
+	err := renameFile(from, to)
+	for _, d := range busyRetryDelays {
+		if err == nil || !isBusy(err) {
+			break
+		}
+		time.Sleep(d)
+		err = renameFile(from, to)
+	}
+	return err
+}
+
+// deferInstall stages files, which aren't to be installed now for the reason
+// cause, for BackgroundRun to install at the next start. It returns
+// ErrUpdateDeferred, or cause if the files can't be staged.
+func (u *Updater) deferInstall(files []stagedFile, version string, cause error) error {
+	if err := stageFiles(files); err != nil {
+		return cause
+	}
+	st := stagedUpdate{Version: version, Sha256: files[0].Sha256, Files: files[1:], Info: &u.Info, AtStart: true}
+	if err := u.saveStaged(st); err != nil {
+		removeStaged(files)
+		return cause
+	}
+	u.logger().Warn("update deferred to the next start", "version", version, "reason", cause)
+	return ErrUpdateDeferred
+}
+
+// applyDeferred installs an update deferred by deferInstall. It reports
+// false if there is none.
+func (u *Updater) applyDeferred() (UpdateResult, bool, error) {
+	result := UpdateResult{From: u.CurrentVersion}
+	st, ok := u.readStaged()
+	if !ok || !st.AtStart {
+		return result, false, nil
+	}
+	err := u.Apply()
+	if err == ErrNoStagedUpdate {
+		return result, false, nil
+	}
+	result.To = st.Version
+	result.Updated = err == nil
+	return result, true, err
+}
@@ -0,0 +1,174 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// errFullBinarySmaller is returned instead of a patch path when downloading
+// the full binary is cheaper.
+var errFullBinarySmaller = errors.New("full binary is smaller than the patches")
+
+// PatchHop is one step of a patch chain, the patch from a release to the one
+// published after it.
+type PatchHop struct {
+	From          string // Version the patch applies to
+	To            string // Version the patch produces
+	DiffAlgorithm string // Diff algorithm of the patch, empty means that of the manifest
+	Size          int64  // Size of the patch in bytes
+	Sha256        []byte // Hash of the executable of To
+	PatchSha256   []byte // Optional hash of the patch itself
+}
+
+// patchPath returns the cheapest series of patches, by download size, from
+// the current version to the one in the manifest. It can combine hops of the
+// patch chain with a patch straight to the latest version. It returns nil if
+// the manifest lists no way there and errFullBinarySmaller if the full binary
+// is smaller than the cheapest one.
+func (u *Updater) patchPath() ([]PatchHop, error) {
+	var edges []PatchHop
+	for from, p := range u.Info.Patches {
+		edges = append(edges, PatchHop{From: from, To: u.Info.Version, Size: p.Size, Sha256: u.Info.Sha256, PatchSha256: p.Sha256})
+	}
+	edges = append(edges, u.Info.PatchChain...)
+
+	cost := func(hop PatchHop) int64 {
+		if hop.Size > 0 {
+			return hop.Size
+		}
+		// a patch of unknown size is assumed to be as large as the full
+		// binary
+		if u.Info.Size > 0 {
+			return u.Info.Size
+		}
+		return 1
+	}
+
+	// Dijkstra over the few versions the manifest mentions
+	dist := map[string]int64{u.CurrentVersion: 0}
+	via := make(map[string]PatchHop)
+	done := make(map[string]bool)
+	for {
+		var next string
+		found := false
+		for v, d := range dist {
+			if !done[v] && (!found || d < dist[next]) {
+				next, found = v, true
+			}
+		}
+		if !found || next == u.Info.Version {
+			break
+		}
+		done[next] = true
+		for _, hop := range edges {
+			if hop.From != next || done[hop.To] {
+				continue
+			}
+			if _, err := u.patcher(u.hopAlgorithm(hop)); err != nil {
+				continue
+			}
+			if d, ok := dist[hop.To]; !ok || dist[next]+cost(hop) < d {
+				dist[hop.To] = dist[next] + cost(hop)
+				via[hop.To] = hop
+			}
+		}
+	}
+	if _, ok := dist[u.Info.Version]; !ok {
+		return nil, nil
+	}
+
+	var hops []PatchHop
+	for v := u.Info.Version; v != u.CurrentVersion; v = via[v].From {
+		hops = append([]PatchHop{via[v]}, hops...)
+	}
+	if size := chainSize(hops); size > 0 && u.Info.Size > 0 && size >= u.Info.Size {
+		return nil, errFullBinarySmaller
+	}
+	return hops, nil
+}
+
+// hopAlgorithm returns the diff algorithm of hop.
+func (u *Updater) hopAlgorithm(hop PatchHop) string {
+	if hop.DiffAlgorithm != "" {
+		return hop.DiffAlgorithm
+	}
+	return u.Info.DiffAlgorithm
+}
+
+// chainSize returns the size of all patches of hops, 0 if one is unknown.
+func chainSize(hops []PatchHop) int64 {
+	var size int64
+	for _, hop := range hops {
+		if hop.Size <= 0 {
+			return 0
+		}
+		size += hop.Size
+	}
+	return size
+}
+
+// hopReader reads the patch of the hop being applied, so that the whole
+// chain is counted as one download.
+type hopReader struct {
+	r io.Reader
+}
+
+func (h *hopReader) Read(p []byte) (int, error) {
+	return h.r.Read(p)
+}
+
+// fetchAndApplyChain applies the patches of hops one after the other to old
+// and writes the result to w. Every patch with a published hash and every
+// intermediate executable is checked, so a bad patch fails at the hop it is
+// in. It returns the number of bytes downloaded.
+func (u *Updater) fetchAndApplyChain(ctx context.Context, hops []PatchHop, old io.Reader, w io.Writer) (int64, error) {
+	hr := &hopReader{}
+	cr := u.countDownload(hr, ProgressPatch, chainSize(hops))
+	start := time.Now()
+	cur := old
+	for i, hop := range hops {
+		patcher, _ := u.patcher(u.hopAlgorithm(hop))
+		r, err := u.source().Patch(ctx, u.CmdName, hop.From, hop.To, u.platform())
+		if err != nil {
+			return cr.n, err
+		}
+		ph := sha256.New()
+		hr.r = io.TeeReader(r, ph)
+
+		// the last hop is checked like any other update
+		out := w
+		var next bytes.Buffer
+		h := sha256.New()
+		if i < len(hops)-1 {
+			out = io.MultiWriter(&next, h)
+		}
+		err = patcher.Patch(cur, out, cr)
+		if err == nil {
+			// a patcher may stop before the end of the patch, which is
+			// still hashed and counted
+			_, err = io.Copy(ioutil.Discard, cr)
+		}
+		if err == nil && hop.PatchSha256 != nil && !bytes.Equal(ph.Sum(nil), hop.PatchSha256) {
+			err = fmt.Errorf("patch from %s to %s is corrupt: %w", hop.From, hop.To, ErrHashMismatch)
+		}
+		r.Close()
+		if err != nil {
+			return cr.n, err
+		}
+		if i == len(hops)-1 {
+			u.recordThroughput(cr.n, time.Since(start))
+			return cr.n, nil
+		}
+		if !bytes.Equal(h.Sum(nil), hop.Sha256) {
+			return cr.n, fmt.Errorf("patch from %s to %s: %w", hop.From, hop.To, ErrHashMismatch)
+		}
+		cur = &next
+	}
+	return cr.n, nil
+}
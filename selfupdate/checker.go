@@ -0,0 +1,122 @@
+package selfupdate
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrCheckerRunning is returned by Start when the checker of the Updater is
+// already running.
+var ErrCheckerRunning = errors.New("update checker already running")
+
+// defaultCheckInterval is how often the checker consults the schedule unless
+// CheckInterval is set.
+const defaultCheckInterval = time.Hour
+
+// checkersMu guards the running field of every Updater.
+var checkersMu sync.Mutex
+
+// checker is a running background checker.
+type checker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start runs a checker in a background goroutine that updates the
+// application the way BackgroundRun does whenever the schedule says a check
+// is due, until ctx is done or Stop is called. It wakes up every
+// CheckInterval to consult the schedule, so a check isn't missed by much
+// after the computer slept, and waits a random part of CheckJitter before
+// each check so that a fleet of clients started together doesn't hit the
+// server at once. Requests go through the same Requester as every other
+// request, the default one honours the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables.
+//
+// OnUpdateApplied is called once a check installed an update. Failed checks
+// are logged and passed to OnError. The checker works on a copy of u,
+// changes to u after Start are not seen by it.
+func (u *Updater) Start(ctx context.Context) error {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	if u.running != nil {
+		return ErrCheckerRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c := &checker{cancel: cancel, done: make(chan struct{})}
+	u.running = c
+
+	run := *u
+	run.running = nil
+	go func() {
+		defer close(c.done)
+		run.runChecker(ctx)
+	}()
+	return nil
+}
+
+// Stop stops the checker started by Start and waits for it to finish, an
+// update in progress is abandoned, including one waiting for the ApplyGate.
+// It does nothing if no checker runs.
+func (u *Updater) Stop() {
+	checkersMu.Lock()
+	c := u.running
+	u.running = nil
+	checkersMu.Unlock()
+	if c == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+func (u *Updater) runChecker(ctx context.Context) {
+	var last time.Time
+	for {
+		timer := time.NewTimer(u.untilCheck(last))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		last = time.Now()
+
+		result, err := u.BackgroundRunWithResult(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			u.logger().Warn("scheduled update check failed", "error", err)
+		}
+		if result.Updated && u.OnUpdateApplied != nil {
+			u.OnUpdateApplied(result)
+		}
+	}
+}
+
+// untilCheck returns how long the checker waits before its next check. It
+// follows the schedule but waits at least CheckInterval after the previous
+// check at last, and no more than CheckInterval before looking again.
+func (u *Updater) untilCheck(last time.Time) time.Duration {
+	interval := u.CheckInterval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	wait := time.Until(u.NextUpdate())
+	if min := time.Until(last.Add(interval)); wait < min {
+		wait = min
+	}
+	if wait > interval {
+		wait = interval
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	if u.CheckJitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(u.CheckJitter) + 1))
+	}
+	return wait
+}
@@ -0,0 +1,116 @@
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// maxChecksumsSize limits how much of a checksums file is read.
+const maxChecksumsSize = 1 << 20
+
+// ErrChecksumMismatch is returned when a downloaded full binary doesn't
+// match its entry in the checksums file named by Updater.ChecksumsFile.
+var ErrChecksumMismatch = errors.New("full binary doesn't match the published checksum")
+
+// parseChecksums parses a checksums file in the format written by
+// sha256sum, one hex encoded hash and file name per line, and returns the
+// hashes keyed by base file name.
+func parseChecksums(data []byte) (map[string][]byte, error) {
+	sums := make(map[string][]byte)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed checksums line %q", line)
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("malformed checksum in line %q", line)
+		}
+		// a leading * marks files hashed in binary mode
+		sums[path.Base(strings.TrimPrefix(fields[1], "*"))] = sum
+	}
+	return sums, s.Err()
+}
+
+// fetchChecksums fetches the checksums file published with the version in
+// u.Info, checks its signature if u.VerifyChecksums is set, and returns the
+// checksum listed for file.
+func (u *Updater) fetchChecksums(ctx context.Context, file string) ([]byte, error) {
+	data, err := u.fetchReleaseFile(ctx, u.ChecksumsFile)
+	if err != nil {
+		return nil, err
+	}
+	if u.VerifyChecksums != nil {
+		sig, err := u.fetchReleaseFile(ctx, u.ChecksumsFile+".sig")
+		if err != nil {
+			return nil, err
+		}
+		if err := u.VerifyChecksums(data, sig); err != nil {
+			return nil, err
+		}
+	}
+	sums, err := parseChecksums(data)
+	if err != nil {
+		return nil, err
+	}
+	sum, ok := sums[file]
+	if !ok {
+		return nil, fmt.Errorf("%s lists no checksum for %s", u.ChecksumsFile, file)
+	}
+	return sum, nil
+}
+
+// fetchReleaseFile reads the small file published with the version in
+// u.Info.
+func (u *Updater) fetchReleaseFile(ctx context.Context, file string) ([]byte, error) {
+	r, err := u.source().Binary(ctx, u.CmdName, u.Info.Version, file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(io.LimitReader(r, maxChecksumsSize))
+}
+
+// checksumReader hashes everything read through it, so that the download
+// can be compared to its published checksum once it was read completely.
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+func newChecksumReader(r io.Reader, want []byte) *checksumReader {
+	h := sha256.New()
+	return &checksumReader{r: io.TeeReader(r, h), h: h, want: want}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// verify reads what is left of the download, which decompressors and
+// archive readers may not have needed, and compares the checksum.
+func (c *checksumReader) verify() error {
+	if _, err := io.Copy(ioutil.Discard, c.r); err != nil {
+		return err
+	}
+	if !bytes.Equal(c.h.Sum(nil), c.want) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package selfupdate
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// holds the consecutive failure count and the time the circuit closes again
+const circuitPath = "circuit" // path to circuit breaker state relative to u.Dir
+
+// defaultCircuitCooldown is the time in hours checks stay suspended when
+// CircuitCooldown isn't set.
+const defaultCircuitCooldown = 24
+
+type circuitState struct {
+	Failures  int
+	OpenUntil time.Time
+}
+
+// circuitOpen reports whether update checks are currently suspended because
+// the update server failed u.CircuitThreshold times in a row.
+func (u *Updater) circuitOpen() bool {
+	if u.CircuitThreshold <= 0 {
+		return false
+	}
+	return readCircuit(u.state()).OpenUntil.After(time.Now())
+}
+
+// recordCheck updates the circuit breaker state with the outcome of a
+// manifest fetch. A success resets the failure count, and reaching
+// u.CircuitThreshold consecutive failures opens the circuit for
+// u.CircuitCooldown hours, 24 if it isn't set.
+func (u *Updater) recordCheck(err error) {
+	if u.CircuitThreshold <= 0 {
+		return
+	}
+	store := u.state()
+	state := readCircuit(store)
+	if err == nil {
+		if state.Failures == 0 {
+			return
+		}
+		state = circuitState{}
+	} else {
+		state.Failures++
+		if state.Failures >= u.CircuitThreshold {
+			state.Failures = 0
+			cooldown := u.CircuitCooldown
+			if cooldown <= 0 {
+				cooldown = defaultCircuitCooldown
+			}
+			state.OpenUntil = time.Now().Add(time.Duration(cooldown) * time.Hour)
+		}
+	}
+	writeCircuit(store, state)
+}
+
+func readCircuit(store StateStore) circuitState {
+	var state circuitState
+	p, err := store.Read(circuitPath)
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(p, &state); err != nil {
+		return circuitState{}
+	}
+	return state
+}
+
+func writeCircuit(store StateStore, state circuitState) bool {
+	p, err := json.Marshal(state)
+	if err != nil {
+		return false
+	}
+	return store.Write(circuitPath, p) == nil
+}
@@ -0,0 +1,58 @@
+package selfupdate
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// badExecutablePath returns the path restorePrevious moves the replaced file to.
+func badExecutablePath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.bad", filepath.Base(path)))
+}
+
+// CleanupArtifacts removes the files earlier updates left next to the
+// executable and the files in Targets: partial downloads, files staged by an
+// update that is no longer recorded, executables replaced by a rollback and
+// previous executables that can't be rolled back to any more because the
+// running version isn't the one that replaced them. Windows can't remove a
+// running executable, so these pile up there as hidden files after every
+// update. The previous executable of the latest update stays for Rollback.
+//
+// BackgroundRun calls CleanupArtifacts. It does nothing while another
+// instance updates the application.
+func (u *Updater) CleanupArtifacts() error {
+	path, err := u.targetPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockUpdate(path)
+	if err == ErrUpdateInProgress {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, staged := u.readStaged()
+	st := u.readRollback()
+	rollback := st.Installed == u.CurrentVersion && !st.RolledBack
+
+	var first error
+	remove := func(p string) {
+		if err := removeIfExists(p); err != nil && first == nil {
+			first = err
+		}
+	}
+	for _, p := range u.installedPaths() {
+		remove(filepath.Join(filepath.Dir(p), fmt.Sprintf(".%s.new", filepath.Base(p))))
+		remove(badExecutablePath(p))
+		if !staged {
+			remove(stagedExecutablePath(p))
+		}
+		if !rollback {
+			remove(oldExecutablePath(p))
+		}
+	}
+	return first
+}
@@ -0,0 +1,130 @@
+// Package cliutil asks the user of a command line program before it
+// updates itself with a selfupdate.Updater.
+package cliutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+)
+
+// ErrDeclined is returned by Prompt.BeforeUpdate when the user didn't agree
+// to the update.
+var ErrDeclined = errors.New("update declined")
+
+// Prompt asks on a terminal whether to update. Without a terminal, for
+// example in scripts, CI jobs or under a service manager, nobody can answer,
+// and the answer is no unless AssumeYes is set.
+type Prompt struct {
+	In        io.Reader // Where the answer is read from, defaults to os.Stdin
+	Out       io.Writer // Where the question is written to, defaults to os.Stderr
+	AssumeYes bool      // Answer yes without asking, e.g. for a --yes flag
+}
+
+// Confirm asks "Version X is available, update now? [y/N]" and reports
+// whether the user answered yes.
+func (p *Prompt) Confirm(version string) (bool, error) {
+	if p.AssumeYes {
+		return true, nil
+	}
+	if !p.interactive() {
+		return false, nil
+	}
+	fmt.Fprintf(p.out(), "Version %s is available, update now? [y/N] ", version)
+	answer, err := bufio.NewReader(p.in()).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// BeforeUpdate confirms the update to version and returns ErrDeclined if
+// the user doesn't agree. It can be used as Updater.OnBeforeUpdate, which
+// isn't called for critical and mandatory releases.
+func (p *Prompt) BeforeUpdate(version string) error {
+	ok, err := p.Confirm(version)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDeclined
+	}
+	return nil
+}
+
+// Update checks for a new version, asks the user and downloads and installs
+// it if they agree. A release that is mandatory for u is installed without
+// asking. An update staged by an earlier Download is offered first. Update
+// reports whether a new version was installed, which runs once the program
+// restarts.
+func Update(u *selfupdate.Updater, p *Prompt) (bool, error) {
+	if version, ok := u.StagedVersion(); ok {
+		if yes, err := p.Confirm(version); err != nil || !yes {
+			return false, err
+		}
+		if err := u.Apply(); err != selfupdate.ErrNoStagedUpdate {
+			return err == nil, err
+		}
+	}
+
+	version, err := u.UpdateAvailable()
+	if err != nil || version == "" {
+		return false, err
+	}
+	if u.Mandatory() {
+		fmt.Fprintf(p.out(), "Version %s is required, updating.\n", version)
+	} else if yes, err := p.Confirm(version); err != nil || !yes {
+		return false, err
+	}
+	if err := u.Download(); err != nil {
+		return false, err
+	}
+	if err := u.Apply(); err == selfupdate.ErrNoStagedUpdate {
+		// nothing was downloaded, e.g. because the version was rolled back
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *Prompt) in() io.Reader {
+	if p.In != nil {
+		return p.In
+	}
+	return os.Stdin
+}
+
+func (p *Prompt) out() io.Writer {
+	if p.Out != nil {
+		return p.Out
+	}
+	return os.Stderr
+}
+
+// interactive reports whether someone can answer: In is set, or standard
+// input and the output are terminals.
+func (p *Prompt) interactive() bool {
+	if p.In != nil {
+		return true
+	}
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	f, ok := p.out().(*os.File)
+	return ok && isTerminal(f)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,108 @@
+package cliutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+)
+
+func TestPromptConfirm(t *testing.T) {
+	for _, tc := range []struct {
+		answer string
+		yes    bool
+	}{
+		{"y\n", true},
+		{" Yes \n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+		{"y", true},
+	} {
+		var out bytes.Buffer
+		p := &Prompt{In: strings.NewReader(tc.answer), Out: &out}
+		yes, err := p.Confirm("1.3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if yes != tc.yes {
+			t.Errorf("answer %q: got %v, want %v", tc.answer, yes, tc.yes)
+		}
+		if out.String() != "Version 1.3 is available, update now? [y/N] " {
+			t.Errorf("unexpected question %q", out.String())
+		}
+	}
+
+	p := &Prompt{In: strings.NewReader("n\n"), AssumeYes: true}
+	if yes, _ := p.Confirm("1.3"); !yes {
+		t.Error("AssumeYes didn't answer yes")
+	}
+	if err := (&Prompt{In: strings.NewReader("n\n"), Out: ioutil.Discard}).BeforeUpdate("1.3"); err != ErrDeclined {
+		t.Errorf("got %v, want ErrDeclined", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".json"):
+			rw.Write(manifest)
+		case strings.HasSuffix(r.URL.Path, ".gz"):
+			rw.Write(gz.Bytes())
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cliutil-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         srv.URL + "/",
+		BinURL:         srv.URL + "/",
+		CmdName:        "myapp",
+		Target:         selfupdate.FileResolver(target),
+		State:          &selfupdate.MemoryStore{},
+		Logger:         selfupdate.NopLogger{},
+	}
+
+	updated, err := Update(u, &Prompt{In: strings.NewReader("n\n"), Out: ioutil.Discard})
+	if err != nil || updated {
+		t.Fatalf("declined update: updated %v, error %v", updated, err)
+	}
+	if b, _ := ioutil.ReadFile(target); string(b) != "old" {
+		t.Fatalf("declined update installed %q", b)
+	}
+
+	updated, err = Update(u, &Prompt{In: strings.NewReader("y\n"), Out: ioutil.Discard})
+	if err != nil || !updated {
+		t.Fatalf("accepted update: updated %v, error %v", updated, err)
+	}
+	if b, _ := ioutil.ReadFile(target); string(b) != "new binary" {
+		t.Fatalf("accepted update installed %q", b)
+	}
+}
@@ -0,0 +1,78 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+var (
+	// ErrBinaryNotSigned is returned when u.RequireSignedBinary is set and
+	// the downloaded executable has no valid code signature.
+	ErrBinaryNotSigned = errors.New("new executable is not validly signed")
+
+	// ErrSignerMismatch is returned when u.RequireSameSigner is set and the
+	// downloaded executable is signed by someone else than the one it
+	// replaces.
+	ErrSignerMismatch = errors.New("new executable is signed by a different signer")
+)
+
+// CodeSignatureError is returned when the code signature of a new executable
+// is rejected. Err is ErrBinaryNotSigned or ErrSignerMismatch.
+type CodeSignatureError struct {
+	Path   string // The rejected executable
+	Detail string // What the platform's verifier reported, if anything
+	Err    error
+}
+
+func (e *CodeSignatureError) Error() string {
+	if e.Detail == "" {
+		return e.Path + ": " + e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error() + ": " + e.Detail
+}
+
+// Unwrap returns e.Err, so that errors.Is(err, ErrSignerMismatch) works.
+func (e *CodeSignatureError) Unwrap() error {
+	return e.Err
+}
+
+// sameSignerCert reports whether the signing certificates a and b belong to
+// the same signer: they have the same subject and were issued by the same
+// authority, so a renewed certificate matches but one another authority
+// issued for the same name doesn't.
+func sameSignerCert(a, b *x509.Certificate) bool {
+	return bytes.Equal(a.RawSubject, b.RawSubject) && bytes.Equal(a.RawIssuer, b.RawIssuer)
+}
+
+// verifyBinary prepares the executable staged at newPath, which is to
+// replace the one at path, for being started and checks its code signature,
+// its format and whether it runs as far as u asks for it. The staged file
+// takes on the owner, permissions and extended attributes of the one at
+// path. It is removed if it fails a check.
+func (u *Updater) verifyBinary(newPath, path string) error {
+	if err := copyAttributes(path, newPath); err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+	clearQuarantine(newPath)
+	if u.RequireSignedBinary {
+		err := verifyCodeSignature(newPath, path, u.RequireSameSigner)
+		if err == ErrBinaryNotSigned || err == ErrSignerMismatch {
+			err = &CodeSignatureError{Path: newPath, Err: err}
+		}
+		if err != nil {
+			u.logger().Error("code signature check failed", "version", u.Info.Version, "error", err)
+			_ = os.Remove(newPath)
+			return err
+		}
+	}
+	// only run it once the signature is known to be good
+	if err := u.checkNewExecutable(newPath); err != nil {
+		u.logger().Error("new executable check failed", "version", u.Info.Version, "error", err)
+		_ = os.Remove(newPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// verifyCodeSignature checks the signature of the executable at newPath the
+// way `codesign --verify --strict` does and, if sameSigner is set, that it
+// was signed by the same team as the executable at currentPath.
+func verifyCodeSignature(newPath, currentPath string, sameSigner bool) error {
+	out, err := exec.Command("codesign", "--verify", "--strict", newPath).CombinedOutput()
+	if err != nil {
+		return &CodeSignatureError{Path: newPath, Detail: strings.TrimSpace(string(out)), Err: ErrBinaryNotSigned}
+	}
+	if !sameSigner {
+		return nil
+	}
+	team := teamIdentifier(newPath)
+	current := teamIdentifier(currentPath)
+	if team == "" || team != current {
+		return &CodeSignatureError{Path: newPath, Detail: "team " + team + ", running executable's team " + current, Err: ErrSignerMismatch}
+	}
+	return nil
+}
+
+// teamIdentifier returns the team ID the executable at path was signed by,
+// or "" if it has none.
+func teamIdentifier(path string) string {
+	// codesign prints the details on stderr
+	out, err := exec.Command("codesign", "--display", "--verbose=2", path).CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if team := strings.TrimPrefix(s.Text(), "TeamIdentifier="); team != s.Text() {
+			if team == "not set" {
+				return ""
+			}
+			return team
+		}
+	}
+	return ""
+}
+
+// clearQuarantine removes the quarantine attribute from the file at path,
+// which it inherits when the application writing it is quarantine enabled,
+// so that Gatekeeper doesn't block the new executable when it starts.
+func clearQuarantine(path string) {
+	_ = exec.Command("xattr", "-d", "com.apple.quarantine", path).Run()
+}
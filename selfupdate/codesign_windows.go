@@ -0,0 +1,138 @@
+package selfupdate
+
+import (
+	"crypto/x509"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	wintrust                   = syscall.NewLazyDLL("wintrust.dll")
+	procWinVerifyTrust         = wintrust.NewProc("WinVerifyTrust")
+	procProvDataFromStateData  = wintrust.NewProc("WTHelperProvDataFromStateData")
+	procGetProvSignerFromChain = wintrust.NewProc("WTHelperGetProvSignerFromChain")
+	procGetProvCertFromChain   = wintrust.NewProc("WTHelperGetProvCertFromChain")
+	actionGenericVerifyV2      = syscall.GUID{Data1: 0xaac56b, Data2: 0xcd44, Data3: 0x11d0, Data4: [8]byte{0x8c, 0xc2, 0x00, 0xc0, 0x4f, 0xc2, 0x95, 0xee}}
+)
+
+// WINTRUST_FILE_INFO
+type wintrustFileInfo struct {
+	Size         uint32
+	FilePath     *uint16
+	File         syscall.Handle
+	KnownSubject *syscall.GUID
+}
+
+// WINTRUST_DATA
+type wintrustData struct {
+	Size               uint32
+	PolicyCallbackData uintptr
+	SIPClientData      uintptr
+	UIChoice           uint32
+	RevocationChecks   uint32
+	UnionChoice        uint32
+	File               *wintrustFileInfo
+	StateAction        uint32
+	StateData          syscall.Handle
+	URLReference       *uint16
+	ProvFlags          uint32
+	UIContext          uint32
+	SignatureSettings  uintptr
+}
+
+// CERT_CONTEXT
+type certContext struct {
+	EncodingType uint32
+	Encoded      *byte
+	Length       uint32
+	CertInfo     uintptr
+	Store        syscall.Handle
+}
+
+// the start of CRYPT_PROVIDER_CERT
+type cryptProviderCert struct {
+	Size uint32
+	Cert *certContext
+}
+
+const (
+	wtdUINone            = 2
+	wtdRevokeNone        = 0
+	wtdChoiceFile        = 1
+	wtdStateActionVerify = 1
+	wtdStateActionClose  = 2
+)
+
+func clearQuarantine(path string) {}
+
+// verifyCodeSignature checks the Authenticode signature of the executable at
+// newPath with WinVerifyTrust and, if sameSigner is set, that its signing
+// certificate has the same subject and issuer as the one of the executable
+// at currentPath.
+func verifyCodeSignature(newPath, currentPath string, sameSigner bool) error {
+	signer, err := authenticodeSigner(newPath)
+	if err != nil {
+		return err
+	}
+	if !sameSigner {
+		return nil
+	}
+	current, err := authenticodeSigner(currentPath)
+	if err != nil {
+		// an unsigned executable has no signer to match
+		return ErrSignerMismatch
+	}
+	if !sameSignerCert(signer, current) {
+		return ErrSignerMismatch
+	}
+	return nil
+}
+
+// authenticodeSigner verifies the Authenticode signature of the file at path
+// and returns the certificate it was signed with.
+func authenticodeSigner(path string) (*x509.Certificate, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	file := wintrustFileInfo{FilePath: p}
+	file.Size = uint32(unsafe.Sizeof(file))
+	data := wintrustData{
+		UIChoice:         wtdUINone,
+		RevocationChecks: wtdRevokeNone,
+		UnionChoice:      wtdChoiceFile,
+		File:             &file,
+		StateAction:      wtdStateActionVerify,
+	}
+	data.Size = uint32(unsafe.Sizeof(data))
+
+	r, _, _ := procWinVerifyTrust.Call(0, uintptr(unsafe.Pointer(&actionGenericVerifyV2)), uintptr(unsafe.Pointer(&data)))
+	defer func() {
+		data.StateAction = wtdStateActionClose
+		procWinVerifyTrust.Call(0, uintptr(unsafe.Pointer(&actionGenericVerifyV2)), uintptr(unsafe.Pointer(&data)))
+	}()
+	if r != 0 {
+		return nil, ErrBinaryNotSigned
+	}
+
+	provData, _, _ := procProvDataFromStateData.Call(uintptr(data.StateData))
+	if provData == 0 {
+		return nil, ErrBinaryNotSigned
+	}
+	sgnr, _, _ := procGetProvSignerFromChain.Call(provData, 0, 0, 0)
+	if sgnr == 0 {
+		return nil, ErrBinaryNotSigned
+	}
+	provCert, _, _ := procGetProvCertFromChain.Call(sgnr, 0)
+	if provCert == 0 {
+		return nil, ErrBinaryNotSigned
+	}
+	// the structure is owned by wintrust and stays valid until the state
+	// data is closed
+	cert := (*(**cryptProviderCert)(unsafe.Pointer(&provCert))).Cert
+	if cert == nil {
+		return nil, ErrBinaryNotSigned
+	}
+	der := (*[1 << 20]byte)(unsafe.Pointer(cert.Encoded))[:cert.Length:cert.Length]
+	return x509.ParseCertificate(der)
+}
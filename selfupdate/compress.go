@@ -0,0 +1,56 @@
+package selfupdate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats a manifest may name in its Compression field for full
+// binaries that are not archives. An empty Compression means gzip.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionXz   = "xz"
+)
+
+// compressionExtensions are the file extensions of the full binaries for
+// each compression format.
+var compressionExtensions = map[string]string{
+	"":              ".gz",
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+	CompressionXz:   ".xz",
+}
+
+// decompress writes the full binary r, compressed with format, to w.
+func decompress(format string, r io.Reader, w io.Writer) error {
+	switch format {
+	case "", CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, gz)
+		return err
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		_, err = io.Copy(w, zr)
+		return err
+	case CompressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, xr)
+		return err
+	}
+	return fmt.Errorf("unknown compression %q", format)
+}
@@ -0,0 +1,123 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// holds the manifest fetched last and the validators the server sent with it
+const manifestCachePath = "manifest" // path to the cached manifest relative to u.Dir
+
+// manifestCache is the manifest fetched last, kept so that a check can ask
+// the server to send it only if it changed.
+type manifestCache struct {
+	Platform     string
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	Body         []byte
+}
+
+// conditional carries the validators of a cached response to the request
+// that fetches it again, and those of the new response back. It applies to
+// the URL of the first request made with it and its retries only, further
+// requests, such as those of sources fetching more than one file, are plain.
+type conditional struct {
+	etag, lastModified string // sent with the request
+	url                string // the request it applies to
+	respETag           string // received with the response
+	respLastModified   string
+}
+
+type conditionalKey struct{}
+
+// withConditional returns a context that makes the HTTPRequester fetching
+// with it send a conditional request with the validators in c. The server
+// answers 304 Not Modified if the resource didn't change, which the
+// requester returns as an HTTPStatusError.
+func withConditional(ctx context.Context, c *conditional) context.Context {
+	return context.WithValue(ctx, conditionalKey{}, c)
+}
+
+// setConditional adds the conditional headers of ctx to req, if any, and
+// returns the conditional to record the validators of the response in.
+func setConditional(ctx context.Context, req *http.Request) *conditional {
+	c, _ := ctx.Value(conditionalKey{}).(*conditional)
+	if c == nil {
+		return nil
+	}
+	if c.url == "" {
+		c.url = req.URL.String()
+	} else if c.url != req.URL.String() {
+		return nil
+	}
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	if c.lastModified != "" {
+		req.Header.Set("If-Modified-Since", c.lastModified)
+	}
+	return c
+}
+
+// record keeps the validators of resp.
+func (c *conditional) record(resp *http.Response) {
+	if c == nil {
+		return
+	}
+	c.respETag = resp.Header.Get("ETag")
+	c.respLastModified = resp.Header.Get("Last-Modified")
+}
+
+// isNotModified reports whether err is the 304 Not Modified answer to a
+// conditional request.
+func isNotModified(err error) bool {
+	var statusErr *HTTPStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotModified
+}
+
+// isNotFound reports whether err says that the file asked for isn't
+// published: the server answered 404 Not Found, or the GitHub release lacks
+// the asset.
+func isNotFound(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound
+	}
+	var assetErr *missingAssetError
+	return errors.As(err, &assetErr)
+}
+
+// readManifestCache returns the cached manifest for the platform updates are
+// fetched for, if there is one.
+func (u *Updater) readManifestCache() (manifestCache, bool) {
+	var cache manifestCache
+	p, err := u.state().Read(manifestCachePath)
+	if err != nil || json.Unmarshal(p, &cache) != nil {
+		return manifestCache{}, false
+	}
+	if cache.Platform != u.platform() || len(cache.Body) == 0 || (cache.ETag == "" && cache.LastModified == "") {
+		return manifestCache{}, false
+	}
+	return cache, true
+}
+
+// saveManifestCache caches the manifest body fetched with c, or removes the
+// cache if the server sent no validators with it.
+func (u *Updater) saveManifestCache(c *conditional, body []byte) {
+	if c.respETag == "" && c.respLastModified == "" {
+		u.state().Remove(manifestCachePath)
+		return
+	}
+	p, err := json.Marshal(manifestCache{
+		Platform:     u.platform(),
+		ETag:         c.respETag,
+		LastModified: c.respLastModified,
+		Body:         body,
+	})
+	if err != nil {
+		return
+	}
+	u.state().Write(manifestCachePath, p)
+}
@@ -0,0 +1,40 @@
+package selfupdate
+
+import (
+	"context"
+	"io"
+)
+
+// ContextRequester is implemented by Requesters that can abort a request when
+// its context is done. The Updater uses it in place of Fetch when available,
+// so UpdateContext and BackgroundRunContext can cancel a download that is
+// waiting on the network. HTTPRequester implements it.
+type ContextRequester interface {
+	Requester
+	FetchContext(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// bindContext returns a Requester fetching with ctx if r supports it.
+func bindContext(ctx context.Context, r Requester) Requester {
+	cr, ok := r.(ContextRequester)
+	if !ok {
+		return r
+	}
+	return RequesterFunc(func(url string) (io.ReadCloser, error) {
+		return cr.FetchContext(ctx, url)
+	})
+}
+
+// contextReader fails reads once its context is done, so cancellation also
+// stops copying and patching from requesters that ignore the context.
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
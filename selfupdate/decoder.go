@@ -0,0 +1,234 @@
+package selfupdate
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ManifestDecoder decodes the release metadata fetched as the manifest into
+// the VersionInfo of the latest release for platform, so that releases can
+// be published in formats other than the generator's.
+//
+// Formats that don't carry the SHA-256 hash of the executable leave Sha256
+// empty. That is enough to check for new versions, but updating to them
+// fails with ErrNoHash.
+type ManifestDecoder interface {
+	Decode(body []byte, platform string, info *VersionInfo) error
+}
+
+// ErrNoHash is returned when updating to a release whose manifest doesn't
+// carry the hash of the executable, which the download is verified with.
+var ErrNoHash = errors.New("manifest has no hash of the executable")
+
+// JSONManifest decodes the JSON manifests the generator writes, which is
+// what Updater uses when ManifestDecoder isn't set.
+type JSONManifest struct{}
+
+func (JSONManifest) Decode(body []byte, platform string, info *VersionInfo) error {
+	return json.Unmarshal(body, info)
+}
+
+// PlainTextManifest decodes a text file such as latest.txt that holds just
+// the latest version, optionally followed by the hex encoded SHA-256 hash of
+// its executable.
+type PlainTextManifest struct{}
+
+func (PlainTextManifest) Decode(body []byte, platform string, info *VersionInfo) error {
+	fields := strings.Fields(string(body))
+	switch len(fields) {
+	case 2:
+		sum, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return fmt.Errorf("bad hash in version file: %v", err)
+		}
+		info.Sha256 = sum
+		fallthrough
+	case 1:
+		info.Version = fields[0]
+		return nil
+	}
+	return fmt.Errorf("version file has %d fields, want a version and optionally a hash", len(fields))
+}
+
+// AppcastManifest decodes the appcasts of Sparkle and WinSparkle, RSS feeds
+// listing the releases of an application. The newest item with an enclosure
+// for the operating system of the platform is taken, enclosures without
+// sparkle:os being for macOS. Its enclosure is downloaded as the full
+// binary, an archive unless it is compressed like the generator's. Appcasts
+// carry no SHA-256 hash of the executable.
+type AppcastManifest struct{}
+
+type appcast struct {
+	Items []appcastItem `xml:"channel>item"`
+}
+
+type appcastItem struct {
+	PubDate      string             `xml:"pubDate"`
+	Description  string             `xml:"description"`
+	Version      string             `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle version"`
+	ShortVersion string             `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle shortVersionString"`
+	Critical     *struct{}          `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle criticalUpdate"`
+	Enclosures   []appcastEnclosure `xml:"enclosure"`
+}
+
+type appcastEnclosure struct {
+	URL          string `xml:"url,attr"`
+	Length       int64  `xml:"length,attr"`
+	OS           string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle os,attr"`
+	Version      string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle version,attr"`
+	ShortVersion string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle shortVersionString,attr"`
+}
+
+func (AppcastManifest) Decode(body []byte, platform string, info *VersionInfo) error {
+	var feed appcast
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return err
+	}
+	goos := strings.SplitN(platform, "-", 2)[0]
+	if goos == "darwin" {
+		goos = "macos"
+	}
+	var latest *appcastItem
+	var latestVersion string
+	var enclosure appcastEnclosure
+	for i, item := range feed.Items {
+		for _, e := range item.Enclosures {
+			system := e.OS
+			if system == "" {
+				system = "macos"
+			}
+			if system != goos || e.URL == "" {
+				continue
+			}
+			version := firstNonEmpty(item.ShortVersion, item.Version, e.ShortVersion, e.Version)
+			if version != "" && (latest == nil || compareVersions(version, latestVersion) > 0) {
+				latest, latestVersion, enclosure = &feed.Items[i], version, e
+			}
+			break
+		}
+	}
+	if latest == nil {
+		return fmt.Errorf("appcast has no release for %s", platform)
+	}
+	info.Version = latestVersion
+	info.Size = enclosure.Length
+	info.Downloads.Binary = enclosure.URL
+	info.ReleaseNotes = strings.TrimSpace(latest.Description)
+	if t, err := time.Parse(time.RFC1123Z, latest.PubDate); err == nil {
+		info.Timestamp = t
+	}
+	if latest.Critical != nil {
+		info.Severity = SeverityCritical
+	}
+	_, info.Archive, info.Compression = splitFormat(path.Base(enclosure.URL))
+	return nil
+}
+
+// TUFManifest decodes the targets metadata of The Update Framework. The
+// target of the platform is one named like the platform with the extension
+// of a compression or archive format, such as myapp/1.3/linux-amd64.gz, and
+// of several the newest version. The version is taken from "version" in the
+// custom metadata of the target, or else from the name of its directory, and
+// the hash of the executable from "sha256" there, the hashes of TUF being
+// those of the published file. The expiry of the metadata applies to the
+// manifest. The decoder doesn't check signatures.
+type TUFManifest struct{}
+
+type tufTargets struct {
+	Signed struct {
+		Type    string               `json:"_type"`
+		Expires time.Time            `json:"expires"`
+		Targets map[string]tufTarget `json:"targets"`
+	} `json:"signed"`
+}
+
+type tufTarget struct {
+	Length int64 `json:"length"`
+	Custom struct {
+		Version string `json:"version"`
+		Sha256  string `json:"sha256"`
+	} `json:"custom"`
+}
+
+func (TUFManifest) Decode(body []byte, platform string, info *VersionInfo) error {
+	var meta tufTargets
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return err
+	}
+	if meta.Signed.Type != "targets" {
+		return fmt.Errorf("TUF metadata of type %q, want targets", meta.Signed.Type)
+	}
+	var name, version string
+	var target tufTarget
+	for n, t := range meta.Signed.Targets {
+		// patches are named like the platform without an extension
+		base := path.Base(n)
+		if stem, _, _ := splitFormat(base); stem != platform || base == platform {
+			continue
+		}
+		v := firstNonEmpty(t.Custom.Version, path.Base(path.Dir(n)))
+		if name == "" || compareVersions(v, version) > 0 {
+			name, version, target = n, v, t
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("TUF targets have no release for %s", platform)
+	}
+	info.Version = version
+	info.Size = target.Length
+	info.Expires = meta.Signed.Expires
+	if target.Custom.Sha256 != "" {
+		sum, err := hex.DecodeString(target.Custom.Sha256)
+		if err != nil {
+			return fmt.Errorf("bad hash of target %s: %v", name, err)
+		}
+		info.Sha256 = sum
+	}
+	_, info.Archive, info.Compression = splitFormat(path.Base(name))
+	return nil
+}
+
+// splitFormat splits the file name of a full binary into its stem and the
+// archive or compression format its extension stands for. Unknown
+// extensions are taken to be archive formats, for Updater.Archives.
+func splitFormat(name string) (stem, archive, compression string) {
+	for format, ext := range archiveExtensions {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), format, ""
+		}
+	}
+	for format, ext := range compressionExtensions {
+		if format != "" && strings.HasSuffix(name, ext) {
+			if format == CompressionGzip {
+				format = ""
+			}
+			return strings.TrimSuffix(name, ext), "", format
+		}
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext), strings.TrimPrefix(ext, "."), ""
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// manifestDecoder returns u.ManifestDecoder, or the decoder of the
+// generator's JSON manifests if it is not set.
+func (u *Updater) manifestDecoder() ManifestDecoder {
+	if u.ManifestDecoder != nil {
+		return u.ManifestDecoder
+	}
+	return JSONManifest{}
+}
@@ -0,0 +1,94 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// holds the measured download throughput
+const throughputPath = "throughput" // path to throughput history relative to u.Dir
+
+// minThroughputSample is the smallest download that is used to update the
+// throughput history, smaller ones are dominated by latency.
+const minThroughputSample = 64 << 10
+
+// PatchInfo describes the patch from an older version to the one in the
+// manifest.
+type PatchInfo struct {
+	Size   int64  // Size of the patch in bytes
+	Sha256 []byte // Optional hash of the patch, the result has the hash of the manifest's Version
+}
+
+// UpdateEstimate is the expected cost of updating to the latest version.
+type UpdateEstimate struct {
+	Version  string        // Version the update would install
+	Bytes    int64         // Bytes to download, 0 if the manifest does not publish sizes
+	Patch    bool          // Whether a patch would be downloaded rather than the full binary
+	Duration time.Duration // Expected download time, 0 if there is no throughput history yet
+}
+
+// throughputState is the persisted throughput history, a moving average of
+// the bytes per second seen by previous downloads.
+type throughputState struct {
+	BytesPerSecond float64
+}
+
+// EstimateUpdate fetches the manifest and returns how much would be
+// downloaded to update to the latest version and roughly how long that would
+// take, based on the throughput of earlier downloads. Apps can use it to set
+// user expectations before starting the update.
+func (u *Updater) EstimateUpdate() (UpdateEstimate, error) {
+	if err := u.fetchInfo(context.Background()); err != nil {
+		return UpdateEstimate{}, err
+	}
+	e := UpdateEstimate{Version: u.Info.Version}
+	if u.upToDate() {
+		return e, nil
+	}
+
+	// a patch of unknown size can't be estimated, the full binary can
+	e.Bytes = u.Info.Size
+	if hops, err := u.patchPath(); err == nil && hops != nil && u.Info.DiffAlgorithm != DiffNone {
+		if size := chainSize(hops); size > 0 {
+			e.Bytes = size
+			e.Patch = true
+		}
+	}
+
+	if t := u.readThroughput(); t.BytesPerSecond > 0 {
+		e.Duration = time.Duration(float64(e.Bytes) / t.BytesPerSecond * float64(time.Second))
+	}
+	return e, nil
+}
+
+func (u *Updater) readThroughput() throughputState {
+	var t throughputState
+	p, err := u.state().Read(throughputPath)
+	if err != nil {
+		return t
+	}
+	json.Unmarshal(p, &t)
+	return t
+}
+
+// recordThroughput adds a download of n bytes that took d to the throughput
+// history.
+func (u *Updater) recordThroughput(n int64, d time.Duration) {
+	if n < minThroughputSample || d <= 0 {
+		return
+	}
+	sample := float64(n) / d.Seconds()
+	t := u.readThroughput()
+	if t.BytesPerSecond > 0 {
+		// weigh recent downloads more, networks change
+		t.BytesPerSecond = 0.7*t.BytesPerSecond + 0.3*sample
+	} else {
+		t.BytesPerSecond = sample
+	}
+	p, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	u.state().Write(throughputPath, p)
+}
@@ -0,0 +1,175 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotExecutable is returned when u.VerifyExecutable is set and the
+	// new executable isn't an ELF, Mach-O or PE file for the platform
+	// updates are fetched for.
+	ErrNotExecutable = errors.New("new executable is not built for this platform")
+
+	// ErrProbeFailed is returned, wrapped, when the new executable run with
+	// u.ProbeArgs doesn't exit successfully.
+	ErrProbeFailed = errors.New("new executable failed the probe")
+)
+
+// probeTimeout is how long the probe of a new executable may take, and
+// maxProbeOutput how much of its output goes into the error if it fails.
+const (
+	probeTimeout   = 10 * time.Second
+	maxProbeOutput = 512
+)
+
+// elfMachines, machoCPUs and peMachines are the architectures executables
+// built for a GOARCH declare in their headers.
+var (
+	elfMachines = map[string]elf.Machine{
+		"386": elf.EM_386, "amd64": elf.EM_X86_64, "arm": elf.EM_ARM, "arm64": elf.EM_AARCH64,
+		"mips": elf.EM_MIPS, "mipsle": elf.EM_MIPS, "mips64": elf.EM_MIPS, "mips64le": elf.EM_MIPS,
+		"ppc64": elf.EM_PPC64, "ppc64le": elf.EM_PPC64, "riscv64": elf.EM_RISCV, "s390x": elf.EM_S390,
+	}
+	machoCPUs = map[string]macho.Cpu{
+		"amd64": macho.CpuAmd64, "arm64": macho.CpuArm64,
+	}
+	peMachines = map[string]uint16{
+		"386": pe.IMAGE_FILE_MACHINE_I386, "amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+		"arm": pe.IMAGE_FILE_MACHINE_ARMNT, "arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+	}
+)
+
+// checkExecutable returns ErrNotExecutable unless the file at path is an
+// executable for platform, such as linux-amd64 or linux-arm-7. Platforms
+// whose executables are neither ELF, Mach-O nor PE, and architectures not
+// known here, aren't checked.
+func checkExecutable(path, platform string) error {
+	parts := strings.SplitN(platform, "-", 3)
+	if len(parts) < 2 {
+		return nil
+	}
+	goos, goarch := parts[0], parts[1]
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var ok bool
+	switch goos {
+	case "darwin", "ios":
+		ok = isMachOFor(f, goarch)
+	case "windows":
+		ok = isPEFor(f, goarch)
+	case "aix", "js", "plan9", "wasip1":
+		return nil
+	default:
+		ok = isELFFor(f, goarch)
+	}
+	if !ok {
+		return ErrNotExecutable
+	}
+	return nil
+}
+
+func isELFFor(r io.ReaderAt, goarch string) bool {
+	f, err := elf.NewFile(r)
+	if err != nil || f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return false
+	}
+	want, known := elfMachines[goarch]
+	return !known || f.Machine == want
+}
+
+func isMachOFor(r io.ReaderAt, goarch string) bool {
+	want, known := machoCPUs[goarch]
+	if f, err := macho.NewFile(r); err == nil {
+		return f.Type == macho.TypeExec && (!known || f.Cpu == want)
+	}
+	// a universal binary has to contain the architecture
+	fat, err := macho.NewFatFile(r)
+	if err != nil {
+		return false
+	}
+	for _, a := range fat.Arches {
+		if !known || a.Cpu == want {
+			return true
+		}
+	}
+	return false
+}
+
+func isPEFor(r io.ReaderAt, goarch string) bool {
+	f, err := pe.NewFile(r)
+	if err != nil || f.Characteristics&pe.IMAGE_FILE_EXECUTABLE_IMAGE == 0 {
+		return false
+	}
+	want, known := peMachines[goarch]
+	return !known || f.Machine == want
+}
+
+// checkNewExecutable checks the new executable at path as asked for by
+// u.VerifyExecutable and u.ProbeArgs. The probe is skipped for executables
+// of other platforms than the running one, which can't be run here.
+func (u *Updater) checkNewExecutable(path string) error {
+	if u.VerifyExecutable {
+		if err := checkExecutable(path, u.platform()); err != nil {
+			return err
+		}
+	}
+	if len(u.ProbeArgs) == 0 {
+		return nil
+	}
+	if !strings.HasPrefix(u.platform()+"-", plat+"-") {
+		u.logger().Debug("not probing executable of another platform", "platform", u.platform())
+		return nil
+	}
+	return u.probe(path)
+}
+
+// probe runs the executable at path with u.ProbeArgs in an empty temporary
+// directory, with no environment but PATH and on Windows SYSTEMROOT, and
+// returns an error wrapping ErrProbeFailed unless it exits successfully
+// within probeTimeout.
+func (u *Updater) probe(path string) error {
+	dir, err := ioutil.TempDir("", "selfupdate-probe")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, u.ProbeArgs...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	if root := os.Getenv("SYSTEMROOT"); root != "" {
+		// Windows programs can't do much without it
+		cmd.Env = append(cmd.Env, "SYSTEMROOT="+root)
+	}
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		output := bytes.TrimSpace(out.Bytes())
+		if len(output) > maxProbeOutput {
+			output = output[:maxProbeOutput]
+		}
+		return fmt.Errorf("%w: %v: %s", ErrProbeFailed, err, output)
+	}
+	return nil
+}
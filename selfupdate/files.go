@@ -0,0 +1,181 @@
+package selfupdate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReleaseFile is a file published with a release besides the main
+// executable, such as a helper daemon shipped with a CLI, a plugin or a data
+// bundle.
+type ReleaseFile struct {
+	Name   string      // Base name of the file on the client, such as myapp-helper
+	Sha256 []byte      // Hash of the file
+	Size   int64       // Size of the compressed file in bytes
+	Mode   os.FileMode `json:",omitempty"` // Permissions of the file, 0755 when not set
+}
+
+// mode returns the permissions the file is installed with.
+func (f ReleaseFile) mode() os.FileMode {
+	if f.Mode == 0 {
+		return 0755
+	}
+	return f.Mode.Perm()
+}
+
+// stagedFile is a file of an update that was downloaded and verified but
+// not installed yet.
+type stagedFile struct {
+	Path    string // File to replace, or to create if it doesn't exist yet
+	NewPath string // Where its new version is staged
+	Sha256  []byte // Hash the new version has
+}
+
+// FileResolver resolves to a fixed path, such as that of a plugin or data
+// file updated along with the executable. Relative paths are taken to be
+// relative to the directory of the running executable.
+type FileResolver string
+
+// Path returns the path of the file.
+func (r FileResolver) Path() (string, error) {
+	path := string(r)
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	exe, err := ExecutableResolver{}.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exe), path), nil
+}
+
+// releaseFile returns the file named name in the fetched manifest. On
+// Windows the .exe suffix is ignored, so that a target named myapp-helper
+// matches myapp-helper.exe and the other way round.
+func (u *Updater) releaseFile(name string) (ReleaseFile, bool) {
+	for _, f := range u.Info.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	if u.windows() {
+		for _, f := range u.Info.Files {
+			if strings.TrimSuffix(f.Name, ".exe") == strings.TrimSuffix(name, ".exe") {
+				return f, true
+			}
+		}
+	}
+	return ReleaseFile{}, false
+}
+
+// downloadFiles stages the new versions of u.Targets, each matched with the
+// file of the same name in the manifest. Targets that don't exist yet are
+// created, along with their directory. Either all of them are staged or, on
+// error, none.
+func (u *Updater) downloadFiles(ctx context.Context, result *UpdateResult) ([]stagedFile, error) {
+	var staged []stagedFile
+	for _, target := range u.Targets {
+		path, err := target.Path()
+		if err != nil {
+			removeStaged(staged)
+			return nil, err
+		}
+		f, ok := u.releaseFile(filepath.Base(path))
+		if !ok {
+			removeStaged(staged)
+			return nil, fmt.Errorf("release %s has no file %s", u.Info.Version, filepath.Base(path))
+		}
+		if u.windows() && filepath.Ext(path) == "" && filepath.Ext(f.Name) == ".exe" {
+			// Windows only runs it with the suffix
+			path += ".exe"
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			removeStaged(staged)
+			return nil, err
+		}
+		if err := canUpdate(path); err != nil {
+			removeStaged(staged)
+			return nil, err
+		}
+		newPath, err := stageUpdate(path, func(p string) error { return verifyFile(p, f.Sha256) }, func(w io.Writer) error {
+			n, err := u.fetchFile(ctx, f, w)
+			result.Bytes += n
+			return err
+		})
+		if err != nil {
+			u.logger().Error("fetching file failed", "version", u.Info.Version, "file", f.Name, "error", err)
+			removeStaged(staged)
+			return nil, err
+		}
+		// keep owner, permissions and extended attributes of the file it
+		// replaces unless the manifest sets the mode
+		err = copyAttributes(path, newPath)
+		if os.IsNotExist(err) || (err == nil && f.Mode != 0) {
+			err = os.Chmod(newPath, f.mode())
+		}
+		if err != nil {
+			_ = os.Remove(newPath)
+			removeStaged(staged)
+			return nil, err
+		}
+		staged = append(staged, stagedFile{Path: path, NewPath: newPath, Sha256: f.Sha256})
+	}
+	return staged, nil
+}
+
+// fetchFile downloads the file f of the release and writes it, decompressed,
+// to w. It returns the number of bytes downloaded.
+func (u *Updater) fetchFile(ctx context.Context, f ReleaseFile, w io.Writer) (int64, error) {
+	r, err := u.source().Binary(ctx, u.CmdName, u.Info.Version, u.platform()+"-"+f.Name+compressionExtensions[u.Info.Compression])
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cr := u.countDownload(r, ProgressBinary, f.Size)
+	err = decompress(u.Info.Compression, cr, w)
+	return cr.n, err
+}
+
+// installFiles installs all staged files or, if one of them can't be
+// installed, puts back the ones that already were and removes the ones that
+// didn't exist before. If it failed because a file was busy the staged files
+// are kept, so that the update can be deferred.
+func installFiles(files []stagedFile) error {
+	created := make([]bool, len(files))
+	for i, f := range files {
+		_, err := os.Stat(f.Path)
+		created[i] = os.IsNotExist(err)
+		err, errRecover := install(f.NewPath, f.Path)
+		if err == nil {
+			continue
+		}
+		if !isBusy(err) {
+			removeStaged(files[i+1:])
+		}
+		for j, done := range files[:i] {
+			if created[j] {
+				_ = os.Remove(done.Path)
+				continue
+			}
+			if errRevert := restorePrevious(done.Path); errRevert != nil && errRecover == nil {
+				errRecover = errRevert
+			}
+		}
+		if errRecover != nil {
+			return fmt.Errorf("update and recovery errors: %q %q", err, errRecover)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeStaged removes the staged new versions of files.
+func removeStaged(files []stagedFile) {
+	for _, f := range files {
+		_ = os.Remove(f.NewPath)
+	}
+}
@@ -0,0 +1,115 @@
+package selfupdate
+
+import (
+	"context"
+	"sync"
+)
+
+// ApplyGate coordinates installing an update with the application, so that
+// long running servers and workers can finish in-flight work first.
+type ApplyGate interface {
+	// WaitIdle blocks until the application is idle and keeps it from
+	// starting new work until Release is called. Returning an error aborts
+	// the update, WaitIdle must return ctx.Err() once ctx is done, Release
+	// is not called then.
+	WaitIdle(ctx context.Context) error
+	// Release lets the application resume work after the update was
+	// installed or aborted.
+	Release()
+}
+
+// IdleGate is an ApplyGate that tracks in-flight work. Call Begin before
+// and End after every job or request; the Updater then waits for running
+// jobs to finish before swapping the executable, and Begin blocks while it
+// does so.
+//
+//	gate := &selfupdate.IdleGate{}
+//	updater.ApplyGate = gate
+//
+//	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		gate.Begin()
+//		defer gate.End()
+//		// ...
+//	})
+type IdleGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	applying bool
+}
+
+func (g *IdleGate) init() {
+	if g.cond == nil {
+		g.cond = sync.NewCond(&g.mu)
+	}
+}
+
+// Begin marks the start of a unit of work. It blocks while an update is
+// being applied.
+func (g *IdleGate) Begin() {
+	g.mu.Lock()
+	g.init()
+	for g.applying {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+// End marks the end of a unit of work started with Begin.
+func (g *IdleGate) End() {
+	g.mu.Lock()
+	g.init()
+	g.active--
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// WaitIdle waits until no work is in flight and blocks new work. If ctx is
+// done first new work is let through again and ctx.Err() returned.
+func (g *IdleGate) WaitIdle(ctx context.Context) error {
+	g.mu.Lock()
+	g.init()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// taking the lock makes sure the waits below see ctx done or
+			// are woken
+			g.mu.Lock()
+			g.mu.Unlock()
+			g.cond.Broadcast()
+		case <-stop:
+		}
+	}()
+
+	for g.applying && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	g.applying = true
+	for g.active > 0 && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		g.applying = false
+		g.mu.Unlock()
+		g.cond.Broadcast()
+		return err
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+// Release lets blocked Begin calls proceed.
+func (g *IdleGate) Release() {
+	g.mu.Lock()
+	g.init()
+	g.applying = false
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
@@ -0,0 +1,159 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com/"
+
+// GitHubReleaseSource is an UpdateSource that downloads updates from the
+// releases of a GitHub repository, so no update server is needed. Each
+// release is tagged with its version and carries the files written by the
+// go-selfupdate generator as assets, named after the platform:
+//
+//	linux-amd64.json          the manifest, from public/linux-amd64.json
+//	linux-amd64.gz            the full binary, from public/<version>/linux-amd64.gz,
+//	                          or linux-amd64.zip etc. if the manifest names an Archive
+//	linux-amd64-1.1.patch     optional patch from 1.1, from public/1.1/<version>/linux-amd64
+//	keys.json                 optional key manifest named by Updater.KeyManifest, as written by rotate
+//
+// The latest release provides the manifest and the description of a release
+// serves as its release notes. As a repository hosts a single command the
+// Updater's CmdName is not used.
+//
+// The requests go through the Requester, Middleware, HTTPClient and pins of
+// the Updater, like those of the default source. They carry the Accept and
+// Authorization headers GitHub expects, which a Requester other than
+// HTTPRequester has to send itself.
+//
+// Example:
+//
+//	updater.Source = &selfupdate.GitHubReleaseSource{Owner: "sanbornm", Repo: "myapp"}
+type GitHubReleaseSource struct {
+	Owner  string       // Owner of the repository
+	Repo   string       // Name of the repository
+	Token  string       // Optional access token, required for private repositories
+	APIURL string       // Optional API base URL for GitHub Enterprise, defaults to https://api.github.com/
+	Client *http.Client // Optional HTTP client unless the Updater has an HTTPClient or Requester, defaults to the one HTTPRequester uses
+
+	u *Updater // Updater fetching through the source, set by bind
+}
+
+func (s *GitHubReleaseSource) bind(u *Updater) UpdateSource {
+	bound := *s
+	bound.u = u
+	return &bound
+}
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Body    string        `json:"body"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Manifest returns the manifest asset of the latest release.
+func (s *GitHubReleaseSource) Manifest(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	return s.asset(ctx, "releases/latest", platform+".json")
+}
+
+// ManifestSignature returns the detached signature of the manifest asset of
+// the latest release.
+func (s *GitHubReleaseSource) ManifestSignature(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	return s.asset(ctx, "releases/latest", platform+".json.asc")
+}
+
+// KeyManifest returns the key manifest asset called name of the latest
+// release.
+func (s *GitHubReleaseSource) KeyManifest(ctx context.Context, cmd, name string) (io.ReadCloser, error) {
+	return s.asset(ctx, "releases/latest", name)
+}
+
+// Binary returns the full binary asset of the release tagged version.
+func (s *GitHubReleaseSource) Binary(ctx context.Context, cmd, version, file string) (io.ReadCloser, error) {
+	return s.asset(ctx, "releases/tags/"+url.PathEscape(version), file)
+}
+
+// Patch returns the patch asset from version from in the release tagged to.
+func (s *GitHubReleaseSource) Patch(ctx context.Context, cmd, from, to, platform string) (io.ReadCloser, error) {
+	return s.asset(ctx, "releases/tags/"+url.PathEscape(to), platform+"-"+from+".patch")
+}
+
+// ReleaseNotes returns the description of the release tagged version.
+func (s *GitHubReleaseSource) ReleaseNotes(ctx context.Context, cmd, version string) (io.ReadCloser, error) {
+	release, err := s.release(ctx, "releases/tags/"+url.PathEscape(version))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(release.Body)), nil
+}
+
+// release fetches the release at path below the repository.
+func (s *GitHubReleaseSource) release(ctx context.Context, path string) (*githubRelease, error) {
+	apiURL := s.APIURL
+	if apiURL == "" {
+		apiURL = defaultGitHubAPIURL
+	}
+	r, err := s.get(ctx, apiURL+"repos/"+url.PathEscape(s.Owner)+"/"+url.PathEscape(s.Repo)+"/"+path, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var release githubRelease
+	if err := json.NewDecoder(r).Decode(&release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// asset downloads the asset called name of the release at path below the
+// repository.
+func (s *GitHubReleaseSource) asset(ctx context.Context, path, name string) (io.ReadCloser, error) {
+	release, err := s.release(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range release.Assets {
+		if a.Name == name {
+			// the API URL of an asset works for private repositories too,
+			// it redirects to the download
+			return s.get(ctx, a.URL, "application/octet-stream")
+		}
+	}
+	return nil, &missingAssetError{release: release.TagName, repo: s.Owner + "/" + s.Repo, name: name}
+}
+
+// missingAssetError is returned when a release has no asset of the name
+// asked for, which is what a 404 is for other sources.
+type missingAssetError struct {
+	release, repo, name string
+}
+
+func (e *missingAssetError) Error() string {
+	return fmt.Sprintf("release %s of %s has no asset %s", e.release, e.repo, e.name)
+}
+
+// get fetches url through the Updater using the source, if any, with the
+// headers GitHub expects.
+func (s *GitHubReleaseSource) get(ctx context.Context, url, accept string) (io.ReadCloser, error) {
+	header := map[string]string{"Accept": accept}
+	if s.Token != "" {
+		header["Authorization"] = "Bearer " + s.Token
+	}
+	ctx = withHeader(ctx, header)
+	if s.u != nil {
+		return s.u.fetchWith(ctx, url, s.Client)
+	}
+	return (&HTTPRequester{Client: s.Client}).FetchContext(ctx, url)
+}
@@ -0,0 +1,25 @@
+//go:build go1.18
+// +build go1.18
+
+package selfupdate
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// goarm returns the GOARM version the program was built with, or "" if it
+// isn't recorded.
+func goarm() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, s := range info.Settings {
+		if s.Key == "GOARM" {
+			// newer toolchains add the float mode, as in 7,softfloat
+			return strings.SplitN(s.Value, ",", 2)[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !go1.18
+// +build !go1.18
+
+package selfupdate
+
+// goarm returns "", toolchains before Go 1.18 don't record the GOARM version
+// in the executable.
+func goarm() string {
+	return ""
+}
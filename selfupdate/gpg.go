@@ -0,0 +1,85 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// maxManifestSignatureSize bounds the detached manifest signature read from
+// a source.
+const maxManifestSignatureSize = 64 << 10
+
+// ManifestSignatureSource is implemented by UpdateSources that publish a
+// detached OpenPGP signature of the manifest, which Updater.GPGKeyring checks
+// manifests that aren't clearsigned against. The default source fetches it
+// from the URL of the manifest with .asc appended, GitHubReleaseSource from
+// the release asset named like the manifest with .asc appended.
+type ManifestSignatureSource interface {
+	ManifestSignature(ctx context.Context, cmd, platform string) (io.ReadCloser, error)
+}
+
+// verifyGPG checks the manifest body against u.GPGKeyring, if set, and
+// returns the manifest it vouches for. A clearsigned manifest carries its
+// signature, which is stripped, otherwise the detached signature is fetched
+// from the source. It returns an error wrapping ErrSignatureInvalid if the
+// signature is missing or made by a key not in the keyring.
+func (u *Updater) verifyGPG(ctx context.Context, body []byte) ([]byte, error) {
+	if u.GPGKeyring == nil {
+		return body, nil
+	}
+	keyring, err := readKeyring(u.GPGKeyring)
+	if err != nil {
+		return nil, fmt.Errorf("GPG keyring: %v", err)
+	}
+	if block, _ := clearsign.Decode(body); block != nil {
+		if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+		}
+		return block.Plaintext, nil
+	}
+
+	src, ok := u.baseSource().(ManifestSignatureSource)
+	if !ok {
+		return nil, fmt.Errorf("%w: manifest isn't clearsigned and the source publishes no signatures", ErrSignatureInvalid)
+	}
+	r, err := src.ManifestSignature(ctx, u.CmdName, u.platform())
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: no signature published", ErrSignatureInvalid)
+		}
+		return nil, err
+	}
+	defer r.Close()
+	sig, err := ioutil.ReadAll(io.LimitReader(r, maxManifestSignatureSize))
+	if err != nil {
+		return nil, err
+	}
+	check := openpgp.CheckDetachedSignature
+	if isArmored(sig) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	if _, err := check(keyring, bytes.NewReader(body), bytes.NewReader(sig)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+	}
+	return body, nil
+}
+
+// readKeyring reads an armored or binary OpenPGP keyring.
+func readKeyring(b []byte) (openpgp.EntityList, error) {
+	if isArmored(b) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(b))
+}
+
+// isArmored reports whether b is ASCII armored rather than binary OpenPGP
+// data.
+func isArmored(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN PGP"))
+}
@@ -0,0 +1,49 @@
+package selfupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRolledBack is returned, wrapped along with the reason, when the
+// HealthCheck of a newly installed executable failed and the previous one
+// was put back.
+var ErrRolledBack = errors.New("update rolled back")
+
+// healthCheckInterval is the pause between attempts of the HealthCheck
+// during HealthCheckWindow.
+const healthCheckInterval = time.Second
+
+// checkHealth runs u.HealthCheck against the executable just installed at
+// path until it passes or u.HealthCheckWindow is over, once if there is no
+// window. If it doesn't pass the update is rolled back, the release isn't
+// installed again, and an error wrapping ErrRolledBack is returned.
+func (u *Updater) checkHealth(ctx context.Context, path string) error {
+	if u.HealthCheck == nil {
+		return nil
+	}
+	deadline := time.Now().Add(u.HealthCheckWindow)
+	err := u.HealthCheck(path)
+	for err != nil && time.Until(deadline) > healthCheckInterval && ctx.Err() == nil {
+		u.logger().Debug("health check failed, trying again", "error", err)
+		timer := time.NewTimer(healthCheckInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		err = u.HealthCheck(path)
+	}
+	if err == nil {
+		return nil
+	}
+
+	u.logger().Error("health check of new version failed", "version", u.readRollback().Installed, "error", err)
+	if errRollback := u.rollback(path); errRollback != nil {
+		return fmt.Errorf("health check failed: %v, rolling back failed: %w", err, errRollback)
+	}
+	return fmt.Errorf("%w: health check failed: %v", ErrRolledBack, err)
+}
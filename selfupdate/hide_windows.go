@@ -17,3 +17,17 @@ func hideFile(path string) error {
 		return nil
 	}
 }
+
+func unhideFile(path string) error {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	setFileAttributes := kernel32.NewProc("SetFileAttributesW")
+
+	// FILE_ATTRIBUTE_NORMAL
+	r1, _, err := setFileAttributes.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(path))), 0x80)
+
+	if r1 == 0 {
+		return err
+	} else {
+		return nil
+	}
+}
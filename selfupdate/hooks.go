@@ -0,0 +1,31 @@
+package selfupdate
+
+import (
+	"context"
+	"errors"
+)
+
+// updateAvailable calls OnUpdateAvailable for version.
+func (u *Updater) updateAvailable(version string) {
+	if u.OnUpdateAvailable != nil {
+		u.OnUpdateAvailable(version)
+	}
+}
+
+// beforeUpdate asks OnBeforeUpdate whether the fetched release may be
+// installed now. Mandatory releases are installed without asking.
+func (u *Updater) beforeUpdate() error {
+	if u.OnBeforeUpdate == nil || u.mandatory() {
+		return nil
+	}
+	return u.OnBeforeUpdate(u.Info.Version)
+}
+
+// notifyError passes err to OnError unless it is nil or the caller
+// cancelled the update.
+func (u *Updater) notifyError(err error) {
+	if err == nil || u.OnError == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	u.OnError(err)
+}
@@ -0,0 +1,169 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// keysPath is the state file the last verified key manifest is kept in.
+const keysPath = "keys"
+
+// maxKeyManifestSize bounds the key manifest read from the server.
+const maxKeyManifestSize = 1 << 20
+
+// ErrKeyManifestInvalid is returned when the key manifest isn't signed by a
+// trusted key or is older than the one verified before.
+var ErrKeyManifestInvalid = errors.New("key manifest signature is missing or invalid")
+
+// errNoKeyManifestSource is the reason a KeyManifest is ignored with a Source
+// that doesn't publish key manifests.
+var errNoKeyManifestSource = errors.New("Source publishes no key manifest, it doesn't implement KeyManifestSource")
+
+// KeyManifestSource is implemented by UpdateSources that publish a key
+// manifest, named by Updater.KeyManifest. The default source fetches it from
+// next to the manifests, GitHubReleaseSource from the asset of that name of
+// the latest release.
+type KeyManifestSource interface {
+	KeyManifest(ctx context.Context, cmd, name string) (io.ReadCloser, error)
+}
+
+// TrustedKey is an Ed25519 key manifests may be signed with. The validity
+// bounds are compared to the time of the check, not to a timestamp the key
+// signs itself, so a retired key can't vouch for anything by backdating it.
+type TrustedKey struct {
+	PublicKey ed25519.PublicKey
+	NotBefore time.Time // The key isn't trusted before, zero means no bound
+	NotAfter  time.Time // The key isn't trusted after, zero means no bound
+}
+
+// validAt reports whether the key is trusted at now, give or take skew.
+func (k TrustedKey) validAt(now time.Time, skew time.Duration) bool {
+	return len(k.PublicKey) == ed25519.PublicKeySize &&
+		(k.NotBefore.IsZero() || !now.Add(skew).Before(k.NotBefore)) &&
+		(k.NotAfter.IsZero() || !now.Add(-skew).After(k.NotAfter))
+}
+
+// KeyManifest lists the keys manifests are signed with, so that publishers
+// can rotate their signing key. It is published as Updater.KeyManifest and
+// written by go-selfupdate rotate. Clients take it from the server only if
+// it is signed by a key they already trust, and from then on trust only the
+// keys it lists, with the validity given there, in place of PublicKey and
+// TrustedKeys.
+type KeyManifest struct {
+	Timestamp  time.Time      // Time the key manifest was signed, clients refuse older ones than they have seen
+	Keys       []TrustedKey   // Keys manifests may be signed with
+	Signatures []KeySignature // Signatures of the key manifest
+}
+
+// KeySignature is a signature of a key manifest.
+type KeySignature struct {
+	PublicKey ed25519.PublicKey // Key the signature was made with
+	Signature []byte
+}
+
+// Payload returns the bytes the signatures of the key manifest are computed
+// over, which go-selfupdate rotate signs as well.
+func (m *KeyManifest) Payload() []byte {
+	s := "go-selfupdate keys v1\n" + m.Timestamp.UTC().Format(time.RFC3339) + "\n"
+	for _, k := range m.Keys {
+		s += "key " + base64.StdEncoding.EncodeToString(k.PublicKey) + " " + formatBound(k.NotBefore) + " " + formatBound(k.NotAfter) + "\n"
+	}
+	return []byte(s)
+}
+
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// signedBy reports whether the key manifest carries a valid signature of a
+// key in keys that is valid at now, give or take skew.
+func (m *KeyManifest) signedBy(keys []TrustedKey, now time.Time, skew time.Duration) bool {
+	payload := m.Payload()
+	for _, sig := range m.Signatures {
+		for _, k := range keys {
+			if bytes.Equal(sig.PublicKey, k.PublicKey) && k.validAt(now, skew) && ed25519.Verify(k.PublicKey, payload, sig.Signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trustedKeys returns the keys manifests may be signed with: those of the
+// key manifest verified last, or until there is one u.PublicKey, valid
+// without bounds, and u.TrustedKeys. A key the key manifest leaves out is no
+// longer trusted, however the Updater is configured.
+func (u *Updater) trustedKeys() []TrustedKey {
+	if m, ok := u.readKeyManifest(); ok {
+		return m.Keys
+	}
+	var keys []TrustedKey
+	if u.PublicKey != nil {
+		keys = append(keys, TrustedKey{PublicKey: u.PublicKey})
+	}
+	return append(keys, u.TrustedKeys...)
+}
+
+// updateKeys fetches the key manifest, if u.KeyManifest is set, and keeps it
+// if it is signed by a trusted key and not older than the one kept before.
+// A key manifest that isn't published is no error, nor is a source that
+// can't publish one, which is logged and leaves the keys as they are.
+func (u *Updater) updateKeys(ctx context.Context) error {
+	if u.KeyManifest == "" {
+		return nil
+	}
+	src, ok := u.baseSource().(KeyManifestSource)
+	if !ok {
+		u.logger().Warn("key manifest skipped", "error", &ConfigError{"KeyManifest", errNoKeyManifestSource})
+		return nil
+	}
+	r, err := src.KeyManifest(ctx, u.CmdName, u.KeyManifest)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxKeyManifestSize))
+	if err != nil {
+		return err
+	}
+	var m KeyManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return fmt.Errorf("key manifest: %v", err)
+	}
+	old, ok := u.readKeyManifest()
+	if ok && !m.Timestamp.After(old.Timestamp) {
+		if m.Timestamp.Equal(old.Timestamp) {
+			return nil
+		}
+		return fmt.Errorf("%w: older than the one seen before", ErrKeyManifestInvalid)
+	}
+	if !m.signedBy(u.trustedKeys(), u.now(), u.clockSkew()) {
+		return ErrKeyManifestInvalid
+	}
+	u.logger().Info("updated signing keys", "keys", len(m.Keys))
+	return u.state().Write(keysPath, body)
+}
+
+// readKeyManifest returns the key manifest verified last.
+func (u *Updater) readKeyManifest() (KeyManifest, bool) {
+	var m KeyManifest
+	b, err := u.state().Read(keysPath)
+	if err != nil {
+		return m, false
+	}
+	return m, json.Unmarshal(b, &m) == nil
+}
@@ -0,0 +1,49 @@
+package selfupdate
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// holds the outcome of the latest update check
+const lastCheckPath = "lastcheck" // path to last check result relative to u.Dir
+
+// LastCheckResult is the persisted outcome of the most recent update check.
+type LastCheckResult struct {
+	Time      time.Time // When the check was made
+	Version   string    // Latest version published, empty if the check failed
+	Available bool      // Whether Version differs from the version that was running and is offered to this installation
+	Error     string    `json:",omitempty"` // Why the check failed, empty on success
+}
+
+// LastCheck returns the result of the most recent update check, as stored in
+// the state directory, without making any network request. The boolean is
+// false if no check was recorded yet. This lets an app show something like
+// "last checked 2h ago, v1.9.3 available" right after it starts.
+func (u *Updater) LastCheck() (LastCheckResult, bool) {
+	var result LastCheckResult
+	p, err := u.state().Read(lastCheckPath)
+	if err != nil {
+		return result, false
+	}
+	if err := json.Unmarshal(p, &result); err != nil {
+		return LastCheckResult{}, false
+	}
+	return result, true
+}
+
+// saveLastCheck stores the outcome of the manifest fetch that just finished.
+func (u *Updater) saveLastCheck(err error) {
+	result := LastCheckResult{Time: time.Now()}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Version = u.Info.Version
+		result.Available = !u.upToDate() && u.offered() && !u.skipped()
+	}
+	p, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	u.state().Write(lastCheckPath, p)
+}
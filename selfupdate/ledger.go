@@ -0,0 +1,55 @@
+package selfupdate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// holds the highest version installed so far
+const ledgerPath = "ledger" // path to the version ledger relative to u.Dir
+
+// ErrVersionRollback is returned when the manifest offers a version older
+// than one installed before, which indicates a replayed manifest of an old,
+// possibly vulnerable release.
+var ErrVersionRollback = errors.New("update manifest offers an older version than installed before")
+
+// checkLedger refuses the manifest in u.Info if it offers a version older
+// than the running one or the highest one installed so far, unless
+// u.AllowDowngrade is set. The running version covers installations without
+// a ledger, fresh ones or ones whose state was wiped. A Paused release is
+// withheld anyway, whatever its version.
+func (u *Updater) checkLedger() error {
+	if u.AllowDowngrade || u.Info.Paused {
+		return nil
+	}
+	highest := u.highestInstalled()
+	if u.CurrentVersion != "dev" && (highest == "" || compareVersions(u.CurrentVersion, highest) > 0) {
+		highest = u.CurrentVersion
+	}
+	if highest != "" && compareVersions(u.Info.Version, highest) < 0 {
+		return fmt.Errorf("%w: %s offered, %s installed", ErrVersionRollback, u.Info.Version, highest)
+	}
+	return nil
+}
+
+// highestInstalled returns the highest version installed so far, or "" if
+// none was.
+func (u *Updater) highestInstalled() string {
+	p, err := u.state().Read(ledgerPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(p))
+}
+
+// recordInstalled raises the ledger to version unless a newer one was
+// installed before. It is only called once the HealthCheck passed, a release
+// rolled back right away doesn't count. The ledger never goes down, not even
+// on Rollback.
+func (u *Updater) recordInstalled(version string) {
+	if highest := u.highestInstalled(); highest != "" && compareVersions(version, highest) <= 0 {
+		return
+	}
+	u.state().Write(ledgerPath, []byte(version))
+}
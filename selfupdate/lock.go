@@ -0,0 +1,46 @@
+package selfupdate
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrUpdateInProgress is returned when another process, such as a second
+// instance of the application, is updating the same executable right now.
+var ErrUpdateInProgress = errors.New("update in progress in another process")
+
+// lockPath returns the path of the lock file guarding updates of the file at
+// path. It is kept in the temporary directory, named after a hash of the
+// path, so that it doesn't clutter the directory of the executable and works
+// when the state directory isn't shared.
+func lockPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("selfupdate-%x.lock", sum[:8]))
+}
+
+// lockUpdate takes the advisory lock on updating the file at path without
+// waiting for it, and returns ErrUpdateInProgress if another process holds
+// it. The lock is released by unlock, or by the system when the process
+// exits or execs. The lock file itself stays, removing it would let two
+// processes lock different files.
+func lockUpdate(path string) (unlock func(), err error) {
+	// read-only, so that other users can lock the file as well
+	f, err := os.OpenFile(lockPath(path), os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,57 @@
+package selfupdate
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the log messages of an Updater. keyvals are alternating
+// keys and values adding structured context to msg, such as "version",
+// "1.2". A *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NopLogger discards all log messages.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (NopLogger) Info(msg string, keyvals ...interface{})  {}
+func (NopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (NopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// stdLogger is the default Logger, it writes everything but debug messages
+// to the standard logger of the log package.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, keyvals ...interface{}) {}
+func (stdLogger) Info(msg string, keyvals ...interface{})  { stdLog(msg, keyvals) }
+func (stdLogger) Warn(msg string, keyvals ...interface{})  { stdLog(msg, keyvals) }
+func (stdLogger) Error(msg string, keyvals ...interface{}) { stdLog(msg, keyvals) }
+
+func stdLog(msg string, keyvals []interface{}) {
+	var b strings.Builder
+	b.WriteString("update: ")
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keyvals[i])
+		}
+	}
+	log.Println(b.String())
+}
+
+// logger returns u.Logger, or the default logger writing to the log package
+// when it is not set.
+func (u *Updater) logger() Logger {
+	if u.Logger != nil {
+		return u.Logger
+	}
+	return stdLogger{}
+}
@@ -0,0 +1,148 @@
+package selfupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ManifestVersion is the newest manifest format understood. Version 2 adds
+// release notes, the minimum supported version and download URLs, manifests
+// without a version use the original format.
+const ManifestVersion = 2
+
+// DownloadURLs are the absolute URLs of the files of a release, for
+// manifests that publish them.
+type DownloadURLs struct {
+	Binary  string            // URL of the full binary
+	Patches map[string]string // URLs of the patches, keyed by the version they apply to
+}
+
+// VersionInfo is the manifest of the latest release, as fetched into
+// Updater.Info.
+type VersionInfo struct {
+	Version       string
+	Sha256        []byte
+	DiffAlgorithm string               // Algorithm the patches to Version were created with, empty means bsdiff
+	Expires       time.Time            // Time after which the manifest must not be trusted, zero means never
+	Severity      string               // Severity of the release, SeverityCritical marks a critical security fix
+	Timestamp     time.Time            // Time the release was published
+	Size          int64                // Size of the compressed full binary in bytes
+	Patches       map[string]PatchInfo // Patches to Version, keyed by the version they apply to
+	PatchChain    []PatchHop           // Patches between consecutive releases, oldest first, for versions without a patch in Patches
+	Signature     []byte               // Ed25519 signature of the manifest, checked against PublicKey and TrustedKeys
+	Archive       string               // Archive format of the full binary, empty means a compressed executable
+	Compression   string               // Compression of the full binary if it is not an archive, empty means gzip
+
+	// Fields of manifest version 2
+	ManifestVersion int           // Version of the manifest format, 0 for the original one
+	ReleaseNotes    string        // Notes describing the changes in Version
+	MinimumVersion  string        // Oldest version still supported by the publisher
+	Downloads       DownloadURLs  // Absolute URLs of the files of Version, overriding BinURL and DiffURL
+	Files           []ReleaseFile // Files published besides the executable, see Targets
+	RolloutPercent  int           // Percentage of installations Version is offered to, 0 means all
+	UpdateFrom      string        // Versions Version is offered to, e.g. ">=1.4.0, <2", empty means all
+	SkipPlatforms   []string      // Platforms Version isn't offered to, e.g. darwin-arm64
+	Minisig         string        // minisign signature of the executable, checked by MinisignVerifier
+	Paused          bool          // Version is withheld from everyone until the publisher resumes it, not covered by Signature
+	Channel         string        // Release channel of Version, Updater.Channel unless the manifest names one, not covered by Signature
+}
+
+// defaultClockSkew is the clock difference between client and update server
+// tolerated when u.MaxClockSkew is not set.
+const defaultClockSkew = 5 * time.Minute
+
+var (
+	// ErrManifestExpired is returned when the update manifest is past its
+	// expiry time, which indicates a stale mirror or a replayed manifest.
+	ErrManifestExpired = errors.New("update manifest has expired")
+
+	// ErrManifestStale is returned when the manifest's release timestamp is
+	// older than u.MaxManifestAge, which indicates a frozen mirror.
+	ErrManifestStale = errors.New("update manifest is implausibly old")
+)
+
+func (u *Updater) clockSkew() time.Duration {
+	if u.MaxClockSkew > 0 {
+		return u.MaxClockSkew
+	}
+	return defaultClockSkew
+}
+
+// now returns the current time according to u.TimeSource, falling back to
+// the local clock.
+func (u *Updater) now() time.Time {
+	if u.TimeSource != nil {
+		if t, err := u.TimeSource(); err == nil {
+			return t
+		} else {
+			u.logger().Warn("trusted time source failed, using the local clock", "error", err)
+		}
+	}
+	return time.Now()
+}
+
+// validateInfo checks the freshly fetched manifest in u.Info before any of
+// its contents are acted on.
+func (u *Updater) validateInfo() error {
+	now := u.now()
+	if !u.Info.Expires.IsZero() && now.Add(-u.clockSkew()).After(u.Info.Expires) {
+		return ErrManifestExpired
+	}
+	if u.MaxManifestAge > 0 && !u.Info.Timestamp.IsZero() &&
+		now.Add(-u.clockSkew()).Sub(u.Info.Timestamp) > u.MaxManifestAge {
+		if !u.WarnOnStaleManifest {
+			return ErrManifestStale
+		}
+		u.logger().Warn("manifest is older than allowed", "version", u.Info.Version, "released", u.Info.Timestamp.Format(time.RFC3339), "maxAge", u.MaxManifestAge)
+	}
+	if u.httpsOnly {
+		downloads := []string{u.Info.Downloads.Binary}
+		for _, rawurl := range u.Info.Downloads.Patches {
+			downloads = append(downloads, rawurl)
+		}
+		for _, rawurl := range downloads {
+			if rawurl == "" {
+				continue
+			}
+			if err := u.checkInsecure(rawurl); err != nil {
+				return fmt.Errorf("manifest download: %w", err)
+			}
+		}
+	}
+	return u.checkLedger()
+}
+
+// timeSourceTimeout bounds the request of HTTPDateTimeSource, which every
+// check waits for.
+var timeSourceTimeout = 10 * time.Second
+
+// HTTPDateTimeSource returns a TimeSource that reads the time from the Date
+// header of a HEAD request to rawurl. Pointing it at a trusted HTTPS server
+// other than the update mirror protects freshness checks against a client
+// clock that was set back. The request uses the client of HTTPRequester, or
+// client if given, and gives up after 10 seconds, the check then falls back
+// to the local clock. Pass the Updater's HTTPClient for its proxy or
+// certificates.
+func HTTPDateTimeSource(rawurl string, client ...*http.Client) func() (time.Time, error) {
+	c := defaultHTTPClient
+	if len(client) > 0 && client[0] != nil {
+		c = client[0]
+	}
+	return func() (time.Time, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeSourceTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawurl, nil)
+		if err != nil {
+			return time.Time{}, err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return time.Time{}, err
+		}
+		resp.Body.Close()
+		return http.ParseTime(resp.Header.Get("Date"))
+	}
+}
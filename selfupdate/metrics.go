@@ -0,0 +1,35 @@
+package selfupdate
+
+import "time"
+
+// Metrics receives measurements of an Updater, so that operators can watch
+// the update health of a fleet, for example by backing it with Prometheus
+// counters and histograms or expvar variables. Calls with a non-nil err
+// count as failures. The methods are called synchronously and should return
+// quickly.
+type Metrics interface {
+	// Check is called when fetching the manifest finished after d.
+	Check(d time.Duration, err error)
+	// Download is called when downloading the new version finished after d.
+	// kind is ProgressPatch for a patch, ProgressBinary for the full binary,
+	// and bytes the number of bytes downloaded.
+	Download(kind string, bytes int64, d time.Duration, err error)
+	// Update is called when Update or BackgroundRun finished downloading
+	// version to and installing it over version from after d. Updates
+	// vetoed by OnBeforeUpdate and dry runs aren't counted.
+	Update(from, to string, d time.Duration, err error)
+}
+
+// NopMetrics discards all measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) Check(d time.Duration, err error)                              {}
+func (NopMetrics) Download(kind string, bytes int64, d time.Duration, err error) {}
+func (NopMetrics) Update(from, to string, d time.Duration, err error)            {}
+
+func (u *Updater) metrics() Metrics {
+	if u.Metrics != nil {
+		return u.Metrics
+	}
+	return NopMetrics{}
+}
@@ -0,0 +1,35 @@
+package selfupdate
+
+import "io"
+
+// RequesterFunc is an adapter to allow the use of ordinary functions as a
+// Requester.
+type RequesterFunc func(url string) (io.ReadCloser, error)
+
+// Fetch calls f(url).
+func (f RequesterFunc) Fetch(url string) (io.ReadCloser, error) {
+	return f(url)
+}
+
+// Middleware wraps a Requester to add behaviour around every fetch made by
+// the Updater, such as logging, authentication, caching or metrics.
+//
+// Example:
+//
+//	logging := func(next selfupdate.Requester) selfupdate.Requester {
+//		return selfupdate.RequesterFunc(func(url string) (io.ReadCloser, error) {
+//			log.Println("fetching", url)
+//			return next.Fetch(url)
+//		})
+//	}
+//	updater.Middleware = []selfupdate.Middleware{logging}
+type Middleware func(next Requester) Requester
+
+// Chain wraps r with the given middleware. The first middleware is the
+// outermost one, so it sees every request first and every response last.
+func Chain(r Requester, middleware ...Middleware) Requester {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		r = middleware[i](r)
+	}
+	return r
+}
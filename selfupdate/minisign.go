@@ -0,0 +1,150 @@
+package selfupdate
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrMinisignInvalid is returned by MinisignVerifier when the manifest has no
+// minisign signature of the new executable or it does not verify.
+var ErrMinisignInvalid = errors.New("minisign signature of the new executable is missing or invalid")
+
+// MinisignVerifier checks the minisign signature of new executables that the
+// generator puts into the manifest when run with -sign-key. Keys created
+// with signify can be used as well, minisign and signify sharing the format
+// of public keys and signatures.
+type MinisignVerifier struct {
+	PublicKey string // Public key, the contents of a minisign or signify .pub file or just its base64 line
+}
+
+func (v MinisignVerifier) Verify(artifact io.Reader, manifest VersionInfo) error {
+	id, pub, err := parseMinisignKey(v.PublicKey)
+	if err != nil {
+		return err
+	}
+	if manifest.Minisig == "" {
+		return ErrMinisignInvalid
+	}
+	sig, err := parseMinisig(manifest.Minisig)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMinisignInvalid, err)
+	}
+	if sig.keyID != id {
+		return fmt.Errorf("%w: signed with key %s, want %s", ErrMinisignInvalid, sig.keyID, id)
+	}
+	var msg []byte
+	if sig.hashed {
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, artifact); err != nil {
+			return err
+		}
+		msg = h.Sum(nil)
+	} else if msg, err = ioutil.ReadAll(artifact); err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, msg, sig.sig) {
+		return ErrMinisignInvalid
+	}
+	// signify signatures have no trusted comment
+	if sig.global != nil && !ed25519.Verify(pub, append(append([]byte(nil), sig.sig...), sig.comment...), sig.global) {
+		return fmt.Errorf("%w: trusted comment doesn't verify", ErrMinisignInvalid)
+	}
+	return nil
+}
+
+// minisignKeyID is the ID a minisign signature names its key with.
+type minisignKeyID [8]byte
+
+// String returns the ID the way minisign prints it.
+func (id minisignKeyID) String() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// minisig is a decoded minisign or signify signature.
+type minisig struct {
+	keyID   minisignKeyID
+	hashed  bool   // Whether the BLAKE2b-512 hash of the content is signed rather than the content
+	sig     []byte // Signature of the content
+	comment string // Trusted comment, if any
+	global  []byte // Signature of sig and the trusted comment, nil without one
+}
+
+// minisignLines returns the lines of s that aren't blank or untrusted
+// comments.
+func minisignLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		// the trusted comment is signed as is, up to the line break
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseMinisignKey decodes a minisign or signify public key: the signature
+// algorithm, the key ID and the Ed25519 key.
+func parseMinisignKey(s string) (minisignKeyID, ed25519.PublicKey, error) {
+	var id minisignKeyID
+	lines := minisignLines(s)
+	if len(lines) != 1 {
+		return id, nil, errors.New("minisign public key: expected a single base64 line")
+	}
+	b, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return id, nil, fmt.Errorf("minisign public key: %v", err)
+	}
+	if len(b) != 10+ed25519.PublicKeySize || string(b[:2]) != "Ed" {
+		return id, nil, errors.New("minisign public key: not an Ed25519 key")
+	}
+	copy(id[:], b[2:10])
+	return id, ed25519.PublicKey(b[10:]), nil
+}
+
+// parseMinisig decodes a minisign signature, or a signify one, which lacks
+// the trusted comment and its signature.
+func parseMinisig(s string) (*minisig, error) {
+	lines := minisignLines(s)
+	if len(lines) != 1 && len(lines) != 3 {
+		return nil, errors.New("malformed signature")
+	}
+	b, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 10+ed25519.SignatureSize {
+		return nil, errors.New("malformed signature")
+	}
+	sig := &minisig{sig: b[10:]}
+	switch string(b[:2]) {
+	case "Ed":
+	case "ED":
+		sig.hashed = true
+	default:
+		return nil, fmt.Errorf("unknown signature algorithm %q", b[:2])
+	}
+	copy(sig.keyID[:], b[2:10])
+	if len(lines) == 3 {
+		const prefix = "trusted comment: "
+		if !strings.HasPrefix(lines[1], prefix) {
+			return nil, errors.New("malformed trusted comment")
+		}
+		sig.comment = strings.TrimPrefix(lines[1], prefix)
+		if sig.global, err = base64.StdEncoding.DecodeString(lines[2]); err != nil {
+			return nil, err
+		}
+		if len(sig.global) != ed25519.SignatureSize {
+			return nil, errors.New("malformed trusted comment signature")
+		}
+	}
+	return sig, nil
+}
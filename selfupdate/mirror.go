@@ -0,0 +1,95 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// holds the response times of the update hosts for FastestMirror
+const mirrorsPath = "mirrors" // path to the mirror latencies relative to u.Dir
+
+// mirrorFailedLatency is recorded for a host that failed, so that it is
+// tried last until it answers again.
+const mirrorFailedLatency = time.Minute
+
+// fetchMirrored fetches rawurl, a file below ApiURL, BinURL or DiffURL. If
+// that fails with an error the RetryPolicy would retry, such as a network
+// error or a 503, the same path is fetched from each of Mirrors in turn.
+// With FastestMirror the hosts are tried in the order of their last
+// response times instead, hosts not tried yet first.
+func (u *Updater) fetchMirrored(ctx context.Context, rawurl string) (io.ReadCloser, error) {
+	if len(u.Mirrors) == 0 {
+		return u.fetch(ctx, rawurl)
+	}
+	primary, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	hosts := append([]string{primary.Scheme + "://" + primary.Host}, u.Mirrors...)
+	var latencies map[string]time.Duration
+	if u.FastestMirror {
+		latencies = u.readMirrorLatencies()
+		sort.SliceStable(hosts, func(i, j int) bool {
+			return latencies[hosts[i]] < latencies[hosts[j]]
+		})
+	}
+	policy := u.Retry
+	if policy == nil {
+		policy = &RetryPolicy{}
+	}
+
+	for _, host := range hosts {
+		var target string
+		if target, err = mirrorURL(primary, host); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		var r io.ReadCloser
+		r, err = u.fetch(ctx, target)
+		failed := err != nil && policy.retryable(ctx, err)
+		if latencies != nil && ctx.Err() == nil {
+			latencies[host] = time.Since(start)
+			if failed {
+				latencies[host] = mirrorFailedLatency
+			}
+			u.saveMirrorLatencies(latencies)
+		}
+		if !failed {
+			return r, err
+		}
+		u.logger().Warn("update host failed", "url", target, "error", err)
+	}
+	return nil, err
+}
+
+// mirrorURL returns u with the scheme and host of the mirror host.
+func mirrorURL(u *url.URL, host string) (string, error) {
+	m, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	mirrored := *u
+	mirrored.Scheme, mirrored.Host = m.Scheme, m.Host
+	return mirrored.String(), nil
+}
+
+// readMirrorLatencies returns the last response times of the update hosts.
+func (u *Updater) readMirrorLatencies() map[string]time.Duration {
+	latencies := make(map[string]time.Duration)
+	if p, err := u.state().Read(mirrorsPath); err == nil {
+		json.Unmarshal(p, &latencies)
+	}
+	return latencies
+}
+
+func (u *Updater) saveMirrorLatencies(latencies map[string]time.Duration) {
+	p, err := json.Marshal(latencies)
+	if err != nil {
+		return
+	}
+	u.state().Write(mirrorsPath, p)
+}
@@ -0,0 +1,55 @@
+package selfupdate
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// releaseNotesFile is the name of the release notes next to the full
+// binaries of a version.
+const releaseNotesFile = "CHANGELOG.md"
+
+// maxReleaseNotesSize bounds the release notes read from a source.
+const maxReleaseNotesSize = 1 << 20
+
+// ReleaseNotesSource is implemented by UpdateSources that can provide the
+// release notes of a version. The default source reads them from
+// BinURL/CmdName/<version>/CHANGELOG.md, GitHubReleaseSource uses the
+// release description.
+type ReleaseNotesSource interface {
+	ReleaseNotes(ctx context.Context, cmd, version string) (io.ReadCloser, error)
+}
+
+// ReleaseNotes fetches the manifest and returns the release notes of the
+// latest version, so that applications can show users what changed before
+// they apply the update. The notes in the manifest are used if it has them,
+// otherwise they are fetched from the source. An empty string without error
+// means none were published.
+func (u *Updater) ReleaseNotes() (string, error) {
+	ctx := context.Background()
+	if err := u.fetchInfo(ctx); err != nil {
+		return "", err
+	}
+	if u.Info.ReleaseNotes != "" {
+		return u.Info.ReleaseNotes, nil
+	}
+
+	src, ok := u.baseSource().(ReleaseNotesSource)
+	if !ok {
+		return "", nil
+	}
+	r, err := src.ReleaseNotes(ctx, u.CmdName, u.Info.Version)
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer r.Close()
+	notes, err := ioutil.ReadAll(io.LimitReader(r, maxReleaseNotesSize))
+	if err != nil {
+		return "", err
+	}
+	return string(notes), nil
+}
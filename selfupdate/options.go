@@ -0,0 +1,205 @@
+package selfupdate
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInsecureHTTP is wrapped by the ConfigError New returns for a plain http
+// URL, and by the error of a manifest publishing plain http downloads, unless
+// AllowInsecureHTTP is set. Anyone on the network path can serve other
+// updates over it, which only a PublicKey would catch.
+var ErrInsecureHTTP = errors.New("plain http can be tampered with, use https or set AllowInsecureHTTP")
+
+// ConfigError is returned by New for an Updater field that is missing or
+// malformed.
+type ConfigError struct {
+	Field string // Field of the Updater, such as ApiURL
+	Err   error  // What is wrong with it
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid Updater.%s: %v", e.Field, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Option configures the Updater created by New. Any function setting fields
+// of the Updater is one, the With functions cover the common ones:
+//
+//	u, err := selfupdate.New(
+//		selfupdate.WithCmdName("myapp"),
+//		selfupdate.WithCurrentVersion(version),
+//		selfupdate.WithURL("https://updates.yourdomain.com/"),
+//		func(u *selfupdate.Updater) { u.CheckTime = 24 },
+//	)
+type Option func(*Updater)
+
+// WithCmdName sets CmdName, the name of the application in the update tree.
+func WithCmdName(name string) Option {
+	return func(u *Updater) { u.CmdName = name }
+}
+
+// WithCurrentVersion sets CurrentVersion, the version that is running.
+func WithCurrentVersion(version string) Option {
+	return func(u *Updater) { u.CurrentVersion = version }
+}
+
+// WithURL sets ApiURL, BinURL and DiffURL to rawurl, for an update tree
+// published by the generator in one place.
+func WithURL(rawurl string) Option {
+	return WithURLs(rawurl, rawurl, rawurl)
+}
+
+// WithURLs sets ApiURL, BinURL and DiffURL, for manifests, full binaries and
+// patches hosted in different places.
+func WithURLs(api, bin, diff string) Option {
+	return func(u *Updater) { u.ApiURL, u.BinURL, u.DiffURL = api, bin, diff }
+}
+
+// WithDir sets Dir, the directory next to the executable the state is kept
+// in.
+func WithDir(dir string) Option {
+	return func(u *Updater) { u.Dir = dir }
+}
+
+// WithSource sets Source, which replaces ApiURL, BinURL and DiffURL.
+func WithSource(src UpdateSource) Option {
+	return func(u *Updater) { u.Source = src }
+}
+
+// WithPublicKey sets PublicKey, the key manifests must be signed with.
+func WithPublicKey(pub ed25519.PublicKey) Option {
+	return func(u *Updater) { u.PublicKey = pub }
+}
+
+// New returns an Updater configured by opts. Unlike an Updater literal,
+// whose mistakes only show when it fetches the wrong URLs or keeps its state
+// in the wrong place, it checks the configuration and returns a ConfigError
+// naming the field at fault:
+//
+//   - CmdName and CurrentVersion must be set.
+//   - ApiURL must be set unless Source is. BinURL and DiffURL default to it.
+//   - The URLs must be absolute https URLs without a query, unless a
+//     Requester is set, which may understand other schemes. The trailing
+//     slash the file names are appended to is added if missing.
+//   - Plain http URLs, Mirrors and ReportURL included, are refused with ErrInsecureHTTP
+//     unless AllowInsecureHTTP is set. So are the manifests publishing plain
+//     http Downloads the Updater fetches later.
+//   - KeyManifest needs a Source that publishes it, a KeyManifestSource.
+//   - Dir must be relative to the executable, it is cleaned. An absolute
+//     directory can be given as State: DirStore(dir).
+func New(opts ...Option) (*Updater, error) {
+	u := &Updater{}
+	for _, opt := range opts {
+		opt(u)
+	}
+	if err := u.configure(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// configure checks the fields New checks and normalizes them.
+func (u *Updater) configure() error {
+	if u.CmdName == "" {
+		return &ConfigError{"CmdName", errors.New("missing")}
+	}
+	if u.CurrentVersion == "" {
+		return &ConfigError{"CurrentVersion", errors.New("missing, set it to dev for builds that must not update")}
+	}
+
+	if u.ApiURL == "" && u.Source == nil {
+		return &ConfigError{"ApiURL", errors.New("missing, set it or Source")}
+	}
+	if u.BinURL == "" {
+		u.BinURL = u.ApiURL
+	}
+	if u.DiffURL == "" {
+		u.DiffURL = u.ApiURL
+	}
+	for _, f := range []struct {
+		name string
+		url  *string
+	}{{"ApiURL", &u.ApiURL}, {"BinURL", &u.BinURL}, {"DiffURL", &u.DiffURL}} {
+		if *f.url == "" {
+			continue
+		}
+		rawurl, err := u.baseURL(*f.url)
+		if err != nil {
+			return &ConfigError{f.name, err}
+		}
+		*f.url = rawurl
+	}
+	for _, mirror := range u.Mirrors {
+		if err := u.checkInsecure(mirror); err != nil {
+			return &ConfigError{"Mirrors", err}
+		}
+	}
+
+	if u.KeyManifest != "" && u.Source != nil {
+		if _, ok := u.Source.(KeyManifestSource); !ok {
+			return &ConfigError{"KeyManifest", errNoKeyManifestSource}
+		}
+	}
+
+	if u.ReportURL != "" {
+		if err := u.checkInsecure(u.ReportURL); err != nil {
+			return &ConfigError{"ReportURL", err}
+		}
+	}
+	u.httpsOnly = true
+
+	if u.Dir != "" {
+		dir := filepath.Clean(filepath.FromSlash(u.Dir))
+		if filepath.IsAbs(dir) {
+			return &ConfigError{"Dir", fmt.Errorf("%s is absolute, it is relative to the executable, use State: DirStore(dir) instead", u.Dir)}
+		}
+		u.Dir = dir + string(filepath.Separator)
+	}
+	return nil
+}
+
+// baseURL checks rawurl, a URL file names are appended to, and returns it
+// with a trailing slash.
+func (u *Updater) baseURL(rawurl string) (string, error) {
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	if u.Requester == nil && parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("%s is not an http or https URL", rawurl)
+	}
+	if parsed.Host == "" && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		return "", fmt.Errorf("%s has no host", rawurl)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("%s has a query or fragment, file names are appended to it", rawurl)
+	}
+	if err := u.checkInsecure(rawurl); err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(rawurl, "/") {
+		rawurl += "/"
+	}
+	return rawurl, nil
+}
+
+// checkInsecure refuses rawurl with ErrInsecureHTTP if it is a plain http URL
+// and AllowInsecureHTTP isn't set.
+func (u *Updater) checkInsecure(rawurl string) error {
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme == "http" && !u.AllowInsecureHTTP {
+		return fmt.Errorf("%s: %w", rawurl, ErrInsecureHTTP)
+	}
+	return nil
+}
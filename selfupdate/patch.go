@@ -0,0 +1,44 @@
+package selfupdate
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kr/binarydist"
+)
+
+// Diff algorithms a manifest may name in its DiffAlgorithm field. An empty
+// DiffAlgorithm means bsdiff, which is what older generators produced.
+const (
+	DiffBsdiff = "bsdiff"
+	DiffNone   = "none"
+)
+
+// Patcher applies a binary patch to old and writes the result to new.
+type Patcher interface {
+	Patch(old io.Reader, new io.Writer, patch io.Reader) error
+}
+
+// PatcherFunc is an adapter to allow the use of ordinary functions as a
+// Patcher.
+type PatcherFunc func(old io.Reader, new io.Writer, patch io.Reader) error
+
+// Patch calls f(old, new, patch).
+func (f PatcherFunc) Patch(old io.Reader, new io.Writer, patch io.Reader) error {
+	return f(old, new, patch)
+}
+
+var bsdiffPatcher = PatcherFunc(binarydist.Patch)
+
+// patcher returns the Patcher for the diff algorithm named in the manifest.
+// Algorithms other than bsdiff, such as xdelta3 or zstd, need a decoder
+// registered in u.Patchers.
+func (u *Updater) patcher(algorithm string) (Patcher, error) {
+	if p, ok := u.Patchers[algorithm]; ok {
+		return p, nil
+	}
+	if algorithm == "" || algorithm == DiffBsdiff {
+		return bsdiffPatcher, nil
+	}
+	return nil, fmt.Errorf("no patcher for diff algorithm %q", algorithm)
+}
@@ -0,0 +1,95 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrCertificatePinMismatch is returned when the update server presents no
+// certificate matching HTTPRequester.PinnedCertSHA256.
+var ErrCertificatePinMismatch = errors.New("update server certificate matches no pinned hash")
+
+// pinKey identifies a pinned client derived from a base client.
+type pinKey struct {
+	base *http.Client
+	pins string
+}
+
+// pinnedClients caches the clients derived by pinnedClient, so that
+// connections are reused across requests.
+var pinnedClients sync.Map
+
+// pinnedClient returns a client like base that only talks HTTPS to servers
+// whose certificate chain contains a certificate matching one of pins.
+func pinnedClient(base *http.Client, pins [][]byte) (*http.Client, error) {
+	key := pinKey{base: base, pins: string(bytes.Join(pins, nil))}
+	if c, ok := pinnedClients.Load(key); ok {
+		return c.(*http.Client), nil
+	}
+
+	rt := base.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("certificate pinning needs an *http.Transport, not %T", rt)
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	verify := transport.TLSClientConfig.VerifyConnection
+	// runs after the usual verification of the chain, pinning only
+	// narrows down which certificates are accepted
+	transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		if !matchesPin(cs.PeerCertificates, pins) {
+			return ErrCertificatePinMismatch
+		}
+		return nil
+	}
+
+	c := *base
+	c.Transport = transport
+	checkRedirect := base.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("refusing redirect to %s with pinned certificates", req.URL)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	actual, _ := pinnedClients.LoadOrStore(key, &c)
+	return actual.(*http.Client), nil
+}
+
+// matchesPin reports whether any of certs has a SHA-256 hash of either its
+// subject public key info or the whole certificate among pins.
+func matchesPin(certs []*x509.Certificate, pins [][]byte) bool {
+	for _, cert := range certs {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		whole := sha256.Sum256(cert.Raw)
+		for _, pin := range pins {
+			if bytes.Equal(pin, spki[:]) || bytes.Equal(pin, whole[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package selfupdate
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PackageManager describes where a system package manager installs
+// executables, so that updates can leave those to it.
+type PackageManager struct {
+	Name  string   // Name of the package manager, such as Homebrew
+	Paths []string // Directories it installs into, such as /usr/lib/ or /Cellar/, matched anywhere in the path regardless of case
+}
+
+// DefaultPackageManagers are the package managers checked for when
+// Updater.PackageManagers isn't set.
+var DefaultPackageManagers = []PackageManager{
+	{Name: "Homebrew", Paths: []string{"/Cellar/", "/Caskroom/"}},
+	{Name: "Snap", Paths: []string{"/snap/"}},
+	{Name: "Flatpak", Paths: []string{"/flatpak/"}},
+	{Name: "Nix", Paths: []string{"/nix/store/"}},
+	{Name: "the system package manager", Paths: []string{"/usr/bin/", "/usr/sbin/", "/usr/lib/", "/usr/libexec/", "/usr/lib64/"}},
+	{Name: "Chocolatey", Paths: []string{"/chocolatey/lib/"}},
+	{Name: "Scoop", Paths: []string{"/scoop/apps/"}},
+	{Name: "WinGet", Paths: []string{"/WinGet/Packages/"}},
+}
+
+// PackageManagedError is returned when the executable was installed by a
+// package manager, which updates it instead, unless u.AllowPackageManaged is
+// set. Replacing it would leave the package manager with a file it doesn't
+// know about and the next upgrade of the package would replace it again.
+type PackageManagedError struct {
+	Path    string // Path of the executable
+	Manager string // Name of the package manager it was installed by
+}
+
+func (e *PackageManagedError) Error() string {
+	return fmt.Sprintf("%s was installed by %s and has to be updated with it", e.Path, e.Manager)
+}
+
+// packageManager returns the name of the package manager that installed the
+// file at path, following symlinks such as those Homebrew puts in its bin
+// directory, or "" if it looks like no package manager did.
+func (u *Updater) packageManager(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	path = strings.ToLower(filepath.ToSlash(path))
+	managers := u.PackageManagers
+	if managers == nil {
+		managers = DefaultPackageManagers
+	}
+	for _, m := range managers {
+		for _, p := range m.Paths {
+			if strings.Contains(path, strings.ToLower(p)) {
+				return m.Name
+			}
+		}
+	}
+	return ""
+}
+
+// checkPackageManaged returns a PackageManagedError if the file at path was
+// installed by a package manager and u.AllowPackageManaged isn't set.
+func (u *Updater) checkPackageManaged(path string) error {
+	if u.AllowPackageManaged {
+		return nil
+	}
+	if m := u.packageManager(path); m != "" {
+		return &PackageManagedError{Path: path, Manager: m}
+	}
+	return nil
+}
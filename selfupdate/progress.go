@@ -0,0 +1,50 @@
+package selfupdate
+
+import "io"
+
+// Phases reported to Updater.OnProgress.
+const (
+	ProgressPatch  = "patch"  // Downloading the patch from the current version
+	ProgressBinary = "binary" // Downloading the full binary
+)
+
+// countingReader counts the bytes read through it and reports them to
+// onRead.
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	onRead func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if n > 0 && c.onRead != nil {
+		c.onRead(c.n)
+	}
+	return n, err
+}
+
+// countDownload wraps the download r of the given phase so that its progress
+// is reported to u.OnProgress and it is read no faster than
+// u.MaxBytesPerSecond. total is the expected size, 0 if unknown.
+func (u *Updater) countDownload(r io.Reader, phase string, total int64) *countingReader {
+	if u.MaxBytesPerSecond > 0 {
+		r = newThrottledReader(r, u.MaxBytesPerSecond)
+	}
+	cr := &countingReader{r: r}
+	if u.OnProgress != nil {
+		if total <= 0 {
+			total = -1
+		}
+		cr.onRead = func(n int64) { u.OnProgress(phase, n, total) }
+		u.OnProgress(phase, 0, total)
+	}
+	return cr
+}
+
+// patchSize returns the size of the patch from the current version as
+// published in the manifest, 0 if unknown.
+func (u *Updater) patchSize() int64 {
+	return u.Info.Patches[u.CurrentVersion].Size
+}
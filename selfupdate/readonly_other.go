@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package selfupdate
+
+// readOnlyFS can't tell read-only file systems apart on the platform.
+func readOnlyFS(dir string, err error) bool {
+	return false
+}
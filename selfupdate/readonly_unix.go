@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || linux
+// +build darwin dragonfly freebsd linux
+
+package selfupdate
+
+import (
+	"errors"
+	"syscall"
+)
+
+// readOnlyFS reports whether err, the failure to create a file in dir, is
+// because dir is on a file system mounted read-only. The mount flags are
+// looked at too, as write permission is checked first and a user without it
+// gets EACCES rather than EROFS.
+func readOnlyFS(dir string, err error) bool {
+	if errors.Is(err, syscall.EROFS) {
+		return true
+	}
+	var st syscall.Statfs_t
+	if syscall.Statfs(dir, &st) != nil {
+		return false
+	}
+	return st.Flags&1 != 0 // ST_RDONLY on linux, MNT_RDONLY on the BSDs
+}
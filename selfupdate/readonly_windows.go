@@ -0,0 +1,15 @@
+package selfupdate
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorWriteProtect is ERROR_WRITE_PROTECT, returned for read-only media.
+const errorWriteProtect = syscall.Errno(19)
+
+// readOnlyFS reports whether err, the failure to create a file in dir, is
+// because dir is on a read-only volume.
+func readOnlyFS(dir string, err error) bool {
+	return errors.Is(err, errorWriteProtect)
+}
@@ -0,0 +1,74 @@
+package selfupdate
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+// errRelaunchUnsupported is returned by Restart and RelaunchElevated on
+// platforms that can't replace the running process.
+var errRelaunchUnsupported = errors.New("restarting in place is not supported on " + runtime.GOOS)
+
+// The command line and working directory the application was started with,
+// captured before the application has a chance to change them.
+var (
+	launchArgs   = append([]string(nil), os.Args...)
+	launchDir, _ = os.Getwd()
+)
+
+// restartProcess replaces the process with exe, tests replace it to see
+// when Restart is called.
+var restartProcess = restart
+
+// RelaunchOptions controls how Relaunch starts the new process.
+type RelaunchOptions struct {
+	// PreserveElevation starts the new process elevated if the current one
+	// is. It only has an effect on Windows, where the user is shown a UAC
+	// prompt.
+	PreserveElevation bool
+}
+
+// Relaunch starts the executable again with the exact command line and
+// working directory the current process was started with, so interactive
+// users experience a seamless restart into the new version. It returns once
+// the new process was started; the caller should exit afterwards.
+func Relaunch(opts RelaunchOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return relaunch(exe, launchArgs[1:], launchDir, opts)
+}
+
+// Restart replaces the running process with the executable u updates, which
+// after an update is the new version, keeping the command line, environment
+// and working directory the process was started with. On Unix the process
+// is replaced in place, keeping its process ID; on Windows the new process
+// is started and the current one exits. Other platforms, such as js/wasm,
+// can't restart and get an error. Deferred functions don't run, so the
+// application should be in a state where it can stop. Restart only returns
+// if starting the new executable failed.
+func (u *Updater) Restart() error {
+	path, err := u.targetPath()
+	if err != nil {
+		return err
+	}
+	u.logger().Info("restarting", "path", path)
+	return restartProcess(path, launchArgs, launchDir)
+}
+
+// RelaunchElevated starts the executable again like Relaunch, but with
+// administrator rights, for when updating failed with ErrPermissionDenied.
+// On Unix the process is replaced by sudo running the executable, which asks
+// for a password on the terminal if needed, and RelaunchElevated only
+// returns on failure. On Windows the user is shown a UAC prompt; it returns
+// once the elevated process was started and the caller should exit then.
+// Other platforms get an error.
+func RelaunchElevated() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return relaunchElevated(exe, launchArgs[1:], launchDir)
+}
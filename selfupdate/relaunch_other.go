@@ -0,0 +1,29 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package selfupdate
+
+import (
+	"os"
+	"os/exec"
+)
+
+func relaunch(exe string, args []string, dir string, opts RelaunchOptions) error {
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Start()
+}
+
+// restart can't replace the process, package syscall has no Exec for the
+// platform.
+func restart(exe string, args []string, dir string) error {
+	return errRelaunchUnsupported
+}
+
+func relaunchElevated(exe string, args []string, dir string) error {
+	return errRelaunchUnsupported
+}
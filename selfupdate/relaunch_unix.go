@@ -0,0 +1,42 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package selfupdate
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func relaunch(exe string, args []string, dir string, opts RelaunchOptions) error {
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Start()
+}
+
+func restart(exe string, args []string, dir string) error {
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	return syscall.Exec(exe, args, os.Environ())
+}
+
+func relaunchElevated(exe string, args []string, dir string) error {
+	if os.Geteuid() == 0 {
+		return errors.New("already running as root")
+	}
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	return syscall.Exec(sudo, append([]string{"sudo", exe}, args...), os.Environ())
+}
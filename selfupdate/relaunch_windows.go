@@ -0,0 +1,82 @@
+package selfupdate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+func relaunch(exe string, args []string, dir string, opts RelaunchOptions) error {
+	if opts.PreserveElevation && isElevated() {
+		return shellExecute("runas", exe, args, dir)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Start()
+}
+
+// restart starts exe and exits, windows has no way to replace the running
+// process.
+func restart(exe string, args []string, dir string) error {
+	if err := relaunch(exe, args[1:], dir, RelaunchOptions{}); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+func relaunchElevated(exe string, args []string, dir string) error {
+	return shellExecute("runas", exe, args, dir)
+}
+
+// isElevated reports whether the current process runs with an elevated
+// (administrator) token.
+func isElevated() bool {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return false
+	}
+	defer token.Close()
+
+	const tokenElevation = 20 // TOKEN_INFORMATION_CLASS TokenElevation
+	var elevated uint32
+	var n uint32
+	err = syscall.GetTokenInformation(token, tokenElevation, (*byte)(unsafe.Pointer(&elevated)), uint32(unsafe.Sizeof(elevated)), &n)
+	return err == nil && elevated != 0
+}
+
+// shellExecute starts exe through ShellExecuteW with the given verb, which
+// for "runas" asks the user to approve running it elevated.
+func shellExecute(verb, exe string, args []string, dir string) error {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = syscall.EscapeArg(arg)
+	}
+
+	shell32 := syscall.NewLazyDLL("shell32.dll")
+	shellExecuteW := shell32.NewProc("ShellExecuteW")
+
+	const swShowNormal = 1
+	r1, _, err := shellExecuteW.Call(
+		0,
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(verb))),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(exe))),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(strings.Join(quoted, " ")))),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(dir))),
+		swShowNormal,
+	)
+
+	// ShellExecuteW returns a value greater than 32 on success
+	if r1 <= 32 {
+		return fmt.Errorf("ShellExecute %s %s: %v", verb, exe, err)
+	}
+	return nil
+}
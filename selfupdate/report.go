@@ -0,0 +1,111 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// reportTimeout bounds how long reporting an update may take.
+const reportTimeout = 10 * time.Second
+
+// UpdateReport describes the outcome of an update for a Reporter.
+type UpdateReport struct {
+	Cmd      string // Command name of the application
+	Platform string // Platform of the executable, e.g. linux-amd64
+	From     string // Version that was running
+	To       string // Version the update installed or tried to
+	Err      error  // Why the update failed, nil if it succeeded
+}
+
+// Reporter is told about every update that was installed or failed after a
+// new version was found, so that release managers can follow the adoption
+// of a release and spot failure spikes. Updates that were vetoed, cancelled
+// or deferred aren't reported. Report is called synchronously, errors are
+// logged.
+type Reporter interface {
+	Report(r UpdateReport) error
+}
+
+// HTTPReporter posts reports as JSON to URL, typically a server.Stats
+// endpoint. Only the command name, platform, versions and whether the update
+// failed are sent, nothing that identifies the installation and not the
+// error, which may contain paths.
+type HTTPReporter struct {
+	URL    string       // Where the reports are posted to
+	Client *http.Client // Client the reports are posted with, nil means the one of HTTPRequester, a report gives up after 10 seconds either way
+
+	// PinnedCertSHA256 optionally restricts the server reported to like the
+	// field of HTTPRequester does.
+	PinnedCertSHA256 [][]byte
+}
+
+// Report posts r to h.URL.
+func (h HTTPReporter) Report(r UpdateReport) error {
+	body, err := json.Marshal(struct {
+		Cmd      string
+		Platform string
+		From     string
+		To       string
+		Failed   bool `json:",omitempty"`
+	}{r.Cmd, r.Platform, r.From, r.To, r.Err != nil})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := h.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	if len(h.PinnedCertSHA256) > 0 {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("refusing to report to %s without TLS, certificates are pinned", h.URL)
+		}
+		if client, err = pinnedClient(client, h.PinnedCertSHA256); err != nil {
+			return err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("report to %s: %s", h.URL, resp.Status)
+	}
+	return nil
+}
+
+func (u *Updater) reporter() Reporter {
+	if u.Reporter != nil {
+		return u.Reporter
+	}
+	if u.ReportURL != "" {
+		return HTTPReporter{URL: u.ReportURL, Client: u.HTTPClient, PinnedCertSHA256: u.PinnedCertSHA256}
+	}
+	return nil
+}
+
+// reportUpdate tells the reporter, if any, that the update from version from
+// to version to finished with err.
+func (u *Updater) reportUpdate(from, to string, err error) {
+	r := u.reporter()
+	if r == nil || errors.Is(err, context.Canceled) || err == ErrUpdateDeferred {
+		return
+	}
+	report := UpdateReport{Cmd: u.CmdName, Platform: u.platform(), From: from, To: to, Err: err}
+	if err := r.Report(report); err != nil {
+		u.logger().Warn("reporting update failed", "error", err)
+	}
+}
@@ -1,9 +1,15 @@
 package selfupdate
 
 import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // Requester interface allows developers to customize the method in which
@@ -14,21 +20,136 @@ type Requester interface {
 
 // HTTPRequester is the normal requester that is used and does an HTTP
 // to the URL location requested to retrieve the specified data.
-type HTTPRequester struct{}
+type HTTPRequester struct {
+	Client *http.Client      // Optional client to make requests with, for proxies, TLS settings or timeouts
+	Header map[string]string // Optional headers sent with every request
+	Auth   AuthProvider      // Optional authorization applied to every request
+
+	// PinnedCertSHA256 optionally restricts the servers talked to, over
+	// HTTPS only, to those presenting a certificate chain that contains a
+	// certificate whose SHA-256 hash, or the hash of whose subject public
+	// key info, is listed.
+	PinnedCertSHA256 [][]byte
+}
+
+type headerKey struct{}
+
+// withHeader returns a context that makes the HTTPRequester fetching with it
+// send header besides its own, for sources whose server expects them.
+func withHeader(ctx context.Context, header map[string]string) context.Context {
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// defaultHTTPClient is used by HTTPRequester without a Client. It doesn't
+// limit how long a request may take, downloads can be large and connections
+// slow, but gives up on servers that don't answer instead of hanging.
+var defaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+}
 
 // Fetch will return an HTTP request to the specified url and return
 // the body of the result. An error will occur for a non 200 status code.
 func (httpRequester *HTTPRequester) Fetch(url string) (io.ReadCloser, error) {
-	resp, err := http.Get(url)
+	return httpRequester.FetchContext(context.Background(), url)
+}
+
+// FetchContext is like Fetch but aborts the request when ctx is done.
+func (httpRequester *HTTPRequester) FetchContext(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := httpRequester.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	if len(httpRequester.PinnedCertSHA256) > 0 {
+		if req.URL.Scheme != "https" {
+			return nil, fmt.Errorf("refusing to fetch %s without TLS, certificates are pinned", url)
+		}
+		if client, err = pinnedClient(client, httpRequester.PinnedCertSHA256); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range httpRequester.Header {
+		req.Header.Set(k, v)
+	}
+	header, _ := ctx.Value(headerKey{}).(map[string]string)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		// decoded below, whatever the transport of the client
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	cond := setConditional(ctx, req)
+	if httpRequester.Auth != nil {
+		if err := httpRequester.Auth.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad http status from %s: %v", url, resp.Status)
+		resp.Body.Close()
+		return nil, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	cond.record(resp)
+
+	body, err := decodeContent(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", url, err)
 	}
+	return body, nil
+}
+
+// decodeContent returns the body of resp with its Content-Encoding removed.
+// Full binaries stay compressed, servers send them as they are with no
+// Content-Encoding of their own.
+func decodeContent(resp *http.Response) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		r, err = zlib.NewReader(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodedBody{r, resp.Body}, nil
+}
+
+// decodedBody reads the decoded body of a response and closes both the
+// decoder and the body.
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
 
-	return resp.Body, nil
+func (b decodedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
 }
 
 // mockRequester used for some mock testing to ensure the requester contract
@@ -0,0 +1,144 @@
+package selfupdate
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PlatformResolver names the platform whose update files are fetched, in the
+// form used by the update tree such as "linux-amd64".
+type PlatformResolver interface {
+	Platform() string
+}
+
+// UpdatableResolver locates the file that an update replaces.
+type UpdatableResolver interface {
+	Path() (string, error)
+}
+
+// RuntimePlatform resolves to the platform the program was compiled for,
+// GOOS-GOARCH. It is the default PlatformResolver.
+type RuntimePlatform struct{}
+
+// Platform returns runtime.GOOS + "-" + runtime.GOARCH.
+func (RuntimePlatform) Platform() string {
+	return plat
+}
+
+// StaticPlatform resolves to the platform it names, such as darwin-amd64.
+// Tests and tools use it to act as another platform, and darwin-arm64
+// installations can follow the amd64 builds, which run under Rosetta, while
+// no native ones are published.
+type StaticPlatform string
+
+// Platform returns p.
+func (p StaticPlatform) Platform() string {
+	return string(p)
+}
+
+// ExecutableResolver resolves to the running executable with any symlinks
+// resolved. It is the default UpdatableResolver.
+type ExecutableResolver struct{}
+
+// Path returns the path of the running executable.
+func (ExecutableResolver) Path() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolvedPath, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolvedPath
+	}
+	return path, nil
+}
+
+// platform returns the platform to fetch updates for: the native one, or
+// the fallback the manifest was found for by the check.
+func (u *Updater) platform() string {
+	if u.fallback != "" {
+		return u.fallback
+	}
+	return u.nativePlatform()
+}
+
+// nativePlatform returns the platform u.Platform resolves to.
+func (u *Updater) nativePlatform() string {
+	if u.Platform != nil {
+		return u.Platform.Platform()
+	}
+	return RuntimePlatform{}.Platform()
+}
+
+// platformFallbacks returns the platforms to fetch updates for, in order,
+// when none are published for the native platform. They follow from
+// u.PlatformFallbacks, the fallbacks of a fallback included.
+func (u *Updater) platformFallbacks() []string {
+	chain := []string{u.nativePlatform()}
+	for i := 0; i < len(chain); i++ {
+		for _, f := range u.PlatformFallbacks {
+			if f[0] == chain[i] && !hasPlatform(chain, f[1]) {
+				chain = append(chain, f[1])
+			}
+		}
+	}
+	return chain[1:]
+}
+
+func hasPlatform(platforms []string, platform string) bool {
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchFallbackManifest fetches the manifest of the first fallback platform
+// that has one, for a native platform that has none, and makes the Updater
+// fetch updates for that platform. It returns err, the error of the native
+// platform, if none has one.
+func (u *Updater) fetchFallbackManifest(ctx context.Context, cond *conditional, err error) (io.ReadCloser, error) {
+	for _, p := range u.platformFallbacks() {
+		// the validators of the native manifest don't apply
+		*cond = conditional{}
+		r, fallbackErr := u.source().Manifest(withConditional(ctx, cond), u.CmdName, p)
+		if fallbackErr == nil {
+			u.logger().Debug("no release for the platform, using a fallback", "platform", u.nativePlatform(), "fallback", p)
+			u.fallback = p
+			return r, nil
+		}
+		if !isNotFound(fallbackErr) {
+			return nil, fallbackErr
+		}
+	}
+	return nil, err
+}
+
+// windows reports whether updates are fetched for Windows.
+func (u *Updater) windows() bool {
+	return strings.HasPrefix(u.platform(), "windows-")
+}
+
+// targetPath returns the path of the file to update. A Target without an
+// extension gets the .exe suffix on Windows, which only runs executables
+// that have it. With Versions it is the link the application is started
+// through.
+func (u *Updater) targetPath() (string, error) {
+	if u.Versions != nil {
+		return u.Versions.Link, nil
+	}
+	if u.Target == nil {
+		return ExecutableResolver{}.Path()
+	}
+	path, err := u.Target.Path()
+	if err != nil {
+		return "", err
+	}
+	if u.windows() && filepath.Ext(path) == "" {
+		path += ".exe"
+	}
+	return path, nil
+}
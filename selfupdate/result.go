@@ -0,0 +1,10 @@
+package selfupdate
+
+// UpdateResult tells what an update run did.
+type UpdateResult struct {
+	Updated bool   // Whether a new version was installed
+	From    string // Version that was running
+	To      string // Latest version published, empty if the manifest wasn't fetched
+	Bytes   int64  // Bytes downloaded, including a patch that failed to apply
+	Patched bool   // Whether the new version was built from a patch rather than the full binary
+}
@@ -0,0 +1,111 @@
+package selfupdate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// HTTPStatusError is returned by HTTPRequester and GitHubReleaseSource when
+// the server answers with a status other than 200 OK.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("bad http status from %s: %v", e.URL, e.Status)
+}
+
+// RetryPolicy retries failed downloads with exponential backoff. Network
+// errors are retried, as are the retryable HTTP status codes, other status
+// codes fail right away. Only opening a download is retried, a download that
+// breaks off midway fails the update.
+type RetryPolicy struct {
+	MaxAttempts          int           // Attempts including the first one, 1 or less disables retries
+	InitialBackoff       time.Duration // Wait before the first retry, doubled for every further one, defaults to 1 second
+	MaxBackoff           time.Duration // Upper bound of the wait between attempts, defaults to 30 seconds
+	RetryableStatusCodes []int         // HTTP status codes worth retrying, defaults to 408, 429, 500, 502, 503 and 504
+}
+
+var defaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+// retryable reports whether a fetch that failed with err should be retried.
+func (p *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	statusErr, ok := err.(*HTTPStatusError)
+	if !ok {
+		return true
+	}
+	codes := p.RetryableStatusCodes
+	if codes == nil {
+		codes = defaultRetryableStatusCodes
+	}
+	for _, code := range codes {
+		if statusErr.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before retry number n, starting at 1.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	// spread the retries of many clients hitting the same outage
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do calls fetch until it succeeds, fails with an error that is not
+// retryable or the attempts are used up.
+func (p *RetryPolicy) do(ctx context.Context, fetch func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	for attempt := 1; ; attempt++ {
+		r, err := fetch()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(ctx, err) {
+			return r, err
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retrySource retries the downloads of an UpdateSource.
+type retrySource struct {
+	src    UpdateSource
+	policy *RetryPolicy
+}
+
+func (s retrySource) Manifest(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	return s.policy.do(ctx, func() (io.ReadCloser, error) { return s.src.Manifest(ctx, cmd, platform) })
+}
+
+func (s retrySource) Binary(ctx context.Context, cmd, version, file string) (io.ReadCloser, error) {
+	return s.policy.do(ctx, func() (io.ReadCloser, error) { return s.src.Binary(ctx, cmd, version, file) })
+}
+
+func (s retrySource) Patch(ctx context.Context, cmd, from, to, platform string) (io.ReadCloser, error) {
+	return s.policy.do(ctx, func() (io.ReadCloser, error) { return s.src.Patch(ctx, cmd, from, to, platform) })
+}
@@ -0,0 +1,220 @@
+package selfupdate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// holds the versions involved in the latest update
+const rollbackPath = "rollback" // path to rollback state relative to u.Dir
+
+// ErrNoRollback is returned by Rollback when there is no previous executable
+// to restore.
+var ErrNoRollback = errors.New("no previous executable to roll back to")
+
+// rollbackState records the latest update, and whether it was rolled back.
+type rollbackState struct {
+	Previous   string    // Version that was replaced
+	Installed  string    // Version that was installed
+	RolledBack bool      // Whether Installed was replaced by Previous again
+	Time       time.Time // When Installed was installed
+}
+
+// oldExecutablePath returns the path the previous executable is kept at
+// after an update.
+func oldExecutablePath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.old", filepath.Base(path)))
+}
+
+// installedPaths returns the paths of the executable and the files in
+// u.Targets, those that can't be resolved left out. On windows files without
+// an extension are listed with the .exe suffix downloadFiles may add, too.
+func (u *Updater) installedPaths() []string {
+	var paths []string
+	if path, err := u.targetPath(); err == nil {
+		paths = append(paths, path)
+	}
+	for _, target := range u.Targets {
+		p, err := target.Path()
+		if err != nil {
+			continue
+		}
+		paths = append(paths, p)
+		if u.windows() && filepath.Ext(p) == "" {
+			paths = append(paths, p+".exe")
+		}
+	}
+	return paths
+}
+
+// recoverInstalls puts back the executable and the files in u.Targets where
+// an install was interrupted, see recoverInstall.
+func (u *Updater) recoverInstalls() {
+	for _, path := range u.installedPaths() {
+		u.recoverInstall(path)
+	}
+}
+
+// recoverInterrupted runs recoverInstalls holding the update lock. It does
+// nothing while another instance updates, whose install in progress looks
+// just like an interrupted one.
+func (u *Updater) recoverInterrupted() {
+	path, err := u.targetPath()
+	if err != nil {
+		return
+	}
+	unlock, err := lockUpdate(path)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	u.recoverInstalls()
+}
+
+// recoverInstall puts the previous file back at path if the process died
+// in install after moving it out of the way and before the new one took its
+// place, which leaves nothing at path. The update is tried again later.
+func (u *Updater) recoverInstall(path string) {
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return
+	}
+	oldPath := oldExecutablePath(path)
+	if _, err := os.Stat(oldPath); err != nil {
+		return
+	}
+	if err := os.Rename(oldPath, path); err != nil {
+		u.logger().Error("recovering from interrupted update failed", "path", path, "error", err)
+		return
+	}
+	_ = unhideFile(path)
+	_ = syncDir(filepath.Dir(path))
+	u.logger().Warn("recovered from interrupted update", "path", path)
+}
+
+// CanRollback reports whether the executable replaced by the latest update
+// is still around, so that Rollback can restore it. With Versions that is
+// the directory of the replaced version.
+func (u *Updater) CanRollback() bool {
+	if u.Versions != nil {
+		st := u.readRollback()
+		path, err := u.Versions.path(st.Previous)
+		if err != nil || st.RolledBack {
+			return false
+		}
+		_, err = os.Stat(path)
+		return err == nil
+	}
+	path, err := u.targetPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(oldExecutablePath(path))
+	return err == nil
+}
+
+// Rollback restores the executable that was running before the latest
+// update, for example when the new release turns out to be broken. The
+// release that was rolled back is remembered and not installed again, the
+// next update waits for a newer one. As with updates, the restored version
+// runs once the application restarts.
+func (u *Updater) Rollback() error {
+	path, err := u.targetPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockUpdate(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return u.rollback(path)
+}
+
+// rollback restores the executable at path and the files in u.Targets like
+// Rollback, with the update lock already held.
+func (u *Updater) rollback(path string) error {
+	if u.Versions != nil {
+		if err := u.rollbackVersion(); err != nil {
+			return err
+		}
+	} else {
+		if _, err := os.Stat(oldExecutablePath(path)); err != nil {
+			return ErrNoRollback
+		}
+		if err := restorePrevious(path); err != nil {
+			return err
+		}
+	}
+	// the files updated along with the executable go back as well
+	for _, target := range u.Targets {
+		p, err := target.Path()
+		if err != nil {
+			continue
+		}
+		if u.windows() && filepath.Ext(p) == "" {
+			// installed with the suffix, see downloadFiles
+			if _, err := os.Stat(oldExecutablePath(p + ".exe")); err == nil {
+				p += ".exe"
+			}
+		}
+		if _, err := os.Stat(oldExecutablePath(p)); err == nil {
+			if err := restorePrevious(p); err != nil {
+				u.logger().Warn("rolling back file failed", "path", p, "error", err)
+			}
+		}
+	}
+
+	st := u.readRollback()
+	st.RolledBack = true
+	u.saveRollback(st)
+	u.logger().Info("rolled back update", "from", st.Installed, "to", st.Previous)
+	return nil
+}
+
+// restorePrevious puts the file kept by install back in place of the one at
+// path.
+func restorePrevious(path string) error {
+	// move the bad file out of the way first, windows can't rename onto an
+	// existing file
+	badPath := badExecutablePath(path)
+	_ = os.Remove(badPath)
+	if err := os.Rename(path, badPath); err != nil {
+		return err
+	}
+	if err := os.Rename(oldExecutablePath(path), path); err != nil {
+		if errRecover := os.Rename(badPath, path); errRecover != nil {
+			return fmt.Errorf("rollback and recovery errors: %q %q", err, errRecover)
+		}
+		return err
+	}
+	_ = unhideFile(path)
+
+	// windows can't remove the executable while it's running, so hide it
+	// instead
+	if err := os.Remove(badPath); err != nil {
+		_ = hideFile(badPath)
+	}
+	return nil
+}
+
+func (u *Updater) readRollback() rollbackState {
+	var st rollbackState
+	p, err := u.state().Read(rollbackPath)
+	if err != nil {
+		return st
+	}
+	json.Unmarshal(p, &st)
+	return st
+}
+
+func (u *Updater) saveRollback(st rollbackState) {
+	p, err := json.Marshal(st)
+	if err != nil {
+		return
+	}
+	u.state().Write(rollbackPath, p)
+}
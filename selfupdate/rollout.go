@@ -0,0 +1,42 @@
+package selfupdate
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// holds the random seed that places the installation in rollout cohorts
+const cohortPath = "cohort" // path to the rollout seed relative to u.Dir
+
+// inRollout reports whether the fetched release is offered to this
+// installation. A release with a RolloutPercent between 1 and 99 is offered
+// to that share of installations only. Which ones is decided by a hash of
+// the version and a random seed kept in the state store, so each
+// installation gets the same answer for a release every time, stays in the
+// cohort as the percentage grows, and isn't among the first for every
+// release. Mandatory releases are offered to all.
+func (u *Updater) inRollout() bool {
+	percent := u.Info.RolloutPercent
+	if percent <= 0 || percent >= 100 || u.mandatory() {
+		return true
+	}
+	h := sha256.Sum256(append(u.cohortSeed(), u.Info.Version...))
+	return binary.BigEndian.Uint64(h[:8])%100 < uint64(percent)
+}
+
+// cohortSeed returns the random seed of the installation, creating it on
+// first use. If it can't be stored the installation gets a new seed every
+// run and falls into cohorts at random.
+func (u *Updater) cohortSeed() []byte {
+	if p, err := u.state().Read(cohortPath); err == nil {
+		if seed, err := hex.DecodeString(string(p)); err == nil && len(seed) > 0 {
+			return seed
+		}
+	}
+	seed := make([]byte, 16)
+	rand.Read(seed)
+	u.state().Write(cohortPath, []byte(hex.EncodeToString(seed)))
+	return seed
+}
@@ -0,0 +1,119 @@
+package selfupdate
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CheckForUpdatesSchedule decides when an Updater checks for updates.
+type CheckForUpdatesSchedule interface {
+	// NextCheck returns the time of the next check. A zero time or a time
+	// in the past means a check is due.
+	NextCheck() time.Time
+	// ScheduleNextCheck is called when a check starts and schedules the one
+	// after it.
+	ScheduleNextCheck() error
+	// Reset forgets the scheduled check so that the next one is due
+	// immediately.
+	Reset() error
+}
+
+// FsCacheCheckForUpdateSchedule keeps the time of the next check in a file,
+// so the schedule survives restarts of the application. This is the
+// schedule an Updater uses unless its Schedule field is set.
+type FsCacheCheckForUpdateSchedule struct {
+	Path          string        // File holding the RFC3339 timestamp of the next check
+	CheckTime     time.Duration // Time between checks
+	RandomizeTime time.Duration // Maximum random time added to CheckTime to spread checks of many clients
+}
+
+func (s *FsCacheCheckForUpdateSchedule) stateSchedule() *stateSchedule {
+	return &stateSchedule{
+		store:         DirStore(filepath.Dir(s.Path)),
+		name:          filepath.Base(s.Path),
+		checkTime:     s.CheckTime,
+		randomizeTime: s.RandomizeTime,
+	}
+}
+
+// NextCheck returns the time stored in s.Path.
+func (s *FsCacheCheckForUpdateSchedule) NextCheck() time.Time {
+	return s.stateSchedule().NextCheck()
+}
+
+// ScheduleNextCheck writes now plus CheckTime and a random part of
+// RandomizeTime to s.Path.
+func (s *FsCacheCheckForUpdateSchedule) ScheduleNextCheck() error {
+	return s.stateSchedule().ScheduleNextCheck()
+}
+
+// Reset removes s.Path.
+func (s *FsCacheCheckForUpdateSchedule) Reset() error {
+	return s.stateSchedule().Reset()
+}
+
+// stateSchedule is FsCacheCheckForUpdateSchedule on a StateStore, it keeps
+// the time of the next check in the state file name.
+type stateSchedule struct {
+	store         StateStore
+	name          string
+	checkTime     time.Duration
+	randomizeTime time.Duration
+}
+
+func (s *stateSchedule) NextCheck() time.Time {
+	p, err := s.store.Read(s.name)
+	if os.IsNotExist(err) {
+		return time.Time{}
+	}
+	if err != nil {
+		return time.Now().Add(1000 * time.Hour)
+	}
+	t, err := time.Parse(time.RFC3339, string(p))
+	if err != nil {
+		return time.Now().Add(1000 * time.Hour)
+	}
+	return t
+}
+
+func (s *stateSchedule) ScheduleNextCheck() error {
+	wait := s.checkTime
+	if s.randomizeTime > 0 {
+		// Add 1 to random time since max is not included
+		wait += time.Duration(rand.Int63n(int64(s.randomizeTime) + 1))
+	}
+	return s.store.Write(s.name, []byte(time.Now().Add(wait).Format(time.RFC3339)))
+}
+
+func (s *stateSchedule) Reset() error {
+	return s.store.Remove(s.name)
+}
+
+// AlwaysCheckForUpdatesSchedule makes every run check for updates.
+type AlwaysCheckForUpdatesSchedule struct{}
+
+// NextCheck always returns the zero time.
+func (AlwaysCheckForUpdatesSchedule) NextCheck() time.Time { return time.Time{} }
+
+// ScheduleNextCheck does nothing.
+func (AlwaysCheckForUpdatesSchedule) ScheduleNextCheck() error { return nil }
+
+// Reset does nothing.
+func (AlwaysCheckForUpdatesSchedule) Reset() error { return nil }
+
+// schedule returns u.Schedule, or the schedule configured by the legacy
+// CheckTime and RandomizeTime fields, kept in the state store, when it is not
+// set.
+func (u *Updater) schedule() CheckForUpdatesSchedule {
+	if u.Schedule != nil {
+		return u.Schedule
+	}
+	return &stateSchedule{
+		store:         u.state(),
+		name:          upcktimePath,
+		checkTime:     time.Duration(u.CheckTime) * time.Hour,
+		randomizeTime: time.Duration(u.RandomizeTime) * time.Hour,
+	}
+}
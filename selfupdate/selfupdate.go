@@ -1,23 +1,19 @@
 package selfupdate
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"math/rand"
-	"net/url"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"time"
-
-	"github.com/kr/binarydist"
 )
 
 const (
@@ -26,9 +22,26 @@ const (
 	plat         = runtime.GOOS + "-" + runtime.GOARCH // ex: linux-amd64
 )
 
+// SeverityCritical is the manifest Severity of a release that fixes a
+// critical security issue.
+const SeverityCritical = "critical"
+
 var (
 	ErrHashMismatch = errors.New("new file hash mismatch after patch")
 
+	// ErrPermissionDenied is returned when the executable can't be replaced
+	// because the directory it is in isn't writable, for example in
+	// /usr/local/bin or Program Files. RelaunchElevated can start the
+	// application again with the rights to update.
+	ErrPermissionDenied = errors.New("no permission to replace the executable")
+
+	// ErrReadOnlyInstall is returned when the executable is on a read-only
+	// file system, such as in a container image, and is to be updated by
+	// deploying a new image instead. The check for updates still runs, so
+	// that OnUpdateAvailable and the To of the UpdateResult tell about the
+	// new version.
+	ErrReadOnlyInstall = errors.New("executable is on a read-only file system")
+
 	defaultHTTPRequester = HTTPRequester{}
 )
 
@@ -40,9 +53,9 @@ var (
 //
 //	updater := &selfupdate.Updater{
 //		CurrentVersion: version,
-//		ApiURL:         "http://updates.yourdomain.com/",
-//		BinURL:         "http://updates.yourdownmain.com/",
-//		DiffURL:        "http://updates.yourdomain.com/",
+//		ApiURL:         "https://updates.yourdomain.com/",
+//		BinURL:         "https://updates.yourdownmain.com/",
+//		DiffURL:        "https://updates.yourdomain.com/",
 //		Dir:            "update/",
 //		CmdName:        "myapp", // app name
 //	}
@@ -50,42 +63,119 @@ var (
 //		go updater.BackgroundRun()
 //	}
 type Updater struct {
-	CurrentVersion string    // Currently running version. `dev` is a special version here and will cause the updater to never update.
-	ApiURL         string    // Base URL for API requests (JSON files).
-	CmdName        string    // Command name is appended to the ApiURL like http://apiurl/CmdName/. This represents one binary.
-	BinURL         string    // Base URL for full binary downloads.
-	DiffURL        string    // Base URL for diff downloads.
-	Dir            string    // Directory to store selfupdate state.
-	ForceCheck     bool      // Check for update regardless of cktime timestamp
-	CheckTime      int       // Time in hours before next check
-	RandomizeTime  int       // Time in hours to randomize with CheckTime
-	Requester      Requester // Optional parameter to override existing HTTP request handler
-	Info           struct {
-		Version string
-		Sha256  []byte
-	}
-	OnSuccessfulUpdate func() // Optional function to run after an update has successfully taken place
+	CurrentVersion       string                                    // Currently running version. `dev` is a special version here and will cause the updater to never update.
+	ApiURL               string                                    // Base URL for API requests (JSON files).
+	CmdName              string                                    // Command name is appended to the ApiURL like http://apiurl/CmdName/. This represents one binary.
+	BinURL               string                                    // Base URL for full binary downloads.
+	DiffURL              string                                    // Base URL for diff downloads.
+	URLTemplates         *URLTemplates                             // Optional layout of the URLs below ApiURL, BinURL and DiffURL, such as {{.Base}}/{{.Cmd}}/{{.Channel}}/{{.Platform}}.json
+	Channel              string                                    // Optional release channel, such as beta, that URLTemplates can refer to as {{.Channel}}
+	ManifestDecoder      ManifestDecoder                           // Optional decoder of manifests in other formats than the JSON of the generator, such as PlainTextManifest or AppcastManifest
+	Dir                  string                                    // Directory next to the executable to store selfupdate state in, by default it is kept in the user's cache directory
+	State                StateStore                                // Optional store of the state files such as cktime, defaults to Dir or the user's cache directory
+	ForceCheck           bool                                      // Check for update regardless of cktime timestamp
+	DryRun               bool                                      // Download and verify updates but don't install them, to validate a release pipeline end to end
+	CheckTime            int                                       // Time in hours before next check, unless Schedule is set
+	RandomizeTime        int                                       // Time in hours to randomize with CheckTime, unless Schedule is set
+	Schedule             CheckForUpdatesSchedule                   // Optional schedule for update checks, defaults to a cktime file in Dir using CheckTime and RandomizeTime
+	CheckInterval        time.Duration                             // How often the checker started by Start consults the schedule, defaults to an hour
+	CheckJitter          time.Duration                             // Maximum random delay the checker started by Start adds before each check
+	CircuitThreshold     int                                       // Consecutive failed checks before checks are suspended, 0 disables the circuit breaker
+	CircuitCooldown      int                                       // Time in hours checks stay suspended once the circuit breaker opens, 24 if not set
+	Requester            Requester                                 // Optional parameter to override existing HTTP request handler
+	HTTPClient           *http.Client                              // Optional client for the default HTTPRequester, for proxies, custom CAs, client certificates or timeouts
+	RequestHeaders       map[string]string                         // Optional headers sent with every request of the default HTTPRequester
+	Auth                 AuthProvider                              // Optional authorization of every request of the default HTTPRequester, such as a BearerToken
+	PinnedCertSHA256     [][]byte                                  // Optional SHA-256 hashes of certificates or their public keys the update server's chain must contain
+	AllowInsecureHTTP    bool                                      // Let New accept plain http URLs, and the manifests of its Updater plain http Downloads, for local testing or update trees whose manifests are signed
+	Middleware           []Middleware                              // Optional middleware wrapped around every fetch, outermost first
+	Source               UpdateSource                              // Optional source of manifests, binaries and patches, defaults to ApiURL, BinURL and DiffURL
+	Retry                *RetryPolicy                              // Optional retries of failed downloads, by default a failed download fails the update
+	Mirrors              []string                                  // Optional hosts serving copies of the files below ApiURL, BinURL and DiffURL, e.g. https://mirror.example.com, tried in turn when a download fails
+	FastestMirror        bool                                      // With Mirrors, try the hosts that answered fastest before first
+	MaxBytesPerSecond    int64                                     // Optional limit of the download rate, so updates on slow or metered connections leave bandwidth to the application
+	Platform             PlatformResolver                          // Optional platform to fetch updates for, defaults to RuntimePlatform
+	PlatformFallbacks    [][2]string                               // Optional platforms to fetch updates for when the first has no release, such as {"darwin-arm64", "darwin-amd64"}
+	Target               UpdatableResolver                         // Optional file to update, defaults to ExecutableResolver
+	Targets              []UpdatableResolver                       // Optional further files updated together with Target, each from the manifest file of the same name
+	Versions             *VersionedInstall                         // Optional side by side install of each version into a directory of its own, switched to through a symlink instead of replacing Target
+	PackageManagers      []PackageManager                          // Optional heuristics recognizing executables installed by a package manager, which are not updated, defaults to DefaultPackageManagers
+	AllowPackageManaged  bool                                      // Update executables installed by a package manager anyway
+	Patchers             map[string]Patcher                        // Optional decoders for diff algorithms other than bsdiff, keyed by name
+	Archives             map[string]ArchiveHandler                 // Optional extractors for archive formats other than zip and tar.gz, keyed by name
+	ArchiveName          string                                    // Name of the executable inside archives, defaults to CmdName, with .exe on Windows
+	NamingScheme         string                                    // Optional naming of full binaries, NamingGoreleaser for myapp_1.2.3_linux_amd64.tar.gz
+	BinaryFileName       func(version, platform string) string     // Optional name of the full binary of a version, for releases not named <platform>.gz
+	ChecksumsFile        string                                    // Optional checksums file published with each version, such as SHA256SUMS, full binaries must match
+	VerifyChecksums      func(sums, sig []byte) error              // Optional check of the checksums file against its signature, published with .sig appended
+	MaxClockSkew         time.Duration                             // Clock difference tolerated when checking manifest expiry, defaults to 5 minutes
+	MaxManifestAge       time.Duration                             // Reject manifests released longer ago than this, 0 disables the check
+	WarnOnStaleManifest  bool                                      // Only log manifests older than MaxManifestAge instead of rejecting them
+	AllowDowngrade       bool                                      // Accept manifests offering a version older than the highest one installed before
+	IgnorePattern        *regexp.Regexp                            // Optional pattern of versions never to install, such as -rc for release candidates
+	Prereleases          PrereleasePolicy                          // Which installations pre-releases such as 1.3.0-beta.2 are offered to, defaults to PrereleasesAlways
+	IgnoreBuildMetadata  bool                                      // Treat versions that only differ in build metadata after "+" as the same, so they trigger no update
+	TimeSource           func() (time.Time, error)                 // Optional trusted clock for expiry and freshness checks, defaults to the local clock
+	ReportURL            string                                    // Optional URL of a server.Stats endpoint that updates are reported to, short for an HTTPReporter with HTTPClient and PinnedCertSHA256
+	Reporter             Reporter                                  // Optional receiver of the outcome of every update, overrides ReportURL
+	ForceCriticalUpdates bool                                      // Install releases marked SeverityCritical as soon as they are published, regardless of schedule
+	EnforceMinimum       bool                                      // Install updates right away, regardless of schedule, while running a version older than the manifest MinimumVersion
+	PublicKey            ed25519.PublicKey                         // Optional key the manifest signature must verify with, binaries of unsigned releases are refused
+	TrustedKeys          []TrustedKey                              // Optional further keys the manifest signature may verify with, each within its validity
+	KeyManifest          string                                    // Optional name of the signed key manifest published next to the manifests, such as keys.json, to learn of rotated keys from
+	GPGKeyring           []byte                                    // Optional armored or binary OpenPGP keyring the manifest must be signed with, clearsigned or with a detached .asc signature
+	RequireSignedBinary  bool                                      // On Windows and macOS, refuse new executables without a valid code signature
+	RequireSameSigner    bool                                      // With RequireSignedBinary, also refuse new executables signed by someone else than the running one
+	VerifyExecutable     bool                                      // Refuse new executables that are not ELF, Mach-O or PE files for the platform
+	ProbeArgs            []string                                  // Optional arguments to run the new executable with before installing it, such as --version, it must exit successfully
+	Verifiers            []Verifier                                // Optional further checks of new executables, run in order after the check of their SHA-256 hash
+	HealthCheck          func(path string) error                   // Optional check of the newly installed executable at path, the update is rolled back if it fails
+	HealthCheckWindow    time.Duration                             // How long HealthCheck is retried until it passes, 0 means it is called once
+	Info                 VersionInfo                               // The manifest fetched last, describing the latest release
+	Logger               Logger                                    // Optional destination of log messages, defaults to the standard logger; NopLogger silences them
+	Metrics              Metrics                                   // Optional receiver of counts and durations of checks, downloads and updates, for monitoring
+	OnProgress           func(phase string, received, total int64) // Optional function called while downloading the patch or binary, total is -1 if unknown
+	ApplyGate            ApplyGate                                 // Optional gate the update waits on until the application is idle before swapping the executable
+	OnSuccessfulUpdate   func()                                    // Optional function to run after an update has successfully taken place
+	OnMandatoryUpdate    func()                                    // Optional function to run after an update enforced by EnforceMinimum, for example to restart right away
+	OnUpdateAvailable    func(version string)                      // Optional function called when a check finds a version other than the running one that is to be installed
+	OnBeforeUpdate       func(version string) error                // Optional function called before installing version; an error vetoes the update and is returned, ErrUpdateDeferred defers it to the next start
+	OnError              func(err error)                           // Optional function called with the errors of BackgroundRun, Update and the checker, for example for telemetry
+	OnUpdateApplied      func(result UpdateResult)                 // Optional function the checker started by Start calls after it installed an update
+	RestartAfterUpdate   bool                                      // Restart into the new version with Restart once an update is installed and the hooks ran
+
+	running   *checker // checker started by Start, guarded by checkersMu
+	fallback  string   // platform of PlatformFallbacks the check found the manifest for, if not the native one
+	httpsOnly bool     // set by New, which refuses plain http Downloads as it does plain http URLs
 }
 
+// getExecRelativeDir returns dir relative to the directory of the executable
+// u updates, the running one with symlinks resolved the way
+// ExecutableResolver does unless Target says otherwise, so that the state is
+// kept with the installation that is updated.
 func (u *Updater) getExecRelativeDir(dir string) string {
-	filename, _ := os.Executable()
+	filename, err := u.targetPath()
+	if err != nil {
+		filename, _ = ExecutableResolver{}.Path()
+	}
 	path := filepath.Join(filepath.Dir(filename), dir)
 	return path
 }
 
-func canUpdate() (err error) {
+func canUpdate(path string) (err error) {
 	// get the directory the file exists in
-	path, err := os.Executable()
-	if err != nil {
-		return
-	}
-
 	fileDir := filepath.Dir(path)
 	fileName := filepath.Base(path)
 
 	// attempt to open a file in the file's directory
 	newPath := filepath.Join(fileDir, fmt.Sprintf(".%s.new", fileName))
 	fp, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil && readOnlyFS(fileDir, err) {
+		return ErrReadOnlyInstall
+	}
+	if os.IsPermission(err) {
+		return ErrPermissionDenied
+	}
 	if err != nil {
 		return
 	}
@@ -95,34 +185,95 @@ func canUpdate() (err error) {
 	return
 }
 
-// BackgroundRun starts the update check and apply cycle.
-func (u *Updater) BackgroundRun() error {
-	if err := os.MkdirAll(u.getExecRelativeDir(u.Dir), 0755); err != nil {
-		// fail
+// checkInstall returns an error if the executable at path mustn't be
+// updated, because a package manager installed it, or can't be.
+func (u *Updater) checkInstall(path string) error {
+	if err := u.checkPackageManaged(path); err != nil {
 		return err
 	}
+	return canUpdate(path)
+}
+
+// BackgroundRun starts the update check and apply cycle. If
+// u.ForceCriticalUpdates or u.EnforceMinimum is set the manifest is fetched
+// even when no check is scheduled, and a release marked SeverityCritical or,
+// respectively, one whose MinimumVersion is newer than the running version is
+// installed right away.
+func (u *Updater) BackgroundRun() error {
+	return u.BackgroundRunContext(context.Background())
+}
+
+// BackgroundRunContext is like BackgroundRun but stops downloading and
+// applying the update when ctx is done.
+func (u *Updater) BackgroundRunContext(ctx context.Context) error {
+	_, err := u.BackgroundRunWithResult(ctx)
+	return err
+}
+
+// BackgroundRunWithResult is like BackgroundRunContext and also tells what
+// the run did.
+func (u *Updater) BackgroundRunWithResult(ctx context.Context) (UpdateResult, error) {
+	result, err := u.backgroundRun(ctx)
+	u.saveLastError(err)
+	u.notifyError(err)
+	return result, err
+}
+
+func (u *Updater) backgroundRun(ctx context.Context) (UpdateResult, error) {
+	result := UpdateResult{From: u.CurrentVersion}
+	// a previous run may have died in the middle of installing
+	u.recoverInterrupted()
+	// or found the executable busy
+	if result, ok, err := u.applyDeferred(); ok {
+		return result, err
+	}
+	if err := u.CleanupArtifacts(); err != nil {
+		u.logger().Debug("cleaning up after earlier updates failed", "error", err)
+	}
+
 	// check to see if we want to check for updates based on version
 	// and last update time
 	if u.WantUpdate() {
-		if err := canUpdate(); err != nil {
+		path, err := u.targetPath()
+		if err != nil {
+			return result, err
+		}
+		// a read-only install still checks, to tell about new versions
+		if err := u.checkInstall(path); err != nil && err != ErrReadOnlyInstall {
 			// fail
-			return err
+			return result, err
 		}
 
 		u.SetUpdateTime()
 
-		if err := u.Update(); err != nil {
-			return err
+		return u.updateWithResult(ctx)
+	} else if (u.ForceCriticalUpdates || u.EnforceMinimum) && u.CurrentVersion != "dev" && !u.circuitOpen() {
+		// the schedule says not yet, but a critical release or one required
+		// by the minimum version must not wait for it, so look at the
+		// manifest anyway
+		if err := u.fetchInfo(ctx); err != nil {
+			return result, err
+		}
+		if !u.mandatory() {
+			return result, nil
+		}
+		path, err := u.targetPath()
+		if err != nil {
+			return result, err
 		}
+		if err := u.checkInstall(path); err != nil && err != ErrReadOnlyInstall {
+			return result, err
+		}
+		return u.update(ctx)
 	}
-	return nil
+	return result, nil
 }
 
 // WantUpdate returns boolean designating if an update is desired. If the app's version
-// is `dev` WantUpdate will return false. If u.ForceCheck is true or cktime is after now
-// WantUpdate will return true.
+// is `dev` or the circuit breaker is open WantUpdate will return false. If u.ForceCheck
+// is true or the schedule's next check is not in the future WantUpdate will return true.
 func (u *Updater) WantUpdate() bool {
-	if u.CurrentVersion == "dev" || (!u.ForceCheck && u.NextUpdate().After(time.Now())) {
+	if u.CurrentVersion == "dev" || u.circuitOpen() || (!u.ForceCheck && u.NextUpdate().After(time.Now())) {
 		return false
 	}
 
@@ -131,31 +282,27 @@ func (u *Updater) WantUpdate() bool {
 
 // NextUpdate returns the next time update should be checked
 func (u *Updater) NextUpdate() time.Time {
-	path := u.getExecRelativeDir(u.Dir + upcktimePath)
-	nextTime := readTime(path)
-
-	return nextTime
+	return u.schedule().NextCheck()
 }
 
-// SetUpdateTime writes the next update time to the state file
+// SetUpdateTime schedules the next update check, by default by writing its
+// time to the state file
 func (u *Updater) SetUpdateTime() bool {
-	path := u.getExecRelativeDir(u.Dir + upcktimePath)
-	wait := time.Duration(u.CheckTime) * time.Hour
-	// Add 1 to random time since max is not included
-	waitrand := time.Duration(rand.Intn(u.RandomizeTime+1)) * time.Hour
-
-	return writeTime(path, time.Now().Add(wait+waitrand))
+	return u.schedule().ScheduleNextCheck() == nil
 }
 
-// ClearUpdateState writes current time to state file
+// ClearUpdateState resets the schedule so the next update check is due now
 func (u *Updater) ClearUpdateState() {
-	path := u.getExecRelativeDir(u.Dir + upcktimePath)
-	os.Remove(path)
+	u.schedule().Reset()
 }
 
-// UpdateAvailable checks if update is available and returns version
+// UpdateAvailable checks if update is available and returns version. It
+// fails if the executable can't be opened, CheckRemoteVersion doesn't look
+// at it. Unlike CheckRemoteVersion it goes by the state of the installation,
+// leaving out versions it skipped or rolled back and releases it isn't in
+// the rollout of yet, and records the check there.
 func (u *Updater) UpdateAvailable() (string, error) {
-	path, err := os.Executable()
+	path, err := u.targetPath()
 	if err != nil {
 		return "", err
 	}
@@ -165,225 +312,611 @@ func (u *Updater) UpdateAvailable() (string, error) {
 	}
 	defer old.Close()
 
-	err = u.fetchInfo()
-	if err != nil {
+	if err := u.fetchInfo(context.Background()); err != nil {
 		return "", err
 	}
-	if u.Info.Version == u.CurrentVersion {
+	if u.upToDate() || !u.offered() || u.skipped() {
 		return "", nil
-	} else {
-		return u.Info.Version, nil
 	}
+	return u.Info.Version, nil
+}
+
+// CheckRemoteVersion fetches the manifest and returns the version it offers
+// this installation, or "" if there is none other than the running one. It
+// neither resolves nor opens the executable nor reads or writes the state
+// store, so it also works where the executable's path doesn't matter and
+// Dir can't be written to or doesn't exist, such as in tests or on
+// read-only mounts. Without the state it can't tell versions the
+// installation skipped or rolled back, or whether it is in a staged
+// rollout, so it reports those too, and the check isn't recorded.
+func (u *Updater) CheckRemoteVersion(ctx context.Context) (string, error) {
+	c, version, err := u.checkRemote(ctx)
+	u.Info, u.fallback = c.Info, c.fallback
+	return version, err
+}
+
+// checkRemote does the check of CheckRemoteVersion on a copy of u, which it
+// returns with the fetched manifest, and leaves u alone.
+func (u *Updater) checkRemote(ctx context.Context) (*Updater, string, error) {
+	// a copy keeping whatever the check saves in memory for its duration,
+	// taken while Start and Stop can't change u
+	checkersMu.Lock()
+	c := *u
+	checkersMu.Unlock()
+	c.running = nil
+	c.State = &MemoryStore{}
+	if err := c.fetchInfo(ctx); err != nil {
+		return &c, "", err
+	}
+	if c.upToDate() || !c.targeted() {
+		return &c, "", nil
+	}
+	return &c, c.Info.Version, nil
+}
+
+// Check fetches the manifest and returns the release it offers this
+// installation, or nil if there is none other than the running one, like
+// CheckRemoteVersion. Besides the manifest the release carries the channel
+// it was fetched from and the URLs of its full binary and of the patch from
+// the running version, as far as they are known without downloading them,
+// so that tools can show or fetch them. The Updater keeps the manifest in
+// Info as with any check.
+func (u *Updater) Check(ctx context.Context) (*VersionInfo, error) {
+	version, err := u.CheckRemoteVersion(ctx)
+	if err != nil || version == "" {
+		return nil, err
+	}
+	info := u.Info
+	info.Downloads = u.downloadURLs()
+	return &info, nil
 }
 
 // Update initiates the self update process
 func (u *Updater) Update() error {
-	path, err := os.Executable()
+	return u.UpdateContext(context.Background())
+}
+
+// UpdateContext is like Update but aborts the download and patch
+// application when ctx is done. The executable is left untouched then.
+func (u *Updater) UpdateContext(ctx context.Context) error {
+	_, err := u.UpdateWithResult(ctx)
+	return err
+}
+
+// UpdateWithResult is like UpdateContext and also tells whether an update
+// was installed, and how it was downloaded.
+func (u *Updater) UpdateWithResult(ctx context.Context) (UpdateResult, error) {
+	result, err := u.updateWithResult(ctx)
+	u.saveLastError(err)
+	u.notifyError(err)
+	return result, err
+}
+
+func (u *Updater) updateWithResult(ctx context.Context) (UpdateResult, error) {
+	// go fetch latest updates manifest
+	err := u.fetchInfo(ctx)
 	if err != nil {
-		return err
+		return UpdateResult{From: u.CurrentVersion}, err
 	}
 
-	if resolvedPath, err := filepath.EvalSymlinks(path); err == nil {
-		path = resolvedPath
+	return u.update(ctx)
+}
+
+// update installs the version described by the already fetched u.Info.
+func (u *Updater) update(ctx context.Context) (UpdateResult, error) {
+	result := UpdateResult{From: u.CurrentVersion, To: u.Info.Version}
+
+	// we are on the latest version, nothing to do
+	if u.upToDate() {
+		return result, nil
 	}
 
-	// go fetch latest updates manifest
-	err = u.fetchInfo()
+	// don't reinstall a release that was rolled back or skipped
+	if u.skipped() {
+		u.logger().Info("not installing skipped version", "version", u.Info.Version)
+		return result, nil
+	}
+	if u.Info.Paused {
+		u.logger().Info("release paused by the publisher", "version", u.Info.Version)
+		return result, nil
+	}
+	if !u.targeted() {
+		u.logger().Debug("version not offered to this installation", "version", u.Info.Version, "platform", u.platform(), "from", u.Info.UpdateFrom)
+		return result, nil
+	}
+	if !u.inRollout() {
+		u.logger().Debug("not in the rollout of version yet", "version", u.Info.Version, "percent", u.Info.RolloutPercent)
+		return result, nil
+	}
+	u.updateAvailable(u.Info.Version)
+	if len(u.Info.Sha256) == 0 {
+		return result, ErrNoHash
+	}
+
+	path, err := u.targetPath()
 	if err != nil {
-		return err
+		return result, err
+	}
+	// find out before downloading anything
+	if err := u.checkInstall(path); err != nil {
+		if err == ErrReadOnlyInstall {
+			u.logger().Info("update available for read-only install", "version", u.Info.Version, "path", path)
+		}
+		return result, err
+	}
+	unlock, err := lockUpdate(path)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+	u.recoverInstalls()
+	if verifyFile(path, u.Info.Sha256) == nil {
+		// another instance installed it while this one checked
+		return result, nil
 	}
 
-	// we are on the latest version, nothing to do
-	if u.Info.Version == u.CurrentVersion {
-		return nil
+	start := time.Now()
+	files, err := u.downloadAll(ctx, path, &result)
+	if err != nil {
+		u.metrics().Update(u.CurrentVersion, u.Info.Version, time.Since(start), err)
+		u.reportUpdate(u.CurrentVersion, u.Info.Version, err)
+		return result, err
 	}
 
+	// the release downloaded and verified, which is all a dry run checks
+	if u.DryRun {
+		removeStaged(files)
+		u.logger().Info("dry run verified update", "from", u.CurrentVersion, "to", u.Info.Version)
+		return result, nil
+	}
+
+	if err := u.beforeUpdate(); err == ErrUpdateDeferred {
+		return result, u.deferInstall(files, u.Info.Version, err)
+	} else if err != nil {
+		removeStaged(files)
+		u.logger().Info("update vetoed", "version", u.Info.Version, "reason", err)
+		return result, err
+	}
+
+	// the gate is held until after the restart, so that the application
+	// starts no work the restart would kill
+	release, err := u.holdGate(ctx, files)
+	if err == nil {
+		defer release()
+		err = u.apply(ctx, files, u.Info.Version)
+	}
+	u.metrics().Update(u.CurrentVersion, u.Info.Version, time.Since(start), err)
+	if err != nil {
+		return result, err
+	}
+	result.Updated = true
+	if u.RestartAfterUpdate {
+		return result, u.Restart()
+	}
+	return result, nil
+}
+
+// downloadAll stages the executable at path and the files in u.Targets,
+// the executable first.
+func (u *Updater) downloadAll(ctx context.Context, path string, result *UpdateResult) ([]stagedFile, error) {
+	if err := u.checkSpace(path); err != nil {
+		return nil, err
+	}
+	newPath, err := u.download(ctx, path, result)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := u.downloadFiles(ctx, result)
+	if err != nil {
+		_ = os.Remove(newPath)
+		return nil, err
+	}
+	return append([]stagedFile{{Path: path, NewPath: newPath, Sha256: u.Info.Sha256}}, extra...), nil
+}
+
+// download stages the executable described by u.Info next to the one at
+// path, patching that one if possible, and returns the path of the new
+// executable. How it was downloaded is recorded in result.
+func (u *Updater) download(ctx context.Context, path string, result *UpdateResult) (string, error) {
 	old, err := os.Open(path)
 	if err != nil {
-		return err
+		return "", err
 	}
+	// close the old binary before installing because on windows
+	// it can't be renamed if a handle to the file is still open
 	defer old.Close()
 
-	bin, err := u.fetchAndVerifyPatch(old)
+	var n int64
+	start := time.Now()
+	newPath, err := stageUpdate(path, u.verifyDownload, func(w io.Writer) error {
+		var err error
+		n, err = u.fetchAndApplyPatch(ctx, old, w)
+		result.Bytes += n
+		return err
+	})
+	if err == nil {
+		result.Patched = true
+		u.metrics().Download(ProgressPatch, n, time.Since(start), nil)
+		return newPath, u.verifyBinary(newPath, path)
+	}
+	if err == ErrHashMismatch {
+		u.logger().Warn("hash mismatch from patched binary", "version", u.Info.Version)
+		u.metrics().Download(ProgressPatch, n, time.Since(start), err)
+	} else if err == errFullBinarySmaller {
+		u.logger().Debug("downloading full binary", "version", u.Info.Version, "reason", err)
+	} else {
+		if (u.DiffURL != "" || u.Source != nil) && u.Info.DiffAlgorithm != DiffNone {
+			u.logger().Warn("patching binary failed", "version", u.Info.Version, "error", err)
+			u.metrics().Download(ProgressPatch, n, time.Since(start), err)
+		}
+	}
+
+	// a cancelled update must not start another download
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	// if patch failed grab the full new bin
+	start = time.Now()
+	newPath, err = stageUpdate(path, u.verifyDownload, func(w io.Writer) error {
+		var err error
+		n, err = u.fetchBin(ctx, w)
+		result.Bytes += n
+		return err
+	})
+	u.metrics().Download(ProgressBinary, n, time.Since(start), err)
 	if err != nil {
 		if err == ErrHashMismatch {
-			log.Println("update: hash mismatch from patched binary")
+			u.logger().Error("hash mismatch from full binary", "version", u.Info.Version)
 		} else {
-			if u.DiffURL != "" {
-				log.Println("update: patching binary,", err)
-			}
+			u.logger().Error("fetching full binary failed", "version", u.Info.Version, "error", err)
 		}
+		return "", err
+	}
+	return newPath, u.verifyBinary(newPath, path)
+}
 
-		// if patch failed grab the full new bin
-		bin, err = u.fetchAndVerifyFullBin()
-		if err != nil {
-			if err == ErrHashMismatch {
-				log.Println("update: hash mismatch from full binary")
-			} else {
-				log.Println("update: fetching full binary,", err)
-			}
-			return err
-		}
+// holdGate waits for u.ApplyGate, if set, to find the application idle
+// before the staged files are installed, and returns the func releasing it.
+// The staged files are removed if it doesn't.
+func (u *Updater) holdGate(ctx context.Context, files []stagedFile) (release func(), err error) {
+	if u.ApplyGate == nil {
+		return func() {}, nil
+	}
+	if err := u.ApplyGate.WaitIdle(ctx); err != nil {
+		removeStaged(files)
+		return nil, err
 	}
+	return u.ApplyGate.Release, nil
+}
 
-	// close the old binary before installing because on windows
-	// it can't be renamed if a handle to the file is still open
-	old.Close()
+// apply installs the staged files of version, the executable first, and
+// runs the hooks for a successful update. The caller holds the ApplyGate.
+func (u *Updater) apply(ctx context.Context, files []stagedFile, version string) error {
+	if err := ctx.Err(); err != nil {
+		removeStaged(files)
+		return err
+	}
 
-	err, errRecover := fromStream(bytes.NewBuffer(bin))
-	if errRecover != nil {
-		return fmt.Errorf("update and recovery errors: %q %q", err, errRecover)
+	install := installFiles
+	if u.Versions != nil {
+		install = func(files []stagedFile) error { return u.installVersioned(files, version) }
 	}
-	if err != nil {
+	if err := install(files); err != nil {
+		if isBusy(err) {
+			return u.deferInstall(files, version, err)
+		}
+		u.reportUpdate(u.CurrentVersion, version, err)
+		return err
+	}
+
+	u.logger().Info("installed update", "from", u.CurrentVersion, "to", version)
+	u.saveRollback(rollbackState{Previous: u.CurrentVersion, Installed: version, Time: time.Now()})
+	if err := u.checkHealth(ctx, files[0].Path); err != nil {
+		u.reportUpdate(u.CurrentVersion, version, err)
 		return err
 	}
+	u.recordInstalled(version)
+	u.reportUpdate(u.CurrentVersion, version, nil)
 
 	// update was successful, run func if set
 	if u.OnSuccessfulUpdate != nil {
 		u.OnSuccessfulUpdate()
 	}
+	if u.OnMandatoryUpdate != nil && u.EnforceMinimum && u.belowMinimum() {
+		u.OnMandatoryUpdate()
+	}
 
 	return nil
 }
 
-func fromStream(updateWith io.Reader) (err error, errRecover error) {
-	updatePath, err := os.Executable()
-	if err != nil {
-		return
-	}
-
-	var newBytes []byte
-	newBytes, err = ioutil.ReadAll(updateWith)
-	if err != nil {
-		return
-	}
-
+// stageUpdate streams the new executable written by write into a file next
+// to updatePath and returns the path of the file. The file is flushed to
+// disk and read back, it is removed again unless it is complete and verify
+// accepts it.
+func stageUpdate(updatePath string, verify func(path string) error, write func(io.Writer) error) (newPath string, err error) {
 	// get the directory the executable exists in
 	updateDir := filepath.Dir(updatePath)
 	filename := filepath.Base(updatePath)
 
-	// Copy the contents of of newbinary to a the new executable file
-	newPath := filepath.Join(updateDir, fmt.Sprintf(".%s.new", filename))
+	newPath = filepath.Join(updateDir, fmt.Sprintf(".%s.new", filename))
 	fp, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
 	if err != nil {
-		return
+		return "", err
+	}
+	err = write(fp)
+	if err == nil {
+		// a power loss after the rename must not leave a truncated
+		// executable behind
+		err = fp.Sync()
 	}
-	defer fp.Close()
-	_, err = io.Copy(fp, bytes.NewReader(newBytes))
 
 	// if we don't call fp.Close(), windows won't let us move the new executable
 	// because the file will still be "in use"
-	fp.Close()
+	if errClose := fp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		// check what ended up on disk rather than what was downloaded
+		err = verify(newPath)
+	}
+	if err == nil {
+		_ = syncDir(updateDir)
+	}
+	if err != nil {
+		// don't leave a partial executable behind
+		_ = os.Remove(newPath)
+		return "", err
+	}
+	return newPath, nil
+}
 
+// install replaces the executable at updatePath with the one staged at
+// newPath, or puts it there if there is none yet. Renames of a busy file are
+// retried for a while. If that fails errRecover tells whether the original
+// executable could be put back, and the staged file is removed unless it
+// failed because a file was busy.
+func install(newPath, updatePath string) (err error, errRecover error) {
 	// this is where we'll move the executable to so that we can swap in the updated replacement
-	oldPath := filepath.Join(updateDir, fmt.Sprintf(".%s.old", filename))
+	oldPath := oldExecutablePath(updatePath)
+	updateDir := filepath.Dir(updatePath)
 
 	// delete any existing old exec file - this is necessary on Windows for two reasons:
 	// 1. after a successful update, Windows can't remove the .old file because the process is still running
 	// 2. windows rename operations fail if the destination file already exists
 	_ = os.Remove(oldPath)
 
+	// where the new executable can be renamed over the old one keep the old
+	// one as a second link and do so, then there is an executable at
+	// updatePath at every moment
+	if renameReplaces && os.Link(updatePath, oldPath) == nil {
+		if err = renameRetry(newPath, updatePath); err != nil {
+			if !isBusy(err) {
+				_ = os.Remove(newPath)
+			}
+			_ = os.Remove(oldPath)
+			return
+		}
+		_ = syncDir(updateDir)
+		return
+	}
+
 	// move the existing executable to a new file in the same directory
-	err = os.Rename(updatePath, oldPath)
-	if err != nil {
+	err = renameRetry(updatePath, oldPath)
+	existed := !os.IsNotExist(err)
+	if err != nil && existed {
+		// a busy executable may be replaced later, see deferInstall
+		if !isBusy(err) {
+			_ = os.Remove(newPath)
+		}
 		return
 	}
 
 	// move the new exectuable in to become the new program
-	err = os.Rename(newPath, updatePath)
+	err = renameRetry(newPath, updatePath)
 
 	if err != nil {
 		// copy unsuccessful
-		errRecover = os.Rename(oldPath, updatePath)
-	} else {
-		// copy successful, remove the old binary
-		errRemove := os.Remove(oldPath)
-
-		// windows has trouble with removing old binaries, so hide it instead
-		if errRemove != nil {
-			_ = hideFile(oldPath)
+		if !isBusy(err) {
+			_ = os.Remove(newPath)
+		}
+		if existed {
+			errRecover = renameRetry(oldPath, updatePath)
 		}
+	} else if existed {
+		// copy successful, keep the old binary hidden so that the update
+		// can be rolled back
+		_ = hideFile(oldPath)
 	}
+	// if the process dies before this the next run puts the old
+	// executable back, see recoverInstall
+	_ = syncDir(updateDir)
 
 	return
 }
 
 // fetchInfo fetches the update JSON manifest at u.ApiURL/appname/platform.json
 // and updates u.Info.
-func (u *Updater) fetchInfo() error {
-	r, err := u.fetch(u.ApiURL + url.QueryEscape(u.CmdName) + "/" + url.QueryEscape(plat) + ".json")
+func (u *Updater) fetchInfo(ctx context.Context) (err error) {
+	start := time.Now()
+	defer func() {
+		u.saveLastCheck(err)
+		u.metrics().Check(time.Since(start), err)
+	}()
+
+	// the native platform may have a release by now
+	u.fallback = ""
+	// ask for the manifest only if it changed since the last check
+	cond := &conditional{}
+	cache, cached := u.readManifestCache()
+	if cached {
+		cond.etag, cond.lastModified = cache.ETag, cache.LastModified
+	}
+	r, err := u.source().Manifest(withConditional(ctx, cond), u.CmdName, u.platform())
+	if isNotFound(err) && len(u.PlatformFallbacks) > 0 {
+		r, err = u.fetchFallbackManifest(ctx, cond, err)
+	}
+	var body []byte
+	notModified := cached && isNotModified(err)
+	if notModified {
+		u.logger().Debug("manifest not modified")
+		body, err = cache.Body, nil
+	} else if err == nil {
+		body, err = ioutil.ReadAll(r)
+		r.Close()
+	}
+	if ctx.Err() == nil {
+		// a cancelled check says nothing about the server
+		u.recordCheck(err)
+	}
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-	err = json.NewDecoder(r).Decode(&u.Info)
+	manifest, err := u.verifyGPG(ctx, body)
 	if err != nil {
 		return err
 	}
-	if len(u.Info.Sha256) != sha256.Size {
+	// start afresh so that fields missing from this manifest don't linger
+	u.Info = VersionInfo{}
+	err = u.manifestDecoder().Decode(manifest, u.platform(), &u.Info)
+	if err != nil {
+		return err
+	}
+	// other formats may lack the hash, which only rules out installing
+	if len(u.Info.Sha256) != sha256.Size && (len(u.Info.Sha256) != 0 || u.ManifestDecoder == nil) {
 		return errors.New("bad cmd hash in info")
 	}
+	if u.Info.Channel == "" {
+		u.Info.Channel = u.Channel
+	}
+	u.logger().Debug("fetched manifest", "version", u.Info.Version)
+	if u.Info.ManifestVersion > ManifestVersion {
+		return fmt.Errorf("unsupported manifest version %d", u.Info.ManifestVersion)
+	}
+	if err := u.updateKeys(ctx); err != nil {
+		return err
+	}
+	if err := u.verifySignature(); err != nil {
+		return err
+	}
+	if err := u.validateInfo(); err != nil {
+		return err
+	}
+	if cond.url != "" && !notModified {
+		u.saveManifestCache(cond, body)
+	}
 	return nil
 }
 
-func (u *Updater) fetchAndVerifyPatch(old io.Reader) ([]byte, error) {
-	bin, err := u.fetchAndApplyPatch(old)
+// fetchAndApplyPatch downloads the patch from the current version and
+// writes the result of applying it to old to w. It returns the number of
+// bytes downloaded.
+func (u *Updater) fetchAndApplyPatch(ctx context.Context, old io.Reader, w io.Writer) (int64, error) {
+	if u.Info.DiffAlgorithm == DiffNone {
+		return 0, errors.New("no patches published for this version")
+	}
+	hops, err := u.patchPath()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if !verifySha(bin, u.Info.Sha256) {
-		return nil, ErrHashMismatch
+	if hops != nil {
+		return u.fetchAndApplyChain(ctx, hops, old, w)
 	}
-	return bin, nil
-}
-
-func (u *Updater) fetchAndApplyPatch(old io.Reader) ([]byte, error) {
-	r, err := u.fetch(u.DiffURL + url.QueryEscape(u.CmdName) + "/" + url.QueryEscape(u.CurrentVersion) + "/" + url.QueryEscape(u.Info.Version) + "/" + url.QueryEscape(plat))
+	// manifests that don't list their patches
+	patcher, err := u.patcher(u.Info.DiffAlgorithm)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	r, err := u.source().Patch(ctx, u.CmdName, u.CurrentVersion, u.Info.Version, u.platform())
+	if err != nil {
+		return 0, err
 	}
 	defer r.Close()
-	var buf bytes.Buffer
-	err = binarydist.Patch(old, &buf, r)
-	return buf.Bytes(), err
+	cr := u.countDownload(r, ProgressPatch, u.patchSize())
+	start := time.Now()
+	err = patcher.Patch(old, w, cr)
+	if err == nil {
+		// a patcher may stop before the end of the patch, which is still
+		// counted
+		_, err = io.Copy(ioutil.Discard, cr)
+	}
+	if err == nil {
+		u.recordThroughput(cr.n, time.Since(start))
+	}
+	return cr.n, err
 }
 
-func (u *Updater) fetchAndVerifyFullBin() ([]byte, error) {
-	bin, err := u.fetchBin()
-	if err != nil {
-		return nil, err
+// fetchBin downloads the full binary and writes the executable in it to w.
+// It returns the number of bytes downloaded.
+func (u *Updater) fetchBin(ctx context.Context, w io.Writer) (int64, error) {
+	var archive ArchiveHandler
+	if format := u.archiveFormat(); format != "" {
+		var err error
+		if archive, err = u.archiveHandler(format); err != nil {
+			return 0, err
+		}
 	}
-	verified := verifySha(bin, u.Info.Sha256)
-	if !verified {
-		return nil, ErrHashMismatch
+	var sum []byte
+	if u.ChecksumsFile != "" {
+		var err error
+		if sum, err = u.fetchChecksums(ctx, u.binaryFile()); err != nil {
+			return 0, err
+		}
 	}
-	return bin, nil
-}
-
-func (u *Updater) fetchBin() ([]byte, error) {
-	r, err := u.fetch(u.BinURL + url.QueryEscape(u.CmdName) + "/" + url.QueryEscape(u.Info.Version) + "/" + url.QueryEscape(plat) + ".gz")
+	r, err := u.source().Binary(ctx, u.CmdName, u.Info.Version, u.binaryFile())
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer r.Close()
-	buf := new(bytes.Buffer)
-	gz, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+	cr := u.countDownload(r, ProgressBinary, u.Info.Size)
+	var src io.Reader = cr
+	var checksum *checksumReader
+	if sum != nil {
+		checksum = newChecksumReader(cr, sum)
+		src = checksum
+	}
+	start := time.Now()
+	if archive != nil {
+		err = archive.Extract(src, u.archiveName(), w)
+	} else {
+		err = decompress(u.Info.Compression, src, w)
 	}
-	if _, err = io.Copy(buf, gz); err != nil {
-		return nil, err
+	if err == nil && checksum != nil {
+		err = checksum.verify()
 	}
+	if err != nil {
+		return cr.n, err
+	}
+	u.recordThroughput(cr.n, time.Since(start))
+	return cr.n, nil
+}
 
-	return buf.Bytes(), nil
+func (u *Updater) fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	return u.fetchWith(ctx, url, nil)
 }
 
-func (u *Updater) fetch(url string) (io.ReadCloser, error) {
-	if u.Requester == nil {
-		return defaultHTTPRequester.Fetch(url)
+// fetchWith is fetch with client in place of the default client of
+// HTTPRequester, unless u.HTTPClient or u.Requester is set.
+func (u *Updater) fetchWith(ctx context.Context, url string, client *http.Client) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	readCloser, err := u.Requester.Fetch(url)
+	if u.HTTPClient != nil {
+		client = u.HTTPClient
+	}
+	var requester Requester = &defaultHTTPRequester
+	if u.Requester != nil {
+		requester = u.Requester
+	} else if client != nil || u.RequestHeaders != nil || u.Auth != nil || len(u.PinnedCertSHA256) > 0 {
+		requester = &HTTPRequester{Client: client, Header: u.RequestHeaders, Auth: u.Auth, PinnedCertSHA256: u.PinnedCertSHA256}
+	}
+	requester = bindContext(ctx, requester)
+	if len(u.Middleware) > 0 {
+		requester = Chain(requester, u.Middleware...)
+	}
+
+	readCloser, err := requester.Fetch(url)
 	if err != nil {
 		return nil, err
 	}
@@ -392,30 +925,12 @@ func (u *Updater) fetch(url string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("Fetch was expected to return non-nil ReadCloser")
 	}
 
-	return readCloser, nil
+	return &contextReader{ctx: ctx, ReadCloser: readCloser}, nil
 }
 
-func readTime(path string) time.Time {
-	p, err := ioutil.ReadFile(path)
-	if os.IsNotExist(err) {
-		return time.Time{}
-	}
-	if err != nil {
-		return time.Now().Add(1000 * time.Hour)
-	}
-	t, err := time.Parse(time.RFC3339, string(p))
-	if err != nil {
-		return time.Now().Add(1000 * time.Hour)
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-	return t
-}
-
-func verifySha(bin []byte, sha []byte) bool {
-	h := sha256.New()
-	h.Write(bin)
-	return bytes.Equal(h.Sum(nil), sha)
-}
-
-func writeTime(path string, t time.Time) bool {
-	return ioutil.WriteFile(path, []byte(t.Format(time.RFC3339)), 0644) == nil
+	return nil
 }
@@ -1,10 +1,45 @@
 package selfupdate
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/kr/binarydist"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 func TestUpdaterFetchMustReturnNonNilReaderCloser(t *testing.T) {
@@ -118,49 +153,4286 @@ func TestUpdateAvailable(t *testing.T) {
 	equals(t, "2023-07-09-66c6c12", version)
 }
 
-func createUpdater(mr *mockRequester) *Updater {
-	return &Updater{
-		CurrentVersion: "1.2",
-		ApiURL:         "http://updates.yourdomain.com/",
-		BinURL:         "http://updates.yourdownmain.com/",
-		DiffURL:        "http://updates.yourdomain.com/",
-		Dir:            "update/",
-		CmdName:        "myapp", // app name
-		Requester:      mr,
+func TestCheckRemoteVersion(t *testing.T) {
+	mr := &mockRequester{}
+	for _, v := range []string{"1.3", "1.2"} {
+		manifest := `{"Version": "` + v + `", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
 	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	// the executable isn't looked at
+	updater.Target = mockUpdatableResolver{path: filepath.Join(os.TempDir(), "selfupdate-test-missing", "myapp")}
+
+	version, err := updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+	version, err = updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "", version)
 }
 
-func createUpdaterWithEscapedCharacters(mr *mockRequester) *Updater {
-	return &Updater{
-		CurrentVersion: "1.2+foobar",
-		ApiURL:         "http://updates.yourdomain.com/",
-		BinURL:         "http://updates.yourdownmain.com/",
-		DiffURL:        "http://updates.yourdomain.com/",
-		Dir:            "update/",
-		CmdName:        "myapp+foo", // app name
-		Requester:      mr,
+func TestCheckRemoteVersionWithoutState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	updater := createUpdater(mr)
+	// the state directory next to the executable doesn't exist
+	missing := filepath.Join(dir, "missing")
+	updater.Target = mockUpdatableResolver{path: filepath.Join(missing, "myapp")}
+	updater.Dir = "state/"
+	updater.CircuitThreshold = 3
+
+	version, err := updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+	equals(t, "1.3", updater.Info.Version)
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("state written to %s", missing)
+	}
+	if _, ok := updater.LastCheck(); ok {
+		t.Error("check recorded in the state")
 	}
 }
 
-func equals(t *testing.T, expected, actual interface{}) {
-	if expected != actual {
-		t.Logf("Expected: %#v got %#v\n", expected, actual)
-		t.Fail()
+func TestUpdaterSkipVersion(t *testing.T) {
+	manifest := `{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+	mr := &mockRequester{}
+	for i := 0; i < 4; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+
+	version, err := updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+
+	if err := updater.SkipVersion("1.3"); err != nil {
+		t.Fatal(err)
 	}
+	version, err = updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "", version)
+	result, _ := updater.LastCheck()
+	equals(t, false, result.Available)
+
+	// a newer release is offered again
+	manifest = `{"Version": "1.4", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+	version, err = updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.4", version)
+
+	// and a mandatory one isn't skipped
+	updater.SkipVersion("1.4")
+	updater.ForceCriticalUpdates = true
+	manifest = `{"Version": "1.4", "Severity": "critical", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+	version, err = updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.4", version)
 }
 
-type testReadCloser struct {
-	buffer *bytes.Buffer
+func TestUpdaterIgnorePattern(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.3-rc1", "Severity": "critical", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.ForceCriticalUpdates = true
+	updater.IgnorePattern = regexp.MustCompile(`-rc`)
+
+	version, err := updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "", version)
+	equals(t, false, updater.Mandatory())
 }
 
-func newTestReaderCloser(payload string) io.ReadCloser {
-	return &testReadCloser{buffer: bytes.NewBufferString(payload)}
+func TestUpdaterPrereleases(t *testing.T) {
+	for _, tc := range []struct {
+		policy  PrereleasePolicy
+		current string
+		latest  string
+		want    string
+	}{
+		{PrereleasesAlways, "1.2.0", "1.3.0-beta.2", "1.3.0-beta.2"},
+		{PrereleasesNever, "1.2.0", "1.3.0-beta.2", ""},
+		{PrereleasesNever, "1.3.0-beta.1", "1.3.0-beta.2", ""},
+		{PrereleasesNever, "1.2.0", "1.3.0", "1.3.0"},
+		{PrereleasesWhenRunning, "1.2.0", "1.3.0-beta.2", ""},
+		{PrereleasesWhenRunning, "1.3.0-beta.1", "1.3.0-beta.2", "1.3.0-beta.2"},
+		{PrereleasesWhenRunning, "1.3.0-beta.2", "1.3.0", "1.3.0"},
+		// dates aren't pre-releases
+		{PrereleasesNever, "2023-07-01", "2023-07-09-66c6c12", "2023-07-09-66c6c12"},
+	} {
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(`{"Version": "` + tc.latest + `", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+			})
+		updater := createUpdater(mr)
+		updater.State = &MemoryStore{}
+		updater.CurrentVersion = tc.current
+		updater.Prereleases = tc.policy
+
+		version, err := updater.CheckRemoteVersion(context.Background())
+		equals(t, nil, err)
+		if version != tc.want {
+			t.Errorf("policy %d running %s offered %q of %s, want %q", tc.policy, tc.current, version, tc.latest, tc.want)
+		}
+	}
 }
 
-func (trc *testReadCloser) Read(p []byte) (n int, err error) {
-	return trc.buffer.Read(p)
+func TestUpdaterIgnoreBuildMetadata(t *testing.T) {
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(`{"Version": "1.3.0+nightly.6", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.CurrentVersion = "1.3.0+nightly.5"
+
+	version, err := updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "1.3.0+nightly.6", version)
+
+	updater.IgnoreBuildMetadata = true
+	version, err = updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "", version)
 }
 
-func (trc *testReadCloser) Close() error {
+func TestUpdaterCheck(t *testing.T) {
+	mr := &mockRequester{}
+	for _, v := range []string{"1.3", "1.2"} {
+		manifest := `{
+    "Version": "` + v + `",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Timestamp": "2023-07-09T00:00:00Z",
+    "Size": 5000000,
+    "Patches": {"1.2": {"Size": 1000000}}
+}`
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Platform = StaticPlatform("linux-amd64")
+	updater.Channel = "beta"
+
+	info, err := updater.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", info.Version)
+	equals(t, int64(5000000), info.Size)
+	equals(t, time.Date(2023, 7, 9, 0, 0, 0, 0, time.UTC), info.Timestamp.UTC())
+	equals(t, "beta", info.Channel)
+	equals(t, "http://updates.yourdownmain.com/myapp/1.3/linux-amd64.gz", info.Downloads.Binary)
+	equals(t, "http://updates.yourdomain.com/myapp/1.2/1.3/linux-amd64", info.Downloads.Patches["1.2"])
+	// the resolved URLs don't replace the configured ones
+	equals(t, "", updater.Info.Downloads.Binary)
+
+	info, err = updater.Check(context.Background())
+	equals(t, nil, err)
+	equals(t, true, info == nil)
+}
+
+func TestUpdaterPlatformFallbacks(t *testing.T) {
+	var urls []string
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			urls = append(urls, url)
+			return nil, &HTTPStatusError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			urls = append(urls, url)
+			return newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Platform = StaticPlatform("darwin-arm64")
+	updater.PlatformFallbacks = [][2]string{{"linux-arm-7", "linux-arm-6"}, {"darwin-arm64", "darwin-amd64"}}
+
+	info, err := updater.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "http://updates.yourdomain.com/myapp/darwin-arm64.json", urls[0])
+	equals(t, "http://updates.yourdomain.com/myapp/darwin-amd64.json", urls[1])
+	equals(t, "http://updates.yourdownmain.com/myapp/1.3/darwin-amd64.gz", info.Downloads.Binary)
+
+	// fallbacks of fallbacks are followed
+	updater.Platform = StaticPlatform("linux-arm-7")
+	updater.PlatformFallbacks = [][2]string{{"linux-arm-6", "linux-arm"}, {"linux-arm-7", "linux-arm-6"}, {"linux-arm", "linux-arm-7"}}
+	equals(t, "linux-arm-6 linux-arm", strings.Join(updater.platformFallbacks(), " "))
+}
+
+func TestNew(t *testing.T) {
+	app := []Option{WithCmdName("myapp"), WithCurrentVersion("1.2")}
+	for _, tc := range []struct {
+		name  string
+		opts  []Option
+		field string
+	}{
+		{"no cmd", []Option{WithCurrentVersion("1.2"), WithURL("https://updates.yourdomain.com/")}, "CmdName"},
+		{"no version", []Option{WithCmdName("myapp"), WithURL("https://updates.yourdomain.com/")}, "CurrentVersion"},
+		{"no url", app, "ApiURL"},
+		{"relative url", append(app, WithURL("updates.yourdomain.com/")), "ApiURL"},
+		{"bad scheme", append(app, WithURLs("https://updates.yourdomain.com/", "ftp://updates.yourdomain.com/", "")), "BinURL"},
+		{"no host", append(app, WithURLs("https://updates.yourdomain.com/", "", "https:///diffs/")), "DiffURL"},
+		{"query", append(app, WithURL("https://updates.yourdomain.com/?token=1")), "ApiURL"},
+		{"absolute dir", append(app, WithURL("https://updates.yourdomain.com/"), WithDir(filepath.Join(os.TempDir(), "update"))), "Dir"},
+	} {
+		_, err := New(tc.opts...)
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || configErr.Field != tc.field {
+			t.Errorf("%s: got %v, want an error about %s", tc.name, err, tc.field)
+		}
+	}
+
+	u, err := New(append(app, WithURL("https://updates.yourdomain.com/myapp-updates"), WithDir("update"))...)
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "https://updates.yourdomain.com/myapp-updates/", u.ApiURL)
+	equals(t, "https://updates.yourdomain.com/myapp-updates/", u.BinURL)
+	equals(t, "https://updates.yourdomain.com/myapp-updates/", u.DiffURL)
+	equals(t, "update"+string(filepath.Separator), u.Dir)
+
+	// a source needs no URLs, a requester may understand other schemes
+	_, err = New(append(app, WithSource(&GitHubReleaseSource{Owner: "sanbornm", Repo: "myapp"}))...)
+	equals(t, nil, err)
+	_, err = New(append(app, WithURL("s3://updates/"), func(u *Updater) { u.Requester = &mockRequester{} })...)
+	equals(t, nil, err)
+}
+
+func TestNewRefusesInsecureHTTP(t *testing.T) {
+	app := []Option{WithCmdName("myapp"), WithCurrentVersion("1.2")}
+	for _, tc := range []struct {
+		opts  []Option
+		field string
+	}{
+		{[]Option{WithURL("http://updates.yourdomain.com/")}, "ApiURL"},
+		{[]Option{WithURLs("https://updates.yourdomain.com/", "http://updates.yourdownmain.com/", "")}, "BinURL"},
+		{[]Option{WithURL("https://updates.yourdomain.com/"), func(u *Updater) { u.Mirrors = []string{"http://mirror.example.com"} }}, "Mirrors"},
+		{[]Option{WithURL("https://updates.yourdomain.com/"), func(u *Updater) { u.Mirrors = []string{"HTTP://mirror.example.com"} }}, "Mirrors"},
+	} {
+		_, err := New(append(app, tc.opts...)...)
+		var configErr *ConfigError
+		equals(t, true, errors.Is(err, ErrInsecureHTTP))
+		equals(t, true, errors.As(err, &configErr) && configErr.Field == tc.field)
+
+		u, err := New(append(append(app, tc.opts...), func(u *Updater) { u.AllowInsecureHTTP = true })...)
+		equals(t, nil, err)
+		equals(t, true, u != nil)
+	}
+}
+
+func TestUpdaterRefusesInsecureDownloads(t *testing.T) {
+	mr := &mockRequester{}
+	for _, downloads := range []string{
+		`{"Binary": "http://cdn.example.com/myapp-1.3.gz"}`,
+		`{"Patches": {"1.2": "HTTP://cdn.example.com/myapp-1.2-1.3"}}`,
+		`{"Binary": "http://cdn.example.com/myapp-1.3.gz"}`,
+	} {
+		manifest := `{"ManifestVersion": 2, "Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=", "Downloads": ` + downloads + `}`
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
+	}
+	updater, err := New(WithCmdName("myapp"), WithCurrentVersion("1.2"), WithURL("https://updates.yourdomain.com/"), func(u *Updater) {
+		u.Requester = mr
+		u.State = &MemoryStore{}
+	})
+	equals(t, nil, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := updater.UpdateAvailable()
+		equals(t, true, errors.Is(err, ErrInsecureHTTP))
+	}
+
+	updater.AllowInsecureHTTP = true
+	version, err := updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+
+	// a struct literal, which may use plain http itself, isn't checked
+	literal := createUpdater(mr)
+	literal.State = &MemoryStore{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"ManifestVersion": 2, "Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=", "Downloads": {"Binary": "http://cdn.example.com/myapp-1.3.gz"}}`), nil
+		})
+	version, err = literal.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+}
+
+func TestUpdaterMiddlewareOrder(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser("{}"), nil
+		})
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Requester) Requester {
+			return RequesterFunc(func(url string) (io.ReadCloser, error) {
+				calls = append(calls, name)
+				return next.Fetch(url)
+			})
+		}
+	}
+	updater := createUpdater(mr)
+	updater.Middleware = []Middleware{trace("outer"), trace("inner")}
+
+	if _, err := updater.fetch(context.Background(), "http://updates.yourdomain.com/myapp/linux-amd64.json"); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, 2, len(calls))
+	equals(t, "outer", calls[0])
+	equals(t, "inner", calls[1])
+	equals(t, 1, mr.currentIndex)
+}
+
+func TestUpdaterCircuitBreaker(t *testing.T) {
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return nil, errors.New("connection refused")
+			})
+	}
+	updater := createUpdater(mr)
+	updater.Dir = "circuit-test/"
+	updater.ForceCheck = true
+	updater.CircuitThreshold = 2
+	updater.CircuitCooldown = 1
+	if err := os.MkdirAll(updater.getExecRelativeDir(updater.Dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+
+	updater.fetchInfo(context.Background())
+	if !updater.WantUpdate() {
+		t.Errorf("circuit opened after a single failure")
+	}
+	updater.fetchInfo(context.Background())
+	if updater.WantUpdate() {
+		t.Errorf("circuit still closed after %d failures", updater.CircuitThreshold)
+	}
+
+	// without a cooldown the circuit still opens, for the default one
+	updater.State = &MemoryStore{}
+	updater.CircuitThreshold = 1
+	updater.CircuitCooldown = 0
+	updater.recordCheck(errors.New("connection refused"))
+	equals(t, true, updater.circuitOpen())
+	until := readCircuit(updater.state()).OpenUntil
+	equals(t, true, time.Until(until) > 23*time.Hour && time.Until(until) <= 24*time.Hour)
+}
+
+func TestHTTPDateTimeSource(t *testing.T) {
+	date := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	stall := make(chan struct{})
+	defer close(stall)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		equals(t, http.MethodHead, r.Method)
+		if r.URL.Path == "/stalled" {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		rw.Header().Set("Date", date.Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	// the test server's certificate is only trusted by its client
+	got, err := HTTPDateTimeSource(srv.URL, srv.Client())()
+	equals(t, nil, err)
+	equals(t, true, got.Equal(date))
+	_, err = HTTPDateTimeSource(srv.URL)()
+	equals(t, true, err != nil)
+
+	defer func(d time.Duration) { timeSourceTimeout = d }(timeSourceTimeout)
+	timeSourceTimeout = 10 * time.Millisecond
+	_, err = HTTPDateTimeSource(srv.URL+"/stalled", srv.Client())()
+	equals(t, true, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestCheckAsyncTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			<-release
+			return newTestReaderCloser("{}"), nil
+		})
+	updater := createUpdater(mr)
+
+	select {
+	case res := <-updater.CheckAsync(10 * time.Millisecond):
+		equals(t, ErrCheckTimeout, res.Err)
+	case <-time.After(time.Second):
+		t.Fatal("CheckAsync did not honor its timeout")
+	}
+
+	// the request itself is cancelled too
+	cancelled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer srv.Close()
+	updater = createUpdater(nil)
+	updater.ApiURL = srv.URL + "/"
+	updater.Requester = nil
+	equals(t, ErrCheckTimeout, (<-updater.CheckAsync(10 * time.Millisecond)).Err)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("CheckAsync left the request running")
+	}
+	equals(t, "", updater.Info.Version)
+}
+
+func TestUpdaterPatcherSelection(t *testing.T) {
+	updater := createUpdater(&mockRequester{})
+
+	if _, err := updater.patcher("xdelta3"); err == nil {
+		t.Error("expected an error for an unregistered diff algorithm")
+	}
+	if _, err := updater.patcher(""); err != nil {
+		t.Errorf("default diff algorithm not supported: %v", err)
+	}
+
+	called := false
+	updater.Patchers = map[string]Patcher{
+		"xdelta3": PatcherFunc(func(old io.Reader, new io.Writer, patch io.Reader) error {
+			called = true
+			return nil
+		}),
+	}
+	p, err := updater.patcher("xdelta3")
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	p.Patch(nil, nil, nil)
+	equals(t, true, called)
+}
+
+func TestUpdaterRejectsExpiredManifest(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "2023-07-09-66c6c12",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Expires": "2023-07-10T00:00:00Z"
+}`), nil
+		})
+	updater := createUpdater(mr)
+
+	_, err := updater.UpdateAvailable()
+	equals(t, ErrManifestExpired, err)
+}
+
+func TestUpdaterForceCriticalUpdatesBypassesSchedule(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.2",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Severity": "critical"
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.Dir = "critical-test/"
+	updater.ForceCriticalUpdates = true
+	updater.CheckTime = 24
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+	if err := os.MkdirAll(updater.getExecRelativeDir(updater.Dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater.SetUpdateTime()
+
+	if updater.WantUpdate() {
+		t.Fatal("expected no scheduled check")
+	}
+	if err := updater.BackgroundRun(); err != nil {
+		t.Errorf("Error occurred: %#v", err)
+	}
+	equals(t, 1, mr.currentIndex)
+	equals(t, SeverityCritical, updater.Info.Severity)
+}
+
+func TestUpdaterEnforceMinimumBypassesSchedule(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.2",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "ManifestVersion": 2,
+    "MinimumVersion": "1.1"
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.CurrentVersion = "1.0"
+	updater.Dir = "minimum-test/"
+	updater.EnforceMinimum = true
+	updater.CheckTime = 24
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+	if err := os.MkdirAll(updater.getExecRelativeDir(updater.Dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater.SetUpdateTime()
+
+	if updater.WantUpdate() {
+		t.Fatal("expected no scheduled check")
+	}
+	// the mock serves no binary, so getting that far means the update was
+	// attempted despite the schedule
+	if err := updater.BackgroundRun(); err == nil {
+		t.Error("expected the update to be attempted")
+	}
+	equals(t, true, updater.belowMinimum())
+	equals(t, true, updater.mandatory())
+
+	updater.CurrentVersion = "1.1"
+	equals(t, false, updater.mandatory())
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.2", "1.10", -1},
+		{"v2.0.1", "2.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0+build.5", "1.0.0", 0},
+		{"2023-07-09-66c6c12", "2023-07-10-0a1b2c3", -1},
+	} {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := compareVersions(c.b, c.a); got != -c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.b, c.a, got, -c.want)
+		}
+	}
+}
+
+func TestUpdaterRejectsStaleManifest(t *testing.T) {
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(`{
+    "Version": "2023-07-09-66c6c12",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Timestamp": "2023-07-09T00:00:00Z"
+}`), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.MaxManifestAge = 30 * 24 * time.Hour
+	updater.TimeSource = func() (time.Time, error) {
+		return time.Date(2023, 7, 20, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Errorf("Error occurred: %#v", err)
+	}
+	equals(t, "2023-07-09-66c6c12", version)
+
+	updater.TimeSource = func() (time.Time, error) {
+		return time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	_, err = updater.UpdateAvailable()
+	equals(t, ErrManifestStale, err)
+}
+
+func TestUpdaterRefusesDowngrade(t *testing.T) {
+	mr := &mockRequester{}
+	for i := 0; i < 3; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Errorf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", version)
+
+	// a replayed manifest of 1.3 after 1.4 was installed
+	updater.recordInstalled("1.4")
+	updater.recordInstalled("1.2")
+	equals(t, "1.4", updater.highestInstalled())
+	_, err = updater.UpdateAvailable()
+	equals(t, true, errors.Is(err, ErrVersionRollback))
+
+	updater.AllowDowngrade = true
+	version, err = updater.UpdateAvailable()
+	if err != nil {
+		t.Errorf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", version)
+}
+
+type mockSchedule struct {
+	next      time.Time
+	scheduled int
+}
+
+func (s *mockSchedule) NextCheck() time.Time { return s.next }
+
+func (s *mockSchedule) ScheduleNextCheck() error {
+	s.scheduled++
+	s.next = time.Now().Add(time.Hour)
+	return nil
+}
+
+func (s *mockSchedule) Reset() error {
+	s.next = time.Time{}
 	return nil
 }
+
+func TestUpdaterUsesSchedule(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser("{}"), nil
+		})
+	schedule := &mockSchedule{}
+	updater := createUpdater(mr)
+	updater.Schedule = schedule
+
+	if !updater.WantUpdate() {
+		t.Fatal("expected an update check to be due")
+	}
+	updater.BackgroundRun()
+	equals(t, 1, schedule.scheduled)
+	if updater.WantUpdate() {
+		t.Error("expected no update check to be due after scheduling the next one")
+	}
+
+	updater.Schedule = AlwaysCheckForUpdatesSchedule{}
+	if !updater.WantUpdate() {
+		t.Error("AlwaysCheckForUpdatesSchedule should always want an update")
+	}
+}
+
+type mockUpdatableResolver struct {
+	path string
+	err  error
+}
+
+func (r mockUpdatableResolver) Path() (string, error) { return r.path, r.err }
+
+func TestUpdaterUsesResolvers(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/windows-386.json", url)
+			return newTestReaderCloser(`{
+    "Version": "2023-07-09-66c6c12",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.Platform = StaticPlatform("windows-386")
+	updater.Target = mockUpdatableResolver{err: errors.New("no target")}
+
+	_, err := updater.UpdateAvailable()
+	if err == nil || err.Error() != "no target" {
+		t.Fatalf("expected the target resolver error, got %v", err)
+	}
+
+	target, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	updater.Target = mockUpdatableResolver{path: target}
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Errorf("Error occurred: %#v", err)
+	}
+	equals(t, "2023-07-09-66c6c12", version)
+}
+
+func TestUpdaterLastCheck(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "2023-07-09-66c6c12",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.Dir = "lastcheck-test/"
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+	if err := os.MkdirAll(updater.getExecRelativeDir(updater.Dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := updater.LastCheck(); ok {
+		t.Fatal("expected no recorded check")
+	}
+	if _, err := updater.UpdateAvailable(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	last, ok := updater.LastCheck()
+	if !ok {
+		t.Fatal("expected a recorded check")
+	}
+	equals(t, "2023-07-09-66c6c12", last.Version)
+	equals(t, true, last.Available)
+	equals(t, "", last.Error)
+	if time.Since(last.Time) > time.Minute {
+		t.Errorf("unexpected check time %s", last.Time)
+	}
+}
+
+func TestUpdaterStatus(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("server down")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.State = &MemoryStore{}
+
+	status := updater.Status()
+	equals(t, true, status.LastCheck.IsZero())
+	equals(t, true, status.LastUpdate.IsZero())
+	equals(t, "", status.LastError)
+
+	if err := updater.Update(); err == nil {
+		t.Fatal("expected the check to fail")
+	}
+	status = updater.Status()
+	equals(t, false, status.LastCheck.IsZero())
+	equals(t, "", status.LatestVersion)
+	equals(t, "server down", status.LastError)
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	status = updater.Status()
+	equals(t, "1.3", status.LatestVersion)
+	equals(t, true, status.UpdateAvailable)
+	equals(t, "1.3", status.UpdatedTo)
+	equals(t, false, status.RolledBack)
+	equals(t, "", status.LastError)
+	if time.Since(status.LastUpdate) > time.Minute {
+		t.Errorf("unexpected update time %s", status.LastUpdate)
+	}
+}
+
+func TestIdleGateWaitsForWork(t *testing.T) {
+	gate := &IdleGate{}
+	gate.Begin()
+
+	idle := make(chan struct{})
+	go func() {
+		gate.WaitIdle(context.Background())
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+		t.Fatal("WaitIdle returned while work was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	gate.End()
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("WaitIdle did not return after work finished")
+	}
+
+	started := make(chan struct{})
+	go func() {
+		gate.Begin()
+		close(started)
+		gate.End()
+	}()
+	select {
+	case <-started:
+		t.Fatal("Begin did not block while the update was applied")
+	case <-time.After(20 * time.Millisecond):
+	}
+	gate.Release()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Begin did not proceed after Release")
+	}
+
+	// giving up lets work through again
+	gate.Begin()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	equals(t, context.DeadlineExceeded, gate.WaitIdle(ctx))
+	gate.Begin()
+	gate.End()
+	gate.End()
+}
+
+func TestUpdaterEstimateUpdate(t *testing.T) {
+	mr := &mockRequester{}
+	manifest := func(url string) (io.ReadCloser, error) {
+		return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Size": 4000000,
+    "Patches": {"1.2": {"Size": 1000000}}
+}`), nil
+	}
+	mr.handleRequest(manifest)
+	mr.handleRequest(manifest)
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Size": 4000000,
+    "Patches": {"1.2": {}}
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.Dir = "estimate-test/"
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+	if err := os.MkdirAll(updater.getExecRelativeDir(updater.Dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := updater.EstimateUpdate()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", e.Version)
+	equals(t, int64(1000000), e.Bytes)
+	equals(t, true, e.Patch)
+	equals(t, time.Duration(0), e.Duration)
+
+	updater.recordThroughput(500000, time.Second)
+	e, err = updater.EstimateUpdate()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, 2*time.Second, e.Duration)
+
+	// a patch of unknown size falls back to the full binary
+	e, err = updater.EstimateUpdate()
+	equals(t, nil, err)
+	equals(t, int64(4000000), e.Bytes)
+	equals(t, false, e.Patch)
+}
+
+func TestUpdaterUpdateContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			// the user gives up while the patch is downloading
+			cancel()
+			return newTestReaderCloser("patch"), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	err = updater.UpdateContext(ctx)
+	equals(t, context.Canceled, err)
+	equals(t, 2, mr.currentIndex)
+	if _, err := os.Stat(filepath.Join(dir, ".myapp.new")); !os.IsNotExist(err) {
+		t.Error("partial .new file left behind")
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+}
+
+func TestUpdaterVerifiesSignature(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	signer := createUpdater(nil)
+	signer.Info.Version = "2023-07-09-66c6c12"
+	signer.Info.Sha256 = make([]byte, 32)
+	signer.Info.Timestamp = time.Now().UTC().Truncate(time.Second)
+	signer.Info.Signature = ed25519.Sign(key, signer.Info.SignaturePayload())
+	signed, err := json.Marshal(signer.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Info.Version = "2023-07-10-deadbee"
+	tampered, err := json.Marshal(signer.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		manifest string
+		err      error
+	}{
+		{string(signed), nil},
+		{string(tampered), ErrSignatureInvalid},
+		{`{"Version": "2023-07-09-66c6c12", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`, ErrSignatureInvalid},
+	} {
+		manifest := tc.manifest
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
+		updater := createUpdater(mr)
+		updater.PublicKey = key.Public().(ed25519.PublicKey)
+
+		_, err := updater.UpdateAvailable()
+		equals(t, tc.err, err)
+	}
+}
+
+func TestUpdaterGitHubReleaseSource(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		release := `{"tag_name": "1.3", "assets": [
+			{"name": "linux-amd64.json", "url": "` + srv.URL + `/assets/1"},
+			{"name": "linux-amd64.gz", "url": "` + srv.URL + `/assets/2"}]}`
+		switch r.URL.Path {
+		case "/repos/sanbornm/myapp/releases/latest", "/repos/sanbornm/myapp/releases/tags/1.3":
+			rw.Write([]byte(release))
+		case "/assets/1":
+			rw.Write(manifest)
+		case "/assets/2":
+			equals(t, "application/octet-stream", r.Header.Get("Accept"))
+			rw.Write(gz.Bytes())
+		default:
+			http.Error(rw, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(nil)
+	updater.Requester = nil
+	updater.Platform = StaticPlatform("linux-amd64")
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.Source = &GitHubReleaseSource{Owner: "sanbornm", Repo: "myapp", Token: "secret", APIURL: srv.URL + "/"}
+	// the requests go through the Updater like those of other sources
+	var fetched []string
+	updater.Middleware = []Middleware{func(next Requester) Requester {
+		return RequesterFunc(func(url string) (io.ReadCloser, error) {
+			fetched = append(fetched, strings.TrimPrefix(url, srv.URL))
+			return next.Fetch(url)
+		})
+	}}
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+	// the release is looked up for the patch, which it doesn't have, and the
+	// full binary
+	equals(t, "/repos/sanbornm/myapp/releases/latest /assets/1 /repos/sanbornm/myapp/releases/tags/1.3 /repos/sanbornm/myapp/releases/tags/1.3 /assets/2", strings.Join(fetched, " "))
+}
+
+func TestUpdaterKeyManifestFromSource(t *testing.T) {
+	oldKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	newKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	signer := createUpdater(nil)
+	signer.Info.Version = "1.3"
+	signer.Info.Sha256 = make([]byte, 32)
+	signer.Info.Signature = ed25519.Sign(newKey, signer.Info.SignaturePayload())
+	manifest, _ := json.Marshal(signer.Info)
+	keys := KeyManifest{Timestamp: time.Now(), Keys: []TrustedKey{{PublicKey: newKey.Public().(ed25519.PublicKey)}}}
+	keys.Signatures = []KeySignature{{PublicKey: oldKey.Public().(ed25519.PublicKey), Signature: ed25519.Sign(oldKey, keys.Payload())}}
+	keyManifest, _ := json.Marshal(keys)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/sanbornm/myapp/releases/latest":
+			rw.Write([]byte(`{"tag_name": "1.3", "assets": [
+				{"name": "linux-amd64.json", "url": "` + srv.URL + `/assets/1"},
+				{"name": "keys.json", "url": "` + srv.URL + `/assets/2"}]}`))
+		case "/assets/1":
+			rw.Write(manifest)
+		case "/assets/2":
+			rw.Write(keyManifest)
+		default:
+			http.Error(rw, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	src := &GitHubReleaseSource{Owner: "sanbornm", Repo: "myapp", APIURL: srv.URL + "/"}
+	updater := createUpdater(nil)
+	updater.ApiURL = ""
+	updater.Requester = nil
+	updater.State = &MemoryStore{}
+	updater.Platform = StaticPlatform("linux-amd64")
+	updater.Source = src
+	updater.PublicKey = oldKey.Public().(ed25519.PublicKey)
+	updater.KeyManifest = "keys.json"
+	version, err := updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+
+	// a source without key manifests leaves the keys as they are
+	updater.State = &MemoryStore{}
+	updater.Source = struct{ UpdateSource }{src}
+	_, err = updater.UpdateAvailable()
+	equals(t, ErrSignatureInvalid, err)
+
+	_, err = New(WithCmdName("myapp"), WithCurrentVersion("1.2"), WithSource(updater.Source), func(u *Updater) { u.KeyManifest = "keys.json" })
+	var configErr *ConfigError
+	equals(t, true, errors.As(err, &configErr) && configErr.Field == "KeyManifest")
+}
+
+func TestUpdaterOnProgress(t *testing.T) {
+	old := bytes.Repeat([]byte("old binary "), 20000)
+	bin := bytes.Repeat([]byte("new binary "), 20000)
+	sum := sha256.Sum256(bin)
+	var gz, patch bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	if err := binarydist.Diff(bytes.NewReader(old), bytes.NewReader(bin), &patch); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		phase string
+		size  int
+	}{
+		{ProgressPatch, patch.Len()},
+		{ProgressBinary, gz.Len()},
+	} {
+		info := map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "Size": gz.Len()}
+		if test.phase == ProgressPatch {
+			info["Patches"] = map[string]PatchInfo{"1.2": {Size: int64(patch.Len())}}
+		} else {
+			info["DiffAlgorithm"] = DiffNone
+		}
+		manifest, _ := json.Marshal(info)
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/myapp/linux-amd64.json":
+				rw.Write(manifest)
+			case "/myapp/1.3/linux-amd64.gz":
+				rw.Write(gz.Bytes())
+			case "/myapp/1.2/1.3/linux-amd64":
+				rw.Write(patch.Bytes())
+			default:
+				http.Error(rw, "not found", http.StatusNotFound)
+			}
+		}))
+		defer srv.Close()
+
+		dir, err := ioutil.TempDir("", "selfupdate-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		target := filepath.Join(dir, "myapp")
+		if err := ioutil.WriteFile(target, old, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		var phases []string
+		var received int64
+		updater := createUpdater(nil)
+		updater.Requester = nil
+		updater.ApiURL, updater.BinURL, updater.DiffURL = srv.URL+"/", srv.URL+"/", srv.URL+"/"
+		updater.Platform = StaticPlatform("linux-amd64")
+		updater.Target = mockUpdatableResolver{path: target}
+		updater.State = &MemoryStore{}
+		updater.OnProgress = func(phase string, n, total int64) {
+			if len(phases) == 0 || phases[len(phases)-1] != phase {
+				phases = append(phases, phase)
+			} else if n <= received {
+				t.Errorf("%s: progress went from %d to %d", test.phase, received, n)
+			}
+			if total != int64(test.size) {
+				t.Errorf("%s: total %d, want %d", test.phase, total, test.size)
+			}
+			received = n
+		}
+		if err := updater.Update(); err != nil {
+			t.Fatalf("%s: %v", test.phase, err)
+		}
+		if len(phases) != 1 || phases[0] != test.phase {
+			t.Errorf("got phases %v, want [%s]", phases, test.phase)
+		}
+		equals(t, int64(test.size), received)
+		b, _ := ioutil.ReadFile(target)
+		equals(t, true, bytes.Equal(bin, b))
+	}
+}
+
+func TestUpdaterRollback(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+			})
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Dir = "rollback-test/"
+	updater.Target = mockUpdatableResolver{path: target}
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+	if err := os.MkdirAll(updater.getExecRelativeDir(updater.Dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	equals(t, ErrNoRollback, updater.Rollback())
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, true, updater.CanRollback())
+	if err := updater.Rollback(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, false, updater.CanRollback())
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	// the ledger keeps the rolled back release
+	equals(t, "1.3", updater.highestInstalled())
+
+	// the rolled back release is not installed again
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	equals(t, 3, mr.currentIndex)
+}
+
+func TestUpdaterRestartAfterUpdate(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+
+	var restarted []string
+	hooked := false
+	gate := &IdleGate{}
+	defer func(r func(string, []string, string) error) { restartProcess = r }(restartProcess)
+	restartProcess = func(exe string, args []string, dir string) error {
+		if !hooked {
+			t.Error("restarted before OnSuccessfulUpdate ran")
+		}
+		gate.mu.Lock()
+		if !gate.applying {
+			t.Error("restarted after the ApplyGate was released")
+		}
+		gate.mu.Unlock()
+		restarted = append(restarted, exe)
+		return nil
+	}
+
+	for _, test := range []struct {
+		name    string
+		binary  []byte
+		health  error
+		restart bool
+	}{
+		{"installed", gz.Bytes(), nil, true},
+		{"corrupt", []byte("not gzip"), nil, false},
+		{"rolled back", gz.Bytes(), errors.New("crashed"), false},
+	} {
+		restarted, hooked = nil, false
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		binary := test.binary
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(binary)), nil
+			})
+		dir, err := ioutil.TempDir("", "selfupdate-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		target := filepath.Join(dir, "myapp")
+		if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		updater := createUpdater(mr)
+		updater.Target = mockUpdatableResolver{path: target}
+		updater.State = &MemoryStore{}
+		updater.RestartAfterUpdate = true
+		updater.ApplyGate = gate
+		updater.OnSuccessfulUpdate = func() { hooked = true }
+		health := test.health
+		updater.HealthCheck = func(path string) error { return health }
+
+		err = updater.Update()
+		if test.restart {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			if len(restarted) != 1 || restarted[0] != target {
+				t.Errorf("%s: restarted %v, want [%s]", test.name, restarted, target)
+			}
+			continue
+		}
+		if err == nil || (test.health != nil && !errors.Is(err, ErrRolledBack)) {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if len(restarted) != 0 {
+			t.Errorf("%s: restarted %v after a failed update", test.name, restarted)
+		}
+	}
+}
+
+func TestUpdaterDownloadAndApply(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Dir = "staged-test/"
+	updater.Target = mockUpdatableResolver{path: target}
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+
+	equals(t, ErrNoStagedUpdate, updater.Apply())
+	if err := updater.Download(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	version, ok := updater.StagedVersion()
+	equals(t, true, ok)
+	equals(t, "1.3", version)
+
+	// a later run applies what the earlier one downloaded
+	updater = createUpdater(&mockRequester{})
+	updater.Dir = "staged-test/"
+	updater.Target = mockUpdatableResolver{path: target}
+	if err := updater.Apply(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+	_, ok = updater.StagedVersion()
+	equals(t, false, ok)
+	equals(t, ErrNoStagedUpdate, updater.Apply())
+}
+
+func TestUpdaterHashMismatchLeavesExecutable(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("tampered binary"))
+	w.Close()
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "DiffAlgorithm": "none"
+}`), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	equals(t, ErrHashMismatch, updater.Update())
+	if _, err := os.Stat(filepath.Join(dir, ".myapp.new")); !os.IsNotExist(err) {
+		t.Error("unverified .new file left behind")
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+}
+
+func TestUpdaterArchives(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	zw.Create("myapp_1.3/README.md")
+	f, _ := zw.Create("myapp_1.3/myapp")
+	f.Write(bin)
+	zw.Close()
+
+	var tarred bytes.Buffer
+	gw := gzip.NewWriter(&tarred)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "myapp_1.3/myapp", Mode: 0755, Size: int64(len(bin)), Typeflag: tar.TypeReg})
+	tw.Write(bin)
+	tw.Close()
+	gw.Close()
+
+	for _, tc := range []struct {
+		archive string
+		file    string
+		data    []byte
+	}{
+		{ArchiveZip, "linux-amd64.zip", zipped.Bytes()},
+		{ArchiveTarGz, "linux-amd64.tar.gz", tarred.Bytes()},
+	} {
+		tc := tc
+		manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none", "Archive": tc.archive})
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+tc.file, url)
+				return ioutil.NopCloser(bytes.NewReader(tc.data)), nil
+			})
+		dir, err := ioutil.TempDir("", "selfupdate-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		target := filepath.Join(dir, "myapp")
+		if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		updater := createUpdater(mr)
+		updater.Platform = StaticPlatform("linux-amd64")
+		updater.Target = mockUpdatableResolver{path: target}
+
+		if err := updater.Update(); err != nil {
+			t.Fatalf("%s: Error occurred: %#v", tc.archive, err)
+		}
+		b, _ := ioutil.ReadFile(target)
+		equals(t, "new binary", string(b))
+	}
+}
+
+func TestUpdaterCompression(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+
+	var zstdData bytes.Buffer
+	zw, _ := zstd.NewWriter(&zstdData)
+	zw.Write(bin)
+	zw.Close()
+
+	var xzData bytes.Buffer
+	xw, _ := xz.NewWriter(&xzData)
+	xw.Write(bin)
+	xw.Close()
+
+	for _, tc := range []struct {
+		compression string
+		file        string
+		data        []byte
+	}{
+		{CompressionZstd, "linux-amd64.zst", zstdData.Bytes()},
+		{CompressionXz, "linux-amd64.xz", xzData.Bytes()},
+	} {
+		tc := tc
+		manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none", "Compression": tc.compression})
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+tc.file, url)
+				return ioutil.NopCloser(bytes.NewReader(tc.data)), nil
+			})
+		dir, err := ioutil.TempDir("", "selfupdate-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		target := filepath.Join(dir, "myapp")
+		if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		updater := createUpdater(mr)
+		updater.Platform = StaticPlatform("linux-amd64")
+		updater.Target = mockUpdatableResolver{path: target}
+
+		if err := updater.Update(); err != nil {
+			t.Fatalf("%s: Error occurred: %#v", tc.compression, err)
+		}
+		b, _ := ioutil.ReadFile(target)
+		equals(t, "new binary", string(b))
+	}
+}
+
+func TestUpdaterDryRun(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Platform = StaticPlatform("linux-amd64")
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.State = &MemoryStore{}
+	updater.DryRun = true
+	updated := false
+	updater.OnSuccessfulUpdate = func() { updated = true }
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, 2, mr.currentIndex)
+	equals(t, false, updated)
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	files, _ := ioutil.ReadDir(dir)
+	equals(t, 1, len(files))
+}
+
+func TestUpdaterUpdateWithResult(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	result, err := updater.UpdateWithResult(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, UpdateResult{Updated: true, From: "1.2", To: "1.3", Bytes: int64(gz.Len())}, result)
+
+	updater.CurrentVersion = "1.3"
+	result, err = updater.UpdateWithResult(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, UpdateResult{From: "1.3", To: "1.3"}, result)
+}
+
+func TestUpdaterChecksumsFile(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+	gzSum := sha256.Sum256(gz.Bytes())
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	for _, tc := range []struct {
+		sums string
+		err  error
+	}{
+		{fmt.Sprintf("%x  dist/myapp_1.3_linux_amd64.gz\n%x  myapp_1.3_darwin_arm64.gz\n", gzSum, sum), nil},
+		{fmt.Sprintf("%x *myapp_1.3_linux_amd64.gz\n", sum), ErrChecksumMismatch},
+	} {
+		var urls []string
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				urls = append(urls, url)
+				return newTestReaderCloser(tc.sums), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				urls = append(urls, url)
+				return newTestReaderCloser("signature"), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				urls = append(urls, url)
+				return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+			})
+		dir, err := ioutil.TempDir("", "selfupdate-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		target := filepath.Join(dir, "myapp")
+		if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		updater := createUpdater(mr)
+		updater.Platform = StaticPlatform("linux-amd64")
+		updater.Target = mockUpdatableResolver{path: target}
+		updater.BinaryFileName = func(version, platform string) string {
+			return "myapp_" + version + "_" + strings.Replace(platform, "-", "_", 1) + ".gz"
+		}
+		updater.ChecksumsFile = "checksums.txt"
+		updater.VerifyChecksums = func(sums, sig []byte) error {
+			equals(t, tc.sums, string(sums))
+			equals(t, "signature", string(sig))
+			return nil
+		}
+
+		err = updater.Update()
+		equals(t, tc.err, err)
+		equals(t, 3, len(urls))
+		equals(t, "http://updates.yourdownmain.com/myapp/1.3/checksums.txt", urls[0])
+		equals(t, "http://updates.yourdownmain.com/myapp/1.3/checksums.txt.sig", urls[1])
+		equals(t, "http://updates.yourdownmain.com/myapp/1.3/myapp_1.3_linux_amd64.gz", urls[2])
+		b, _ := ioutil.ReadFile(target)
+		if tc.err == nil {
+			equals(t, "new binary", string(b))
+		} else {
+			equals(t, "old", string(b))
+		}
+	}
+}
+
+func TestUpdaterNamingGoreleaser(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var archive bytes.Buffer
+	gw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "README.md", Mode: 0644, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "myapp", Mode: 0755, Size: int64(len(bin))})
+	tw.Write(bin)
+	tw.Close()
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "v1.3.0", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/v1.3.0/myapp_1.3.0_linux_amd64.tar.gz", url)
+			return ioutil.NopCloser(bytes.NewReader(archive.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Platform = StaticPlatform("linux-amd64")
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.NamingScheme = NamingGoreleaser
+	updater.State = &MemoryStore{}
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+}
+
+func TestUpdaterTargets(t *testing.T) {
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(b)
+		gw.Close()
+		return buf.Bytes()
+	}
+	bin, helper := []byte("new binary"), []byte("new helper")
+	sum, helperSum := sha256.Sum256(bin), sha256.Sum256(helper)
+
+	for _, tc := range []struct {
+		name       string
+		helperHash []byte
+		updated    bool
+	}{
+		{"all verify", helperSum[:], true},
+		{"helper mismatch", sum[:], false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			manifest, _ := json.Marshal(map[string]interface{}{
+				"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none",
+				"Files": []ReleaseFile{{Name: "myapp-helper", Sha256: tc.helperHash}},
+			})
+			var urls []string
+			mr := &mockRequester{}
+			for _, body := range [][]byte{manifest, gzipped(bin), gzipped(helper)} {
+				body := body
+				mr.handleRequest(
+					func(url string) (io.ReadCloser, error) {
+						urls = append(urls, url)
+						return ioutil.NopCloser(bytes.NewReader(body)), nil
+					})
+			}
+			dir, err := ioutil.TempDir("", "selfupdate-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			target := filepath.Join(dir, "myapp")
+			helperTarget := filepath.Join(dir, "myapp-helper")
+			for _, p := range []string{target, helperTarget} {
+				if err := ioutil.WriteFile(p, []byte("old"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			updater := createUpdater(mr)
+			updater.Platform = StaticPlatform("linux-amd64")
+			updater.Target = mockUpdatableResolver{path: target}
+			updater.Targets = []UpdatableResolver{mockUpdatableResolver{path: helperTarget}}
+			updater.State = &MemoryStore{}
+
+			err = updater.Update()
+			equals(t, tc.updated, err == nil)
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/linux-amd64-myapp-helper.gz", urls[2])
+			want := "old"
+			if tc.updated {
+				want = "new binary"
+			}
+			b, _ := ioutil.ReadFile(target)
+			equals(t, want, string(b))
+			if tc.updated {
+				want = "new helper"
+			}
+			b, _ = ioutil.ReadFile(helperTarget)
+			equals(t, want, string(b))
+			if tc.updated {
+				return
+			}
+			files, _ := ioutil.ReadDir(dir)
+			equals(t, 2, len(files))
+		})
+	}
+}
+
+func TestUpdaterWindowsExe(t *testing.T) {
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(b)
+		gw.Close()
+		return buf.Bytes()
+	}
+	bin, helper := []byte("new binary"), []byte("new helper")
+	sum, helperSum := sha256.Sum256(bin), sha256.Sum256(helper)
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none",
+		"Files": []ReleaseFile{{Name: "myapp-helper.exe", Sha256: helperSum[:]}},
+	})
+	var urls []string
+	mr := &mockRequester{}
+	for _, body := range [][]byte{manifest, gzipped(bin), gzipped(helper)} {
+		body := body
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				urls = append(urls, url)
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			})
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "myapp.exe"), []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Platform = StaticPlatform("windows-amd64")
+	// resolvers without the suffix still update the .exe files
+	updater.Target = FileResolver(filepath.Join(dir, "myapp"))
+	updater.Targets = []UpdatableResolver{FileResolver(filepath.Join(dir, "myapp-helper"))}
+
+	if err := updater.Update(); err != nil {
+		t.Fatal(err)
+	}
+	equals(t, "http://updates.yourdownmain.com/myapp/1.3/windows-amd64-myapp-helper.exe.gz", urls[2])
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "myapp.exe"))
+	equals(t, "new binary", string(b))
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "myapp-helper.exe"))
+	equals(t, "new helper", string(b))
+	if _, err := os.Stat(filepath.Join(dir, "myapp")); !os.IsNotExist(err) {
+		t.Errorf("installed without the .exe suffix")
+	}
+}
+
+func TestUpdaterAssets(t *testing.T) {
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(b)
+		gw.Close()
+		return buf.Bytes()
+	}
+	bin, data := []byte("new binary"), []byte("plugin data")
+	sum, dataSum := sha256.Sum256(bin), sha256.Sum256(data)
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none",
+		"Files": []ReleaseFile{{Name: "data.bin", Sha256: dataSum[:], Mode: 0640}},
+	})
+	mr := &mockRequester{}
+	for _, body := range [][]byte{manifest, gzipped(bin), gzipped(data)} {
+		body := body
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			})
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the plugin directory and file don't exist yet
+	asset := filepath.Join(dir, "plugins", "data.bin")
+	updater := createUpdater(mr)
+	updater.Platform = StaticPlatform("linux-amd64")
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.Targets = []UpdatableResolver{FileResolver(asset)}
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := ioutil.ReadFile(asset)
+	equals(t, "plugin data", string(b))
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(asset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		equals(t, os.FileMode(0640), fi.Mode().Perm())
+	}
+}
+
+func TestUpdaterPatchChain(t *testing.T) {
+	bin, mid := []byte("new binary"), []byte("binary 1.2.5")
+	sum, midSum := sha256.Sum256(bin), sha256.Sum256(mid)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	for _, tc := range []struct {
+		name      string
+		midHash   []byte
+		patchHash []byte
+		third     []byte // the second patch, or the full binary once the chain broke
+	}{
+		{"verified", midSum[:], midSum[:], bin},
+		{"intermediate mismatch", sum[:], nil, gz.Bytes()},
+		{"corrupt patch", midSum[:], sum[:], gz.Bytes()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			manifest, _ := json.Marshal(map[string]interface{}{
+				"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "replace",
+				"Patches": map[string]PatchInfo{"1.2.5": {Size: 10}},
+				"PatchChain": []PatchHop{
+					{From: "1.2", To: "1.2.5", Size: 12, Sha256: tc.midHash, PatchSha256: tc.patchHash},
+					{From: "1.2.5", To: "1.3", Size: 10, Sha256: sum[:]},
+				},
+			})
+			var urls []string
+			mr := &mockRequester{}
+			for _, body := range [][]byte{manifest, mid, tc.third} {
+				body := body
+				mr.handleRequest(
+					func(url string) (io.ReadCloser, error) {
+						urls = append(urls, url)
+						return ioutil.NopCloser(bytes.NewReader(body)), nil
+					})
+			}
+			dir, err := ioutil.TempDir("", "selfupdate-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			target := filepath.Join(dir, "myapp")
+			if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			updater := createUpdater(mr)
+			updater.Platform = StaticPlatform("linux-amd64")
+			updater.Target = mockUpdatableResolver{path: target}
+			// the patch is the new executable
+			updater.Patchers = map[string]Patcher{
+				"replace": PatcherFunc(func(old io.Reader, new io.Writer, patch io.Reader) error {
+					_, err := io.Copy(new, patch)
+					return err
+				}),
+			}
+
+			if err := updater.Update(); err != nil {
+				t.Fatalf("Error occurred: %#v", err)
+			}
+			equals(t, 3, mr.currentIndex)
+			equals(t, "http://updates.yourdomain.com/myapp/1.2/1.2.5/linux-amd64", urls[1])
+			b, _ := ioutil.ReadFile(target)
+			equals(t, "new binary", string(b))
+		})
+	}
+}
+
+func TestUpdaterPatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		direct int64 // size of the patch from 1.2 straight to 1.3
+		full   int64
+		want   []string
+		err    error
+	}{
+		{"direct", 25, 1000, []string{"1.2"}, nil},
+		{"chain", 500, 1000, []string{"1.2", "1.2.5"}, nil},
+		{"full binary", 500, 25, nil, errFullBinarySmaller},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := createUpdater(&mockRequester{})
+			u.Info.Version, u.Info.Size = "1.3", tc.full
+			u.Info.Patches = map[string]PatchInfo{"1.2": {Size: tc.direct}, "1.2.5": {Size: 10}}
+			u.Info.PatchChain = []PatchHop{
+				{From: "1.2", To: "1.2.5", Size: 20},
+				{From: "1.2.5", To: "1.3", Size: 10},
+			}
+			hops, err := u.patchPath()
+			equals(t, tc.err, err)
+			var from []string
+			for _, hop := range hops {
+				from = append(from, hop.From)
+			}
+			equals(t, strings.Join(tc.want, " "), strings.Join(from, " "))
+		})
+	}
+}
+
+func TestVariantPlatform(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(l string) { muslLoaders = l }(muslLoaders)
+	muslLoaders = filepath.Join(dir, "ld-musl-*.so.1")
+
+	equals(t, plat, VariantPlatform{Libc: true}.Platform())
+	ioutil.WriteFile(filepath.Join(dir, "ld-musl-x86_64.so.1"), nil, 0755)
+	if runtime.GOOS == "linux" {
+		equals(t, plat+"-musl", VariantPlatform{Libc: true}.Platform())
+	}
+	equals(t, plat, VariantPlatform{}.Platform())
+
+	equals(t, "linux_armv7", goreleaserPlatform("linux-arm-7"))
+	equals(t, "linux_amd64_musl", goreleaserPlatform("linux-amd64-musl"))
+	equals(t, "darwin_arm64", goreleaserPlatform("darwin-arm64"))
+}
+
+func TestUpdaterStart(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte("new binary"))
+	gw.Close()
+	sum := sha256.Sum256([]byte("new binary"))
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	for _, body := range [][]byte{manifest, buf.Bytes()} {
+		body := body
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			})
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	updater := createUpdater(mr)
+	updater.Dir = "checker-test/"
+	defer os.RemoveAll(updater.getExecRelativeDir(updater.Dir))
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.Schedule = AlwaysCheckForUpdatesSchedule{}
+	// one check only, the next would be an hour later
+	updater.CheckInterval = time.Hour
+	available := make(chan string, 1)
+	applied := make(chan UpdateResult, 1)
+	updater.OnUpdateAvailable = func(version string) { available <- version }
+	updater.OnUpdateApplied = func(result UpdateResult) { applied <- result }
+
+	if err := updater.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	equals(t, ErrCheckerRunning, updater.Start(context.Background()))
+	select {
+	case result := <-applied:
+		equals(t, "1.3", result.To)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update applied")
+	}
+	equals(t, "1.3", <-available)
+	updater.Stop()
+	updater.Stop()
+
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+	equals(t, 2, mr.currentIndex)
+	// stopped checkers can be started again
+	if err := updater.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	updater.Stop()
+}
+
+// waitingGate is an IdleGate telling when an update starts waiting for it.
+type waitingGate struct {
+	*IdleGate
+	waiting chan struct{}
+}
+
+func (g waitingGate) WaitIdle(ctx context.Context) error {
+	close(g.waiting)
+	return g.IdleGate.WaitIdle(ctx)
+}
+
+func TestUpdaterStopWhileWaitingForGate(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte("new binary"))
+	gw.Close()
+	sum := sha256.Sum256([]byte("new binary"))
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	for _, body := range [][]byte{manifest, buf.Bytes()} {
+		body := body
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			})
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// work that never finishes keeps the update waiting
+	gate := waitingGate{&IdleGate{}, make(chan struct{})}
+	gate.Begin()
+	defer gate.End()
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.Schedule = AlwaysCheckForUpdatesSchedule{}
+	updater.CheckInterval = time.Hour
+	updater.ApplyGate = gate
+	if err := updater.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gate.waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update never waited for the gate")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		updater.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on the ApplyGate")
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+}
+
+func TestUpdaterUntilCheck(t *testing.T) {
+	updater := createUpdater(&mockRequester{})
+	updater.Schedule = AlwaysCheckForUpdatesSchedule{}
+	updater.CheckInterval = time.Hour
+	equals(t, time.Duration(0), updater.untilCheck(time.Time{}))
+	if wait := updater.untilCheck(time.Now()); wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("checked again after %s", wait)
+	}
+	updater.CheckJitter = time.Minute
+	if wait := updater.untilCheck(time.Time{}); wait > time.Minute {
+		t.Errorf("jitter of %s", wait)
+	}
+}
+
+func TestStateStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, store := range map[string]StateStore{
+		"dir":    DirStore(filepath.Join(dir, "state")),
+		"memory": &MemoryStore{},
+	} {
+		if _, err := store.Read("cktime"); !os.IsNotExist(err) {
+			t.Errorf("%s: read of a missing file: %v", name, err)
+		}
+		if err := store.Write("cktime", []byte("a")); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, err := store.Read("cktime")
+		equals(t, nil, err)
+		equals(t, "a", string(b))
+		equals(t, nil, store.Remove("cktime"))
+		equals(t, nil, store.Remove("cktime"))
+		if _, err := store.Read("cktime"); !os.IsNotExist(err) {
+			t.Errorf("%s: read of a removed file: %v", name, err)
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+		os.Setenv("XDG_CACHE_HOME", dir)
+		store, err := CacheDirStore("myapp")
+		equals(t, nil, err)
+		equals(t, DirStore(filepath.Join(dir, "myapp")), store)
+	}
+}
+
+func TestUpdaterStateStore(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.2",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.Dir = "state-store-test/"
+	updater.CheckTime = 24
+	updater.CircuitThreshold = 3
+	store := &MemoryStore{}
+	updater.State = store
+
+	if err := updater.BackgroundRun(); err != nil {
+		t.Fatal(err)
+	}
+	equals(t, 1, mr.currentIndex)
+	if _, err := os.Stat(updater.getExecRelativeDir(updater.Dir)); !os.IsNotExist(err) {
+		t.Errorf("state written next to the executable")
+	}
+	if _, err := store.Read("cktime"); err != nil {
+		t.Errorf("schedule not kept in the store: %v", err)
+	}
+	if _, ok := updater.LastCheck(); !ok {
+		t.Errorf("last check not kept in the store")
+	}
+	// the next check is a day away
+	if updater.WantUpdate() {
+		t.Errorf("check due again")
+	}
+}
+
+func TestUpdaterStateNextToTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	updater := createUpdater(&mockRequester{})
+	updater.Dir = "state/"
+	updater.Target = mockUpdatableResolver{path: filepath.Join(dir, "myapp")}
+
+	equals(t, filepath.Join(dir, "state"), updater.getExecRelativeDir(updater.Dir))
+	if !updater.SetUpdateTime() {
+		t.Fatal("schedule not saved")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state", "cktime")); err != nil {
+		t.Errorf("schedule not kept next to the target: %v", err)
+	}
+}
+
+func TestUpdaterDefaultStateMigrates(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the cache directory is set through XDG_CACHE_HOME")
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", dir)
+
+	updater := createUpdater(&mockRequester{})
+	updater.CmdName = "migrate-test"
+	updater.Dir = ""
+	// a schedule left next to the executable by an earlier version
+	legacy := updater.getExecRelativeDir(upcktimePath)
+	next := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := ioutil.WriteFile(legacy, []byte(next.Format(time.RFC3339)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(legacy)
+
+	equals(t, DirStore(filepath.Join(dir, "migrate-test", "selfupdate")), updater.state())
+	equals(t, next, updater.NextUpdate().UTC())
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("state left next to the executable")
+	}
+
+	// Dir keeps the state next to the executable
+	updater.Dir = "update/"
+	equals(t, DirStore(updater.getExecRelativeDir("update/")), updater.state())
+}
+
+func TestUpdaterLocksUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	// another instance is updating
+	unlock, err := lockUpdate(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockUpdate(target); err != ErrUpdateInProgress {
+		unlock()
+		t.Skipf("no advisory locks on %s: %v", runtime.GOOS, err)
+	}
+	equals(t, ErrUpdateInProgress, updater.Update())
+	equals(t, 1, mr.currentIndex)
+	equals(t, ErrUpdateInProgress, updater.Rollback())
+
+	// nor is its install in progress taken for an interrupted one
+	if err := os.Rename(target, oldExecutablePath(target)); err != nil {
+		t.Fatal(err)
+	}
+	updater.recoverInterrupted()
+	_, err = os.Stat(target)
+	equals(t, true, os.IsNotExist(err))
+
+	unlock()
+	updater.recoverInterrupted()
+	got, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(got))
+	relock, err := lockUpdate(target)
+	equals(t, nil, err)
+	relock()
+}
+
+func TestUpdaterRecoversInterruptedInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+
+	// the process died between moving the executable away and moving the
+	// new one in
+	if err := ioutil.WriteFile(oldExecutablePath(target), []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(&mockRequester{})
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.recoverInstalls()
+	got, err := ioutil.ReadFile(target)
+	equals(t, nil, err)
+	equals(t, "old", string(got))
+	_, err = os.Stat(oldExecutablePath(target))
+	equals(t, true, os.IsNotExist(err))
+
+	// a finished install is left alone
+	sum := sha256.Sum256([]byte("new"))
+	newPath, err := stageUpdate(target, func(p string) error { return verifyFile(p, sum[:]) }, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	equals(t, nil, err)
+	err, errRecover := install(newPath, target)
+	equals(t, nil, err)
+	equals(t, nil, errRecover)
+	updater.recoverInstalls()
+	got, _ = ioutil.ReadFile(target)
+	equals(t, "new", string(got))
+	got, _ = ioutil.ReadFile(oldExecutablePath(target))
+	equals(t, "old", string(got))
+
+	// a file that doesn't match once written isn't staged
+	other := sha256.Sum256([]byte("other"))
+	_, err = stageUpdate(target, func(p string) error { return verifyFile(p, other[:]) }, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	equals(t, ErrHashMismatch, err)
+	_, err = os.Stat(newPath)
+	equals(t, true, os.IsNotExist(err))
+}
+
+func TestUpdaterKeepsFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions on windows")
+	}
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	// the file is ours, so the setgid bit survives
+	if err := os.Chmod(target, 0710|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+	fi, err := os.Stat(target)
+	equals(t, nil, err)
+	equals(t, 0710|os.ModeSetgid, fi.Mode())
+}
+
+func TestUpdaterDefersBusyInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("simulates a busy file with ETXTBSY")
+	}
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	// a virus scanner holds the executable open for longer than the retries
+	attempts := 0
+	defer func(rename func(string, string) error, delays []time.Duration) {
+		renameFile = rename
+		busyRetryDelays = delays
+	}(renameFile, busyRetryDelays)
+	busyRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	renameFile = func(from, to string) error {
+		if to == target {
+			attempts++
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.ETXTBSY}
+		}
+		return os.Rename(from, to)
+	}
+	equals(t, ErrUpdateDeferred, updater.Update())
+	equals(t, 3, attempts)
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	version, ok := updater.StagedVersion()
+	equals(t, true, ok)
+	equals(t, "1.3", version)
+
+	// the next start installs it before checking for updates
+	renameFile = os.Rename
+	result, err := updater.BackgroundRunWithResult(context.Background())
+	equals(t, nil, err)
+	equals(t, UpdateResult{Updated: true, From: "1.2", To: "1.3"}, result)
+	equals(t, 3, mr.currentIndex)
+	b, _ = ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+	_, ok = updater.StagedVersion()
+	equals(t, false, ok)
+}
+
+func TestUpdaterCleanupArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	for _, name := range []string{"myapp", ".myapp.new", ".myapp.bad", ".myapp.staged", ".myapp.old"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updater := createUpdater(&mockRequester{})
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.State = &MemoryStore{}
+	updater.saveRollback(rollbackState{Previous: "1.1", Installed: "1.2"})
+
+	// the previous executable of the running version is kept for Rollback
+	equals(t, nil, updater.CleanupArtifacts())
+	files, _ := ioutil.ReadDir(dir)
+	equals(t, 2, len(files))
+	equals(t, true, updater.CanRollback())
+
+	// but not once another version runs
+	updater.CurrentVersion = "1.4"
+	equals(t, nil, updater.CleanupArtifacts())
+	files, _ = ioutil.ReadDir(dir)
+	equals(t, 1, len(files))
+	equals(t, "myapp", files[0].Name())
+}
+
+func TestUpdaterHooks(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+			})
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.State = &MemoryStore{}
+
+	var available []string
+	var errs []error
+	errBusy := errors.New("user is busy")
+	updater.OnUpdateAvailable = func(version string) { available = append(available, version) }
+	updater.OnError = func(err error) { errs = append(errs, err) }
+
+	// vetoed
+	updater.OnBeforeUpdate = func(version string) error { return errBusy }
+	equals(t, errBusy, updater.Update())
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	files, _ := ioutil.ReadDir(dir)
+	equals(t, 1, len(files))
+
+	// deferred to the next start
+	updater.OnBeforeUpdate = func(version string) error { return ErrUpdateDeferred }
+	equals(t, ErrUpdateDeferred, updater.Update())
+	b, _ = ioutil.ReadFile(target)
+	equals(t, "old", string(b))
+	version, ok := updater.StagedVersion()
+	equals(t, true, ok)
+	equals(t, "1.3", version)
+
+	equals(t, 2, len(available))
+	equals(t, "1.3", available[0])
+	equals(t, 2, len(errs))
+	equals(t, errBusy, errs[0])
+	equals(t, ErrUpdateDeferred, errs[1])
+}
+
+// recordingMetrics records the calls to its methods.
+type recordingMetrics struct {
+	calls []string
+}
+
+func (m *recordingMetrics) Check(d time.Duration, err error) {
+	m.calls = append(m.calls, fmt.Sprintf("check %v", err))
+}
+
+func (m *recordingMetrics) Download(kind string, bytes int64, d time.Duration, err error) {
+	m.calls = append(m.calls, fmt.Sprintf("download %s %d %v", kind, bytes, err))
+}
+
+func (m *recordingMetrics) Update(from, to string, d time.Duration, err error) {
+	m.calls = append(m.calls, fmt.Sprintf("update %s %s %v", from, to, err))
+}
+
+func TestUpdaterMetrics(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := &recordingMetrics{}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.State = &MemoryStore{}
+	updater.Metrics = m
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	want := []string{
+		"check <nil>",
+		"download patch 0 no patch",
+		fmt.Sprintf("download binary %d <nil>", gz.Len()),
+		"update 1.2 1.3 <nil>",
+	}
+	equals(t, len(want), len(m.calls))
+	for i := range want {
+		if i < len(m.calls) {
+			equals(t, want[i], m.calls[i])
+		}
+	}
+}
+
+type recordingReporter struct {
+	reports []UpdateReport
+}
+
+func (r *recordingReporter) Report(report UpdateReport) error {
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func TestUpdaterReporter(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "DiffAlgorithm": "none"})
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("binary missing")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := &recordingReporter{}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.State = &MemoryStore{}
+	updater.Reporter = r
+
+	if err := updater.Update(); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, 2, len(r.reports))
+	equals(t, "myapp", r.reports[0].Cmd)
+	equals(t, "1.3", r.reports[0].To)
+	equals(t, true, r.reports[0].Err != nil)
+	equals(t, nil, r.reports[1].Err)
+
+	// the HTTP reporter only tells whether the update failed
+	var posted map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&posted)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	equals(t, nil, HTTPReporter{URL: srv.URL}.Report(r.reports[0]))
+	equals(t, 5, len(posted))
+	equals(t, true, posted["Failed"])
+
+	// ReportURL posts with the client and pins of the Updater
+	ts := httptest.NewTLSServer(srv.Config.Handler)
+	defer ts.Close()
+	spki := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	updater.Reporter = nil
+	updater.ReportURL = ts.URL
+	updater.HTTPClient = ts.Client()
+	updater.PinnedCertSHA256 = [][]byte{spki[:]}
+	posted = nil
+	equals(t, nil, updater.reporter().Report(r.reports[1]))
+	equals(t, nil, posted["Failed"])
+	other := sha256.Sum256([]byte("some other key"))
+	updater.PinnedCertSHA256 = [][]byte{other[:]}
+	equals(t, true, errors.Is(updater.reporter().Report(r.reports[1]), ErrCertificatePinMismatch))
+
+	_, err = New(WithCmdName("myapp"), WithCurrentVersion("1.2"), WithURL("https://updates.yourdomain.com/"), func(u *Updater) { u.ReportURL = srv.URL })
+	var configErr *ConfigError
+	equals(t, true, errors.As(err, &configErr) && configErr.Field == "ReportURL" && errors.Is(err, ErrInsecureHTTP))
+}
+
+func TestUpdaterRollout(t *testing.T) {
+	in := 0
+	for i := 0; i < 400; i++ {
+		updater := createUpdater(&mockRequester{})
+		updater.State = &MemoryStore{}
+		updater.Info.Version = "1.3"
+		updater.Info.RolloutPercent = 25
+		first := updater.inRollout()
+		// the same answer every time, and still in as the rollout grows
+		equals(t, first, updater.inRollout())
+		updater.Info.RolloutPercent = 60
+		if first && !updater.inRollout() {
+			t.Fatal("left the cohort as the rollout grew")
+		}
+		if first {
+			in++
+		}
+		updater.Info.RolloutPercent = 0
+		equals(t, true, updater.inRollout())
+		updater.Info.RolloutPercent = 100
+		equals(t, true, updater.inRollout())
+	}
+	if in < 50 || in > 150 {
+		t.Errorf("%d of 400 installations in a 25%% rollout", in)
+	}
+
+	// installations outside the rollout see no update
+	manifest := `{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=", "RolloutPercent": 1}`
+	seen := 0
+	for i := 0; i < 20; i++ {
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
+		updater := createUpdater(mr)
+		updater.State = &MemoryStore{}
+		version, err := updater.UpdateAvailable()
+		equals(t, nil, err)
+		if version != "" {
+			seen++
+		}
+	}
+	if seen > 5 {
+		t.Errorf("%d of 20 installations saw a 1%% rollout", seen)
+	}
+}
+
+func TestUpdaterHealthCheck(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+
+	for _, healthy := range []bool{true, false} {
+		if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return nil, errors.New("no patch")
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+			})
+		updater := createUpdater(mr)
+		updater.State = &MemoryStore{}
+		updater.Target = mockUpdatableResolver{path: target}
+		var checked string
+		updater.HealthCheck = func(path string) error {
+			b, _ := ioutil.ReadFile(path)
+			checked = string(b)
+			if !healthy {
+				return errors.New("crashed on start")
+			}
+			return nil
+		}
+		successful := false
+		updater.OnSuccessfulUpdate = func() { successful = true }
+
+		result, err := updater.UpdateWithResult(context.Background())
+		equals(t, "new binary", checked)
+		b, _ := ioutil.ReadFile(target)
+		if healthy {
+			equals(t, nil, err)
+			equals(t, true, result.Updated)
+			equals(t, "new binary", string(b))
+			equals(t, true, successful)
+			continue
+		}
+		if !errors.Is(err, ErrRolledBack) || !strings.Contains(err.Error(), "crashed on start") {
+			t.Errorf("expected rollback, got %v", err)
+		}
+		equals(t, false, result.Updated)
+		equals(t, "old", string(b))
+		equals(t, false, successful)
+		equals(t, true, updater.readRollback().RolledBack)
+	}
+}
+
+func TestUpdaterRefusesDowngradeWithoutLedger(t *testing.T) {
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(`{
+    "Version": "1.1",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+			})
+	}
+	// a fresh install, or one whose state was wiped, runs 1.2
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+
+	_, err := updater.UpdateAvailable()
+	equals(t, true, errors.Is(err, ErrVersionRollback))
+
+	updater.AllowDowngrade = true
+	version, err := updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.1", version)
+}
+
+func TestUpdaterFixAfterRolledBackRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	updater := createUpdater(nil)
+	updater.State = &MemoryStore{}
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.HealthCheck = func(path string) error {
+		if b, _ := ioutil.ReadFile(path); string(b) == "bad 1.3" {
+			return errors.New("crashed on start")
+		}
+		return nil
+	}
+	for _, release := range []struct{ version, bin string }{{"1.3", "bad 1.3"}, {"1.2.1", "fixed 1.2.1"}} {
+		sum := sha256.Sum256([]byte(release.bin))
+		var gz bytes.Buffer
+		gw := gzip.NewWriter(&gz)
+		gw.Write([]byte(release.bin))
+		gw.Close()
+		manifest, _ := json.Marshal(map[string]interface{}{"Version": release.version, "Sha256": sum[:]})
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return nil, errors.New("no patch")
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+			})
+		updater.Requester = mr
+		_, err = updater.UpdateWithResult(context.Background())
+		if release.version == "1.3" {
+			equals(t, true, errors.Is(err, ErrRolledBack))
+			equals(t, "", updater.highestInstalled())
+		}
+	}
+
+	// the fix below the rolled back release isn't refused as a downgrade
+	equals(t, nil, err)
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "fixed 1.2.1", string(b))
+	equals(t, "1.2.1", updater.highestInstalled())
+}
+
+func TestCheckNewExecutable(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	equals(t, nil, checkExecutable(self, plat))
+	other := "linux-s390x"
+	if runtime.GOARCH == "s390x" {
+		other = "linux-amd64"
+	}
+	equals(t, ErrNotExecutable, checkExecutable(self, other))
+	equals(t, ErrNotExecutable, checkExecutable(self, "windows-amd64"))
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n[ \"$1\" = --version ] || { echo broken; exit 3; }\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// not checked unless asked for
+	updater := createUpdater(&mockRequester{})
+	equals(t, nil, updater.checkNewExecutable(script))
+	updater.VerifyExecutable = true
+	equals(t, ErrNotExecutable, updater.checkNewExecutable(script))
+
+	updater.VerifyExecutable = false
+	updater.ProbeArgs = []string{"--version"}
+	equals(t, nil, updater.checkNewExecutable(script))
+	updater.ProbeArgs = []string{"--help"}
+	err = updater.checkNewExecutable(script)
+	if !errors.Is(err, ErrProbeFailed) || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the probe to fail, got %v", err)
+	}
+}
+
+func TestUpdaterChecksSpace(t *testing.T) {
+	defer func(f func(string) (uint64, error)) { freeSpace = f }(freeSpace)
+	var free uint64
+	freeSpace = func(dir string) (uint64, error) { return free, nil }
+
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, make([]byte, 1000), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=", "Size": 2000}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Target = mockUpdatableResolver{path: target}
+	free = 2000
+	_, err = updater.UpdateWithResult(context.Background())
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected insufficient space, got %v", err)
+	}
+	// nothing was downloaded
+	equals(t, 1, mr.currentIndex)
+
+	free = 2500
+	equals(t, nil, updater.checkSpace(target))
+}
+
+func TestThrottledReader(t *testing.T) {
+	tr := newThrottledReader(bytes.NewReader(make([]byte, 1000)), 100)
+	var slept time.Duration
+	tr.now = func() time.Time { return tr.start.Add(slept) }
+	tr.sleep = func(d time.Duration) { slept += d }
+	buf := make([]byte, 64)
+	for {
+		n, err := tr.Read(buf)
+		if n > 10 {
+			t.Fatalf("read %d bytes at once at 100 bytes per second", n)
+		}
+		if err == io.EOF {
+			break
+		}
+		equals(t, nil, err)
+	}
+	if slept < 9*time.Second || slept > 10*time.Second {
+		t.Errorf("reading 1000 bytes at 100 bytes per second waited %s", slept)
+	}
+}
+
+func TestUpdaterTargeting(t *testing.T) {
+	for _, c := range []struct {
+		version, constraint string
+		want                bool
+	}{
+		{"1.4.0", "", true},
+		{"1.4.0", ">=1.4.0", true},
+		{"1.3.9", ">=1.4.0", false},
+		{"1.5", ">=1.4.0, <2", true},
+		{"2.0", ">=1.4.0, <2", false},
+		{"1.2", "1.2", true},
+		{"1.2", "!=1.2", false},
+	} {
+		got, err := satisfies(c.version, c.constraint)
+		equals(t, nil, err)
+		if got != c.want {
+			t.Errorf("satisfies(%q, %q) = %v, want %v", c.version, c.constraint, got, c.want)
+		}
+	}
+	if _, err := satisfies("1.2", "~>1.2"); err == nil {
+		t.Error("unknown operator accepted")
+	}
+
+	manifest := `{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=", "Severity": "critical", %s}`
+	for _, c := range []struct {
+		rules, platform string
+		want            string
+	}{
+		{`"UpdateFrom": ">=1.0"`, "linux-amd64", "1.3"},
+		{`"UpdateFrom": ">=1.2.1"`, "linux-amd64", ""},
+		{`"UpdateFrom": "=>1.0"`, "linux-amd64", ""},
+		{`"SkipPlatforms": ["darwin-arm64"]`, "linux-amd64", "1.3"},
+		{`"SkipPlatforms": ["darwin-arm64"]`, "darwin-arm64", ""},
+		{`"SkipPlatforms": ["linux-arm"]`, "linux-arm-7", ""},
+		{`"Paused": true`, "linux-amd64", ""},
+	} {
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(fmt.Sprintf(manifest, c.rules)), nil
+			})
+		updater := createUpdater(mr)
+		updater.State = &MemoryStore{}
+		updater.Platform = StaticPlatform(c.platform)
+		updater.ForceCriticalUpdates = true
+		version, err := updater.UpdateAvailable()
+		equals(t, nil, err)
+		if version != c.want {
+			t.Errorf("%s on %s: got version %q, want %q", c.rules, c.platform, version, c.want)
+		}
+		// a fenced off release isn't forced on anyone either
+		equals(t, c.want != "", updater.Mandatory())
+	}
+}
+
+func TestUpdaterRetryPolicy(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, &HTTPStatusError{URL: url, StatusCode: 502, Status: "502 Bad Gateway"}
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "2023-07-09-66c6c12",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, &HTTPStatusError{URL: url, StatusCode: 404, Status: "404 Not Found"}
+		})
+	updater := createUpdater(mr)
+	updater.Retry = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "2023-07-09-66c6c12", version)
+	equals(t, 2, mr.currentIndex)
+
+	// not found is not retried
+	if _, err := updater.UpdateAvailable(); err == nil {
+		t.Error("expected an error")
+	}
+	equals(t, 3, mr.currentIndex)
+}
+
+type recordingLogger struct {
+	entries []string
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) {
+	l.entries = append(l.entries, "debug: "+msg)
+}
+func (l *recordingLogger) Info(msg string, keyvals ...interface{}) {
+	l.entries = append(l.entries, "info: "+msg)
+}
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{}) {
+	l.entries = append(l.entries, "warn: "+msg)
+}
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) {
+	l.entries = append(l.entries, "error: "+msg)
+}
+
+func TestUpdaterLogger(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no binary")
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordingLogger{}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.Logger = logger
+
+	if err := updater.Update(); err == nil {
+		t.Fatal("expected an error")
+	}
+	equals(t, 3, len(logger.entries))
+	equals(t, "debug: fetched manifest", logger.entries[0])
+	equals(t, "warn: patching binary failed", logger.entries[1])
+	equals(t, "error: fetching full binary failed", logger.entries[2])
+}
+
+func TestUpdaterManifestV2(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bin)
+	w.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"ManifestVersion": 2,
+		"Version":         "1.3",
+		"Sha256":          sum[:],
+		"DiffAlgorithm":   "none",
+		"ReleaseNotes":    "Fixes everything",
+		"MinimumVersion":  "1.0",
+		"Downloads":       map[string]interface{}{"Binary": "https://cdn.example.com/myapp-1.3.gz"},
+	})
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "https://cdn.example.com/myapp-1.3.gz", url)
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"ManifestVersion": 3, "Version": "2.0", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := createUpdater(mr)
+	updater.Target = mockUpdatableResolver{path: target}
+
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "Fixes everything", updater.Info.ReleaseNotes)
+	equals(t, "1.0", updater.Info.MinimumVersion)
+	b, _ := ioutil.ReadFile(target)
+	equals(t, "new binary", string(b))
+
+	if _, err := updater.UpdateAvailable(); err == nil {
+		t.Error("expected an error for an unsupported manifest version")
+	}
+}
+
+func TestUpdaterReleaseNotes(t *testing.T) {
+	manifest := func(url string) (io.ReadCloser, error) {
+		return newTestReaderCloser(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="
+}`), nil
+	}
+	mr := &mockRequester{}
+	mr.handleRequest(manifest)
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/CHANGELOG.md", url)
+			return newTestReaderCloser("Fixes everything"), nil
+		})
+	mr.handleRequest(manifest)
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, &HTTPStatusError{URL: url, StatusCode: 404, Status: "404 Not Found"}
+		})
+	updater := createUpdater(mr)
+
+	notes, err := updater.ReleaseNotes()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "Fixes everything", notes)
+
+	notes, err = updater.ReleaseNotes()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "", notes)
+}
+
+func TestCodeSignatureError(t *testing.T) {
+	var err error = &CodeSignatureError{Path: "/tmp/.myapp.new", Detail: "team A, running executable's team B", Err: ErrSignerMismatch}
+	equals(t, true, errors.Is(err, ErrSignerMismatch))
+	equals(t, false, errors.Is(err, ErrBinaryNotSigned))
+	equals(t, "/tmp/.myapp.new: new executable is signed by a different signer: team A, running executable's team B", err.Error())
+}
+
+func TestSameSignerCert(t *testing.T) {
+	serial := int64(0)
+	issue := func(subject string, parent *x509.Certificate, parentKey ed25519.PrivateKey) (*x509.Certificate, ed25519.PrivateKey) {
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial++
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: subject},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  parent == nil,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	ca, caKey := issue("Code Signing CA", nil, nil)
+	otherCA, otherCAKey := issue("Other CA", nil, nil)
+	signer, _ := issue("Acme Corp", ca, caKey)
+	renewed, _ := issue("Acme Corp", ca, caKey)
+	impostor, _ := issue("Acme Corp", otherCA, otherCAKey)
+	other, _ := issue("Other Corp", ca, caKey)
+
+	equals(t, true, sameSignerCert(signer, signer))
+	equals(t, true, sameSignerCert(signer, renewed))
+	equals(t, false, sameSignerCert(signer, impostor))
+	equals(t, false, sameSignerCert(signer, other))
+}
+
+func TestCanUpdatePermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 || runtime.GOOS == "windows" {
+		t.Skip("directory permissions are not enforced")
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+
+	equals(t, ErrPermissionDenied, canUpdate(target))
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux":
+	default:
+		t.Skip("read-only file systems are not detected")
+	}
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	equals(t, false, readOnlyFS(dir, nil))
+	equals(t, true, readOnlyFS(dir, &os.PathError{Op: "open", Path: filepath.Join(dir, ".myapp.new"), Err: syscall.EROFS}))
+}
+
+func TestUpdaterRequestHeadersAndAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("X-Api-Key") != "key" {
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`))
+	}))
+	defer ts.Close()
+
+	updater := &Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         ts.URL + "/",
+		CmdName:        "myapp",
+		Dir:            "update/",
+		Platform:       StaticPlatform("linux-amd64"),
+	}
+	_, err := updater.UpdateAvailable()
+	if err, ok := err.(*HTTPStatusError); !ok || err.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized, got %#v", err)
+	}
+
+	updater.RequestHeaders = map[string]string{"X-Api-Key": "key"}
+	updater.Auth = BearerToken("s3cret")
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", version)
+}
+
+func TestUpdaterConditionalManifest(t *testing.T) {
+	manifest := `{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+	var full, notModified int
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(manifest)))
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		rw.Header().Set("ETag", etag)
+		rw.Write([]byte(manifest))
+	}))
+	defer ts.Close()
+
+	updater := &Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         ts.URL + "/",
+		CmdName:        "myapp",
+		State:          &MemoryStore{},
+		Platform:       StaticPlatform("linux-amd64"),
+	}
+	for i := 0; i < 3; i++ {
+		version, err := updater.UpdateAvailable()
+		equals(t, nil, err)
+		equals(t, "1.3", version)
+	}
+	equals(t, 1, full)
+	equals(t, 2, notModified)
+
+	// a changed manifest is fetched in full and cached again
+	manifest = `{"Version": "1.4", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+	for i := 0; i < 2; i++ {
+		version, err := updater.UpdateAvailable()
+		equals(t, nil, err)
+		equals(t, "1.4", version)
+	}
+	equals(t, 2, full)
+	equals(t, 3, notModified)
+}
+
+func TestHTTPRequesterContentEncoding(t *testing.T) {
+	const body = `{"Version": "1.3"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		equals(t, "gzip, deflate", r.Header.Get("Accept-Encoding"))
+		var w io.WriteCloser
+		switch r.URL.Path {
+		case "/gzip":
+			w = gzip.NewWriter(rw)
+		case "/deflate":
+			w = zlib.NewWriter(rw)
+		default:
+			rw.Write([]byte(body))
+			return
+		}
+		rw.Header().Set("Content-Encoding", r.URL.Path[1:])
+		w.Write([]byte(body))
+		w.Close()
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/gzip", "/deflate", "/identity"} {
+		r, err := (&HTTPRequester{}).Fetch(ts.URL + path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		equals(t, nil, err)
+		equals(t, body, string(got))
+	}
+}
+
+func TestUpdaterMirrors(t *testing.T) {
+	var requested []string
+	status := map[string]int{"updates.example.com": 503}
+	updater := &Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         "https://updates.example.com/api/",
+		CmdName:        "myapp",
+		State:          &MemoryStore{},
+		Platform:       StaticPlatform("linux-amd64"),
+		Mirrors:        []string{"https://mirror1.example.com", "https://mirror2.example.com"},
+		Requester: RequesterFunc(func(rawurl string) (io.ReadCloser, error) {
+			requested = append(requested, rawurl)
+			u, _ := url.Parse(rawurl)
+			if code := status[u.Host]; code != 0 {
+				return nil, &HTTPStatusError{URL: rawurl, StatusCode: code, Status: http.StatusText(code)}
+			}
+			return newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		}),
+	}
+	version, err := updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+	equals(t, 2, len(requested))
+	equals(t, "https://mirror1.example.com/api/myapp/linux-amd64.json", requested[1])
+
+	// a missing file isn't looked for elsewhere
+	requested = nil
+	status = map[string]int{"updates.example.com": 404}
+	_, err = updater.UpdateAvailable()
+	if err, ok := err.(*HTTPStatusError); !ok || err.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found, got %#v", err)
+	}
+	equals(t, 1, len(requested))
+
+	// the fastest host is tried first, and the one that failed last
+	updater.FastestMirror = true
+	status = map[string]int{"updates.example.com": 503}
+	requested = nil
+	_, err = updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, 2, len(requested))
+	requested = nil
+	_, err = updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, 1, len(requested))
+	if strings.Contains(requested[0], "updates.example.com") {
+		t.Errorf("failed host tried first: %s", requested[0])
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestUpdaterHTTPClient(t *testing.T) {
+	var requested []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`),
+			Request:    req,
+		}, nil
+	})}
+	updater := &Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         "http://updates.internal/",
+		CmdName:        "myapp",
+		Dir:            "update/",
+		Platform:       StaticPlatform("linux-amd64"),
+		HTTPClient:     client,
+	}
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", version)
+	equals(t, 1, len(requested))
+	equals(t, "http://updates.internal/myapp/linux-amd64.json", requested[0])
+}
+
+func TestUpdaterPinnedCertSHA256(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`))
+	}))
+	defer ts.Close()
+	spki := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+
+	updater := &Updater{
+		CurrentVersion:   "1.2",
+		ApiURL:           ts.URL + "/",
+		CmdName:          "myapp",
+		Dir:              "update/",
+		Platform:         StaticPlatform("linux-amd64"),
+		HTTPClient:       ts.Client(),
+		PinnedCertSHA256: [][]byte{spki[:]},
+	}
+	version, err := updater.UpdateAvailable()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "1.3", version)
+
+	other := sha256.Sum256([]byte("some other key"))
+	updater.PinnedCertSHA256 = [][]byte{other[:]}
+	if _, err := updater.UpdateAvailable(); !errors.Is(err, ErrCertificatePinMismatch) {
+		t.Errorf("expected pin mismatch, got %v", err)
+	}
+
+	updater.ApiURL = "http://updates.yourdomain.com/"
+	if _, err := updater.UpdateAvailable(); err == nil {
+		t.Error("expected plain HTTP to be refused")
+	}
+}
+
+func createUpdater(mr *mockRequester) *Updater {
+	return &Updater{
+		CurrentVersion: "1.2",
+		ApiURL:         "http://updates.yourdomain.com/",
+		BinURL:         "http://updates.yourdownmain.com/",
+		DiffURL:        "http://updates.yourdomain.com/",
+		Dir:            "update/",
+		CmdName:        "myapp", // app name
+		Requester:      mr,
+	}
+}
+
+func createUpdaterWithEscapedCharacters(mr *mockRequester) *Updater {
+	return &Updater{
+		CurrentVersion: "1.2+foobar",
+		ApiURL:         "http://updates.yourdomain.com/",
+		BinURL:         "http://updates.yourdownmain.com/",
+		DiffURL:        "http://updates.yourdomain.com/",
+		Dir:            "update/",
+		CmdName:        "myapp+foo", // app name
+		Requester:      mr,
+	}
+}
+
+func equals(t *testing.T, expected, actual interface{}) {
+	if expected != actual {
+		t.Logf("Expected: %#v got %#v\n", expected, actual)
+		t.Fail()
+	}
+}
+
+type testReadCloser struct {
+	buffer *bytes.Buffer
+}
+
+func newTestReaderCloser(payload string) io.ReadCloser {
+	return &testReadCloser{buffer: bytes.NewBufferString(payload)}
+}
+
+func (trc *testReadCloser) Read(p []byte) (n int, err error) {
+	return trc.buffer.Read(p)
+}
+
+func (trc *testReadCloser) Close() error {
+	return nil
+}
+
+func TestUpdaterVersionedInstall(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	link := filepath.Join(dir, "myapp")
+	// installed in place before, moved aside by the update
+	if err := ioutil.WriteFile(link, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"1.0", "1.1"} {
+		if err := os.MkdirAll(filepath.Join(dir, "versions", version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Versions = &VersionedInstall{Link: link, Keep: 2}
+
+	result, err := updater.UpdateWithResult(context.Background())
+	equals(t, nil, err)
+	equals(t, true, result.Updated)
+	b, _ := ioutil.ReadFile(link)
+	equals(t, "new binary", string(b))
+	dest, _ := os.Readlink(link)
+	equals(t, filepath.Join("versions", "1.3", "myapp"), dest)
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "versions", "1.2", "myapp"))
+	equals(t, "old", string(b))
+	entries, _ := ioutil.ReadDir(filepath.Join(dir, "versions"))
+	var kept []string
+	for _, e := range entries {
+		kept = append(kept, e.Name())
+	}
+	equals(t, "1.1 1.2 1.3", strings.Join(kept, " "))
+
+	equals(t, true, updater.CanRollback())
+	equals(t, nil, updater.Rollback())
+	b, _ = ioutil.ReadFile(link)
+	equals(t, "old", string(b))
+	equals(t, false, updater.CanRollback())
+	equals(t, ErrNoRollback, updater.Rollback())
+
+	_, err = updater.Versions.path("../1.4")
+	if err == nil {
+		t.Error("expected version with a path separator to be rejected")
+	}
+}
+
+func TestUpdaterPackageManaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// brew links the executable in the Cellar into its bin directory
+	cellar := filepath.Join(dir, "Cellar", "myapp", "1.2", "bin")
+	if err := os.MkdirAll(cellar, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cellar, "myapp"), []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "myapp")
+	if err := os.Symlink(filepath.Join(cellar, "myapp"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Target = mockUpdatableResolver{path: link}
+	_, err = updater.UpdateWithResult(context.Background())
+	var managed *PackageManagedError
+	if !errors.As(err, &managed) {
+		t.Fatalf("expected PackageManagedError, got %v", err)
+	}
+	equals(t, "Homebrew", managed.Manager)
+	equals(t, link, managed.Path)
+
+	updater.AllowPackageManaged = true
+	equals(t, nil, updater.checkPackageManaged(link))
+	updater.AllowPackageManaged = false
+	updater.PackageManagers = []PackageManager{{Name: "Acme", Paths: []string{"/acme/"}}}
+	equals(t, nil, updater.checkPackageManaged(link))
+	equals(t, "Acme", updater.packageManager("/opt/ACME/bin/myapp"))
+	updater.PackageManagers = nil
+	equals(t, "the system package manager", updater.packageManager("/usr/bin/myapp"))
+	equals(t, "", updater.packageManager("/usr/local/bin/myapp"))
+}
+
+func TestUpdaterURLTemplates(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/beta/linux-amd64.json", url)
+			return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/patches/1.2-1.3-linux-amd64", url)
+			return nil, errors.New("no patch")
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/releases/1.3/myapp_linux-amd64.gz", url)
+			return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+		})
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Target = mockUpdatableResolver{path: target}
+	updater.Channel = "beta"
+	updater.URLTemplates = &URLTemplates{
+		Manifest: "{{.Base}}/{{.Cmd}}/{{.Channel}}/{{.Platform}}.json",
+		Binary:   "{{.Base}}/releases/{{.Version}}/{{.Cmd}}_{{.Platform}}.gz",
+		Patch:    "{{.Base}}/{{.Cmd}}/patches/{{.From}}-{{.Version}}-{{.Platform}}",
+	}
+
+	result, err := updater.UpdateWithResult(context.Background())
+	equals(t, nil, err)
+	equals(t, true, result.Updated)
+
+	updater.URLTemplates = &URLTemplates{Manifest: "{{.Base}}/{{.Unknown}}.json"}
+	if err := updater.fetchInfo(context.Background()); err == nil {
+		t.Error("expected template with an unknown field to fail")
+	}
+}
+
+func TestManifestDecoders(t *testing.T) {
+	var info VersionInfo
+	equals(t, nil, PlainTextManifest{}.Decode([]byte("1.3\n"), "linux-amd64", &info))
+	equals(t, "1.3", info.Version)
+	equals(t, 0, len(info.Sha256))
+	info = VersionInfo{}
+	equals(t, nil, PlainTextManifest{}.Decode([]byte("1.3  0a0b\n"), "linux-amd64", &info))
+	equals(t, "0a0b", hex.EncodeToString(info.Sha256))
+	if err := (PlainTextManifest{}).Decode([]byte(""), "linux-amd64", &info); err == nil {
+		t.Error("expected empty version file to fail")
+	}
+
+	feed := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:sparkle="http://www.andymatuschak.org/xml-namespaces/sparkle">
+<channel>
+	<item>
+		<title>1.3</title>
+		<pubDate>Tue, 06 Oct 2026 10:00:00 +0000</pubDate>
+		<sparkle:version>1.3</sparkle:version>
+		<description>Fixes</description>
+		<sparkle:criticalUpdate></sparkle:criticalUpdate>
+		<enclosure url="https://example.com/myapp-1.3.zip" length="1234" type="application/octet-stream"/>
+	</item>
+	<item>
+		<sparkle:version>1.4</sparkle:version>
+		<enclosure url="https://example.com/myapp-1.4.msi" sparkle:os="windows" length="99"/>
+	</item>
+	<item>
+		<sparkle:version>1.2</sparkle:version>
+		<enclosure url="https://example.com/myapp-1.2.zip" length="1000"/>
+	</item>
+</channel>
+</rss>`
+	info = VersionInfo{}
+	equals(t, nil, AppcastManifest{}.Decode([]byte(feed), "darwin-arm64", &info))
+	equals(t, "1.3", info.Version)
+	equals(t, int64(1234), info.Size)
+	equals(t, "https://example.com/myapp-1.3.zip", info.Downloads.Binary)
+	equals(t, ArchiveZip, info.Archive)
+	equals(t, "Fixes", info.ReleaseNotes)
+	equals(t, SeverityCritical, info.Severity)
+	equals(t, 2026, info.Timestamp.Year())
+	info = VersionInfo{}
+	equals(t, nil, AppcastManifest{}.Decode([]byte(feed), "windows-amd64", &info))
+	equals(t, "1.4", info.Version)
+	equals(t, "msi", info.Archive)
+	if err := (AppcastManifest{}).Decode([]byte(feed), "linux-amd64", &info); err == nil {
+		t.Error("expected appcast without linux release to fail")
+	}
+
+	targets := `{"signed": {"_type": "targets", "version": 7, "expires": "2030-01-01T00:00:00Z", "targets": {
+		"myapp/1.2/linux-amd64.gz": {"length": 10, "hashes": {"sha256": "00"}},
+		"myapp/1.3/linux-amd64.zst": {"length": 20, "hashes": {"sha256": "11"}, "custom": {"sha256": "0a0b"}},
+		"myapp/1.4/darwin-arm64.gz": {"length": 30, "hashes": {"sha256": "22"}}
+	}}, "signatures": []}`
+	info = VersionInfo{}
+	equals(t, nil, TUFManifest{}.Decode([]byte(targets), "linux-amd64", &info))
+	equals(t, "1.3", info.Version)
+	equals(t, int64(20), info.Size)
+	equals(t, CompressionZstd, info.Compression)
+	equals(t, "0a0b", hex.EncodeToString(info.Sha256))
+	equals(t, 2030, info.Expires.Year())
+	if err := (TUFManifest{}).Decode([]byte(`{"signed": {"_type": "root"}}`), "linux-amd64", &info); err == nil {
+		t.Error("expected root metadata to be refused")
+	}
+}
+
+func TestUpdaterPlainTextManifest(t *testing.T) {
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser("1.3\n"), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.ManifestDecoder = PlainTextManifest{}
+
+	version, err := updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+	// without a hash the release can't be installed
+	_, err = updater.UpdateWithResult(context.Background())
+	equals(t, ErrNoHash, err)
+}
+
+func TestUpdaterVerifiers(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := &mockRequester{}
+	for i := 0; i < 2; i++ {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return nil, errors.New("no patch")
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(gz.Bytes())), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.Target = mockUpdatableResolver{path: target}
+
+	// a refused executable isn't installed
+	errRefused := errors.New("refused")
+	updater.Verifiers = []Verifier{VerifierFunc(func(artifact io.Reader, manifest VersionInfo) error {
+		return errRefused
+	})}
+	_, err = updater.UpdateWithResult(context.Background())
+	equals(t, errRefused, err)
+	got, _ := ioutil.ReadFile(target)
+	equals(t, "old", string(got))
+	_, err = os.Stat(filepath.Join(dir, ".myapp.new"))
+	equals(t, true, os.IsNotExist(err))
+
+	// each verifier reads the executable from the start
+	var seen []string
+	check := VerifierFunc(func(artifact io.Reader, manifest VersionInfo) error {
+		b, err := ioutil.ReadAll(artifact)
+		seen = append(seen, manifest.Version+" "+string(b))
+		return err
+	})
+	updater.Verifiers = []Verifier{check, check}
+	result, err := updater.UpdateWithResult(context.Background())
+	equals(t, nil, err)
+	equals(t, true, result.Updated)
+	equals(t, 2, len(seen))
+	equals(t, "1.3 new binary", seen[0])
+	equals(t, "1.3 new binary", seen[1])
+
+	equals(t, ErrHashMismatch, SHA256Verifier{}.Verify(strings.NewReader("other"), VersionInfo{Sha256: sum[:]}))
+	equals(t, ErrNoHash, SHA256Verifier{}.Verify(strings.NewReader("other"), VersionInfo{}))
+}
+
+func TestMinisignVerifier(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	id := []byte("keyid123")
+	pub := "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), key.Public().(ed25519.PublicKey)...)) + "\n"
+	bin := []byte("new binary")
+
+	// signify signs the content itself and has no trusted comment
+	sig := ed25519.Sign(key, bin)
+	signify := "untrusted comment: verify with myapp.pub\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), sig...)) + "\n"
+	v := MinisignVerifier{PublicKey: pub}
+	equals(t, nil, v.Verify(bytes.NewReader(bin), VersionInfo{Minisig: signify}))
+	if err := v.Verify(strings.NewReader("other"), VersionInfo{Minisig: signify}); !errors.Is(err, ErrMinisignInvalid) {
+		t.Errorf("expected ErrMinisignInvalid for other content, got %v", err)
+	}
+	equals(t, ErrMinisignInvalid, v.Verify(bytes.NewReader(bin), VersionInfo{}))
+
+	// minisign signs the BLAKE2b-512 hash and the trusted comment
+	hash := blake2b.Sum512(bin)
+	sig = ed25519.Sign(key, hash[:])
+	comment := "timestamp:1700000000\tfile:myapp\thashed"
+	global := ed25519.Sign(key, append(append([]byte(nil), sig...), comment...))
+	minisig := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), id...), sig...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+	equals(t, nil, v.Verify(bytes.NewReader(bin), VersionInfo{Minisig: minisig}))
+	tampered := strings.Replace(minisig, "myapp", "other", 1)
+	if err := v.Verify(bytes.NewReader(bin), VersionInfo{Minisig: tampered}); !errors.Is(err, ErrMinisignInvalid) {
+		t.Errorf("expected ErrMinisignInvalid for a tampered trusted comment, got %v", err)
+	}
+
+	// the key ID has to match
+	other := base64.StdEncoding.EncodeToString(append([]byte("Edotherkey"), key.Public().(ed25519.PublicKey)...))
+	if err := (MinisignVerifier{PublicKey: other}).Verify(bytes.NewReader(bin), VersionInfo{Minisig: minisig}); !errors.Is(err, ErrMinisignInvalid) {
+		t.Errorf("expected ErrMinisignInvalid for another key ID, got %v", err)
+	}
+}
+
+func TestUpdaterGPGManifest(t *testing.T) {
+	signer, err := openpgp.NewEntity("Publisher", "", "publisher@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("Mallory", "", "mallory@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyring bytes.Buffer
+	w, _ := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	signer.Serialize(w)
+	w.Close()
+
+	manifest := []byte(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`)
+	detached := func(e *openpgp.Entity) []byte {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, e, bytes.NewReader(manifest), nil); err != nil {
+			t.Fatal(err)
+		}
+		return sig.Bytes()
+	}
+	var clearsigned bytes.Buffer
+	cw, err := clearsign.Encode(&clearsigned, signer.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw.Write(manifest)
+	cw.Close()
+
+	mr := &mockRequester{}
+	serve := func(body, sig []byte, sigErr error) {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			})
+		if sig == nil && sigErr == nil {
+			return
+		}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				equals(t, "http://updates.yourdomain.com/myapp/linux-amd64.json.asc", url)
+				if sigErr != nil {
+					return nil, sigErr
+				}
+				return ioutil.NopCloser(bytes.NewReader(sig)), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.GPGKeyring = keyring.Bytes()
+
+	// a detached signature published next to the manifest
+	serve(manifest, detached(signer), nil)
+	version, err := updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+
+	// a clearsigned manifest carries its signature
+	serve(clearsigned.Bytes(), nil, nil)
+	version, err = updater.CheckRemoteVersion(context.Background())
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+
+	// signatures of other keys and missing ones are refused
+	serve(manifest, detached(other), nil)
+	if _, err := updater.CheckRemoteVersion(context.Background()); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("expected ErrSignatureInvalid for another key, got %v", err)
+	}
+	serve(manifest, nil, &HTTPStatusError{StatusCode: 404, Status: "404 Not Found"})
+	if _, err := updater.CheckRemoteVersion(context.Background()); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("expected ErrSignatureInvalid without a signature, got %v", err)
+	}
+	tampered := bytes.Replace(clearsigned.Bytes(), []byte("1.3"), []byte("9.9"), 1)
+	serve(tampered, nil, nil)
+	if _, err := updater.CheckRemoteVersion(context.Background()); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("expected ErrSignatureInvalid for a tampered manifest, got %v", err)
+	}
+}
+
+func TestUpdaterKeyRotation(t *testing.T) {
+	oldKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	newKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	now := time.Now().UTC().Truncate(time.Second)
+	manifest := func(key ed25519.PrivateKey, published time.Time) string {
+		signer := createUpdater(nil)
+		signer.Info.Version = "1.3"
+		signer.Info.Sha256 = make([]byte, 32)
+		signer.Info.Timestamp = published
+		signer.Info.Signature = ed25519.Sign(key, signer.Info.SignaturePayload())
+		b, _ := json.Marshal(signer.Info)
+		return string(b)
+	}
+	rotation := []TrustedKey{
+		{PublicKey: oldKey.Public().(ed25519.PublicKey), NotAfter: now.Add(time.Hour)},
+		{PublicKey: newKey.Public().(ed25519.PublicKey), NotBefore: now.Add(-time.Hour)},
+	}
+	keyManifest := func(signer ed25519.PrivateKey, signed time.Time, keys []TrustedKey) string {
+		m := KeyManifest{Timestamp: signed, Keys: keys}
+		m.Signatures = []KeySignature{{PublicKey: signer.Public().(ed25519.PublicKey), Signature: ed25519.Sign(signer, m.Payload())}}
+		b, _ := json.Marshal(m)
+		return string(b)
+	}
+
+	mr := &mockRequester{}
+	serve := func(manifest, keys string) {
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(manifest), nil
+			})
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				equals(t, "http://updates.yourdomain.com/myapp/keys.json", url)
+				return newTestReaderCloser(keys), nil
+			})
+	}
+	updater := createUpdater(mr)
+	updater.State = &MemoryStore{}
+	updater.PublicKey = oldKey.Public().(ed25519.PublicKey)
+	updater.KeyManifest = "keys.json"
+	clock := now
+	updater.TimeSource = func() (time.Time, error) { return clock, nil }
+
+	// the old key vouches for the new one
+	serve(manifest(newKey, now), keyManifest(oldKey, now, rotation))
+	version, err := updater.UpdateAvailable()
+	equals(t, nil, err)
+	equals(t, "1.3", version)
+
+	// the old key is retired, backdating what it signs doesn't help
+	clock = now.Add(2 * time.Hour)
+	for _, published := range []time.Time{clock, now} {
+		serve(manifest(oldKey, published), keyManifest(oldKey, now, rotation))
+		_, err = updater.UpdateAvailable()
+		equals(t, ErrSignatureInvalid, err)
+	}
+	serve(manifest(oldKey, now), keyManifest(oldKey, clock, rotation[:1]))
+	_, err = updater.UpdateAvailable()
+	equals(t, ErrKeyManifestInvalid, err)
+
+	// an older key manifest can't undo that
+	serve(manifest(newKey, now), keyManifest(newKey, now.Add(-time.Minute), rotation))
+	_, err = updater.UpdateAvailable()
+	if !errors.Is(err, ErrKeyManifestInvalid) {
+		t.Errorf("expected ErrKeyManifestInvalid for an older key manifest, got %v", err)
+	}
+
+	// the key manifest replaces the configured keys
+	updater.State = &MemoryStore{}
+	clock = now
+	serve(manifest(oldKey, now), keyManifest(oldKey, now, rotation[1:]))
+	_, err = updater.UpdateAvailable()
+	equals(t, ErrSignatureInvalid, err)
+
+	// a key manifest signed by a key that isn't trusted is refused
+	updater.State = &MemoryStore{}
+	serve(manifest(newKey, now), keyManifest(newKey, now, rotation))
+	_, err = updater.UpdateAvailable()
+	equals(t, ErrKeyManifestInvalid, err)
+}
+
+func TestRestartMissingExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	u := &Updater{Target: mockUpdatableResolver{path: filepath.Join(dir, "missing")}}
+	if err := u.Restart(); err == nil {
+		t.Error("expected an error restarting into a missing executable")
+	}
+}
+
+func TestRelaunchUnsupported(t *testing.T) {
+	switch runtime.GOOS {
+	case "aix", "darwin", "dragonfly", "freebsd", "illumos", "ios", "linux", "netbsd", "openbsd", "solaris", "windows":
+		if os.Geteuid() != 0 {
+			t.Skipf("%s can restart", runtime.GOOS)
+		}
+		// sudo is pointless for root, which is refused before looking for it
+		if err := RelaunchElevated(); err == nil || errors.Is(err, errRelaunchUnsupported) {
+			t.Errorf("RelaunchElevated as root: got %v", err)
+		}
+		return
+	}
+	u := &Updater{Target: mockUpdatableResolver{path: "myapp"}}
+	if err := u.Restart(); !errors.Is(err, errRelaunchUnsupported) {
+		t.Errorf("Restart: got %v, want %v", err, errRelaunchUnsupported)
+	}
+	if err := RelaunchElevated(); !errors.Is(err, errRelaunchUnsupported) {
+		t.Errorf("RelaunchElevated: got %v, want %v", err, errRelaunchUnsupported)
+	}
+}
@@ -0,0 +1,97 @@
+package selfupdate
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSignatureInvalid is returned when Updater.PublicKey, TrustedKeys or
+// GPGKeyring is set and the manifest is unsigned or its signature does not
+// verify.
+var ErrSignatureInvalid = errors.New("update manifest signature is missing or invalid")
+
+// SignaturePayload returns the bytes the manifest signature is computed
+// over. It covers the version and binary hash, so a verified manifest vouches
+// for the binary, and the expiry, timestamp, severity, minimum version and
+// release notes, the hashes and permissions of any further files, the
+// rollout percentage and the targeting rules, so they can't be tampered with
+// either. Paused is left out on purpose: it only ever withholds a release,
+// which anyone between client and server can do anyway, and leaving it out
+// lets a release be halted by editing the manifest without the signing key.
+// The generator signs it as well, so publishers signing manifests by other
+// means should too.
+func (v *VersionInfo) SignaturePayload() []byte {
+	var expires string
+	if !v.Expires.IsZero() {
+		expires = v.Expires.UTC().Format(time.RFC3339)
+	}
+	return []byte("go-selfupdate signature v1\n" +
+		v.Version + "\n" +
+		base64.StdEncoding.EncodeToString(v.Sha256) + "\n" +
+		expires + "\n" +
+		v.Timestamp.UTC().Format(time.RFC3339) + "\n" +
+		v.Severity + "\n" +
+		v.MinimumVersion + "\n" +
+		base64.StdEncoding.EncodeToString([]byte(v.ReleaseNotes)) + "\n" +
+		filesPayload(v.Files) +
+		rolloutPayload(v.RolloutPercent) +
+		targetingPayload(v.UpdateFrom, v.SkipPlatforms))
+}
+
+// targetingPayload returns the signed lines for the targeting rules, none
+// for releases offered to all so their signatures stay the same.
+func targetingPayload(from string, skip []string) string {
+	var s string
+	if from != "" {
+		s += "from " + from + "\n"
+	}
+	if len(skip) > 0 {
+		s += "skip " + strings.Join(skip, " ") + "\n"
+	}
+	return s
+}
+
+// rolloutPayload returns the signed line for the rollout percentage, none
+// for releases offered to all so their signatures stay the same.
+func rolloutPayload(percent int) string {
+	if percent == 0 {
+		return ""
+	}
+	return "rollout " + strconv.Itoa(percent) + "\n"
+}
+
+// filesPayload returns the signed lines for the files of a release, none for
+// releases without any so their signatures stay the same.
+func filesPayload(files []ReleaseFile) string {
+	var s string
+	for _, f := range files {
+		s += "file " + f.Name + " " + base64.StdEncoding.EncodeToString(f.Sha256)
+		if f.Mode != 0 {
+			s += " " + strconv.FormatUint(uint64(f.Mode), 8)
+		}
+		s += "\n"
+	}
+	return s
+}
+
+// verifySignature checks the signature of the fetched manifest against the
+// trusted keys valid now. Manifests are not checked when no key is
+// configured, a key manifest leaving out every key fails them all.
+func (u *Updater) verifySignature() error {
+	if u.PublicKey == nil && len(u.TrustedKeys) == 0 {
+		return nil
+	}
+	keys := u.trustedKeys()
+	payload := u.Info.SignaturePayload()
+	now, skew := u.now(), u.clockSkew()
+	for _, k := range keys {
+		if k.validAt(now, skew) && ed25519.Verify(k.PublicKey, payload, u.Info.Signature) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
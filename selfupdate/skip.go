@@ -0,0 +1,53 @@
+package selfupdate
+
+import "encoding/json"
+
+// holds the versions the user declined
+const skippedPath = "skipped" // path to the skipped versions relative to u.Dir
+
+// SkipVersion records that the user declined version, so that checks don't
+// offer it again. The next release is offered as usual, and a mandatory one
+// is installed anyway. Use it when the user dismisses a prompt for good,
+// rather than asking on every check.
+func (u *Updater) SkipVersion(version string) error {
+	var skipped []string
+	for _, v := range u.readSkipped() {
+		// versions the running one caught up with are no longer offered
+		if v != version && compareVersions(v, u.CurrentVersion) > 0 {
+			skipped = append(skipped, v)
+		}
+	}
+	p, err := json.Marshal(append(skipped, version))
+	if err != nil {
+		return err
+	}
+	return u.state().Write(skippedPath, p)
+}
+
+// skipped reports whether the fetched release is left alone because it was
+// rolled back before or, unless it is mandatory, the user skipped it with
+// SkipVersion.
+func (u *Updater) skipped() bool {
+	if st := u.readRollback(); st.RolledBack && st.Installed == u.Info.Version {
+		return true
+	}
+	if u.mandatory() {
+		return false
+	}
+	for _, v := range u.readSkipped() {
+		if v == u.Info.Version {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Updater) readSkipped() []string {
+	var skipped []string
+	p, err := u.state().Read(skippedPath)
+	if err != nil {
+		return nil
+	}
+	json.Unmarshal(p, &skipped)
+	return skipped
+}
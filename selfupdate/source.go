@@ -0,0 +1,196 @@
+package selfupdate
+
+import (
+	"context"
+	"io"
+	"net/url"
+)
+
+// UpdateSource provides the files an Updater downloads: the JSON manifest
+// describing the latest version, the gzipped full binaries and the patches
+// between versions. They have the same format whatever the source.
+type UpdateSource interface {
+	// Manifest returns the manifest of the latest version of cmd for
+	// platform.
+	Manifest(ctx context.Context, cmd, platform string) (io.ReadCloser, error)
+	// Binary returns the full binary of cmd at version. file is its name,
+	// the platform followed by the extension of its format, such as
+	// linux-amd64.gz or linux-amd64.zip.
+	Binary(ctx context.Context, cmd, version, file string) (io.ReadCloser, error)
+	// Patch returns the patch turning version from into version to.
+	Patch(ctx context.Context, cmd, from, to, platform string) (io.ReadCloser, error)
+}
+
+// urlSource is the default UpdateSource, it fetches the files below the
+// Updater's ApiURL, BinURL and DiffURL, or its Mirrors, through its Requester
+// and Middleware.
+type urlSource struct {
+	u *Updater
+}
+
+func (s urlSource) Manifest(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	if t := s.u.URLTemplates; t != nil && t.Manifest != "" {
+		return s.fetchTemplate(ctx, t.Manifest, s.u.ApiURL, URLData{Cmd: cmd, Platform: platform})
+	}
+	return s.u.fetchMirrored(ctx, s.u.ApiURL+url.QueryEscape(cmd)+"/"+url.QueryEscape(platform)+".json")
+}
+
+// ManifestSignature fetches the detached signature published next to the
+// manifest, at its URL with .asc appended.
+func (s urlSource) ManifestSignature(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	if t := s.u.URLTemplates; t != nil && t.Manifest != "" {
+		return s.fetchTemplate(ctx, t.Manifest+".asc", s.u.ApiURL, URLData{Cmd: cmd, Platform: platform})
+	}
+	return s.u.fetchMirrored(ctx, s.u.ApiURL+url.QueryEscape(cmd)+"/"+url.QueryEscape(platform)+".json.asc")
+}
+
+// KeyManifest fetches the key manifest called name, published next to the
+// manifests.
+func (s urlSource) KeyManifest(ctx context.Context, cmd, name string) (io.ReadCloser, error) {
+	if t := s.u.URLTemplates; t != nil && t.Manifest != "" {
+		rawurl, err := s.u.expandURL(t.Manifest, s.u.ApiURL, URLData{Cmd: cmd, Platform: s.u.platform()})
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		return s.u.fetchMirrored(ctx, manifest.ResolveReference(&url.URL{Path: url.PathEscape(name)}).String())
+	}
+	return s.u.fetchMirrored(ctx, s.u.ApiURL+url.QueryEscape(cmd)+"/"+url.PathEscape(name))
+}
+
+func (s urlSource) Binary(ctx context.Context, cmd, version, file string) (io.ReadCloser, error) {
+	rawurl, err := s.binaryURL(cmd, version, file)
+	if err != nil {
+		return nil, err
+	}
+	return s.u.fetchMirrored(ctx, rawurl)
+}
+
+// binaryURL returns the URL Binary fetches from.
+func (s urlSource) binaryURL(cmd, version, file string) (string, error) {
+	if t := s.u.URLTemplates; t != nil && t.Binary != "" {
+		return s.u.expandURL(t.Binary, s.u.BinURL, URLData{Cmd: cmd, Platform: s.u.platform(), Version: version, File: file})
+	}
+	return s.u.BinURL + url.QueryEscape(cmd) + "/" + url.QueryEscape(version) + "/" + url.QueryEscape(file), nil
+}
+
+// ReleaseNotes fetches the notes published next to the binaries, with the
+// Binary template if there is one.
+func (s urlSource) ReleaseNotes(ctx context.Context, cmd, version string) (io.ReadCloser, error) {
+	return s.Binary(ctx, cmd, version, releaseNotesFile)
+}
+
+func (s urlSource) Patch(ctx context.Context, cmd, from, to, platform string) (io.ReadCloser, error) {
+	rawurl, err := s.patchURL(cmd, from, to, platform)
+	if err != nil {
+		return nil, err
+	}
+	return s.u.fetchMirrored(ctx, rawurl)
+}
+
+// patchURL returns the URL Patch fetches from.
+func (s urlSource) patchURL(cmd, from, to, platform string) (string, error) {
+	if t := s.u.URLTemplates; t != nil && t.Patch != "" {
+		return s.u.expandURL(t.Patch, s.u.DiffURL, URLData{Cmd: cmd, Platform: platform, Version: to, From: from})
+	}
+	return s.u.DiffURL + url.QueryEscape(cmd) + "/" + url.QueryEscape(from) + "/" + url.QueryEscape(to) + "/" + url.QueryEscape(platform), nil
+}
+
+// fetchTemplate fetches the URL the template text expands to for data.
+func (s urlSource) fetchTemplate(ctx context.Context, text, base string, data URLData) (io.ReadCloser, error) {
+	rawurl, err := s.u.expandURL(text, base, data)
+	if err != nil {
+		return nil, err
+	}
+	return s.u.fetchMirrored(ctx, rawurl)
+}
+
+// downloadURLs returns the URLs the full binary of the fetched release and
+// the patch from the running version to it are downloaded from: those the
+// manifest publishes, or else those of the configured URLs. Other sources
+// than the default one leave them empty, as does a release without a patch
+// from the running version.
+func (u *Updater) downloadURLs() DownloadURLs {
+	urls := DownloadURLs{Binary: u.Info.Downloads.Binary}
+	if len(u.Info.Downloads.Patches) > 0 {
+		urls.Patches = make(map[string]string, len(u.Info.Downloads.Patches))
+		for from, rawurl := range u.Info.Downloads.Patches {
+			urls.Patches[from] = rawurl
+		}
+	}
+	s, ok := u.baseSource().(urlSource)
+	if !ok {
+		return urls
+	}
+	if urls.Binary == "" {
+		urls.Binary, _ = s.binaryURL(u.CmdName, u.Info.Version, u.binaryFile())
+	}
+	if _, ok := u.Info.Patches[u.CurrentVersion]; ok && urls.Patches[u.CurrentVersion] == "" {
+		if rawurl, err := s.patchURL(u.CmdName, u.CurrentVersion, u.Info.Version, u.platform()); err == nil {
+			if urls.Patches == nil {
+				urls.Patches = make(map[string]string)
+			}
+			urls.Patches[u.CurrentVersion] = rawurl
+		}
+	}
+	return urls
+}
+
+// baseSource returns u.Source, or the source fetching from the configured
+// URLs when it is not set.
+func (u *Updater) baseSource() UpdateSource {
+	if s, ok := u.Source.(boundSource); ok {
+		return s.bind(u)
+	}
+	if u.Source != nil {
+		return u.Source
+	}
+	return urlSource{u}
+}
+
+// boundSource is implemented by sources that fetch through the Requester,
+// Middleware and pins of the Updater using them, like the default source.
+type boundSource interface {
+	UpdateSource
+	// bind returns the source fetching through u.
+	bind(u *Updater) UpdateSource
+}
+
+// source returns u.Source, or the source fetching from the configured URLs
+// when it is not set. Download URLs published in the manifest take precedence
+// and downloads are retried as configured by u.Retry.
+func (u *Updater) source() UpdateSource {
+	var src UpdateSource = manifestURLSource{src: u.baseSource(), u: u}
+	if u.Retry != nil && u.Retry.MaxAttempts > 1 {
+		src = retrySource{src: src, policy: u.Retry}
+	}
+	return src
+}
+
+// manifestURLSource downloads the files whose URLs the fetched manifest
+// publishes from there, and everything else from src.
+type manifestURLSource struct {
+	src UpdateSource
+	u   *Updater
+}
+
+func (s manifestURLSource) Manifest(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	return s.src.Manifest(ctx, cmd, platform)
+}
+
+func (s manifestURLSource) Binary(ctx context.Context, cmd, version, file string) (io.ReadCloser, error) {
+	if u := s.u.Info.Downloads.Binary; u != "" && version == s.u.Info.Version && file == s.u.binaryFile() {
+		return s.u.fetch(ctx, u)
+	}
+	return s.src.Binary(ctx, cmd, version, file)
+}
+
+func (s manifestURLSource) Patch(ctx context.Context, cmd, from, to, platform string) (io.ReadCloser, error) {
+	if u, ok := s.u.Info.Downloads.Patches[from]; ok && to == s.u.Info.Version {
+		return s.u.fetch(ctx, u)
+	}
+	return s.src.Patch(ctx, cmd, from, to, platform)
+}
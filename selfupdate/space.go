@@ -0,0 +1,74 @@
+package selfupdate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrInsufficientSpace is returned, wrapped, when a directory an update is
+// staged in doesn't have room for the new files.
+var ErrInsufficientSpace = errors.New("insufficient disk space for the update")
+
+// errSpaceUnknown is returned by diskFree where the free space can't be
+// told, the check is skipped then.
+var errSpaceUnknown = errors.New("free disk space unknown")
+
+// freeSpace returns the bytes available to the process in the file system
+// of dir.
+var freeSpace = diskFree
+
+// checkSpace makes sure the directories of the executable at path and of
+// u.Targets have room for the new files before anything is downloaded, so
+// that an update doesn't fail halfway through writing them. The size of a
+// new file isn't known before it is decompressed, it is taken to be the
+// larger of the file it replaces and the download, with a quarter on top
+// for growth.
+func (u *Updater) checkSpace(path string) error {
+	need := make(map[string]int64)
+	need[filepath.Dir(path)] += spaceNeeded(path, u.Info.Size)
+	for _, target := range u.Targets {
+		p, err := target.Path()
+		if err != nil {
+			continue
+		}
+		if f, ok := u.releaseFile(filepath.Base(p)); ok {
+			need[filepath.Dir(p)] += spaceNeeded(p, f.Size)
+		}
+	}
+	for dir, n := range need {
+		free, err := freeSpace(existingDir(dir))
+		if err != nil {
+			continue
+		}
+		if free < uint64(n) {
+			return fmt.Errorf("%w: %s has %d bytes free, %d needed", ErrInsufficientSpace, dir, free, n)
+		}
+	}
+	return nil
+}
+
+// spaceNeeded estimates the size of the file replacing the one at path,
+// which is size bytes to download.
+func spaceNeeded(path string, size int64) int64 {
+	if fi, err := os.Stat(path); err == nil && fi.Size() > size {
+		size = fi.Size()
+	}
+	return size + size/4
+}
+
+// existingDir returns dir or, for targets created by the update, the
+// closest parent directory that exists.
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package selfupdate
+
+// diskFree can't tell the free space, package syscall has no Statfs for the
+// platform.
+func diskFree(dir string) (uint64, error) {
+	return 0, errSpaceUnknown
+}
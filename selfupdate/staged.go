@@ -0,0 +1,220 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// holds the update downloaded by Download for Apply
+const stagedPath = "staged" // path to staged update state relative to u.Dir
+
+// ErrNoStagedUpdate is returned by Apply when Download has not staged an
+// update.
+var ErrNoStagedUpdate = errors.New("no staged update to apply")
+
+// stagedUpdate describes the executable Download left next to the target.
+type stagedUpdate struct {
+	Version string       // Version of the staged executable
+	Sha256  []byte       // Hash the staged executable must still have
+	Files   []stagedFile `json:",omitempty"` // Files of u.Targets staged along with it
+	Info    *VersionInfo `json:",omitempty"` // Manifest of the release, for u.Verifiers
+	AtStart bool         `json:",omitempty"` // Whether BackgroundRun installs it at the next start, see ErrUpdateDeferred
+}
+
+// stagedExecutablePath returns the path Download keeps the new executable at
+// until Apply installs it.
+func stagedExecutablePath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.staged", filepath.Base(path)))
+}
+
+// Download fetches and verifies the latest version like Update, but keeps it
+// next to the executable instead of installing it. Apply installs it later,
+// for example once the user agreed to restart, and StagedVersion tells which
+// version is waiting. Download does nothing if the running version is the
+// latest.
+func (u *Updater) Download() error {
+	return u.DownloadContext(context.Background())
+}
+
+// DownloadContext is like Download but aborts the download when ctx is done.
+func (u *Updater) DownloadContext(ctx context.Context) error {
+	if err := u.fetchInfo(ctx); err != nil {
+		return err
+	}
+	if u.upToDate() {
+		return nil
+	}
+	if u.skipped() {
+		u.logger().Info("not downloading skipped version", "version", u.Info.Version)
+		return nil
+	}
+	if !u.offered() {
+		return nil
+	}
+	u.updateAvailable(u.Info.Version)
+	if len(u.Info.Sha256) == 0 {
+		return ErrNoHash
+	}
+	if st, ok := u.readStaged(); ok && st.Version == u.Info.Version && bytes.Equal(st.Sha256, u.Info.Sha256) {
+		// already downloaded
+		return nil
+	}
+
+	path, err := u.targetPath()
+	if err != nil {
+		return err
+	}
+	if err := u.checkInstall(path); err != nil {
+		return err
+	}
+	unlock, err := lockUpdate(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	files, err := u.downloadAll(ctx, path, &UpdateResult{})
+	if err != nil {
+		return err
+	}
+	if err := stageFiles(files); err != nil {
+		return err
+	}
+	u.logger().Info("staged update", "version", u.Info.Version)
+	return u.saveStaged(stagedUpdate{Version: u.Info.Version, Sha256: u.Info.Sha256, Files: files[1:], Info: &u.Info})
+}
+
+// StagedVersion returns the version Download staged, and false if there is
+// none waiting to be applied.
+func (u *Updater) StagedVersion() (string, bool) {
+	st, ok := u.readStaged()
+	if !ok || st.Version == u.CurrentVersion {
+		return "", false
+	}
+	return st.Version, true
+}
+
+// Apply installs the update staged by an earlier Download, which may have
+// happened in an earlier run of the application. The staged executable is
+// verified again before it replaces the current one. It returns
+// ErrNoStagedUpdate if nothing is staged.
+func (u *Updater) Apply() error {
+	path, err := u.targetPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockUpdate(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	u.recoverInstalls()
+	// read after locking, another instance may just have applied it
+	st, ok := u.readStaged()
+	if !ok {
+		return ErrNoStagedUpdate
+	}
+	files := append([]stagedFile{{Path: path, NewPath: stagedExecutablePath(path), Sha256: st.Sha256}}, st.Files...)
+	if st.Version == u.CurrentVersion {
+		// installed in the meantime
+		u.clearStaged(files)
+		return ErrNoStagedUpdate
+	}
+
+	info := VersionInfo{Version: st.Version, Sha256: st.Sha256}
+	if st.Info != nil {
+		info = *st.Info
+	}
+	if err := u.verifyArtifact(files[0].NewPath, info); err != nil {
+		u.clearStaged(files)
+		return err
+	}
+	for _, f := range files[1:] {
+		if err := verifyFile(f.NewPath, f.Sha256); err != nil {
+			u.clearStaged(files)
+			return err
+		}
+	}
+	release, err := u.holdGate(context.Background(), files)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := u.apply(context.Background(), files, st.Version); err != nil {
+		return err
+	}
+	u.clearStaged(files)
+	if u.RestartAfterUpdate {
+		return u.Restart()
+	}
+	return nil
+}
+
+// verifyFile checks that the file at path hashes to sha.
+func verifyFile(path string, sha []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sha) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// stageFiles moves the downloaded files to the paths they are kept at until
+// Apply installs them. On error they are removed.
+func stageFiles(files []stagedFile) error {
+	for i := range files {
+		staged := stagedExecutablePath(files[i].Path)
+		if files[i].NewPath == staged {
+			continue
+		}
+		// replace an older staged version, windows can't rename onto it
+		_ = os.Remove(staged)
+		if err := os.Rename(files[i].NewPath, staged); err != nil {
+			removeStaged(files)
+			return err
+		}
+		files[i].NewPath = staged
+	}
+	return nil
+}
+
+func (u *Updater) readStaged() (stagedUpdate, bool) {
+	var st stagedUpdate
+	p, err := u.state().Read(stagedPath)
+	if err != nil {
+		return st, false
+	}
+	if err := json.Unmarshal(p, &st); err != nil || st.Version == "" {
+		return stagedUpdate{}, false
+	}
+	return st, true
+}
+
+func (u *Updater) saveStaged(st stagedUpdate) error {
+	p, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return u.state().Write(stagedPath, p)
+}
+
+// clearStaged forgets the staged update and removes its files, if they are
+// still there.
+func (u *Updater) clearStaged(files []stagedFile) {
+	removeStaged(files)
+	_ = u.state().Remove(stagedPath)
+}
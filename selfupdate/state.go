@@ -0,0 +1,135 @@
+package selfupdate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// StateStore keeps the small files an Updater remembers things in between
+// runs, such as the time of the next check, the circuit breaker or a staged
+// update. Read returns an error satisfying os.IsNotExist for a name that was
+// never written or was removed.
+type StateStore interface {
+	Read(name string) ([]byte, error)
+	Write(name string, data []byte) error
+	Remove(name string) error
+}
+
+// DirStore keeps the state files in a directory, which is created when the
+// first file is written.
+type DirStore string
+
+// Read returns the contents of the file name in the directory.
+func (d DirStore) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+// Write replaces the file name in the directory with data.
+func (d DirStore) Write(name string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(string(d), name), data, 0644)
+}
+
+// Remove removes the file name from the directory, if it exists.
+func (d DirStore) Remove(name string) error {
+	return removeIfExists(filepath.Join(string(d), name))
+}
+
+// CacheDirStore returns a DirStore in the subdirectory app of the user's
+// cache directory: $XDG_CACHE_HOME or ~/.cache on Linux, ~/Library/Caches on
+// macOS and %LocalAppData% on Windows. Unlike the directory of the
+// executable it is writable when the executable is installed in a read-only
+// location such as /usr/bin or a Homebrew prefix.
+func CacheDirStore(app string) (DirStore, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return DirStore(filepath.Join(dir, app)), nil
+}
+
+// MemoryStore keeps the state in memory, for containers and other
+// short-lived installations where nothing should be written to disk. The
+// state is lost when the process exits, so every run checks for updates.
+// The zero value is ready to use.
+type MemoryStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// Read returns a copy of the data last written to name.
+func (m *MemoryStore) Read(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Write stores a copy of data as name.
+func (m *MemoryStore) Write(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Remove forgets name.
+func (m *MemoryStore) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+// stateFiles are the names of all state files, which are moved when the
+// state moves to the cache directory.
+var stateFiles = []string{upcktimePath, lastCheckPath, lastErrorPath, circuitPath, throughputPath, rollbackPath, stagedPath, cohortPath, manifestCachePath, mirrorsPath, keysPath, ledgerPath, skippedPath}
+
+// migrated holds the cache directories state was already moved to.
+var migrated sync.Map
+
+// state returns u.State if set. Otherwise it returns the directory Dir next
+// to the executable if Dir is set, or the selfupdate directory of CmdName in
+// the user's cache directory, see CacheDirStore. State files left next to
+// the executable by earlier versions are moved there first.
+func (u *Updater) state() StateStore {
+	if u.State != nil {
+		return u.State
+	}
+	if u.Dir == "" {
+		if store, err := CacheDirStore(filepath.Join(u.CmdName, "selfupdate")); err == nil {
+			if _, done := migrated.LoadOrStore(store, true); !done {
+				migrateState(DirStore(u.getExecRelativeDir("")), store)
+			}
+			return store
+		}
+	}
+	return DirStore(u.getExecRelativeDir(u.Dir))
+}
+
+// migrateState moves the state files from one store to another that doesn't
+// have them yet.
+func migrateState(from, to StateStore) {
+	for _, name := range stateFiles {
+		data, err := from.Read(name)
+		if err != nil {
+			continue
+		}
+		if _, err := to.Read(name); os.IsNotExist(err) {
+			if err := to.Write(name, data); err != nil {
+				continue
+			}
+		}
+		_ = from.Remove(name)
+	}
+}
@@ -0,0 +1,72 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// holds the error of the latest update run
+const lastErrorPath = "lasterror" // path to last error relative to u.Dir
+
+// Status summarizes what the updater did and will do, for showing it in the
+// application, e.g. "Checked for updates 2 hours ago".
+type Status struct {
+	LastCheck       time.Time // When the latest check was made, zero if none was recorded
+	NextCheck       time.Time // When the schedule has the next check due
+	LatestVersion   string    // Latest version the latest check found, empty if it failed
+	UpdateAvailable bool      // Whether the latest check found a version other than the running one
+	LastUpdate      time.Time // When the latest update was installed, zero if none was recorded
+	UpdatedTo       string    // Version the latest update installed
+	RolledBack      bool      // Whether the latest update was rolled back
+	LastError       string    // Why the latest BackgroundRun or Update failed, empty if it succeeded
+	LastErrorTime   time.Time // When it failed
+}
+
+// lastError is the persisted error of the latest update run.
+type lastError struct {
+	Time  time.Time
+	Error string
+}
+
+// Status returns the state of the updater as recorded in the state store,
+// without making any network request.
+func (u *Updater) Status() Status {
+	s := Status{NextCheck: u.NextUpdate()}
+	if last, ok := u.LastCheck(); ok {
+		s.LastCheck = last.Time
+		s.LatestVersion = last.Version
+		s.UpdateAvailable = last.Available
+	}
+	if st := u.readRollback(); st.Installed != "" {
+		s.LastUpdate = st.Time
+		s.UpdatedTo = st.Installed
+		s.RolledBack = st.RolledBack
+	}
+	if p, err := u.state().Read(lastErrorPath); err == nil {
+		var e lastError
+		if json.Unmarshal(p, &e) == nil {
+			s.LastError = e.Error
+			s.LastErrorTime = e.Time
+		}
+	}
+	return s
+}
+
+// saveLastError records the outcome of an update run, the error or, if it
+// succeeded, that there is none. Cancelled runs aren't recorded.
+func (u *Updater) saveLastError(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		_ = u.state().Remove(lastErrorPath)
+		return
+	}
+	p, err := json.Marshal(lastError{Time: time.Now(), Error: err.Error()})
+	if err != nil {
+		return
+	}
+	u.state().Write(lastErrorPath, p)
+}
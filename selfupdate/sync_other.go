@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package selfupdate
+
+import "os"
+
+// renameReplaces tells whether a file can be renamed onto an existing one,
+// replacing it in a single step.
+const renameReplaces = true
+
+// syncDir flushes the entries of the directory dir to disk, so that files
+// created or renamed in it survive a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
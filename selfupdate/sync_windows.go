@@ -0,0 +1,12 @@
+package selfupdate
+
+// renameReplaces tells whether a file can be renamed onto an existing one,
+// replacing it in a single step. Windows refuses to for a running
+// executable.
+const renameReplaces = false
+
+// syncDir does nothing, directories can't be opened for flushing on Windows
+// and NTFS journals renames anyway.
+func syncDir(dir string) error {
+	return nil
+}
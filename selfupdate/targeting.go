@@ -0,0 +1,99 @@
+package selfupdate
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// targeted reports whether the fetched release is meant for this
+// installation according to the UpdateFrom and SkipPlatforms of the
+// manifest, which let the publisher fence off upgrade paths known to be
+// broken without withdrawing the release. A Paused release is meant for
+// nobody, nor is one whose version matches u.IgnorePattern or a pre-release
+// u.Prereleases rules out. Unlike a rollout this applies to mandatory
+// releases too. A constraint that can't be parsed targets nobody.
+func (u *Updater) targeted() bool {
+	if u.Info.Paused {
+		return false
+	}
+	if u.IgnorePattern != nil && u.IgnorePattern.MatchString(u.Info.Version) {
+		return false
+	}
+	if !u.prereleaseAllowed() {
+		return false
+	}
+	if skipsPlatform(u.Info.SkipPlatforms, u.platform()) {
+		return false
+	}
+	ok, err := satisfies(u.CurrentVersion, u.Info.UpdateFrom)
+	if err != nil {
+		u.logger().Warn("ignoring release with invalid version constraint", "version", u.Info.Version, "error", err)
+		return false
+	}
+	return ok
+}
+
+// offered reports whether the fetched release is offered to this
+// installation: it is targeted at it and the installation is in its
+// rollout.
+func (u *Updater) offered() bool {
+	return u.targeted() && u.inRollout()
+}
+
+// skipsPlatform reports whether platform or the platform it is a variant of,
+// e.g. linux-arm for linux-arm-7, is one of skip.
+func skipsPlatform(skip []string, platform string) bool {
+	for _, p := range skip {
+		if platform == p || strings.HasPrefix(platform, p+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// satisfies reports whether version meets constraint, a comma separated list
+// of comparisons such as ">=1.4.0, <2" that all have to hold. The operators
+// are =, !=, <, <=, > and >=, a version without one has to match exactly.
+// An empty constraint is met by every version.
+func satisfies(version, constraint string) (bool, error) {
+	for _, c := range strings.Split(constraint, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		i := strings.IndexFunc(c, isVersionStart)
+		if i < 0 {
+			return false, fmt.Errorf("constraint %q has no version", c)
+		}
+		op, want := strings.TrimSpace(c[:i]), c[i:]
+		cmp := compareVersions(version, want)
+		var ok bool
+		switch op {
+		case "", "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		default:
+			return false, fmt.Errorf("constraint %q has unknown operator %q", c, op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isVersionStart reports whether r can start the version of a constraint,
+// which ends the operator before it.
+func isVersionStart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,40 @@
+package selfupdate
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader limits the rate at which r is read to rate bytes per
+// second. Reads are cut into pieces of a tenth of a second's worth, so a
+// cancelled download isn't stuck waiting for long.
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newThrottledReader(r io.Reader, rate int64) *throttledReader {
+	return &throttledReader{r: r, rate: rate, start: time.Now(), now: time.Now, sleep: time.Sleep}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	max := t.rate / 10
+	if max < 1 {
+		max = 1
+	}
+	if int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	// wait until the bytes read so far are due at rate
+	due := time.Duration(t.n * int64(time.Second) / t.rate)
+	if wait := due - t.now().Sub(t.start); wait > 0 {
+		t.sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,240 @@
+package tuf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envelope is a metadata file: the signed role metadata and its signatures.
+type envelope struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []signature     `json:"signatures"`
+}
+
+type signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+type key struct {
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+type role struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// common are the fields all role metadata have.
+type common struct {
+	Type    string    `json:"_type"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+type rootMeta struct {
+	common
+	ConsistentSnapshot bool            `json:"consistent_snapshot"`
+	Keys               map[string]key  `json:"keys"`
+	Roles              map[string]role `json:"roles"`
+}
+
+// metaFile describes a metadata file in timestamp and snapshot metadata.
+type metaFile struct {
+	Version int64             `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// fileMeta is the metadata of timestamp and snapshot, listing other
+// metadata files.
+type fileMeta struct {
+	common
+	Meta map[string]metaFile `json:"meta"`
+}
+
+type target struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+	Custom json.RawMessage   `json:"custom,omitempty"`
+}
+
+type targetsMeta struct {
+	common
+	Targets map[string]target `json:"targets"`
+}
+
+// decode parses the metadata of role type typ in env into v, which embeds
+// common.
+func decode(env envelope, typ string, v interface{}) error {
+	if err := json.Unmarshal(env.Signed, v); err != nil {
+		return fmt.Errorf("%s metadata: %v", typ, err)
+	}
+	var c common
+	if err := json.Unmarshal(env.Signed, &c); err != nil {
+		return err
+	}
+	if c.Type != typ {
+		return fmt.Errorf("%w: metadata of type %q, want %s", ErrInvalid, c.Type, typ)
+	}
+	return nil
+}
+
+// verify returns ErrBadSignature unless the metadata in env carries valid
+// signatures of at least the threshold of distinct keys of role name in
+// root. Only ed25519 keys are supported, signatures of others don't count.
+func verify(env envelope, root *rootMeta, name string) error {
+	r, ok := root.Roles[name]
+	if !ok || r.Threshold < 1 {
+		return fmt.Errorf("%w: root has no %s role", ErrInvalid, name)
+	}
+	payload, err := canonicalJSON(env.Signed)
+	if err != nil {
+		return err
+	}
+	trusted := make(map[string]bool)
+	for _, id := range r.KeyIDs {
+		trusted[id] = true
+	}
+	valid := make(map[string]bool)
+	for _, sig := range env.Signatures {
+		if !trusted[sig.KeyID] || valid[sig.KeyID] {
+			continue
+		}
+		k, ok := root.Keys[sig.KeyID]
+		if !ok || k.KeyType != "ed25519" {
+			continue
+		}
+		pub, err := hex.DecodeString(k.KeyVal.Public)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		s, err := hex.DecodeString(sig.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(ed25519.PublicKey(pub), payload, s) {
+			valid[sig.KeyID] = true
+		}
+	}
+	if len(valid) < r.Threshold {
+		return fmt.Errorf("%w: %s metadata has %d valid signatures, needs %d", ErrBadSignature, name, len(valid), r.Threshold)
+	}
+	return nil
+}
+
+// checkFile returns ErrMismatch if b doesn't have the length and sha256
+// hash given, where given.
+func checkFile(name string, b []byte, length int64, hashes map[string]string) error {
+	if length != 0 && int64(len(b)) != length {
+		return fmt.Errorf("%w: %s has %d bytes, want %d", ErrMismatch, name, len(b), length)
+	}
+	if want, ok := hashes["sha256"]; ok {
+		sum := sha256.Sum256(b)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(want) {
+			return fmt.Errorf("%w: %s has the wrong hash", ErrMismatch, name)
+		}
+	}
+	return nil
+}
+
+// sameKeys reports whether role name has the same keys and threshold in
+// both roots.
+func sameKeys(a, b *rootMeta, name string) bool {
+	ra, rb := a.Roles[name], b.Roles[name]
+	if ra.Threshold != rb.Threshold || len(ra.KeyIDs) != len(rb.KeyIDs) {
+		return false
+	}
+	ids := make(map[string]bool)
+	for _, id := range ra.KeyIDs {
+		ids[id] = true
+	}
+	for _, id := range rb.KeyIDs {
+		if !ids[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalJSON returns the canonical JSON form of raw that TUF signs:
+// object keys sorted, no insignificant whitespace, only quotes and
+// backslashes escaped in strings, and integer numbers only.
+func canonicalJSON(raw []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := writeCanonical(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeCanonical(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return fmt.Errorf("%w: canonical JSON has no number %s", ErrInvalid, v)
+		}
+		b.WriteString(v.String())
+	case string:
+		b.WriteByte('"')
+		for _, r := range v {
+			if r == '"' || r == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('"')
+	case []interface{}:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonical(b, k)
+			b.WriteByte(':')
+			if err := writeCanonical(b, v[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	}
+	return nil
+}
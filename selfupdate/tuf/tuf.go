@@ -0,0 +1,464 @@
+// Package tuf fetches updates from a repository of The Update Framework
+// (https://theupdateframework.io). Before anything is downloaded the root,
+// timestamp, snapshot and targets metadata of the repository are verified,
+// which protects against a compromised update server serving old releases
+// (rollback), withholding new ones (freeze) or combining metadata of
+// different times (mix-and-match), and every target is checked against the
+// length and hash the metadata lists.
+//
+// A Source is used as the Updater's Source together with TUFManifest:
+//
+//	root, _ := ioutil.ReadFile("root.json") // shipped with the application
+//	updater.Source = &tuf.Source{
+//		MetadataURL: "https://updates.example.com/metadata/",
+//		TargetsURL:  "https://updates.example.com/targets/",
+//		Root:        root,
+//		State:       selfupdate.DirStore("/var/lib/myapp/tuf"),
+//	}
+//	updater.ManifestDecoder = selfupdate.TUFManifest{}
+//
+// Targets are named like the files the go-selfupdate generator writes, such
+// as myapp/1.3/linux-amd64.gz for a full binary and myapp/1.2/1.3/linux-amd64
+// for a patch, and carry the hash of the executable in their custom
+// metadata, see TUFManifest. Only ed25519 keys are supported, and targets
+// delegated to other roles aren't found.
+package tuf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+)
+
+var (
+	// ErrBadSignature is returned, wrapped, when metadata isn't signed by
+	// enough keys of its role.
+	ErrBadSignature = errors.New("tuf: metadata signature invalid")
+
+	// ErrRollback is returned, wrapped, when the repository serves metadata
+	// older than the metadata trusted before.
+	ErrRollback = errors.New("tuf: metadata version rolled back")
+
+	// ErrExpired is returned, wrapped, when metadata is past its expiry,
+	// which indicates a frozen repository.
+	ErrExpired = errors.New("tuf: metadata expired")
+
+	// ErrMismatch is returned, wrapped, when a file differs from what the
+	// metadata listing it says.
+	ErrMismatch = errors.New("tuf: file doesn't match its metadata")
+
+	// ErrUnknownTarget is returned, wrapped, for a file the targets
+	// metadata doesn't list.
+	ErrUnknownTarget = errors.New("tuf: unknown target")
+
+	// ErrInvalid is returned, wrapped, for metadata that can't be used.
+	ErrInvalid = errors.New("tuf: invalid metadata")
+)
+
+// State files kept in Source.State.
+const (
+	rootState     = "tuf-root.json" // newest trusted root metadata
+	versionsState = "tuf-versions"  // versions of the trusted metadata
+)
+
+// maxMetadataSize limits the metadata files read.
+const maxMetadataSize = 16 << 20
+
+// Source is a selfupdate.UpdateSource that fetches from a TUF repository.
+// The manifest it returns is the verified targets metadata of the command,
+// for selfupdate.TUFManifest. Use a Source by pointer, it keeps the
+// metadata verified last.
+type Source struct {
+	MetadataURL string                // Base URL of the metadata, such as https://updates.example.com/metadata/
+	TargetsURL  string                // Base URL of the targets, such as https://updates.example.com/targets/
+	Root        []byte                // Initial root metadata the application trusts, root.json of the repository, shipped with it
+	State       selfupdate.StateStore // Optional store of the newest trusted root and metadata versions, by default they are kept in memory and protection against rollbacks ends with the process
+	Requester   selfupdate.Requester  // Optional requester to fetch with, defaults to a selfupdate.HTTPRequester
+	Now         func() time.Time      // Optional clock to check expiry with, defaults to time.Now
+
+	mu         sync.Mutex
+	memory     selfupdate.MemoryStore
+	consistent bool              // whether the repository uses consistent snapshots
+	targets    *targetsMeta      // verified by the latest refresh
+	verified   map[string]target // targets listed by targets, nil before the first refresh
+}
+
+// versions are the versions of the metadata trusted last.
+type versions struct {
+	Timestamp int64
+	Snapshot  int64
+	Targets   int64
+}
+
+// Manifest verifies the metadata of the repository and returns the
+// targets metadata of cmd, the targets below cmd/.
+func (s *Source) Manifest(ctx context.Context, cmd, platform string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	var m targetsMeta
+	m.common = s.targets.common
+	m.Targets = make(map[string]target)
+	for name, t := range s.targets.Targets {
+		if strings.HasPrefix(name, cmd+"/") {
+			m.Targets[name] = t
+		}
+	}
+	signed, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(envelope{Signed: signed})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(string(b))), nil
+}
+
+// Binary returns the full binary target cmd/version/file.
+func (s *Source) Binary(ctx context.Context, cmd, version, file string) (io.ReadCloser, error) {
+	return s.target(ctx, cmd+"/"+version+"/"+file)
+}
+
+// Patch returns the patch target cmd/from/to/platform.
+func (s *Source) Patch(ctx context.Context, cmd, from, to, platform string) (io.ReadCloser, error) {
+	return s.target(ctx, cmd+"/"+from+"/"+to+"/"+platform)
+}
+
+// ReleaseNotes returns the target cmd/version/CHANGELOG.md. Releases without
+// notes have no such target, which the Updater treats as no notes.
+func (s *Source) ReleaseNotes(ctx context.Context, cmd, version string) (io.ReadCloser, error) {
+	r, err := s.target(ctx, cmd+"/"+version+"/CHANGELOG.md")
+	if errors.Is(err, ErrUnknownTarget) {
+		return nil, &selfupdate.HTTPStatusError{URL: cmd + "/" + version + "/CHANGELOG.md", StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	}
+	return r, err
+}
+
+// target downloads the target name, which the verified metadata has to
+// list, and fails reading it if it doesn't match its length and hash.
+func (s *Source) target(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	if s.verified == nil {
+		// nothing is downloaded before the metadata is verified
+		if err := s.refresh(ctx); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	t, ok := s.verified[name]
+	consistent := s.consistent
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTarget, name)
+	}
+	want, ok := t.Hashes["sha256"]
+	if !ok {
+		return nil, fmt.Errorf("%w: target %s has no sha256 hash", ErrInvalid, name)
+	}
+	file := name
+	if consistent {
+		file = path.Join(path.Dir(name), strings.ToLower(want)+"."+path.Base(name))
+	}
+	r, err := s.fetch(ctx, s.TargetsURL+file)
+	if err != nil {
+		return nil, err
+	}
+	return &targetReader{r: r, name: name, remaining: t.Length, want: strings.ToLower(want), h: sha256.New()}, nil
+}
+
+// refresh updates the trusted metadata as the TUF client workflow
+// describes: the root first, then timestamp, snapshot and targets, each
+// checked against the one before.
+func (s *Source) refresh(ctx context.Context) error {
+	root, rootRaw, err := s.trustedRoot()
+	if err != nil {
+		return err
+	}
+	vs := s.readVersions()
+
+	// follow the chain of root versions, each signed by the keys of the
+	// previous one and its own
+	for {
+		raw, err := s.fetchMetadata(ctx, fmt.Sprintf("%d.root.json", root.Version+1))
+		if isNotFound(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		next, env, err := parseRoot(raw)
+		if err != nil {
+			return err
+		}
+		if err := verify(env, root, "root"); err != nil {
+			return err
+		}
+		if err := verify(env, next, "root"); err != nil {
+			return err
+		}
+		if next.Version != root.Version+1 {
+			return fmt.Errorf("%w: root version %d, want %d", ErrMismatch, next.Version, root.Version+1)
+		}
+		// metadata signed with rotated keys isn't comparable any more
+		if !sameKeys(root, next, "timestamp") {
+			vs.Timestamp, vs.Snapshot = 0, 0
+		}
+		if !sameKeys(root, next, "snapshot") {
+			vs.Snapshot = 0
+		}
+		root, rootRaw = next, raw
+		if err := s.state().Write(rootState, rootRaw); err != nil {
+			return err
+		}
+	}
+	now := s.now()
+	if err := checkExpiry("root", root.common, now); err != nil {
+		return err
+	}
+
+	// timestamp
+	raw, err := s.fetchMetadata(ctx, "timestamp.json")
+	if err != nil {
+		return err
+	}
+	var ts fileMeta
+	if err := s.verifyMetadata(raw, root, "timestamp", &ts); err != nil {
+		return err
+	}
+	if ts.Version < vs.Timestamp {
+		return fmt.Errorf("%w: timestamp version %d, trusted %d", ErrRollback, ts.Version, vs.Timestamp)
+	}
+	snapFile, ok := ts.Meta["snapshot.json"]
+	if !ok {
+		return fmt.Errorf("%w: timestamp lists no snapshot", ErrInvalid)
+	}
+	if snapFile.Version < vs.Snapshot {
+		return fmt.Errorf("%w: snapshot version %d, trusted %d", ErrRollback, snapFile.Version, vs.Snapshot)
+	}
+	if err := checkExpiry("timestamp", ts.common, now); err != nil {
+		return err
+	}
+
+	// snapshot
+	if raw, err = s.fetchMetadata(ctx, s.versioned(root, snapFile.Version, "snapshot.json")); err != nil {
+		return err
+	}
+	if err := checkFile("snapshot.json", raw, snapFile.Length, snapFile.Hashes); err != nil {
+		return err
+	}
+	var snap fileMeta
+	if err := s.verifyMetadata(raw, root, "snapshot", &snap); err != nil {
+		return err
+	}
+	if snap.Version != snapFile.Version {
+		return fmt.Errorf("%w: snapshot version %d, timestamp lists %d", ErrMismatch, snap.Version, snapFile.Version)
+	}
+	targetsFile, ok := snap.Meta["targets.json"]
+	if !ok {
+		return fmt.Errorf("%w: snapshot lists no targets", ErrInvalid)
+	}
+	if targetsFile.Version < vs.Targets {
+		return fmt.Errorf("%w: targets version %d, trusted %d", ErrRollback, targetsFile.Version, vs.Targets)
+	}
+	if err := checkExpiry("snapshot", snap.common, now); err != nil {
+		return err
+	}
+
+	// targets
+	if raw, err = s.fetchMetadata(ctx, s.versioned(root, targetsFile.Version, "targets.json")); err != nil {
+		return err
+	}
+	if err := checkFile("targets.json", raw, targetsFile.Length, targetsFile.Hashes); err != nil {
+		return err
+	}
+	var targets targetsMeta
+	if err := s.verifyMetadata(raw, root, "targets", &targets); err != nil {
+		return err
+	}
+	if targets.Version != targetsFile.Version {
+		return fmt.Errorf("%w: targets version %d, snapshot lists %d", ErrMismatch, targets.Version, targetsFile.Version)
+	}
+	if err := checkExpiry("targets", targets.common, now); err != nil {
+		return err
+	}
+
+	s.saveVersions(versions{Timestamp: ts.Version, Snapshot: snap.Version, Targets: targets.Version})
+	s.consistent = root.ConsistentSnapshot
+	s.targets = &targets
+	s.verified = targets.Targets
+	return nil
+}
+
+// trustedRoot returns the newest root trusted so far, from the state or
+// else Root, which has to be signed by its own keys.
+func (s *Source) trustedRoot() (*rootMeta, []byte, error) {
+	raw, err := s.state().Read(rootState)
+	if err != nil {
+		raw = s.Root
+	}
+	root, env, err := parseRoot(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verify(env, root, "root"); err != nil {
+		return nil, nil, err
+	}
+	return root, raw, nil
+}
+
+func parseRoot(raw []byte) (*rootMeta, envelope, error) {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, env, fmt.Errorf("root metadata: %v", err)
+	}
+	var root rootMeta
+	if err := decode(env, "root", &root); err != nil {
+		return nil, env, err
+	}
+	return &root, env, nil
+}
+
+// verifyMetadata parses raw, checks it is signed by role name of root and
+// decodes it into v.
+func (s *Source) verifyMetadata(raw []byte, root *rootMeta, name string, v interface{}) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return fmt.Errorf("%s metadata: %v", name, err)
+	}
+	if err := verify(env, root, name); err != nil {
+		return err
+	}
+	return decode(env, name, v)
+}
+
+// versioned returns the file name of version of the metadata file name,
+// prefixed with the version in repositories with consistent snapshots.
+func (s *Source) versioned(root *rootMeta, version int64, name string) string {
+	if root.ConsistentSnapshot {
+		return fmt.Sprintf("%d.%s", version, name)
+	}
+	return name
+}
+
+func checkExpiry(name string, c common, now time.Time) error {
+	if now.After(c.Expires) {
+		return fmt.Errorf("%w: %s expired %s", ErrExpired, name, c.Expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// fetchMetadata fetches the metadata file name.
+func (s *Source) fetchMetadata(ctx context.Context, name string) ([]byte, error) {
+	r, err := s.fetch(ctx, s.MetadataURL+name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxMetadataSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalid, name, maxMetadataSize)
+	}
+	return b, nil
+}
+
+func (s *Source) fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	r := s.Requester
+	if r == nil {
+		r = &selfupdate.HTTPRequester{}
+	}
+	if cr, ok := r.(selfupdate.ContextRequester); ok {
+		return cr.FetchContext(ctx, url)
+	}
+	return r.Fetch(url)
+}
+
+// isNotFound reports whether err says there is no such file. S3 answers 403
+// for files that don't exist.
+func isNotFound(err error) bool {
+	var statusErr *selfupdate.HTTPStatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusForbidden)
+}
+
+func (s *Source) state() selfupdate.StateStore {
+	if s.State != nil {
+		return s.State
+	}
+	return &s.memory
+}
+
+func (s *Source) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Source) readVersions() versions {
+	var vs versions
+	if p, err := s.state().Read(versionsState); err == nil {
+		json.Unmarshal(p, &vs)
+	}
+	return vs
+}
+
+func (s *Source) saveVersions(vs versions) {
+	p, err := json.Marshal(vs)
+	if err != nil {
+		return
+	}
+	s.state().Write(versionsState, p)
+}
+
+// targetReader reads a target and fails at its end unless it has the
+// length and hash of the metadata, or as soon as it gets longer.
+type targetReader struct {
+	r         io.ReadCloser
+	name      string
+	remaining int64
+	want      string
+	h         hash.Hash
+}
+
+func (t *targetReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > t.remaining+1 {
+		p = p[:t.remaining+1]
+	}
+	n, err := t.r.Read(p)
+	t.h.Write(p[:n])
+	t.remaining -= int64(n)
+	if t.remaining < 0 {
+		return n, fmt.Errorf("%w: %s is longer than listed", ErrMismatch, t.name)
+	}
+	if err == io.EOF {
+		if t.remaining > 0 {
+			return n, fmt.Errorf("%w: %s is shorter than listed", ErrMismatch, t.name)
+		}
+		if hex.EncodeToString(t.h.Sum(nil)) != t.want {
+			return n, fmt.Errorf("%w: %s has the wrong hash", ErrMismatch, t.name)
+		}
+	}
+	return n, err
+}
+
+func (t *targetReader) Close() error {
+	return t.r.Close()
+}
@@ -0,0 +1,309 @@
+package tuf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanbornm/go-selfupdate/selfupdate"
+)
+
+const (
+	metadataURL = "https://updates.example.com/metadata/"
+	targetsURL  = "https://updates.example.com/targets/"
+)
+
+type testKey struct {
+	id   string
+	priv ed25519.PrivateKey
+	key  key
+}
+
+func newTestKey(t *testing.T) testKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := testKey{priv: priv}
+	k.key.KeyType, k.key.Scheme, k.key.KeyVal.Public = "ed25519", "ed25519", hex.EncodeToString(pub)
+	b, _ := json.Marshal(k.key)
+	sum := sha256.Sum256(b)
+	k.id = hex.EncodeToString(sum[:])
+	return k
+}
+
+// testRepo is a TUF repository served from memory.
+type testRepo struct {
+	t        *testing.T
+	keys     map[string]testKey // by role
+	root     rootMeta
+	files    map[string][]byte // by URL
+	expires  time.Time
+	versions versions
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	r := &testRepo{t: t, keys: make(map[string]testKey), files: make(map[string][]byte), expires: time.Now().Add(365 * 24 * time.Hour)}
+	r.root = rootMeta{common: common{Type: "root", Version: 1, Expires: r.expires}, Keys: map[string]key{}, Roles: map[string]role{}}
+	for _, name := range []string{"root", "timestamp", "snapshot", "targets"} {
+		r.setKey(name, newTestKey(t))
+	}
+	return r
+}
+
+func (r *testRepo) setKey(name string, k testKey) {
+	r.keys[name] = k
+	r.root.Keys[k.id] = k.key
+	r.root.Roles[name] = role{KeyIDs: []string{k.id}, Threshold: 1}
+}
+
+// sign returns the envelope of v signed with keys.
+func (r *testRepo) sign(v interface{}, keys ...testKey) []byte {
+	signed, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	payload, err := canonicalJSON(signed)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	env := envelope{Signed: signed}
+	for _, k := range keys {
+		env.Signatures = append(env.Signatures, signature{KeyID: k.id, Sig: hex.EncodeToString(ed25519.Sign(k.priv, payload))})
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return b
+}
+
+func (r *testRepo) rootJSON() []byte {
+	return r.sign(r.root, r.keys["root"])
+}
+
+// publish writes new targets, snapshot and timestamp metadata listing
+// targets, each with the next version.
+func (r *testRepo) publish(targets map[string]target) {
+	r.versions.Targets++
+	r.versions.Snapshot++
+	r.versions.Timestamp++
+	tm := targetsMeta{common: common{Type: "targets", Version: r.versions.Targets, Expires: r.expires}, Targets: targets}
+	r.files[metadataURL+"targets.json"] = r.sign(tm, r.keys["targets"])
+	snap := fileMeta{common: common{Type: "snapshot", Version: r.versions.Snapshot, Expires: r.expires},
+		Meta: map[string]metaFile{"targets.json": {Version: r.versions.Targets}}}
+	snapJSON := r.sign(snap, r.keys["snapshot"])
+	r.files[metadataURL+"snapshot.json"] = snapJSON
+	sum := sha256.Sum256(snapJSON)
+	ts := fileMeta{common: common{Type: "timestamp", Version: r.versions.Timestamp, Expires: r.expires},
+		Meta: map[string]metaFile{"snapshot.json": {Version: r.versions.Snapshot, Length: int64(len(snapJSON)), Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])}}}}
+	r.files[metadataURL+"timestamp.json"] = r.sign(ts, r.keys["timestamp"])
+}
+
+// addTarget serves content as the target name and returns its metadata.
+func (r *testRepo) addTarget(name string, content []byte, custom string) target {
+	r.files[targetsURL+name] = content
+	sum := sha256.Sum256(content)
+	t := target{Length: int64(len(content)), Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])}}
+	if custom != "" {
+		t.Custom = json.RawMessage(custom)
+	}
+	return t
+}
+
+func (r *testRepo) Fetch(url string) (io.ReadCloser, error) {
+	b, ok := r.files[url]
+	if !ok {
+		return nil, &selfupdate.HTTPStatusError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (r *testRepo) source(state selfupdate.StateStore) *Source {
+	return &Source{MetadataURL: metadataURL, TargetsURL: targetsURL, Root: r.rootJSON(), State: state, Requester: r}
+}
+
+func TestSourceUpdate(t *testing.T) {
+	bin := []byte("new binary")
+	sum := sha256.Sum256(bin)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bin)
+	gw.Close()
+
+	repo := newTestRepo(t)
+	repo.publish(map[string]target{
+		"myapp/1.3/linux-amd64.gz": repo.addTarget("myapp/1.3/linux-amd64.gz", gz.Bytes(), fmt.Sprintf(`{"sha256": "%x"}`, sum)),
+		"other/2.0/linux-amd64.gz": repo.addTarget("other/2.0/linux-amd64.gz", []byte("other"), `{"sha256": "00"}`),
+	})
+
+	dir, err := ioutil.TempDir("", "selfupdate-tuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "myapp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	updater := &selfupdate.Updater{
+		CurrentVersion:  "1.2",
+		CmdName:         "myapp",
+		Source:          repo.source(&selfupdate.MemoryStore{}),
+		ManifestDecoder: selfupdate.TUFManifest{},
+		Platform:        testPlatform("linux-amd64"),
+		Target:          testResolver(target),
+		State:           &selfupdate.MemoryStore{},
+	}
+	result, err := updater.UpdateWithResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Updated || result.To != "1.3" {
+		t.Errorf("expected update to 1.3, got %+v", result)
+	}
+	if b, _ := ioutil.ReadFile(target); string(b) != "new binary" {
+		t.Errorf("expected new binary, got %q", b)
+	}
+}
+
+func TestSourceRejects(t *testing.T) {
+	repo := newTestRepo(t)
+	gz := repo.addTarget("myapp/1.3/linux-amd64.gz", []byte("binary"), "")
+	repo.publish(map[string]target{"myapp/1.3/linux-amd64.gz": gz})
+	state := &selfupdate.MemoryStore{}
+	src := repo.source(state)
+	if _, err := src.Manifest(context.Background(), "myapp", "linux-amd64"); err != nil {
+		t.Fatal(err)
+	}
+	oldTimestamp := repo.files[metadataURL+"timestamp.json"]
+	oldSnapshot := repo.files[metadataURL+"snapshot.json"]
+	repo.publish(map[string]target{"myapp/1.3/linux-amd64.gz": gz})
+	if _, err := repo.source(state).Manifest(context.Background(), "myapp", "linux-amd64"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a tampered target fails when it is read
+	repo.files[targetsURL+"myapp/1.3/linux-amd64.gz"] = []byte("BINARY")
+	r, err := src.Binary(context.Background(), "myapp", "1.3", "linux-amd64.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected tampered target to be refused, got %v", err)
+	}
+	if _, err := src.Binary(context.Background(), "myapp", "1.4", "linux-amd64.gz"); !errors.Is(err, ErrUnknownTarget) {
+		t.Errorf("expected unknown target, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		want   error
+	}{
+		{"rollback", func() {
+			repo.files[metadataURL+"timestamp.json"] = oldTimestamp
+			repo.files[metadataURL+"snapshot.json"] = oldSnapshot
+		}, ErrRollback},
+		{"mix and match", func() {
+			// the snapshot lists targets version 3, the targets are version 2
+			repo.versions.Targets++
+			snapshot := fileMeta{common: common{Type: "snapshot", Version: 3, Expires: repo.expires},
+				Meta: map[string]metaFile{"targets.json": {Version: repo.versions.Targets}}}
+			repo.files[metadataURL+"snapshot.json"] = repo.sign(snapshot, repo.keys["snapshot"])
+			ts := fileMeta{common: common{Type: "timestamp", Version: 3, Expires: repo.expires},
+				Meta: map[string]metaFile{"snapshot.json": {Version: 3}}}
+			repo.files[metadataURL+"timestamp.json"] = repo.sign(ts, repo.keys["timestamp"])
+		}, ErrMismatch},
+		{"bad signature", func() {
+			ts := fileMeta{common: common{Type: "timestamp", Version: 9, Expires: repo.expires},
+				Meta: map[string]metaFile{"snapshot.json": {Version: 9}}}
+			repo.files[metadataURL+"timestamp.json"] = repo.sign(ts, newTestKey(t))
+		}, ErrBadSignature},
+		{"expired", func() {
+			repo.expires = time.Now().Add(-time.Hour)
+			repo.publish(map[string]target{"myapp/1.3/linux-amd64.gz": gz})
+		}, ErrExpired},
+	}
+	for _, test := range tests {
+		saved := make(map[string][]byte)
+		for k, v := range repo.files {
+			saved[k] = v
+		}
+		savedVersions, savedExpires := repo.versions, repo.expires
+		test.change()
+		_, err := repo.source(state).Manifest(context.Background(), "myapp", "linux-amd64")
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+		repo.files, repo.versions, repo.expires = saved, savedVersions, savedExpires
+	}
+}
+
+func TestSourceRootRotation(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.publish(map[string]target{})
+	state := &selfupdate.MemoryStore{}
+	src := repo.source(state)
+	if _, err := src.Manifest(context.Background(), "myapp", "linux-amd64"); err != nil {
+		t.Fatal(err)
+	}
+
+	// version 2 of the root replaces the timestamp key, signed by the old
+	// and the new root key
+	oldRoot := repo.keys["root"]
+	repo.root.Version = 2
+	repo.setKey("root", newTestKey(t))
+	repo.setKey("timestamp", newTestKey(t))
+	repo.files[metadataURL+"2.root.json"] = repo.sign(repo.root, oldRoot, repo.keys["root"])
+	repo.publish(map[string]target{})
+	if _, err := src.Manifest(context.Background(), "myapp", "linux-amd64"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := state.Read(rootState); !strings.Contains(string(b), `"version":2`) {
+		t.Error("expected the new root to be trusted")
+	}
+
+	// version 3 signed only by the retired key isn't trusted
+	repo.root.Version = 3
+	repo.files[metadataURL+"3.root.json"] = repo.sign(repo.root, oldRoot)
+	if _, err := src.Manifest(context.Background(), "myapp", "linux-amd64"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected root signed by the retired key to be refused, got %v", err)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	b, err := canonicalJSON([]byte(`{"b": [1, true, null], "a": "x\"y\\zé"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":"x\"y\\zé","b":[1,true,null]}`; string(b) != want {
+		t.Errorf("expected %s, got %s", want, b)
+	}
+	if _, err := canonicalJSON([]byte(`{"a": 1.5}`)); err == nil {
+		t.Error("expected floats to be refused")
+	}
+}
+
+type testPlatform string
+
+func (p testPlatform) Platform() string { return string(p) }
+
+type testResolver string
+
+func (r testResolver) Path() (string, error) { return string(r), nil }
@@ -0,0 +1,53 @@
+package selfupdate
+
+import (
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// URLTemplates lay out the URLs the default source fetches from, for update
+// servers and artifact stores organized differently from the output of the
+// generator. Each is a text/template executed with a URLData, for example
+//
+//	{{.Base}}/{{.Cmd}}/{{.Channel}}/{{.Platform}}.json
+//
+// Kinds without a template keep the generator's layout.
+type URLTemplates struct {
+	Manifest string // URL of the manifest below ApiURL, the generator's is {{.Base}}/{{.Cmd}}/{{.Platform}}.json
+	Binary   string // URL of a full binary or the release notes below BinURL, the generator's is {{.Base}}/{{.Cmd}}/{{.Version}}/{{.File}}
+	Patch    string // URL of a patch below DiffURL, the generator's is {{.Base}}/{{.Cmd}}/{{.From}}/{{.Version}}/{{.Platform}}
+}
+
+// URLData is what URLTemplates are executed with. All fields but Base are
+// escaped for use in a URL.
+type URLData struct {
+	Base     string // ApiURL, BinURL or DiffURL without the trailing slash
+	Cmd      string // CmdName
+	Channel  string // Updater.Channel
+	Platform string // Platform updates are fetched for, such as linux-amd64
+	Version  string // Version of the binary, or the version a patch leads to
+	From     string // Version a patch starts at
+	File     string // Name of the file of a binary, such as linux-amd64.gz
+}
+
+// expandURL executes the template text for the file described by data,
+// whose Base is set from base.
+func (u *Updater) expandURL(text, base string, data URLData) (string, error) {
+	t, err := template.New("url").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data.Base = strings.TrimSuffix(base, "/")
+	data.Cmd = url.QueryEscape(data.Cmd)
+	data.Channel = url.QueryEscape(u.Channel)
+	data.Platform = url.QueryEscape(data.Platform)
+	data.Version = url.QueryEscape(data.Version)
+	data.From = url.QueryEscape(data.From)
+	data.File = url.QueryEscape(data.File)
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
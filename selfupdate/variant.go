@@ -0,0 +1,60 @@
+package selfupdate
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// muslLoaders matches the dynamic loader of musl libc, which glibc systems
+// don't have.
+var muslLoaders = "/lib/ld-musl-*.so.1"
+
+// VariantPlatform resolves to the platform the program was compiled for,
+// like RuntimePlatform, followed by the variants the update tree tells
+// apart: the ARM version on 32 bit ARM, as in linux-arm-7, and musl libc, as
+// in linux-amd64-musl. Use it when the generator publishes such platforms,
+// see its -platforms flag, so that a Raspberry Pi gets the ARMv6 build and
+// Alpine the musl one.
+type VariantPlatform struct {
+	ARM  bool // Append the GOARM version the program was built with on 32 bit ARM
+	Libc bool // Append -musl on Linux systems whose C library is musl
+}
+
+// Platform returns the platform with the enabled variants appended.
+func (p VariantPlatform) Platform() string {
+	platform := plat
+	if p.ARM && runtime.GOARCH == "arm" {
+		if v := goarm(); v != "" {
+			platform += "-" + v
+		}
+	}
+	if p.Libc && runtime.GOOS == "linux" && muslLibc() {
+		platform += "-musl"
+	}
+	return platform
+}
+
+// muslLibc reports whether the system uses musl libc.
+func muslLibc() bool {
+	matches, _ := filepath.Glob(muslLoaders)
+	return len(matches) > 0
+}
+
+// goreleaserPlatform returns platform the way goreleaser names it: os and
+// arch separated by an underscore and the ARM version attached as in armv7.
+func goreleaserPlatform(platform string) string {
+	parts := strings.Split(platform, "-")
+	if len(parts) < 2 {
+		return platform
+	}
+	name := parts[0] + "_" + parts[1]
+	for _, v := range parts[2:] {
+		if parts[1] == "arm" && len(v) == 1 && v[0] >= '5' && v[0] <= '7' {
+			name += "v" + v
+		} else {
+			name += "_" + v
+		}
+	}
+	return name
+}
@@ -0,0 +1,74 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+)
+
+// Verifier checks a new executable before it is installed. artifact reads
+// the executable as it was staged on disk, it is an *os.File so that checks
+// which need a path, such as those of code signatures, can take its Name.
+// manifest describes the release it is the executable of. An error refuses
+// the update and is returned from it.
+type Verifier interface {
+	Verify(artifact io.Reader, manifest VersionInfo) error
+}
+
+// VerifierFunc adapts a function to a Verifier.
+type VerifierFunc func(artifact io.Reader, manifest VersionInfo) error
+
+func (f VerifierFunc) Verify(artifact io.Reader, manifest VersionInfo) error {
+	return f(artifact, manifest)
+}
+
+// SHA256Verifier checks that the executable hashes to the SHA-256 hash in
+// the manifest. It is always the first of the chain, patches being checked
+// with it and staged updates found again by their hash.
+type SHA256Verifier struct{}
+
+func (SHA256Verifier) Verify(artifact io.Reader, manifest VersionInfo) error {
+	if len(manifest.Sha256) == 0 {
+		return ErrNoHash
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, artifact); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), manifest.Sha256) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// verifiers returns the chain new executables are checked with,
+// SHA256Verifier followed by u.Verifiers.
+func (u *Updater) verifiers() []Verifier {
+	return append([]Verifier{SHA256Verifier{}}, u.Verifiers...)
+}
+
+// verifyDownload checks the new executable at path against u.Info.
+func (u *Updater) verifyDownload(path string) error {
+	return u.verifyArtifact(path, u.Info)
+}
+
+// verifyArtifact runs the executable at path, of the release described by
+// info, through the chain of verifiers, each reading it from the start. It
+// returns the error of the first that refuses it.
+func (u *Updater) verifyArtifact(path string, info VersionInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, v := range u.verifiers() {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := v.Verify(f, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package selfupdate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// compareVersions compares two version names and returns -1, 0 or 1 if a
+// is older, the same or newer than b. Versions are compared like semantic
+// versions: an optional "v" prefix and build metadata after "+" are ignored,
+// dot separated parts are compared one by one, numerically where both are
+// numbers, and a pre-release after "-" is older than the release itself.
+// Dates such as 2023-07-09 compare as expected too.
+func compareVersions(a, b string) int {
+	a, aPre := splitVersion(a)
+	b, bPre := splitVersion(b)
+	if c := compareParts(strings.Split(a, "."), strings.Split(b, ".")); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareParts(strings.FieldsFunc(aPre, isVersionSeparator), strings.FieldsFunc(bPre, isVersionSeparator))
+}
+
+// PrereleasePolicy decides which installations pre-releases, versions such
+// as 1.3.0-beta.2, are offered to.
+type PrereleasePolicy int
+
+const (
+	// PrereleasesAlways offers pre-releases like any other release. It is
+	// the default.
+	PrereleasesAlways PrereleasePolicy = iota
+	// PrereleasesWhenRunning offers pre-releases only to installations
+	// running a pre-release, so that testers follow the pre-releases while
+	// everyone else waits for the release.
+	PrereleasesWhenRunning
+	// PrereleasesNever offers no pre-releases.
+	PrereleasesNever
+)
+
+// isPrerelease reports whether version is a pre-release: a dotted release
+// such as 1.3.0 followed by "-" and the pre-release, like 1.3.0-beta.2.
+// Dates such as 2023-07-09 aren't.
+func isPrerelease(version string) bool {
+	release, pre := splitVersion(version)
+	return pre != "" && strings.Contains(release, ".")
+}
+
+// stripBuildMetadata returns version without the build metadata after "+".
+func stripBuildMetadata(version string) string {
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		return version[:i]
+	}
+	return version
+}
+
+func splitVersion(v string) (release, pre string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+func isVersionSeparator(r rune) bool {
+	return r == '.' || r == '-'
+}
+
+func compareParts(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) {
+			return -1
+		}
+		if i >= len(b) {
+			return 1
+		}
+		an, aErr := strconv.ParseUint(a[i], 10, 64)
+		bn, bErr := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case a[i] != b[i]:
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// prereleaseAllowed reports whether u.Prereleases lets the fetched release
+// be offered to this installation.
+func (u *Updater) prereleaseAllowed() bool {
+	switch u.Prereleases {
+	case PrereleasesNever:
+		return !isPrerelease(u.Info.Version)
+	case PrereleasesWhenRunning:
+		return !isPrerelease(u.Info.Version) || isPrerelease(u.CurrentVersion)
+	}
+	return true
+}
+
+// upToDate reports whether the fetched release is the running version. With
+// u.IgnoreBuildMetadata set versions that only differ in their build
+// metadata, such as 1.3.0+nightly.5 and 1.3.0, count as the same.
+func (u *Updater) upToDate() bool {
+	if u.Info.Version == u.CurrentVersion {
+		return true
+	}
+	return u.IgnoreBuildMetadata && stripBuildMetadata(u.Info.Version) == stripBuildMetadata(u.CurrentVersion)
+}
+
+// belowMinimum reports whether the running version is older than the
+// minimum version the fetched manifest still supports.
+func (u *Updater) belowMinimum() bool {
+	return u.Info.MinimumVersion != "" && compareVersions(u.CurrentVersion, u.Info.MinimumVersion) < 0
+}
+
+// mandatory reports whether the fetched release must be installed right
+// away regardless of the schedule. A release not targeted at this
+// installation never is.
+func (u *Updater) mandatory() bool {
+	if u.upToDate() {
+		return false
+	}
+	return ((u.ForceCriticalUpdates && u.Info.Severity == SeverityCritical) ||
+		(u.EnforceMinimum && u.belowMinimum())) && u.targeted()
+}
+
+// Mandatory reports whether the release fetched last must be installed
+// without asking the user: it is critical and ForceCriticalUpdates is set,
+// or the running version is older than its MinimumVersion and
+// EnforceMinimum is set.
+func (u *Updater) Mandatory() bool {
+	return u.mandatory()
+}
@@ -0,0 +1,182 @@
+package selfupdate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// VersionedInstall installs every release side by side into a directory of
+// its own, Dir/<version>/<name of Link>, and points the symlink Link, which
+// the application is started through, at the new one. Switching versions is
+// a single rename of the link, and previous versions stay on disk, so that
+// Rollback only has to point the link back. Files in Targets are still
+// replaced in place.
+//
+// An executable found at Link instead of a symlink is moved to the directory
+// of CurrentVersion by the first update. Windows creates symlinks only for
+// administrators or in developer mode.
+type VersionedInstall struct {
+	Link string // Symlink the application is started through, such as /opt/myapp/myapp
+	Dir  string // Directory holding a directory per version, defaults to versions next to Link
+	Keep int    // Number of previous versions kept on disk, defaults to 1
+}
+
+// dir returns the directory holding the versions.
+func (v *VersionedInstall) dir() string {
+	if v.Dir == "" {
+		return filepath.Join(filepath.Dir(v.Link), "versions")
+	}
+	return v.Dir
+}
+
+// path returns where the executable of version is installed.
+func (v *VersionedInstall) path(version string) (string, error) {
+	if version == "" || version == "." || version == ".." || filepath.Base(version) != version {
+		return "", fmt.Errorf("version %q can't be installed side by side", version)
+	}
+	return filepath.Join(v.dir(), version, filepath.Base(v.Link)), nil
+}
+
+// installVersioned installs the staged executable, files[0], into the
+// directory of version and points the link at it, then installs the other
+// files in place. If those fail the link is pointed back.
+func (u *Updater) installVersioned(files []stagedFile, version string) error {
+	v := u.Versions
+	path, err := v.path(version)
+	if err != nil {
+		removeStaged(files)
+		return err
+	}
+	previous, err := u.adoptExecutable()
+	if err != nil {
+		removeStaged(files)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		removeStaged(files)
+		return err
+	}
+	if err := os.Rename(files[0].NewPath, path); err != nil {
+		removeStaged(files)
+		return err
+	}
+	_ = syncDir(filepath.Dir(path))
+	if err := switchLink(v.Link, path); err != nil {
+		removeStaged(files[1:])
+		return err
+	}
+	if err := installFiles(files[1:]); err != nil {
+		if previous != "" {
+			if errRevert := switchLink(v.Link, previous); errRevert != nil {
+				return fmt.Errorf("update and recovery errors: %q %q", err, errRevert)
+			}
+		}
+		return err
+	}
+	u.pruneVersions(version)
+	return nil
+}
+
+// adoptExecutable returns the executable Link points at. An executable at
+// Link itself, installed before versioned installs were used, is moved to
+// the directory of CurrentVersion first. It returns "" if there is nothing
+// at Link yet.
+func (u *Updater) adoptExecutable() (string, error) {
+	v := u.Versions
+	fi, err := os.Lstat(v.Link)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return filepath.EvalSymlinks(v.Link)
+	}
+	path, err := v.path(u.CurrentVersion)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(v.Link, path); err != nil {
+		return "", err
+	}
+	u.logger().Info("moved executable for versioned installs", "path", path)
+	return path, nil
+}
+
+// switchLink atomically points the symlink link at target, relative to the
+// directory of link where possible so that the installation can be moved.
+// A new link is created next to it and renamed over it.
+func switchLink(link, target string) error {
+	dest := target
+	if rel, err := filepath.Rel(filepath.Dir(link), target); err == nil {
+		dest = rel
+	}
+	tmp := filepath.Join(filepath.Dir(link), fmt.Sprintf(".%s.link", filepath.Base(link)))
+	_ = os.Remove(tmp)
+	if err := os.Symlink(dest, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(link))
+}
+
+// rollbackVersion points the link back at the version the latest update
+// replaced, if it is still on disk and wasn't rolled back to already.
+func (u *Updater) rollbackVersion() error {
+	st := u.readRollback()
+	if st.RolledBack || st.Previous == "" {
+		return ErrNoRollback
+	}
+	path, err := u.Versions.path(st.Previous)
+	if err != nil {
+		return ErrNoRollback
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ErrNoRollback
+	}
+	return switchLink(u.Versions.Link, path)
+}
+
+// pruneVersions removes the directories of versions other than installed,
+// the running one and the newest u.Versions.Keep others.
+func (u *Updater) pruneVersions(installed string) {
+	keep := u.Versions.Keep
+	if keep <= 0 {
+		keep = 1
+	}
+	entries, err := ioutil.ReadDir(u.Versions.dir())
+	if err != nil {
+		return
+	}
+	var others []string
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != installed {
+			others = append(others, e.Name())
+		}
+	}
+	// the replaced version goes first, Rollback restores it
+	sort.Slice(others, func(i, j int) bool {
+		if (others[i] == u.CurrentVersion) != (others[j] == u.CurrentVersion) {
+			return others[i] == u.CurrentVersion
+		}
+		return compareVersions(others[i], others[j]) > 0
+	})
+	if len(others) <= keep {
+		return
+	}
+	for _, version := range others[keep:] {
+		if err := os.RemoveAll(filepath.Join(u.Versions.dir(), version)); err != nil {
+			u.logger().Warn("removing previous version failed", "version", version, "error", err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package selfupdate
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// copyXattrs copies the extended attributes of the file at from to the one
+// at to. Attributes that can't be read or set, such as trusted.* ones
+// without privileges, are left out.
+func copyXattrs(from, to string) {
+	size, err := syscall.Listxattr(from, nil)
+	if err != nil || size == 0 {
+		return
+	}
+	list := make([]byte, size)
+	if size, err = syscall.Listxattr(from, list); err != nil {
+		return
+	}
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		n, err := syscall.Getxattr(from, attr, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(from, attr, value); err != nil {
+			continue
+		}
+		_ = syscall.Setxattr(to, attr, value[:n], 0)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package selfupdate
+
+// copyXattrs does nothing. On macOS the entitlements are part of the code
+// signature embedded in the executable and come with the new one, and the
+// quarantine attribute isn't wanted on it anyway.
+func copyXattrs(from, to string) {}
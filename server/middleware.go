@@ -0,0 +1,152 @@
+package server
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Gzip compresses responses with text or JSON content, such as manifests
+// and release notes, for clients that accept gzip. Binaries and patches are
+// compressed already and, like range requests, are passed through as is.
+func Gzip(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: rw}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports whether responses of contentType are worth
+// compressing.
+func compressible(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(t, "text/") || t == "application/json"
+}
+
+// gzipResponseWriter decides whether to compress once the status and headers
+// are known.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	hdr := w.Header()
+	hdr.Add("Vary", "Accept-Encoding")
+	if status == http.StatusOK && hdr.Get("Content-Encoding") == "" && compressible(hdr.Get("Content-Type")) {
+		hdr.Del("Content-Length")
+		hdr.Del("Accept-Ranges")
+		hdr.Set("Content-Encoding", "gzip")
+		// the compressed body differs from the file the strong ETag
+		// describes
+		if tag := hdr.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+			hdr.Set("ETag", "W/"+tag)
+		}
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// BasicAuth only lets requests with the given HTTP basic auth credentials
+// through to h, for update trees that shouldn't be public.
+func BasicAuth(h http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		// evaluate both comparisons so that the time taken doesn't tell
+		// which one failed
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passwordOK {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="updates", charset="UTF-8"`)
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// AccessLog writes a line in the common log format for every request to w.
+func AccessLog(h http.Handler, w io.Writer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: rw, status: http.StatusOK}
+		h.ServeHTTP(lw, r)
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user := "-"
+		if u, _, ok := r.BasicAuth(); ok && u != "" {
+			user = u
+		}
+		fmt.Fprintf(w, "%s - %s [%s] %q %d %d %s\n",
+			host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto, lw.status, lw.size, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// loggingResponseWriter records the status and size of a response.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,90 @@
+// Package server serves update trees created by the go-selfupdate command so
+// that they can be fetched by selfupdate.Updater clients.
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileServer returns a handler that serves the update tree rooted at dir.
+//
+// Besides plain GET requests it answers HEAD requests, reports
+// Content-Length, honors byte-range requests and sends ETag and
+// Last-Modified headers so that conditional, resumable and segmented
+// downloads work the same as they do against a CDN. ETags are derived from
+// the file contents, so several servers serving copies of the same tree
+// agree on them.
+func FileServer(dir string) http.Handler {
+	return &fileServer{
+		root:    dir,
+		handler: http.FileServer(http.Dir(dir)),
+		etags:   make(map[string]etag),
+	}
+}
+
+type etag struct {
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+type fileServer struct {
+	root    string
+	handler http.Handler
+
+	mu    sync.Mutex
+	etags map[string]etag
+}
+
+func (fs *fileServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		if tag, ok := fs.etag(filepath.Join(fs.root, filepath.FromSlash(name))); ok {
+			// http.FileServer evaluates If-None-Match and If-Range against
+			// the ETag header when it is already set
+			rw.Header().Set("ETag", tag)
+		}
+	}
+	fs.handler.ServeHTTP(rw, r)
+}
+
+// etag returns the ETag for the regular file at name, hashing its contents
+// only when the file changed since the last request.
+func (fs *fileServer) etag(name string) (string, bool) {
+	fi, err := os.Stat(name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+
+	fs.mu.Lock()
+	cached, ok := fs.etags[name]
+	fs.mu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.value, true
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", false
+	}
+	value := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	fs.mu.Lock()
+	fs.etags[name] = etag{modTime: fi.ModTime(), size: fi.Size(), value: value}
+	fs.mu.Unlock()
+	return value, true
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "myapp", "1.2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "myapp", "1.2", "linux-amd64.gz"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(FileServer(dir))
+	return ts, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileServerHead(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	resp, err := http.Head(ts.URL + "/myapp/1.2/linux-amd64.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if resp.ContentLength != 10 {
+		t.Errorf("got Content-Length %d, want 10", resp.ContentLength)
+	}
+	if resp.Header.Get("ETag") == "" {
+		t.Error("missing ETag header")
+	}
+}
+
+func TestFileServerRange(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/myapp/1.2/linux-amd64.gz", nil)
+	req.Header.Set("Range", "bytes=4-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("got status %d, want 206", resp.StatusCode)
+	}
+	if string(body) != "456789" {
+		t.Errorf("got body %q, want %q", body, "456789")
+	}
+}
+
+func TestFileServerIfNoneMatch(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	resp, err := http.Get(ts.URL + "/myapp/1.2/linux-amd64.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/myapp/1.2/linux-amd64.gz", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := `{"Version": "1.2", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "linux-amd64.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "linux-amd64.gz"), []byte{0x1f, 0x8b, 8, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(Gzip(FileServer(dir)))
+	defer ts.Close()
+
+	// the client asks for gzip and decompresses transparently
+	resp, err := http.Get(ts.URL + "/linux-amd64.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !resp.Uncompressed || string(body) != manifest {
+		t.Errorf("got uncompressed %v body %q", resp.Uncompressed, body)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/linux-amd64.gz", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("binary was sent with Content-Encoding %q", enc)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+	ts.Config.Handler = BasicAuth(ts.Config.Handler, "ci", "secret")
+
+	resp, err := http.Get(ts.URL + "/myapp/1.2/linux-amd64.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/myapp/1.2/linux-amd64.gz", nil)
+	req.SetBasicAuth("ci", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var log strings.Builder
+	h := AccessLog(http.NotFoundHandler(), &log)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/myapp/linux-amd64.json", nil))
+	if !strings.Contains(log.String(), `"GET /myapp/linux-amd64.json HTTP/1.1" 404 19`) {
+		t.Errorf("unexpected log line %q", log.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats, err := NewStats("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(stats)
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"Cmd":"myapp","Platform":"linux-amd64","From":"1.1","To":"1.2"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("got status %d, want 204", resp.StatusCode)
+		}
+	}
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"Cmd":"myapp","Platform":"linux-amd64","From":"1.1","To":"1.2","Failed":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"Cmd":"myapp"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("incomplete report got status %d, want 400", resp.StatusCode)
+	}
+
+	counts := stats.Counts()
+	if len(counts) != 2 || counts[0].Count != 2 || counts[0].To != "1.2" || counts[0].Failed || counts[1].Count != 1 || !counts[1].Failed {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+}
+
+func TestStatsFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.json")
+
+	stats, err := NewStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Report{Cmd: "myapp", Platform: "linux-amd64", From: "1.1", To: "1.2"}
+	stats.Record(report)
+	stats.Record(report)
+	// reports are saved together later, not one write each
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("stats saved on every report: %v", err)
+	}
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := loaded.Counts(); len(counts) != 1 || counts[0].Count != 2 {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary files left behind: %d files", len(files))
+	}
+
+	stats, _ = NewStats(filepath.Join(dir, "missing", "stats.json"))
+	stats.Record(report)
+	if err := stats.Flush(); err == nil {
+		t.Error("expected an error saving to a missing directory")
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStatsEntries bounds the number of distinct counters kept by Stats so
+// that bogus reports can't grow it without limit.
+const maxStatsEntries = 10000
+
+// maxReportSize bounds the size of a single report body.
+const maxReportSize = 1 << 10
+
+// statsSaveDelay is how long Stats collects reports before saving them, so
+// that a burst of reports costs a single write.
+const statsSaveDelay = 5 * time.Second
+
+// Report is what a client sends to a Stats endpoint after it updated or
+// failed to. It deliberately contains nothing that identifies the
+// installation.
+type Report struct {
+	Cmd      string
+	Platform string
+	From     string
+	To       string
+	Failed   bool `json:",omitempty"` // Whether the update failed
+}
+
+// Count is the number of reported updates for one combination of command,
+// platform, versions and outcome.
+type Count struct {
+	Report
+	Count int
+}
+
+// Stats aggregates update reports sent by clients into counts per command,
+// platform, version pair and outcome, so that failure spikes of a release
+// stand out. POST a JSON Report to record an update, GET
+// returns the counts as JSON, or as a small HTML dashboard when requested
+// with ?format=html. No client addresses or identifiers are stored.
+type Stats struct {
+	// ErrorLog receives the errors saving the counts, nil means the
+	// standard logger of the log package.
+	ErrorLog *log.Logger
+
+	path   string
+	saveMu sync.Mutex // serializes saving, so that an older save can't win
+
+	mu      sync.Mutex
+	counts  map[Report]int
+	pending bool // a save is scheduled
+}
+
+// NewStats returns an empty Stats. If path is not empty the counts are
+// loaded from and saved to that file so they survive restarts. They are
+// saved within 5 seconds of a report, call Flush before exiting.
+func NewStats(path string) (*Stats, error) {
+	s := &Stats{path: path, counts: make(map[Report]int)}
+	if path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	var counts []Count
+	if err := json.Unmarshal(b, &counts); err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		s.counts[c.Report] = c.Count
+	}
+	return s, nil
+}
+
+// Record counts one update.
+func (s *Stats) Record(r Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counts[r]; !ok && len(s.counts) >= maxStatsEntries {
+		return
+	}
+	s.counts[r]++
+	if s.path != "" && !s.pending {
+		s.pending = true
+		time.AfterFunc(statsSaveDelay, func() {
+			if err := s.Flush(); err != nil {
+				s.logf("saving update statistics: %v", err)
+			}
+		})
+	}
+}
+
+// Flush saves the counts to the file given to NewStats right away. The file
+// is replaced in one go, a crash leaves the previous counts.
+func (s *Stats) Flush() error {
+	if s.path == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	s.pending = false
+	b, err := json.Marshal(s.sorted())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *Stats) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Counts returns all counts, ordered by command, platform and versions.
+func (s *Stats) Counts() []Count {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+func (s *Stats) sorted() []Count {
+	counts := make([]Count, 0, len(s.counts))
+	for r, n := range s.counts {
+		counts = append(counts, Count{Report: r, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Cmd != b.Cmd {
+			return a.Cmd < b.Cmd
+		}
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return !a.Failed && b.Failed
+	})
+	return counts
+}
+
+// ServeHTTP records POSTed reports and serves the counts.
+func (s *Stats) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var report Report
+		body := http.MaxBytesReader(rw, r.Body, maxReportSize)
+		if err := json.NewDecoder(body).Decode(&report); err != nil || !validReport(report) {
+			http.Error(rw, "invalid report", http.StatusBadRequest)
+			return
+		}
+		s.Record(report)
+		rw.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		counts := s.Counts()
+		if r.URL.Query().Get("format") == "html" {
+			rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+			dashboard.Execute(rw, counts)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(counts)
+	default:
+		rw.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validReport(r Report) bool {
+	for _, field := range []string{r.Cmd, r.Platform, r.From, r.To} {
+		if field == "" || len(field) > 128 || strings.ContainsAny(field, "\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+var dashboard = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Update adoption</title></head>
+<body>
+<h1>Update adoption</h1>
+<table>
+<tr><th>Command</th><th>Platform</th><th>From</th><th>To</th><th>Outcome</th><th>Updates</th></tr>
+{{range .}}<tr><td>{{.Cmd}}</td><td>{{.Platform}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{if .Failed}}failed{{else}}updated{{end}}</td><td>{{.Count}}</td></tr>
+{{else}}<tr><td colspan="6">No updates reported yet.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))